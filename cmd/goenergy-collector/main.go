@@ -0,0 +1,218 @@
+// Command goenergy-collector polls the devices of a YAML configuration and writes their values to the configured
+// outputs, see the config package for the format of the configuration.
+//
+// Usage:
+//
+//	goenergy-collector -config site.yaml
+//
+// Unreachable devices are retried every interval, failed writes are logged and skipped.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/config"
+	"github.com/orlopau/go-energy/pkg/meter"
+	"github.com/orlopau/go-energy/pkg/modbus"
+	"github.com/orlopau/go-energy/pkg/record"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+func main() {
+	name := flag.String("config", "goenergy.yaml", "configuration `file`")
+	check := flag.Bool("check", false, "only check the configuration")
+	flag.Parse()
+
+	c, err := config.Load(*name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *check {
+		fmt.Printf("%v: %v devices, %v outputs\n", *name, len(c.Devices), len(c.Outputs))
+		return
+	}
+
+	writers := make(map[string]record.Writer, len(c.Outputs))
+	for name, o := range c.Outputs {
+		w, err := o.Open()
+		if err != nil {
+			log.Fatalf("output %v: %v", name, err)
+		}
+		writers[name] = w
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	for _, d := range c.Devices {
+		out := &outputs{device: d.Name, writers: make(map[string]record.Writer)}
+		for _, name := range d.Outputs {
+			out.writers[name] = writers[name]
+		}
+
+		switch d.Protocol {
+		case config.ProtocolSunSpec:
+			p := &sunSpecPoller{device: d}
+			for interval, fields := range d.Schedule() {
+				wg.Add(1)
+				go func(interval time.Duration, fields []record.SunSpecField) {
+					defer wg.Done()
+					p.poll(ctx, interval, fields, out)
+				}(interval, fields)
+			}
+		case config.ProtocolEnergyMeter:
+			m, err := listenMeter(d)
+			if err != nil {
+				log.Fatalf("device %v: %v", d.Name, err)
+			}
+			wg.Add(1)
+			go func(d config.Device) {
+				defer wg.Done()
+				readTelegrams(m, d, out)
+			}(d)
+			go func() {
+				<-ctx.Done()
+				_ = m.Close()
+			}()
+		}
+	}
+
+	log.Printf("collecting %v devices", len(c.Devices))
+	wg.Wait()
+
+	for name, w := range writers {
+		if err := w.Close(); err != nil {
+			log.Printf("closing output %v: %v", name, err)
+		}
+	}
+}
+
+// outputs writes the records of a device to its outputs.
+type outputs struct {
+	device  string
+	writers map[string]record.Writer
+}
+
+// write tags the record with the device name and writes it to all outputs, logging failed writes.
+func (o *outputs) write(r record.Record) {
+	r.Tags["device"] = o.device
+	for name, w := range o.writers {
+		if err := w.Write(r); err != nil {
+			log.Printf("writing %v to output %v: %v", o.device, name, err)
+		}
+	}
+}
+
+// sunSpecPoller polls a SunSpec device, sharing its connection between the intervals of its points.
+type sunSpecPoller struct {
+	device config.Device
+
+	mu sync.Mutex
+	d  *sunspec.ModbusDevice
+}
+
+// poll reads the fields every interval until the context is canceled.
+func (p *sunSpecPoller) poll(ctx context.Context, interval time.Duration, fields []record.SunSpecField, out *outputs) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if r, err := p.read(fields); err != nil {
+			log.Printf("device %v: %v", p.device.Name, err)
+		} else {
+			out.write(r)
+		}
+
+		select {
+		case <-ctx.Done():
+			p.close()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// read reads the fields, connecting if the device is not connected and disconnecting after errors.
+func (p *sunSpecPoller) read(fields []record.SunSpecField) (record.Record, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.d == nil {
+		addr, unitID, err := p.device.ModbusAddress()
+		if err != nil {
+			return record.Record{}, err
+		}
+		d, err := sunspec.Connect(addr, modbus.WithTimeout(p.device.Timeout), modbus.WithoutReconnect())
+		if err != nil {
+			return record.Record{}, err
+		}
+		d.SetDeviceAddress(unitID)
+		p.d = d
+	}
+
+	r, err := record.ReadSunSpec(p.d, fields, time.Now())
+	if err != nil {
+		_ = p.d.Close()
+		p.d = nil
+	}
+	return r, err
+}
+
+func (p *sunSpecPoller) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.d != nil {
+		_ = p.d.Close()
+		p.d = nil
+	}
+}
+
+func listenMeter(d config.Device) (*meter.EnergyMeter, error) {
+	if d.Transport == config.TransportUnicast {
+		return meter.Listen(meter.WithUnicast(d.Address))
+	}
+	if d.Interface == "" {
+		return meter.Listen()
+	}
+
+	ifi, err := net.InterfaceByName(d.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("interface %q: %w", d.Interface, err)
+	}
+	return meter.Listen(meter.WithInterface(ifi))
+}
+
+// readTelegrams writes the telegrams of the device at most once per interval until the meter is closed.
+func readTelegrams(m *meter.EnergyMeter, d config.Device, out *outputs) {
+	written := make(map[uint32]time.Time)
+	for {
+		t, err := m.ReadTelegram()
+		if meter.IsSkippable(err) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if d.Serial != 0 && t.SerialNo != d.Serial {
+			continue
+		}
+
+		now := time.Now()
+		if now.Sub(written[t.SerialNo]) < d.Interval {
+			continue
+		}
+		written[t.SerialNo] = now
+
+		out.write(record.FromTelegram(t, now))
+	}
+}
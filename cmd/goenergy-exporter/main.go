@@ -0,0 +1,78 @@
+// Command goenergy-exporter exports the values of SunSpec devices and SMA energy meters as Prometheus metrics.
+//
+// Usage:
+//
+//	goenergy-exporter [flags]
+//
+// SunSpec devices are given as host[:port][/unit], e.g. -sunspec 192.168.1.10/3, the port defaults to 502 and
+// the unit id to 1. The flag can be repeated for several devices.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/orlopau/go-energy/internal/cli"
+	"github.com/orlopau/go-energy/pkg/exporter"
+	"github.com/orlopau/go-energy/pkg/logger"
+	"github.com/orlopau/go-energy/pkg/meter"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	var targets cli.SunSpecFlag
+	flag.Var(&targets, "sunspec", "SunSpec `device` as host[:port][/unit], can be repeated")
+	listen := flag.String("listen", ":9942", "`address` serving the metrics")
+	interval := flag.Duration("interval", 10*time.Second, "interval of polling the SunSpec devices")
+	meters := flag.Bool("meter", false, "export the values of SMA energy meters")
+	iface := flag.String("interface", "", "network `interface` receiving energy meter telegrams, the one chosen by the system by default")
+	unicast := flag.String("unicast", "", "receive unicast energy meter telegrams on the `address` instead of the multicast group")
+	flag.Parse()
+
+	if len(targets) == 0 && !*meters {
+		fmt.Fprintln(os.Stderr, "goenergy-exporter: no devices, use -sunspec or -meter")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	e := exporter.New(*interval)
+	defer e.Close()
+
+	for _, t := range targets {
+		e.AddSunSpec(t.Addr, t.UnitID)
+	}
+
+	if *meters {
+		opts, err := cli.MeterOptions(*iface, *unicast)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m, err := meter.Listen(append(opts, meter.WithLogger(infoLogger{logger.Std(nil)}))...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		e.AddEnergyMeter(m)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, `<html><body><h1>goenergy exporter</h1><a href="/metrics">Metrics</a></body></html>`)
+	})
+
+	log.Printf("serving metrics on %v", *listen)
+	log.Fatal(http.ListenAndServe(*listen, mux))
+}
+
+// infoLogger drops debug messages, e.g. of the other SMA datagrams received by the energy meter connection.
+type infoLogger struct {
+	logger.Logger
+}
+
+func (infoLogger) Debug(string, ...any) {}
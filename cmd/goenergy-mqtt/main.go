@@ -0,0 +1,157 @@
+// Command goenergy-mqtt publishes the values of SunSpec devices and SMA energy meters to a MQTT broker,
+// announcing them using Home Assistant MQTT discovery.
+//
+// Usage:
+//
+//	goenergy-mqtt -broker <host[:port]> [flags]
+//
+// SunSpec devices are given as host[:port][/unit], e.g. -sunspec 192.168.1.10/3, the flag can be repeated.
+// The command exits if the connection to the broker is lost.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/orlopau/go-energy/internal/cli"
+	"github.com/orlopau/go-energy/pkg/meter"
+	"github.com/orlopau/go-energy/pkg/modbus"
+	"github.com/orlopau/go-energy/pkg/mqtt"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"log"
+	"os"
+	"time"
+)
+
+// requestTimeout is the timeout for connecting to a SunSpec device and for each modbus request.
+const requestTimeout = 5 * time.Second
+
+func main() {
+	var targets cli.SunSpecFlag
+	flag.Var(&targets, "sunspec", "SunSpec `device` as host[:port][/unit], can be repeated")
+	broker := flag.String("broker", "", "`address` of the MQTT broker as host[:port]")
+	username := flag.String("username", "", "user name of the MQTT broker")
+	password := flag.String("password", os.Getenv("GOENERGY_MQTT_PASSWORD"), "password of the MQTT broker, GOENERGY_MQTT_PASSWORD by default")
+	clientID := flag.String("client-id", "", "MQTT client `id`, random by default")
+	topic := flag.String("topic", mqtt.DefaultTopicTemplate, "`template` of the state topics with the fields .Device, .Sensor and .Serial")
+	asJSON := flag.Bool("json", false, "publish JSON objects instead of plain values")
+	retain := flag.Bool("retain", true, "retain the state messages")
+	qos := flag.Uint("qos", 0, "QoS of published messages, 0 or 1")
+	discovery := flag.String("discovery-prefix", mqtt.DefaultDiscoveryPrefix, "topic `prefix` of Home Assistant discovery, empty to disable discovery")
+	interval := flag.Duration("interval", 10*time.Second, "interval of polling the SunSpec devices")
+	meters := flag.Bool("meter", false, "publish the values of SMA energy meters")
+	meterInterval := flag.Duration("meter-interval", 10*time.Second, "minimum interval between the publications of an energy meter")
+	iface := flag.String("interface", "", "network `interface` receiving energy meter telegrams, the one chosen by the system by default")
+	unicast := flag.String("unicast", "", "receive unicast energy meter telegrams on the `address` instead of the multicast group")
+	flag.Parse()
+
+	if *broker == "" || len(targets) == 0 && !*meters {
+		fmt.Fprintln(os.Stderr, "goenergy-mqtt: a broker and at least one device, -sunspec or -meter, are required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	client, err := mqtt.Dial(*broker, mqtt.WithClientID(*clientID), mqtt.WithCredentials(*username, *password))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	format := mqtt.FormatPlain
+	if *asJSON {
+		format = mqtt.FormatJSON
+	}
+	publisher, err := mqtt.NewPublisher(client,
+		mqtt.WithTopicTemplate(*topic),
+		mqtt.WithFormat(format),
+		mqtt.WithRetain(*retain),
+		mqtt.WithQoS(byte(*qos)),
+		mqtt.WithDiscoveryPrefix(*discovery),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errs := make(chan error, len(targets)+1)
+	for _, t := range targets {
+		go func(t cli.SunSpecTarget) {
+			errs <- pollSunSpec(publisher, t, *interval)
+		}(t)
+	}
+
+	if *meters {
+		opts, err := cli.MeterOptions(*iface, *unicast)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m, err := meter.Listen(opts...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer m.Close()
+
+		go func() {
+			errs <- publishTelegrams(publisher, m, *meterInterval)
+		}()
+	}
+
+	log.Printf("publishing to %v", *broker)
+	log.Fatal(<-errs)
+}
+
+// pollSunSpec publishes the values of the SunSpec device every interval, reconnecting after errors.
+//
+// Returns an error only if publishing fails.
+func pollSunSpec(publisher *mqtt.Publisher, t cli.SunSpecTarget, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var d *sunspec.ModbusDevice
+	for ; ; <-ticker.C {
+		if d == nil {
+			var err error
+			d, err = sunspec.Connect(t.Addr, modbus.WithTimeout(requestTimeout), modbus.WithoutReconnect())
+			if err != nil {
+				log.Printf("connecting to %v: %v", t.Addr, err)
+				continue
+			}
+			d.SetDeviceAddress(t.UnitID)
+		}
+
+		sensors, err := mqtt.ReadSunSpecSensors(d, mqtt.DefaultSunSpecPoints)
+		if err != nil {
+			log.Printf("reading %v/%v: %v", t.Addr, t.UnitID, err)
+			_ = d.Close()
+			d = nil
+			continue
+		}
+
+		if err := publisher.Publish(sensors...); err != nil {
+			_ = d.Close()
+			return err
+		}
+	}
+}
+
+// publishTelegrams publishes the telegrams of each energy meter at most once per interval.
+func publishTelegrams(publisher *mqtt.Publisher, m *meter.EnergyMeter, interval time.Duration) error {
+	published := make(map[uint32]time.Time)
+	for {
+		t, err := m.ReadTelegram()
+		if meter.IsSkippable(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if now.Sub(published[t.SerialNo]) < interval {
+			continue
+		}
+		published[t.SerialNo] = now
+
+		if err := publisher.PublishTelegram(t); err != nil {
+			return err
+		}
+	}
+}
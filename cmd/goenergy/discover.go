@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/discovery"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+func runDiscover(args []string, out io.Writer) error {
+	fs := newFlagSet("discover")
+	timeout := fs.Duration("timeout", 3*time.Second, "time to wait for responses")
+	interfaces := fs.String("interface", "", "comma separated `names` of the interfaces to discover on, all by default")
+	asJSON := fs.Bool("json", false, "print the devices as JSON")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	ifis, err := parseInterfaces(*interfaces)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	devices, err := discovery.DiscoverDevicesContext(ctx, ifis...)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(devices)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "IP\tSUSYID\tSERIAL\tCLASS\tNAME\tVERSION")
+	for _, d := range devices {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", d.IP, d.SusyID, d.SerialNo, d.DeviceClass, d.Name, d.SoftwareVersion)
+	}
+	return w.Flush()
+}
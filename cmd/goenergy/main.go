@@ -0,0 +1,130 @@
+// Command goenergy discovers, scans and reads SMA Speedwire, SunSpec and energy meter devices.
+//
+// Usage:
+//
+//	goenergy <command> [flags] [arguments]
+//
+// Run goenergy without arguments to list the commands, and goenergy <command> -h for the flags of a command.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// command is a subcommand of goenergy.
+type command struct {
+	name        string
+	usage       string
+	description string
+	run         func(args []string, out io.Writer) error
+}
+
+// commands contains the subcommands, it is set in init as the commands refer to it for their usage.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"discover", "discover [flags]", "discover SMA devices using Speedwire", runDiscover},
+		{"scan", "scan [flags] <addr|cidr>", "list the SunSpec models of a device or scan a network for SunSpec devices", runScan},
+		{"read", "read [flags] <addr> <model> <point>", "read a SunSpec point", runRead},
+		{"dump", "dump [flags] <addr>", "dump all SunSpec models of a device", runDump},
+		{"meter", "meter [flags]", "print the telegrams of SMA energy meters", runMeter},
+		{"write", "write [flags] <addr> <model> <point> <value>", "write a SunSpec point", runWrite},
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: goenergy <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.description)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	for _, c := range commands {
+		if c.name != os.Args[1] {
+			continue
+		}
+
+		err := c.run(os.Args[2:], os.Stdout)
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "goenergy %v: %v\n", c.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "goenergy: unknown command %q\n\n", os.Args[1])
+	usage(os.Stderr)
+	os.Exit(2)
+}
+
+// newFlagSet creates the flag set of a command.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		for _, c := range commands {
+			if c.name == name {
+				fmt.Fprintf(fs.Output(), "Usage: goenergy %v\n\n%v\n\nFlags:\n", c.usage, c.description)
+			}
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses the flags and checks the number of remaining arguments.
+func parseArgs(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != n {
+		fs.Usage()
+		return nil, fmt.Errorf("expected %d arguments, got %d", n, fs.NArg())
+	}
+	return fs.Args(), nil
+}
+
+// parseInterfaces returns the network interfaces of a comma separated list of names.
+func parseInterfaces(names string) ([]*net.Interface, error) {
+	if names == "" {
+		return nil, nil
+	}
+
+	var interfaces []*net.Interface
+	for _, name := range strings.Split(names, ",") {
+		ifi, err := net.InterfaceByName(strings.TrimSpace(name))
+		if err != nil {
+			return nil, fmt.Errorf("interface %q: %w", name, err)
+		}
+		interfaces = append(interfaces, ifi)
+	}
+
+	return interfaces, nil
+}
+
+// parseUint16 parses a model or point argument.
+func parseUint16(name, s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v %q", name, s)
+	}
+	return uint16(v), nil
+}
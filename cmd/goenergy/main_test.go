@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/meter"
+	"github.com/orlopau/go-energy/pkg/pcap"
+	"github.com/phayes/freeport"
+	"github.com/tbrandon/mbserver"
+	"os"
+	"strings"
+	"testing"
+)
+
+// setupDevice starts a modbus server with a common model and a three phase inverter model.
+func setupDevice(t *testing.T) (*mbserver.Server, string) {
+	p, err := freeport.GetFreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := mbserver.NewServer()
+	addr := fmt.Sprintf("127.0.0.1:%v", p)
+	if err := server.ListenTCP(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	r := server.HoldingRegisters
+	r[0], r[1] = 0x5375, 0x6e53
+
+	// common model at 2
+	r[2], r[3] = 1, 66
+	copy(r[4:], stringRegisters("SMA", 16))
+	copy(r[20:], stringRegisters("STP 10.0", 16))
+	copy(r[52:], stringRegisters("3000123456", 16))
+	r[68] = 126
+
+	// inverter model at 70, power 1234 W with scale factor 0
+	r[70], r[71] = 103, 50
+	r[70+14] = 1234
+	r[70+15] = 0
+
+	r[122], r[123] = 0xffff, 0
+
+	return server, addr
+}
+
+// bytesRegisters returns the big endian registers of the buffer.
+func bytesRegisters(b []byte) []uint16 {
+	r := make([]uint16, len(b)/2)
+	for i := range r {
+		r[i] = binary.BigEndian.Uint16(b[i*2:])
+	}
+	return r
+}
+
+func stringRegisters(s string, words int) []uint16 {
+	b := make([]byte, words*2)
+	copy(b, s)
+	return bytesRegisters(b)
+}
+
+func TestRunRead(t *testing.T) {
+	server, addr := setupDevice(t)
+	defer server.Close()
+
+	tests := map[string]struct {
+		args []string
+		want string
+	}{
+		"uint16":       {[]string{addr, "1", "66"}, "126"},
+		"scaled":       {[]string{"-type", "int16", "-scaled", addr, "103", "14"}, "1234"},
+		"string":       {[]string{"-type", "string", "-words", "16", addr, "1", "2"}, "SMA"},
+		"other unit":   {[]string{"-unit", "3", addr, "103", "1"}, "50"},
+		"address unit": {[]string{"-unit", "1", addr + "/3", "103", "1"}, "50"},
+		"scale point":  {[]string{"-type", "int16", "-scale-point", "15", addr, "103", "14"}, "1234"},
+		"unknown type": {[]string{"-type", "int8", addr, "1", "66"}, ""},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := runRead(test.args, &out)
+			if test.want == "" {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(out.String()); got != test.want {
+				t.Fatalf("want %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestRunWrite(t *testing.T) {
+	server, addr := setupDevice(t)
+	defer server.Close()
+
+	if err := runWrite([]string{"-type", "int16", addr, "103", "14", "-42"}, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := int16(server.HoldingRegisters[70+14]); v != -42 {
+		t.Fatalf("want %v, got %v", -42, v)
+	}
+}
+
+func TestRunScan(t *testing.T) {
+	server, addr := setupDevice(t)
+	defer server.Close()
+
+	var out bytes.Buffer
+	if err := runScan([]string{"-json", addr}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	var models []struct {
+		ID      uint16
+		Address uint16
+	}
+	if err := json.Unmarshal(out.Bytes(), &models); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(models) != 2 || models[0].ID != 1 || models[0].Address != 2 || models[1].ID != 103 || models[1].Address != 70 {
+		t.Fatalf("unexpected models %+v", models)
+	}
+}
+
+func TestRunDump(t *testing.T) {
+	server, addr := setupDevice(t)
+	defer server.Close()
+
+	var out bytes.Buffer
+	if err := runDump([]string{"-json", addr}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	var models []dumpedModel
+	if err := json.Unmarshal(out.Bytes(), &models); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(models) != 2 {
+		t.Fatalf("expected 2 models, got %v", len(models))
+	}
+	if c := models[0].Common; c == nil || c.Manufacturer != "SMA" || c.SerialNumber != "3000123456" {
+		t.Fatalf("unexpected common model %+v", c)
+	}
+	if models[0].Points["device_address"] != 126 {
+		t.Fatalf("unexpected points %v", models[0].Points)
+	}
+	if len(models[1].Registers) != 50 || models[1].Registers[12] != 1234 {
+		t.Fatalf("unexpected registers %v", models[1].Registers)
+	}
+	if models[1].Points["power"] != 1234 {
+		t.Fatalf("unexpected points %v", models[1].Points)
+	}
+}
+
+func TestPrintTelegrams(t *testing.T) {
+	f, err := os.Open("../../pkg/meter/testdata/telegrams.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	conn, err := pcap.NewReplayConn(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Port = 9522
+
+	var out bytes.Buffer
+	if err := printTelegrams(&meter.EnergyMeter{Conn: conn}, 0, 3, true, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := json.NewDecoder(&out)
+	for i := 0; i < 3; i++ {
+		var telegram decodedTelegram
+		if err := dec.Decode(&telegram); err != nil {
+			t.Fatal(err)
+		}
+		if len(telegram.Values) == 0 {
+			t.Fatal("telegram has no values")
+		}
+	}
+}
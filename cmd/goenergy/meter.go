@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/orlopau/go-energy/internal/cli"
+	"github.com/orlopau/go-energy/pkg/meter"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// telegramValue is a decoded value of a telegram.
+type telegramValue struct {
+	OBIS  meter.OBISIdentifier `json:"obis"`
+	Name  string               `json:"name,omitempty"`
+	Value float64              `json:"value"`
+	Unit  string               `json:"unit,omitempty"`
+}
+
+// decodedTelegram is a telegram printed by meter.
+type decodedTelegram struct {
+	SusyID        uint16          `json:"susy_id"`
+	SerialNo      uint32          `json:"serial_no"`
+	MeasuringTime uint32          `json:"measuring_time"`
+	Values        []telegramValue `json:"values"`
+}
+
+// decodeValues converts the values of a telegram into their units, ordered by identifier.
+func decodeValues(t *meter.EnergyMeterTelegram) []telegramValue {
+	values := make([]telegramValue, 0, len(t.Obis))
+	for id := range t.Obis {
+		v, _ := t.Value(id)
+		info, _ := meter.LookupOBIS(id)
+		values = append(values, telegramValue{OBIS: id, Name: info.Name, Value: v, Unit: info.Unit})
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		a, b := values[i].OBIS, values[j].OBIS
+		if a.Channel != b.Channel {
+			return a.Channel < b.Channel
+		}
+		if a.MeasVal != b.MeasVal {
+			return a.MeasVal < b.MeasVal
+		}
+		if a.MeasType != b.MeasType {
+			return a.MeasType < b.MeasType
+		}
+		return a.Tariff < b.Tariff
+	})
+
+	return values
+}
+
+func runMeter(args []string, out io.Writer) error {
+	fs := newFlagSet("meter")
+	iface := fs.String("interface", "", "network `interface` to listen on, the one chosen by the system by default")
+	unicast := fs.String("unicast", "", "listen for unicast telegrams on the `address` instead of the multicast group")
+	serial := fs.Uint("serial", 0, "only print telegrams of the meter with the serial `number`")
+	count := fs.Int("count", 0, "exit after printing `n` telegrams, 0 prints telegrams until interrupted")
+	asJSON := fs.Bool("json", false, "print the telegrams as JSON lines")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	opts, err := cli.MeterOptions(*iface, *unicast)
+	if err != nil {
+		return err
+	}
+
+	m, err := meter.Listen(opts...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	return printTelegrams(m, uint32(*serial), *count, *asJSON, out)
+}
+
+// printTelegrams prints the telegrams read from the meter until count telegrams are printed.
+//
+// Datagrams which are not energy meter telegrams are skipped.
+func printTelegrams(m interface {
+	ReadTelegram() (*meter.EnergyMeterTelegram, error)
+}, serial uint32, count int, asJSON bool, out io.Writer) error {
+	for printed := 0; count == 0 || printed < count; {
+		t, err := m.ReadTelegram()
+		if meter.IsSkippable(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if serial != 0 && t.SerialNo != serial {
+			continue
+		}
+
+		d := decodedTelegram{SusyID: t.SusyID, SerialNo: t.SerialNo, MeasuringTime: t.MeasuringTime, Values: decodeValues(t)}
+		if asJSON {
+			if err := json.NewEncoder(out).Encode(d); err != nil {
+				return err
+			}
+		} else if err := printTelegram(d, out); err != nil {
+			return err
+		}
+		printed++
+	}
+
+	return nil
+}
+
+func printTelegram(t decodedTelegram, out io.Writer) error {
+	fmt.Fprintf(out, "meter %v:%v at %v ms\n", t.SusyID, t.SerialNo, t.MeasuringTime)
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	for _, v := range t.Values {
+		fmt.Fprintf(w, "  %v\t%v\t%v\t%v\n", v.OBIS, v.Name, v.Value, v.Unit)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintln(out)
+	return err
+}
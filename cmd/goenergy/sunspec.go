@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/discovery"
+	"github.com/orlopau/go-energy/pkg/modbus"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// maxReadRegisters is the number of registers read at once while dumping.
+const maxReadRegisters = 100
+
+// namedPoint is a known SunSpec point decoded by dump.
+type namedPoint struct {
+	name  string
+	point sunspec.Point
+}
+
+var knownPoints = []namedPoint{
+	{"power", sunspec.PointPower1Phase},
+	{"power", sunspec.PointPower2Phase},
+	{"power", sunspec.PointPower3Phase},
+	{"energy", sunspec.PointEnergy1Phase},
+	{"energy", sunspec.PointEnergy2Phase},
+	{"energy", sunspec.PointEnergy3Phase},
+	{"soc", sunspec.PointSoc},
+	{"soc", sunspec.PointBatterySoc},
+	{"battery_power", sunspec.PointBatteryPower},
+	{"device_address", sunspec.PointDeviceAddress},
+}
+
+// sunSpecFlags are the flags of commands connecting to a single SunSpec device.
+type sunSpecFlags struct {
+	unit    *uint
+	timeout *time.Duration
+}
+
+func addSunSpecFlags(fs *flag.FlagSet) sunSpecFlags {
+	return sunSpecFlags{
+		unit:    fs.Uint("unit", 1, "modbus unit `id` of the device"),
+		timeout: fs.Duration("timeout", 5*time.Second, "timeout of modbus requests"),
+	}
+}
+
+// connect connects to the SunSpec device at the address host[:port][/unit], see modbus.ParseAddress. A unit id of
+// the address takes precedence over the unit flag.
+func (f sunSpecFlags) connect(s string) (*sunspec.ModbusDevice, error) {
+	addr, unitID, err := modbus.ParseAddress(s)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(s, "/") {
+		unitID = byte(*f.unit)
+	}
+
+	d, err := sunspec.Connect(addr, modbus.WithTimeout(*f.timeout), modbus.WithoutReconnect())
+	if err != nil {
+		return nil, err
+	}
+	d.SetDeviceAddress(unitID)
+
+	return d, nil
+}
+
+func runScan(args []string, out io.Writer) error {
+	fs := newFlagSet("scan")
+	f := addSunSpecFlags(fs)
+	ports := fs.String("ports", "", "comma separated modbus `ports` probed when scanning a network, 502 and 1502 by default")
+	units := fs.String("units", "", "comma separated unit `ids` probed when scanning a network, 1, 3, 71 and 126 by default")
+	scanTimeout := fs.Duration("scan-timeout", 5*time.Minute, "maximum duration of a network scan")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	args, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if strings.Contains(args[0], "/") {
+		return scanNetwork(args[0], *ports, *units, *scanTimeout, *asJSON, out)
+	}
+
+	d, err := f.connect(args[0])
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	models, err := d.Models()
+	if err != nil {
+		return err
+	}
+
+	type model struct {
+		ID      uint16 `json:"id"`
+		Address uint16 `json:"address"`
+	}
+	result := make([]model, len(models))
+	for i, id := range models {
+		address, err := d.Converter.GetAddress(id)
+		if err != nil {
+			return err
+		}
+		result[i] = model{ID: id, Address: address}
+	}
+
+	if *asJSON {
+		return encodeJSON(out, result)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tADDRESS")
+	for _, m := range result {
+		fmt.Fprintf(w, "%v\t%v\n", m.ID, m.Address)
+	}
+	return w.Flush()
+}
+
+func scanNetwork(cidr, ports, units string, timeout time.Duration, asJSON bool, out io.Writer) error {
+	var portList []int
+	if ports != "" {
+		for _, p := range strings.Split(ports, ",") {
+			port, err := strconv.Atoi(strings.TrimSpace(p))
+			if err != nil {
+				return fmt.Errorf("invalid port %q", p)
+			}
+			portList = append(portList, port)
+		}
+	}
+
+	var unitList []byte
+	if units != "" {
+		for _, u := range strings.Split(units, ",") {
+			unit, err := strconv.ParseUint(strings.TrimSpace(u), 0, 8)
+			if err != nil {
+				return fmt.Errorf("invalid unit id %q", u)
+			}
+			unitList = append(unitList, byte(unit))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	devices, err := discovery.ScanSunSpec(ctx, cidr, portList, unitList)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		return encodeJSON(out, devices)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tUNIT\tMANUFACTURER\tMODEL\tSERIAL\tVERSION\tMODELS")
+	for _, d := range devices {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", net.JoinHostPort(d.IP.String(), strconv.Itoa(d.Port)), d.UnitID,
+			d.Common.Manufacturer, d.Common.Model, d.Common.SerialNumber, d.Common.Version, d.Models)
+	}
+	return w.Flush()
+}
+
+func runRead(args []string, out io.Writer) error {
+	fs := newFlagSet("read")
+	f := addSunSpecFlags(fs)
+	typ := fs.String("type", "uint16", "`type` of the point: uint16, int16, uint32, int32, float32, float64 or string")
+	words := fs.Uint("words", 1, "number of registers of a string point")
+	scaled := fs.Bool("scaled", false, "apply the scale factor following the point")
+	scalePoint := fs.Uint("scale-point", 0, "`point` of the scale factor if it does not follow the point")
+	args, err := parseArgs(fs, args, 3)
+	if err != nil {
+		return err
+	}
+
+	model, err := parseUint16("model", args[1])
+	if err != nil {
+		return err
+	}
+	point, err := parseUint16("point", args[2])
+	if err != nil {
+		return err
+	}
+
+	var t interface{}
+	if *typ != "string" {
+		if t, err = sunspec.PointType(*typ); err != nil {
+			return err
+		}
+	}
+
+	d, err := f.connect(args[0])
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if *typ == "string" {
+		s, err := d.ReadString(model, point, uint16(*words))
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, s)
+		return err
+	}
+
+	v, err := d.GetAnyPoint(sunspec.Point{
+		Model:      model,
+		Point:      point,
+		T:          t,
+		Scaled:     *scaled || *scalePoint != 0,
+		ScalePoint: uint16(*scalePoint),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(out, strconv.FormatFloat(v, 'f', -1, 64))
+	return err
+}
+
+func runWrite(args []string, out io.Writer) error {
+	fs := newFlagSet("write")
+	f := addSunSpecFlags(fs)
+	typ := fs.String("type", "uint16", "`type` of the point: uint16, int16, uint32, int32, float32 or float64")
+	args, err := parseArgs(fs, args, 4)
+	if err != nil {
+		return err
+	}
+
+	model, err := parseUint16("model", args[1])
+	if err != nil {
+		return err
+	}
+	point, err := parseUint16("point", args[2])
+	if err != nil {
+		return err
+	}
+	v, err := sunspec.ParseValue(*typ, args[3])
+	if err != nil {
+		return err
+	}
+
+	d, err := f.connect(args[0])
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if err := d.WritePoint(model, point, v); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "wrote %v to point %v of model %v\n", v, point, model)
+	return err
+}
+
+// dumpedModel is a SunSpec model read by dump.
+type dumpedModel struct {
+	ID      uint16 `json:"id"`
+	Address uint16 `json:"address"`
+	Length  uint16 `json:"length"`
+	// Points contains the values of the known points of the model.
+	Points    map[string]float64   `json:"points,omitempty"`
+	Common    *sunspec.CommonModel `json:"common,omitempty"`
+	Registers []uint16             `json:"registers"`
+}
+
+func runDump(args []string, out io.Writer) error {
+	fs := newFlagSet("dump")
+	f := addSunSpecFlags(fs)
+	asJSON := fs.Bool("json", false, "print the models as JSON")
+	args, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	d, err := f.connect(args[0])
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	models, err := dumpModels(d)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return encodeJSON(out, models)
+	}
+
+	for _, m := range models {
+		fmt.Fprintf(out, "model %v (address %v, length %v)\n", m.ID, m.Address, m.Length)
+		if m.Common != nil {
+			fmt.Fprintf(out, "  manufacturer: %v\n  model: %v\n  options: %v\n  version: %v\n  serial number: %v\n",
+				m.Common.Manufacturer, m.Common.Model, m.Common.Options, m.Common.Version, m.Common.SerialNumber)
+		}
+		for name, v := range m.Points {
+			fmt.Fprintf(out, "  %v: %v\n", name, v)
+		}
+		for i := 0; i < len(m.Registers); i += 8 {
+			end := i + 8
+			if end > len(m.Registers) {
+				end = len(m.Registers)
+			}
+			fmt.Fprintf(out, "  %4d:", i+2)
+			for _, r := range m.Registers[i:end] {
+				fmt.Fprintf(out, " %04x", r)
+			}
+			fmt.Fprintln(out)
+		}
+	}
+
+	return nil
+}
+
+// dumpModels reads the registers of all models of the device and decodes the known points.
+func dumpModels(d *sunspec.ModbusDevice) ([]dumpedModel, error) {
+	ids, err := d.Models()
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]dumpedModel, 0, len(ids))
+	for _, id := range ids {
+		address, err := d.Converter.GetAddress(id)
+		if err != nil {
+			return nil, err
+		}
+		length, err := d.ReadPointUint16(id, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		m := dumpedModel{ID: id, Address: address, Length: length, Registers: make([]uint16, length)}
+		for i := 0; i < len(m.Registers); i += maxReadRegisters {
+			end := i + maxReadRegisters
+			if end > len(m.Registers) {
+				end = len(m.Registers)
+			}
+			if err := d.ReadInto(id, uint16(2+i), m.Registers[i:end]); err != nil {
+				return nil, err
+			}
+		}
+
+		if id == sunspec.ModelCommon {
+			if common, err := d.ReadCommonModel(); err == nil {
+				m.Common = &common
+			}
+		}
+
+		for _, p := range knownPoints {
+			if p.point.Model != id {
+				continue
+			}
+			if v, err := d.GetAnyPoint(p.point); err == nil {
+				if m.Points == nil {
+					m.Points = make(map[string]float64)
+				}
+				m.Points[p.name] = v
+			}
+		}
+
+		models = append(models, m)
+	}
+
+	return models, nil
+}
+
+func encodeJSON(out io.Writer, v interface{}) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
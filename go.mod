@@ -1,15 +1,20 @@
 module github.com/orlopau/go-energy
 
-go 1.15
+go 1.18
 
 require (
-	github.com/goburrow/modbus v0.1.0
-	github.com/goburrow/serial v0.1.0 // indirect
 	github.com/phayes/freeport v0.0.0-20180830031419-95f893ade6f2
 	github.com/pkg/errors v0.9.1
 	github.com/tbrandon/mbserver v0.0.0-20170611213546-993e1772cc62
-	github.com/xiegeo/modbusone v0.2.4-0.20200428173500-797d647e237d
 	go.uber.org/goleak v1.1.10
 	golang.org/x/net v0.0.0-20201216054612-986b41b23924
-	gopkg.in/yaml.v2 v2.2.3 // indirect
+	gopkg.in/yaml.v2 v2.2.3
+)
+
+require (
+	github.com/goburrow/modbus v0.1.0 // indirect
+	github.com/goburrow/serial v0.1.0 // indirect
+	golang.org/x/lint v0.0.0-20190930215403-16217165b5de // indirect
+	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68 // indirect
+	golang.org/x/tools v0.0.0-20191108193012-7d206e10da11 // indirect
 )
@@ -0,0 +1,60 @@
+// Provides the command line flags shared by the goenergy commands.
+package cli
+
+import (
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/meter"
+	"github.com/orlopau/go-energy/pkg/modbus"
+	"net"
+	"strings"
+)
+
+// SunSpecTarget is a SunSpec device given on the command line.
+type SunSpecTarget struct {
+	Addr   string
+	UnitID byte
+}
+
+// SunSpecFlag collects the SunSpec devices of a repeated flag, given as host[:port][/unit], see
+// modbus.ParseAddress.
+type SunSpecFlag []SunSpecTarget
+
+func (f *SunSpecFlag) String() string {
+	targets := make([]string, len(*f))
+	for i, t := range *f {
+		targets[i] = fmt.Sprintf("%v/%v", t.Addr, t.UnitID)
+	}
+	return strings.Join(targets, ",")
+}
+
+func (f *SunSpecFlag) Set(s string) error {
+	addr, unitID, err := modbus.ParseAddress(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, SunSpecTarget{Addr: addr, UnitID: unitID})
+	return nil
+}
+
+// MeterOptions returns the options for listening to energy meter telegrams.
+//
+// Telegrams are received on the multicast group of the network interface with the name, or of the interface
+// chosen by the system if it is empty. If unicast is not empty, unicast telegrams are received on that address
+// instead.
+func MeterOptions(iface, unicast string) ([]meter.ListenOption, error) {
+	if unicast != "" {
+		return []meter.ListenOption{meter.WithUnicast(unicast)}, nil
+	}
+	if iface == "" {
+		return nil, nil
+	}
+	if strings.Contains(iface, ",") {
+		return nil, fmt.Errorf("energy meter telegrams are received on a single interface, got %q", iface)
+	}
+
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("interface %q: %w", iface, err)
+	}
+	return []meter.ListenOption{meter.WithInterface(ifi)}, nil
+}
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSunSpecFlag_Set(t *testing.T) {
+	tests := map[string]struct {
+		s    string
+		want SunSpecTarget
+		err  bool
+	}{
+		"host":         {s: "192.168.1.10", want: SunSpecTarget{"192.168.1.10:502", 1}},
+		"port":         {s: "192.168.1.10:1502", want: SunSpecTarget{"192.168.1.10:1502", 1}},
+		"unit":         {s: "inverter.local/126", want: SunSpecTarget{"inverter.local:502", 126}},
+		"port unit":    {s: "192.168.1.10:1502/3", want: SunSpecTarget{"192.168.1.10:1502", 3}},
+		"ipv6":         {s: "[fe80::1]:502/3", want: SunSpecTarget{"[fe80::1]:502", 3}},
+		"invalid unit": {s: "192.168.1.10/300", err: true},
+		"no host":      {s: "/3", err: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var f SunSpecFlag
+			err := f.Set(test.s)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected error, got %v", f)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(f) != 1 || f[0] != test.want {
+				t.Fatalf("want %v, got %v", test.want, f)
+			}
+		})
+	}
+}
+
+func TestSunSpecFlag_Repeated(t *testing.T) {
+	var f SunSpecFlag
+	for _, s := range []string{"192.168.1.10", "192.168.1.11:1502/3"} {
+		if err := f.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if want := "192.168.1.10:502/1,192.168.1.11:1502/3"; f.String() != want {
+		t.Fatalf("want %v, got %v", want, f.String())
+	}
+}
+
+// loopbackName returns the name of the loopback interface.
+func loopbackName(t *testing.T) string {
+	ifis, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ifi := range ifis {
+		if ifi.Flags&net.FlagLoopback != 0 {
+			return ifi.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestMeterOptions(t *testing.T) {
+	lo := loopbackName(t)
+
+	tests := map[string]struct {
+		iface, unicast string
+		options        int
+		err            bool
+	}{
+		"default interface":  {},
+		"interface":          {iface: lo, options: 1},
+		"unicast":            {iface: lo, unicast: ":9522", options: 1},
+		"several interfaces": {iface: lo + "," + lo, err: true},
+		"unknown interface":  {iface: "goenergy-unknown", err: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts, err := MeterOptions(test.iface, test.unicast)
+			if test.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(opts) != test.options {
+				t.Fatalf("expected %v options, got %v", test.options, len(opts))
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+// Provides an embeddable HTTP/JSON API exposing SunSpec devices, discovered SMA devices and energy meter telegrams.
+//
+// The Server serves the following endpoints relative to its mount point:
+//
+//	GET /devices                               all devices
+//	GET /devices/{id}                          a device, including the common and model map of SunSpec devices
+//	GET /devices/{id}/models                   the model map of a SunSpec device
+//	GET /devices/{id}/values                   the current values of a SunSpec device
+//	GET /devices/{id}/points/{model}/{point}   a point value, see the query parameters type, scaled and scale_point
+//	PUT /devices/{id}/points/{model}/{point}   writes a point, requires the token of WithToken
+//	GET /meters                                the latest telegrams of all energy meters
+//	GET /meters/{serial}                       the latest telegram of an energy meter
+//	GET /meters/events                         a Server-Sent Events stream of telegrams
+//
+// Errors are returned as JSON object with an error field.
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	errNotFound       = errors.New("not found")
+	errWritesDisabled = errors.New("writes are disabled")
+	errUnauthorized   = errors.New("invalid or missing token")
+)
+
+// statusError is an error with the HTTP status of its response.
+type statusError struct {
+	status int
+	err    error
+	// allow are the allowed methods of a response with status 405
+	allow string
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// withStatus annotates the error with the HTTP status of its response.
+func withStatus(status int, err error) error {
+	return &statusError{status: status, err: err}
+}
+
+// methodNotAllowed returns the error of a request with a method other than the allowed methods.
+func methodNotAllowed(r *http.Request, allow ...string) error {
+	return &statusError{
+		status: http.StatusMethodNotAllowed,
+		err:    fmt.Errorf("method %v not allowed", r.Method),
+		allow:  strings.Join(allow, ", "),
+	}
+}
+
+// Device is an entry of the device list.
+type Device struct {
+	ID string `json:"id"`
+	// Protocol is sunspec, speedwire or energy_meter.
+	Protocol string `json:"protocol"`
+	Address  string `json:"address,omitempty"`
+	SerialNo string `json:"serial_no,omitempty"`
+	Name     string `json:"name,omitempty"`
+}
+
+// Server is an http.Handler serving the API, see the package documentation for the endpoints.
+//
+// Devices may be added while serving. Closing the server closes all added devices.
+type Server struct {
+	token string
+
+	mu         sync.Mutex
+	sunSpec    map[string]*sunSpecDevice
+	discovered map[string]SMADevice
+	meters     map[uint32]Snapshot
+	listeners  map[chan Snapshot]struct{}
+	closers    []func() error
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithToken enables writing points for requests with the bearer token, writes are disabled by default.
+func WithToken(token string) Option {
+	return func(s *Server) {
+		s.token = token
+	}
+}
+
+// New creates a server without devices.
+func New(opts ...Option) *Server {
+	s := &Server{
+		sunSpec:    make(map[string]*sunSpecDevice),
+		discovered: make(map[string]SMADevice),
+		meters:     make(map[uint32]Snapshot),
+		listeners:  make(map[chan Snapshot]struct{}),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// ServeHTTP routes the request to the endpoint of its path.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	var err error
+	switch {
+	case path[0] == "devices" && len(path) == 1:
+		err = allowGet(r, func() error { return writeJSON(w, s.devices()) })
+	case path[0] == "devices":
+		err = s.serveDevice(w, r, path[1], path[2:])
+	case path[0] == "meters" && len(path) == 1:
+		err = allowGet(r, func() error { return writeJSON(w, s.snapshots()) })
+	case path[0] == "meters" && len(path) == 2 && path[1] == "events":
+		err = allowGet(r, func() error { return s.serveEvents(w, r) })
+	case path[0] == "meters" && len(path) == 2:
+		err = allowGet(r, func() error { return s.serveMeter(w, path[1]) })
+	default:
+		err = withStatus(http.StatusNotFound, errNotFound)
+	}
+
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+// devices returns all devices ordered by id.
+func (s *Server) devices() []Device {
+	s.mu.Lock()
+	devices := make([]Device, 0, len(s.sunSpec)+len(s.discovered)+len(s.meters))
+	for _, d := range s.sunSpec {
+		devices = append(devices, Device{ID: d.id, Protocol: "sunspec"})
+	}
+	for _, d := range s.discovered {
+		devices = append(devices, d.Device)
+	}
+	for _, m := range s.meters {
+		devices = append(devices, m.info())
+	}
+	s.mu.Unlock()
+
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices
+}
+
+// serveDevice serves the endpoints of a device.
+func (s *Server) serveDevice(w http.ResponseWriter, r *http.Request, id string, path []string) error {
+	s.mu.Lock()
+	d, isSunSpec := s.sunSpec[id]
+	discovered, isDiscovered := s.discovered[id]
+	snapshot, isMeter := s.meterByID(id)
+	s.mu.Unlock()
+
+	switch {
+	case isSunSpec:
+		return s.serveSunSpec(w, r, d, path)
+	case isDiscovered && len(path) == 0:
+		return allowGet(r, func() error { return writeJSON(w, discovered) })
+	case isMeter && len(path) == 0:
+		return allowGet(r, func() error { return writeJSON(w, snapshot) })
+	default:
+		return withStatus(http.StatusNotFound, errNotFound)
+	}
+}
+
+// allowGet serves GET and HEAD requests, rejecting other methods.
+func allowGet(r *http.Request, serve func() error) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return methodNotAllowed(r, http.MethodGet, http.MethodHead)
+	}
+	return serve()
+}
+
+// authorize checks the bearer token of the request.
+func (s *Server) authorize(r *http.Request) error {
+	if s.token == "" {
+		return withStatus(http.StatusForbidden, errWritesDisabled)
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		return withStatus(http.StatusUnauthorized, errUnauthorized)
+	}
+	return nil
+}
+
+// writeJSON writes the value as JSON, only encoding errors are returned as the response is written afterwards.
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(b, '\n'))
+	return nil
+}
+
+// writeError writes the error with the status of a statusError, points which are not implemented by a device
+// are not found and other errors are errors of the device.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadGateway
+	var serr *statusError
+	switch {
+	case errors.As(err, &serr):
+		status = serr.status
+	case errors.Is(err, sunspec.ErrPointNotImplemented):
+		status = http.StatusNotFound
+	}
+
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	if serr != nil && serr.allow != "" {
+		w.Header().Set("Allow", serr.allow)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// addCloser registers a function called when closing the server, returning false if the server is closed.
+func (s *Server) addCloser(close func() error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	s.closers = append(s.closers, close)
+	return true
+}
+
+// Close closes all devices and ends the event streams.
+func (s *Server) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		close(s.done)
+		closers := s.closers
+		s.closers = nil
+		s.mu.Unlock()
+
+		for _, c := range closers {
+			if cerr := c(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+		s.wg.Wait()
+	})
+	return err
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/discovery"
+	"net"
+)
+
+// eventSource emits discovery events, e.g. a *discovery.Watcher.
+type eventSource interface {
+	Events() <-chan discovery.Event
+	Close() error
+}
+
+// SMADevice is a SMA device found by discovery.
+type SMADevice struct {
+	Device
+	SusyID          uint16 `json:"susy_id,omitempty"`
+	DeviceClass     string `json:"device_class,omitempty"`
+	SoftwareVersion string `json:"software_version,omitempty"`
+}
+
+// discoveredID returns the device id of a discovered device, its serial number or its address if the
+// device did not answer the identification.
+func discoveredID(serialNo uint32, ip net.IP) string {
+	if serialNo == 0 {
+		return fmt.Sprintf("sma_%v", ip)
+	}
+	return fmt.Sprintf("sma_%v", serialNo)
+}
+
+func newSMADevice(d discovery.DiscoveredDevice) SMADevice {
+	info := SMADevice{
+		Device: Device{
+			ID:       discoveredID(d.SerialNo, d.IP),
+			Protocol: "speedwire",
+			Address:  d.IP.String(),
+			Name:     d.Name,
+		},
+		SusyID:          d.SusyID,
+		DeviceClass:     d.DeviceClass,
+		SoftwareVersion: d.SoftwareVersion,
+	}
+	if d.SerialNo != 0 {
+		info.SerialNo = fmt.Sprint(d.SerialNo)
+	}
+
+	return info
+}
+
+// AddWatcher serves the devices found by the discovery watcher, devices are removed when they are gone.
+// The watcher is closed when the server is closed.
+func (s *Server) AddWatcher(w eventSource) {
+	if !s.addCloser(w.Close) {
+		_ = w.Close()
+		return
+	}
+
+	s.wg.Add(1)
+	go s.watch(w)
+}
+
+// watch applies the events of the watcher until its events are closed.
+func (s *Server) watch(w eventSource) {
+	defer s.wg.Done()
+
+	for e := range w.Events() {
+		s.mu.Lock()
+		switch e.Type {
+		case discovery.DeviceAppeared:
+			d := newSMADevice(e.Device)
+			s.discovered[d.ID] = d
+		case discovery.DeviceChanged:
+			delete(s.discovered, discoveredID(e.Device.SerialNo, e.PreviousIP))
+			d := newSMADevice(e.Device)
+			s.discovered[d.ID] = d
+		case discovery.DeviceGone:
+			delete(s.discovered, discoveredID(e.Device.SerialNo, e.Device.IP))
+		}
+		s.mu.Unlock()
+	}
+}
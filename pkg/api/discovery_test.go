@@ -0,0 +1,81 @@
+package api
+
+import (
+	"github.com/orlopau/go-energy/pkg/discovery"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// testWatcher emits the events sent to it.
+type testWatcher struct {
+	events chan discovery.Event
+}
+
+func (w *testWatcher) Events() <-chan discovery.Event {
+	return w.events
+}
+
+func (w *testWatcher) Close() error {
+	close(w.events)
+	return nil
+}
+
+// waitForDevices waits until the server lists n devices.
+func waitForDevices(t *testing.T, s *Server, n int) []Device {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		devices := s.devices()
+		if len(devices) == n {
+			return devices
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v devices, got %+v", n, devices)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServer_AddWatcher(t *testing.T) {
+	w := &testWatcher{events: make(chan discovery.Event)}
+	s := New()
+	defer s.Close()
+	s.AddWatcher(w)
+
+	inverter := discovery.DiscoveredDevice{IP: net.IPv4(192, 168, 1, 10), SusyID: 128, SerialNo: 3000123456, Name: "STP 10.0"}
+	unidentified := discovery.DiscoveredDevice{IP: net.IPv4(192, 168, 1, 11)}
+	w.events <- discovery.Event{Type: discovery.DeviceAppeared, Device: inverter}
+	w.events <- discovery.Event{Type: discovery.DeviceAppeared, Device: unidentified}
+
+	devices := waitForDevices(t, s, 2)
+	if devices[0] != (Device{ID: "sma_192.168.1.11", Protocol: "speedwire", Address: "192.168.1.11"}) {
+		t.Fatalf("unexpected device %+v", devices[0])
+	}
+
+	var d SMADevice
+	get(t, s, "/devices/sma_3000123456", http.StatusOK, &d)
+	if d.Address != "192.168.1.10" || d.SerialNo != "3000123456" || d.SusyID != 128 || d.Name != "STP 10.0" {
+		t.Fatalf("unexpected device %+v", d)
+	}
+
+	// the unidentified device gets a new address
+	moved := unidentified
+	moved.IP = net.IPv4(192, 168, 1, 12)
+	w.events <- discovery.Event{Type: discovery.DeviceChanged, Device: moved, PreviousIP: unidentified.IP}
+	w.events <- discovery.Event{Type: discovery.DeviceGone, Device: inverter}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		devices = s.devices()
+		if len(devices) == 1 && devices[0].ID == "sma_192.168.1.12" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected devices %+v", devices)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
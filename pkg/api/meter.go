@@ -0,0 +1,221 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/meter"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// eventBuffer is the number of telegrams buffered for an event stream, telegrams are dropped for slow clients.
+	eventBuffer = 16
+	// keepAliveInterval is the interval of comments sent on idle event streams.
+	keepAliveInterval = 15 * time.Second
+)
+
+// telegramReader reads energy meter telegrams, e.g. a *meter.EnergyMeter.
+type telegramReader interface {
+	ReadTelegram() (*meter.EnergyMeterTelegram, error)
+	Close() error
+}
+
+// TelegramValue is a value of a telegram converted into its unit.
+type TelegramValue struct {
+	OBIS  meter.OBISIdentifier `json:"obis"`
+	Name  string               `json:"name,omitempty"`
+	Value float64              `json:"value"`
+	Unit  string               `json:"unit,omitempty"`
+}
+
+// Snapshot is the latest telegram of an energy meter.
+type Snapshot struct {
+	SerialNo uint32          `json:"serial_no"`
+	SusyID   uint16          `json:"susy_id"`
+	Received time.Time       `json:"received"`
+	Values   []TelegramValue `json:"values"`
+}
+
+// newSnapshot converts the values of the telegram, ordered by identifier.
+func newSnapshot(t *meter.EnergyMeterTelegram, received time.Time) Snapshot {
+	values := make([]TelegramValue, 0, len(t.Obis))
+	for id := range t.Obis {
+		v, _ := t.Value(id)
+		info, _ := meter.LookupOBIS(id)
+		values = append(values, TelegramValue{OBIS: id, Name: info.Name, Value: v, Unit: info.Unit})
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		a, b := values[i].OBIS, values[j].OBIS
+		if a.Channel != b.Channel {
+			return a.Channel < b.Channel
+		}
+		if a.MeasVal != b.MeasVal {
+			return a.MeasVal < b.MeasVal
+		}
+		if a.MeasType != b.MeasType {
+			return a.MeasType < b.MeasType
+		}
+		return a.Tariff < b.Tariff
+	})
+
+	return Snapshot{SerialNo: t.SerialNo, SusyID: t.SusyID, Received: received, Values: values}
+}
+
+// meterID returns the device id of the energy meter with the serial number.
+func meterID(serialNo uint32) string {
+	return fmt.Sprintf("sma_em_%v", serialNo)
+}
+
+func (s Snapshot) info() Device {
+	return Device{ID: meterID(s.SerialNo), Protocol: "energy_meter", SerialNo: fmt.Sprint(s.SerialNo)}
+}
+
+// AddEnergyMeter starts reading the telegrams of the energy meter connection, serving the latest telegram of
+// every meter sending telegrams to it. The connection is closed when the server is closed.
+func (s *Server) AddEnergyMeter(m telegramReader) {
+	if !s.addCloser(m.Close) {
+		_ = m.Close()
+		return
+	}
+
+	s.wg.Add(1)
+	go s.readTelegrams(m)
+}
+
+// readTelegrams stores and publishes the telegrams of the reader until the connection is closed.
+func (s *Server) readTelegrams(m telegramReader) {
+	defer s.wg.Done()
+
+	for {
+		t, err := m.ReadTelegram()
+		if meter.IsSkippable(err) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		snapshot := newSnapshot(t, time.Now())
+
+		s.mu.Lock()
+		s.meters[t.SerialNo] = snapshot
+		for l := range s.listeners {
+			select {
+			case l <- snapshot:
+			default:
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// snapshots returns the latest telegrams of all meters ordered by serial number, guarded by the mutex.
+func (s *Server) snapshots() []Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshots := make([]Snapshot, 0, len(s.meters))
+	for _, m := range s.meters {
+		snapshots = append(snapshots, m)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].SerialNo < snapshots[j].SerialNo })
+
+	return snapshots
+}
+
+// meterByID returns the snapshot of the meter with the device id, the caller must hold the mutex.
+func (s *Server) meterByID(id string) (Snapshot, bool) {
+	if !strings.HasPrefix(id, "sma_em_") {
+		return Snapshot{}, false
+	}
+	serialNo, err := strconv.ParseUint(strings.TrimPrefix(id, "sma_em_"), 10, 32)
+	if err != nil {
+		return Snapshot{}, false
+	}
+
+	snapshot, ok := s.meters[uint32(serialNo)]
+	return snapshot, ok
+}
+
+func (s *Server) serveMeter(w http.ResponseWriter, serial string) error {
+	serialNo, err := strconv.ParseUint(serial, 10, 32)
+	if err != nil {
+		return withStatus(http.StatusNotFound, errNotFound)
+	}
+
+	s.mu.Lock()
+	snapshot, ok := s.meters[uint32(serialNo)]
+	s.mu.Unlock()
+	if !ok {
+		return withStatus(http.StatusNotFound, errNotFound)
+	}
+
+	return writeJSON(w, snapshot)
+}
+
+// serveEvents streams the telegrams as Server-Sent Events of type telegram, starting with the latest telegram
+// of each meter, until the client disconnects or the server is closed.
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return withStatus(http.StatusInternalServerError, errors.New("streaming is not supported"))
+	}
+
+	events := make(chan Snapshot, eventBuffer)
+	s.mu.Lock()
+	s.listeners[events] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, events)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, snapshot := range s.snapshots() {
+		if err := writeEvent(w, snapshot); err != nil {
+			return nil
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case snapshot := <-events:
+			if err := writeEvent(w, snapshot); err != nil {
+				return nil
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+		case <-r.Context().Done():
+			return nil
+		case <-s.done:
+			return nil
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, snapshot Snapshot) error {
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: telegram\ndata: %s\n\n", b)
+	return err
+}
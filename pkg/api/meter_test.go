@@ -0,0 +1,179 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/orlopau/go-energy/pkg/meter"
+	"github.com/orlopau/go-energy/pkg/pcap"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMeter emits the telegrams sent to it until it is closed.
+type testMeter struct {
+	telegrams chan *meter.EnergyMeterTelegram
+	done      chan struct{}
+}
+
+func newTestMeter() *testMeter {
+	return &testMeter{telegrams: make(chan *meter.EnergyMeterTelegram), done: make(chan struct{})}
+}
+
+func (m *testMeter) ReadTelegram() (*meter.EnergyMeterTelegram, error) {
+	select {
+	case t := <-m.telegrams:
+		return t, nil
+	case <-m.done:
+		return nil, os.ErrClosed
+	}
+}
+
+func (m *testMeter) Close() error {
+	close(m.done)
+	return nil
+}
+
+func testTelegram(serialNo uint32, power uint64) *meter.EnergyMeterTelegram {
+	return &meter.EnergyMeterTelegram{
+		SusyID:   349,
+		SerialNo: serialNo,
+		Obis:     map[meter.OBISIdentifier]uint64{meter.OBISPowerImport: power, meter.OBISPowerExport: 0},
+	}
+}
+
+// waitForMeters waits until the server has telegrams of n meters.
+func waitForMeters(t *testing.T, s *Server, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(s.snapshots()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected telegrams of %v meters, got %v", n, len(s.snapshots()))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServer_Meter(t *testing.T) {
+	f, err := os.Open("../meter/testdata/telegrams.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	conn, err := pcap.NewReplayConn(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Port = 9522
+
+	s := New()
+	defer s.Close()
+	s.AddEnergyMeter(&meter.EnergyMeter{Conn: conn})
+	waitForMeters(t, s, 1)
+
+	var snapshots []Snapshot
+	get(t, s, "/meters", http.StatusOK, &snapshots)
+	if len(snapshots) != 1 || snapshots[0].SerialNo != 1901401956 || snapshots[0].SusyID != 349 {
+		t.Fatalf("unexpected snapshots %+v", snapshots)
+	}
+
+	var snapshot Snapshot
+	get(t, s, "/meters/1901401956", http.StatusOK, &snapshot)
+	var power *TelegramValue
+	for i, v := range snapshot.Values {
+		if v.OBIS == meter.OBISPowerImport {
+			power = &snapshot.Values[i]
+		}
+	}
+	if power == nil || power.Value != 306.3 || power.Unit != "W" || power.Name != "active_power_import" {
+		t.Fatalf("unexpected import power %+v in %+v", power, snapshot.Values)
+	}
+
+	// the meter is listed as device
+	get(t, s, "/devices/sma_em_1901401956", http.StatusOK, &snapshot)
+	var devices []Device
+	get(t, s, "/devices", http.StatusOK, &devices)
+	if len(devices) != 1 || devices[0] != (Device{ID: "sma_em_1901401956", Protocol: "energy_meter", SerialNo: "1901401956"}) {
+		t.Fatalf("unexpected devices %+v", devices)
+	}
+
+	get(t, s, "/meters/123", http.StatusNotFound, nil)
+	get(t, s, "/meters/foo", http.StatusNotFound, nil)
+}
+
+// readEvent reads the next event of the stream, returning its type and data.
+func readEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	t.Helper()
+
+	var event, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestServer_Events(t *testing.T) {
+	m := newTestMeter()
+	s := New()
+	s.AddEnergyMeter(m)
+
+	m.telegrams <- testTelegram(1, 1000)
+	waitForMeters(t, s, 1)
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/meters/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %v", ct)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	expect := func(serialNo uint32, power float64) {
+		t.Helper()
+
+		event, data := readEvent(t, r)
+		var snapshot Snapshot
+		if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+			t.Fatal(err)
+		}
+		if event != "telegram" || snapshot.SerialNo != serialNo || snapshot.Values[0].Value != power {
+			t.Fatalf("unexpected event %v: %+v", event, snapshot)
+		}
+	}
+
+	// the stream starts with the latest telegrams
+	expect(1, 100)
+
+	m.telegrams <- testTelegram(2, 2000)
+	expect(2, 200)
+
+	// closing the server ends the stream
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Fatal("expected end of stream")
+	}
+}
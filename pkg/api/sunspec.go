@@ -0,0 +1,258 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/device"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// maxBodySize is the maximum size of request bodies.
+const maxBodySize = 4096
+
+// SunSpecDevice is a SunSpec device served by the API, e.g. a *sunspec.ModbusDevice.
+type SunSpecDevice interface {
+	ReadCommonModel() (sunspec.CommonModel, error)
+	ModelAddresses() (map[uint16]uint16, error)
+	GetAnyPoint(ps ...sunspec.Point) (float64, error)
+	WritePoint(model, point uint16, v interface{}) error
+}
+
+// sunSpecDevice is a SunSpec device added to a Server.
+type sunSpecDevice struct {
+	id string
+	// mu serializes the requests to the device
+	mu     sync.Mutex
+	device SunSpecDevice
+}
+
+// Model is an entry of the model map of a SunSpec device.
+type Model struct {
+	ID      uint16 `json:"id"`
+	Address uint16 `json:"address"`
+}
+
+// SunSpecInfo is the identification and model map of a SunSpec device.
+type SunSpecInfo struct {
+	Device
+	Manufacturer string  `json:"manufacturer"`
+	Model        string  `json:"model"`
+	Options      string  `json:"options,omitempty"`
+	Version      string  `json:"version"`
+	Models       []Model `json:"models"`
+}
+
+// Value is a current value of a device.
+type Value struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit,omitempty"`
+}
+
+// PointValue is the value of a SunSpec point.
+type PointValue struct {
+	Model uint16  `json:"model"`
+	Point uint16  `json:"point"`
+	Value float64 `json:"value"`
+}
+
+// WriteRequest is the body of a point write, the value is parsed as the type, see sunspec.ParseValue.
+type WriteRequest struct {
+	Type  string      `json:"type"`
+	Value json.Number `json:"value"`
+}
+
+// AddSunSpec adds the SunSpec device with the id, replacing a device with the same id.
+//
+// The device is closed when the server is closed if it implements io.Closer.
+func (s *Server) AddSunSpec(id string, d SunSpecDevice) {
+	if c, ok := d.(io.Closer); ok && !s.addCloser(c.Close) {
+		_ = c.Close()
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sunSpec[id] = &sunSpecDevice{id: id, device: d}
+}
+
+// serveSunSpec serves the endpoints of a SunSpec device.
+func (s *Server) serveSunSpec(w http.ResponseWriter, r *http.Request, d *sunSpecDevice, path []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case len(path) == 0:
+		return allowGet(r, func() error { return d.serveInfo(w) })
+	case len(path) == 1 && path[0] == "models":
+		return allowGet(r, func() error {
+			models, err := d.models()
+			if err != nil {
+				return err
+			}
+			return writeJSON(w, models)
+		})
+	case len(path) == 1 && path[0] == "values":
+		return allowGet(r, func() error { return d.serveValues(w) })
+	case len(path) == 3 && path[0] == "points":
+		model, err := parseUint16("model", path[1])
+		if err != nil {
+			return err
+		}
+		point, err := parseUint16("point", path[2])
+		if err != nil {
+			return err
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			return d.servePoint(w, r, model, point)
+		case http.MethodPut:
+			if err := s.authorize(r); err != nil {
+				return err
+			}
+			return d.writePoint(w, r, model, point)
+		default:
+			return methodNotAllowed(r, http.MethodGet, http.MethodHead, http.MethodPut)
+		}
+	default:
+		return withStatus(http.StatusNotFound, errNotFound)
+	}
+}
+
+// models returns the model map of the device ordered by address.
+func (d *sunSpecDevice) models() ([]Model, error) {
+	addresses, err := d.device.ModelAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]Model, 0, len(addresses))
+	for id, addr := range addresses {
+		models = append(models, Model{ID: id, Address: addr})
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Address < models[j].Address })
+
+	return models, nil
+}
+
+func (d *sunSpecDevice) serveInfo(w http.ResponseWriter) error {
+	common, err := d.device.ReadCommonModel()
+	if err != nil {
+		return err
+	}
+	models, err := d.models()
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, SunSpecInfo{
+		Device:       Device{ID: d.id, Protocol: "sunspec", SerialNo: common.SerialNumber, Name: common.Manufacturer + " " + common.Model},
+		Manufacturer: common.Manufacturer,
+		Model:        common.Model,
+		Options:      common.Options,
+		Version:      common.Version,
+		Models:       models,
+	})
+}
+
+// serveValues writes the values of the inverter and battery models implemented by the device.
+func (d *sunSpecDevice) serveValues(w http.ResponseWriter) error {
+	adapter := device.NewSunSpec(d.device)
+	readers := []struct {
+		name string
+		unit string
+		read func() (float64, error)
+	}{
+		{"power", sunspec.UnitWatts, adapter.Power},
+		{"energy_total", sunspec.UnitWattHours, adapter.EnergyTotal},
+		{"battery_soc", sunspec.UnitPercentage, adapter.Soc},
+		{"battery_power", sunspec.UnitWatts, adapter.BatteryPower},
+	}
+
+	values := make(map[string]Value, len(readers))
+	for _, r := range readers {
+		v, err := r.read()
+		if errors.Is(err, device.ErrNotSupported) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		values[r.name] = Value{Value: v, Unit: r.unit}
+	}
+
+	return writeJSON(w, values)
+}
+
+// servePoint reads the point with the type of the query parameter type, uint16 by default.
+//
+// The value is scaled if the query parameter scaled is true or scale_point is set.
+func (d *sunSpecDevice) servePoint(w http.ResponseWriter, r *http.Request, model, point uint16) error {
+	query := r.URL.Query()
+
+	typ := query.Get("type")
+	if typ == "" {
+		typ = "uint16"
+	}
+	t, err := sunspec.PointType(typ)
+	if err != nil {
+		return withStatus(http.StatusBadRequest, err)
+	}
+
+	p := sunspec.Point{Model: model, Point: point, T: t}
+	if s := query.Get("scaled"); s != "" {
+		if p.Scaled, err = strconv.ParseBool(s); err != nil {
+			return withStatus(http.StatusBadRequest, fmt.Errorf("invalid scaled %q", s))
+		}
+	}
+	if s := query.Get("scale_point"); s != "" {
+		if p.ScalePoint, err = parseUint16("scale_point", s); err != nil {
+			return err
+		}
+		p.Scaled = true
+	}
+
+	v, err := d.device.GetAnyPoint(p)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, PointValue{Model: model, Point: point, Value: v})
+}
+
+// writePoint writes the point with the value of the WriteRequest body.
+func (d *sunSpecDevice) writePoint(w http.ResponseWriter, r *http.Request, model, point uint16) error {
+	var req WriteRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxBodySize))
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		return withStatus(http.StatusBadRequest, fmt.Errorf("invalid body: %w", err))
+	}
+
+	v, err := sunspec.ParseValue(req.Type, req.Value.String())
+	if err != nil {
+		return withStatus(http.StatusBadRequest, err)
+	}
+
+	if err := d.device.WritePoint(model, point, v); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func parseUint16(name, s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, withStatus(http.StatusBadRequest, fmt.Errorf("invalid %v %q", name, s))
+	}
+	return uint16(v), nil
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type pointKey struct {
+	model, point uint16
+}
+
+// testSunSpecDevice implements SunSpecDevice using fixed point values, recording writes.
+type testSunSpecDevice struct {
+	points map[pointKey]float64
+	writes map[pointKey]interface{}
+	err    error
+	closed bool
+}
+
+func newTestSunSpecDevice() *testSunSpecDevice {
+	return &testSunSpecDevice{
+		points: map[pointKey]float64{
+			{103, 14}: 1234,
+			{103, 24}: 56789,
+			{802, 11}: 80,
+		},
+		writes: make(map[pointKey]interface{}),
+	}
+}
+
+func (d *testSunSpecDevice) ReadCommonModel() (sunspec.CommonModel, error) {
+	return sunspec.CommonModel{Manufacturer: "SMA", Model: "STP 10.0", Version: "1.0", SerialNumber: "3000123456"}, d.err
+}
+
+func (d *testSunSpecDevice) ModelAddresses() (map[uint16]uint16, error) {
+	return map[uint16]uint16{1: 40002, 103: 40070, 802: 40122}, d.err
+}
+
+func (d *testSunSpecDevice) GetAnyPoint(ps ...sunspec.Point) (float64, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	for _, p := range ps {
+		if v, ok := d.points[pointKey{p.Model, p.Point}]; ok {
+			return v, nil
+		}
+	}
+	return 0, sunspec.ErrPointNotImplemented
+}
+
+func (d *testSunSpecDevice) WritePoint(model, point uint16, v interface{}) error {
+	d.writes[pointKey{model, point}] = v
+	return d.err
+}
+
+func (d *testSunSpecDevice) Close() error {
+	d.closed = true
+	return nil
+}
+
+// request serves the request, decoding the JSON response into v if it is not nil.
+func request(t *testing.T, s *Server, req *http.Request, status int, v interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != status {
+		t.Fatalf("%v %v: expected status %v, got %v: %s", req.Method, req.URL, status, rec.Code, rec.Body)
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("decoding %s: %v", rec.Body, err)
+		}
+	}
+
+	return rec
+}
+
+func get(t *testing.T, s *Server, path string, status int, v interface{}) {
+	t.Helper()
+	request(t, s, httptest.NewRequest(http.MethodGet, path, nil), status, v)
+}
+
+func TestServer_SunSpec(t *testing.T) {
+	d := newTestSunSpecDevice()
+	s := New()
+	s.AddSunSpec("inverter", d)
+
+	var devices []Device
+	get(t, s, "/devices", http.StatusOK, &devices)
+	if len(devices) != 1 || devices[0].ID != "inverter" || devices[0].Protocol != "sunspec" {
+		t.Fatalf("unexpected devices %+v", devices)
+	}
+
+	var info SunSpecInfo
+	get(t, s, "/devices/inverter", http.StatusOK, &info)
+	if info.SerialNo != "3000123456" || info.Model != "STP 10.0" || len(info.Models) != 3 || info.Models[1] != (Model{ID: 103, Address: 40070}) {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	var models []Model
+	get(t, s, "/devices/inverter/models", http.StatusOK, &models)
+	if len(models) != 3 || models[0] != (Model{ID: 1, Address: 40002}) {
+		t.Fatalf("unexpected models %+v", models)
+	}
+
+	var values map[string]Value
+	get(t, s, "/devices/inverter/values", http.StatusOK, &values)
+	if len(values) != 3 || values["power"] != (Value{Value: 1234, Unit: "W"}) || values["battery_soc"].Value != 80 {
+		t.Fatalf("unexpected values %+v", values)
+	}
+
+	var point PointValue
+	get(t, s, "/devices/inverter/points/103/14?type=int16&scaled=true", http.StatusOK, &point)
+	if point != (PointValue{Model: 103, Point: 14, Value: 1234}) {
+		t.Fatalf("unexpected point %+v", point)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !d.closed {
+		t.Fatal("device not closed")
+	}
+}
+
+func TestServer_Errors(t *testing.T) {
+	d := newTestSunSpecDevice()
+	s := New()
+	defer s.Close()
+	s.AddSunSpec("inverter", d)
+
+	tests := map[string]struct {
+		method, path string
+		status       int
+	}{
+		"unknown path":          {http.MethodGet, "/foo", http.StatusNotFound},
+		"unknown device":        {http.MethodGet, "/devices/foo", http.StatusNotFound},
+		"unknown endpoint":      {http.MethodGet, "/devices/inverter/foo", http.StatusNotFound},
+		"not implemented point": {http.MethodGet, "/devices/inverter/points/1/66", http.StatusNotFound},
+		"invalid model":         {http.MethodGet, "/devices/inverter/points/x/66", http.StatusBadRequest},
+		"invalid type":          {http.MethodGet, "/devices/inverter/points/103/14?type=int8", http.StatusBadRequest},
+		"invalid scaled":        {http.MethodGet, "/devices/inverter/points/103/14?scaled=maybe", http.StatusBadRequest},
+		"method":                {http.MethodPost, "/devices", http.StatusMethodNotAllowed},
+		"point method":          {http.MethodDelete, "/devices/inverter/points/103/14", http.StatusMethodNotAllowed},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var body struct{ Error string }
+			request(t, s, httptest.NewRequest(test.method, test.path, nil), test.status, &body)
+			if body.Error == "" {
+				t.Fatal("expected error message")
+			}
+		})
+	}
+
+	// errors of the device
+	d.err = errors.New("connection reset")
+	get(t, s, "/devices/inverter/values", http.StatusBadGateway, nil)
+}
+
+func TestServer_WritePoint(t *testing.T) {
+	write := func(s *Server, token, body string, status int) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, "/devices/inverter/points/123/3", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := request(t, s, req, status, nil)
+		if status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Fatal("expected authentication challenge")
+		}
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		d := newTestSunSpecDevice()
+		s := New()
+		defer s.Close()
+		s.AddSunSpec("inverter", d)
+
+		write(s, "secret", `{"type":"uint16","value":50}`, http.StatusForbidden)
+		if len(d.writes) != 0 {
+			t.Fatalf("unexpected writes %v", d.writes)
+		}
+	})
+
+	t.Run("authenticated", func(t *testing.T) {
+		d := newTestSunSpecDevice()
+		s := New(WithToken("secret"))
+		defer s.Close()
+		s.AddSunSpec("inverter", d)
+
+		write(s, "", `{"type":"uint16","value":50}`, http.StatusUnauthorized)
+		write(s, "wrong", `{"type":"uint16","value":50}`, http.StatusUnauthorized)
+		write(s, "secret", `{"type":"uint16","value":70000}`, http.StatusBadRequest)
+		write(s, "secret", `{"type":"uint16"`, http.StatusBadRequest)
+		if len(d.writes) != 0 {
+			t.Fatalf("unexpected writes %v", d.writes)
+		}
+
+		write(s, "secret", `{"type":"int16","value":-50}`, http.StatusNoContent)
+		if v := d.writes[pointKey{123, 3}]; v != int16(-50) {
+			t.Fatalf("expected write of int16 -50, got %#v", v)
+		}
+	})
+}
@@ -0,0 +1,419 @@
+// Provides a declarative YAML configuration of devices, the points polled from them and the outputs fed with
+// their values.
+//
+// An example configuration of a site:
+//
+//	interval: 10s
+//	devices:
+//	  - name: inverter
+//	    protocol: sunspec
+//	    address: 192.168.1.10:502
+//	    unit_id: 3
+//	    points:
+//	      - name: power                # a preset, see record.DefaultSunSpecFields
+//	      - name: ac_current
+//	        model: 103
+//	        point: 2
+//	        type: uint16
+//	        scaled: true
+//	        interval: 1m
+//	    outputs: [influx]
+//	  - name: grid
+//	    protocol: energy_meter
+//	    interface: eth0
+//	    interval: 5s
+//	outputs:
+//	  influx:
+//	    type: influx
+//	    url: http://localhost:8086/api/v2/write?org=home&bucket=energy
+//	    token: ${INFLUX_TOKEN}
+//	  archive:
+//	    type: csv
+//	    path: /var/lib/goenergy/energy.csv
+//	    rotation: 2006-01-02
+//
+// Environment variables in the configuration are expanded, e.g. to pass secrets.
+package config
+
+import (
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/modbus"
+	"github.com/orlopau/go-energy/pkg/record"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"gopkg.in/yaml.v2"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultInterval is the polling interval if the configuration does not specify an interval.
+	DefaultInterval = 10 * time.Second
+	// DefaultTimeout is the timeout of connecting and requests if a device does not specify a timeout.
+	DefaultTimeout = 5 * time.Second
+)
+
+// protocols of devices
+const (
+	ProtocolSunSpec     = "sunspec"
+	ProtocolEnergyMeter = "energy_meter"
+)
+
+// transports of devices
+const (
+	// TransportTCP is the transport of SunSpec devices using modbus TCP.
+	TransportTCP = "tcp"
+	// TransportMulticast is the transport of energy meters sending telegrams to the multicast group.
+	TransportMulticast = "multicast"
+	// TransportUnicast is the transport of energy meters sending telegrams to the address of the device.
+	TransportUnicast = "unicast"
+)
+
+// types of outputs
+const (
+	OutputInflux = "influx"
+	OutputCSV    = "csv"
+)
+
+// Config describes the devices of a site and the outputs of their values.
+type Config struct {
+	// Interval is the default polling interval of devices, DefaultInterval if it is zero.
+	Interval time.Duration     `yaml:"interval"`
+	Devices  []Device          `yaml:"devices"`
+	Outputs  map[string]Output `yaml:"outputs"`
+}
+
+// Device is a device polled for values.
+type Device struct {
+	// Name identifies the device, it must be unique.
+	Name string `yaml:"name"`
+	// Protocol is ProtocolSunSpec or ProtocolEnergyMeter.
+	Protocol string `yaml:"protocol"`
+	// Transport is TransportTCP for SunSpec devices and TransportMulticast or TransportUnicast for energy meters,
+	// by default TransportTCP and TransportMulticast.
+	Transport string `yaml:"transport"`
+	// Address is the modbus address host[:port][/unit] of SunSpec devices, see modbus.ParseAddress, or the
+	// listen address of energy meters using TransportUnicast.
+	Address string `yaml:"address"`
+	// UnitID overrides the unit id of the address of SunSpec devices if it is not zero.
+	UnitID byte `yaml:"unit_id"`
+	// Interface is the network interface receiving the telegrams of energy meters using TransportMulticast,
+	// the interface chosen by the system if it is empty.
+	Interface string `yaml:"interface"`
+	// Serial selects the energy meter of the telegrams, zero accepts all meters.
+	Serial uint32 `yaml:"serial"`
+	// Interval is the polling interval of the device, the interval of the configuration if it is zero.
+	// Telegrams of energy meters are written at most once per interval.
+	Interval time.Duration `yaml:"interval"`
+	// Timeout is the timeout of connecting and requests, DefaultTimeout if it is zero.
+	Timeout time.Duration `yaml:"timeout"`
+	// Points are the points polled from SunSpec devices, the presets of record.DefaultSunSpecFields if it is empty.
+	Points []Point `yaml:"points"`
+	// Outputs are the names of the outputs fed with the values of the device, all outputs if it is empty.
+	Outputs []string `yaml:"outputs"`
+}
+
+// Point is a point of a SunSpec device.
+//
+// A point without a model refers to the preset of record.DefaultSunSpecFields with its name,
+// e.g. power or battery_soc, which reads the point from the models implemented by the device.
+type Point struct {
+	Name  string `yaml:"name"`
+	Model uint16 `yaml:"model"`
+	Point uint16 `yaml:"point"`
+	// Type is the type of the point, see sunspec.PointType, uint16 by default.
+	Type string `yaml:"type"`
+	// Scaled must be set for scaled values, see sunspec.Point.
+	Scaled     bool   `yaml:"scaled"`
+	ScalePoint uint16 `yaml:"scale_point"`
+	// Interval is the polling interval of the point, the interval of the device if it is zero.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Output is a destination of the values of devices.
+type Output struct {
+	// Type is OutputInflux or OutputCSV.
+	Type string `yaml:"type"`
+
+	// URL is the HTTP write endpoint of an influx output, see record.NewInfluxHTTPWriter.
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+	// UDP is the address of the UDP listener of an influx output.
+	UDP string `yaml:"udp"`
+
+	// Path is the file of an influx or csv output.
+	Path string `yaml:"path"`
+	// Rotation is the time layout rotating the files of a csv output, see record.WithRotation.
+	Rotation string   `yaml:"rotation"`
+	Columns  []string `yaml:"columns"`
+}
+
+// Load reads the configuration file.
+func Load(name string) (*Config, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", name, err)
+	}
+	return c, nil
+}
+
+// Parse parses and validates the YAML configuration, expanding environment variables.
+//
+// Unknown fields are rejected. The intervals, timeouts, transports and outputs of devices are set to their defaults.
+func Parse(b []byte) (*Config, error) {
+	var c Config
+	if err := yaml.UnmarshalStrict([]byte(os.ExpandEnv(string(b))), &c); err != nil {
+		return nil, err
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// validate checks the configuration and sets the defaults.
+func (c *Config) validate() error {
+	if c.Interval == 0 {
+		c.Interval = DefaultInterval
+	}
+	if err := checkDuration("interval", c.Interval); err != nil {
+		return err
+	}
+
+	outputs := make([]string, 0, len(c.Outputs))
+	for name, o := range c.Outputs {
+		if err := o.validate(); err != nil {
+			return fmt.Errorf("output %v: %w", name, err)
+		}
+		outputs = append(outputs, name)
+	}
+	sort.Strings(outputs)
+
+	names := make(map[string]bool)
+	for i := range c.Devices {
+		d := &c.Devices[i]
+		if d.Name == "" {
+			return fmt.Errorf("device %d: missing name", i+1)
+		}
+		if names[d.Name] {
+			return fmt.Errorf("device %v: duplicate name", d.Name)
+		}
+		names[d.Name] = true
+
+		if d.Interval == 0 {
+			d.Interval = c.Interval
+		}
+		if d.Timeout == 0 {
+			d.Timeout = DefaultTimeout
+		}
+		if d.Outputs == nil {
+			d.Outputs = outputs
+		}
+
+		if err := d.validate(c.Outputs); err != nil {
+			return fmt.Errorf("device %v: %w", d.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (d *Device) validate(outputs map[string]Output) error {
+	if err := checkDuration("interval", d.Interval); err != nil {
+		return err
+	}
+	if err := checkDuration("timeout", d.Timeout); err != nil {
+		return err
+	}
+
+	for _, o := range d.Outputs {
+		if _, ok := outputs[o]; !ok {
+			return fmt.Errorf("unknown output %q", o)
+		}
+	}
+
+	switch d.Protocol {
+	case ProtocolSunSpec:
+		if d.Transport == "" {
+			d.Transport = TransportTCP
+		}
+		if d.Transport != TransportTCP {
+			return fmt.Errorf("unsupported transport %q of protocol %v", d.Transport, d.Protocol)
+		}
+		if _, _, err := modbus.ParseAddress(d.Address); err != nil {
+			return err
+		}
+
+		for i := range d.Points {
+			p := &d.Points[i]
+			if p.Interval == 0 {
+				p.Interval = d.Interval
+			}
+			if err := p.validate(); err != nil {
+				return fmt.Errorf("point %v: %w", p.Name, err)
+			}
+		}
+	case ProtocolEnergyMeter:
+		if d.Transport == "" {
+			d.Transport = TransportMulticast
+		}
+		switch d.Transport {
+		case TransportMulticast:
+		case TransportUnicast:
+			if d.Address == "" {
+				return fmt.Errorf("missing address of transport %v", d.Transport)
+			}
+		default:
+			return fmt.Errorf("unsupported transport %q of protocol %v", d.Transport, d.Protocol)
+		}
+		if len(d.Points) > 0 {
+			return fmt.Errorf("points are not supported by protocol %v", d.Protocol)
+		}
+	default:
+		return fmt.Errorf("unknown protocol %q", d.Protocol)
+	}
+
+	return nil
+}
+
+func (p *Point) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if err := checkDuration("interval", p.Interval); err != nil {
+		return err
+	}
+
+	if p.Model == 0 {
+		if _, ok := preset(p.Name); !ok {
+			return fmt.Errorf("unknown preset, the point requires a model")
+		}
+		return nil
+	}
+
+	if p.Type == "" {
+		p.Type = "uint16"
+	}
+	_, err := sunspec.PointType(p.Type)
+	return err
+}
+
+func (o *Output) validate() error {
+	switch o.Type {
+	case OutputInflux:
+		var targets int
+		for _, t := range []string{o.URL, o.UDP, o.Path} {
+			if t != "" {
+				targets++
+			}
+		}
+		if targets != 1 {
+			return fmt.Errorf("exactly one of url, udp and path is required")
+		}
+	case OutputCSV:
+		if o.Path == "" {
+			return fmt.Errorf("missing path")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", o.Type)
+	}
+
+	return nil
+}
+
+// checkDuration checks that a duration is positive and at least a millisecond, numbers without a unit are
+// decoded as nanoseconds.
+func checkDuration(name string, d time.Duration) error {
+	if d < time.Millisecond {
+		return fmt.Errorf("invalid %v %v, durations require a unit, e.g. 10s", name, d)
+	}
+	return nil
+}
+
+// preset returns the field of record.DefaultSunSpecFields with the name.
+func preset(name string) (record.SunSpecField, bool) {
+	for _, f := range record.DefaultSunSpecFields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return record.SunSpecField{}, false
+}
+
+// ModbusAddress returns the modbus tcp address and unit id of a SunSpec device.
+func (d Device) ModbusAddress() (string, byte, error) {
+	addr, unitID, err := modbus.ParseAddress(d.Address)
+	if err != nil {
+		return "", 0, err
+	}
+	if d.UnitID != 0 {
+		unitID = d.UnitID
+	}
+
+	return addr, unitID, nil
+}
+
+// Schedule returns the fields of the points of a SunSpec device grouped by their polling interval.
+func (d Device) Schedule() map[time.Duration][]record.SunSpecField {
+	if len(d.Points) == 0 {
+		return map[time.Duration][]record.SunSpecField{d.Interval: record.DefaultSunSpecFields}
+	}
+
+	schedule := make(map[time.Duration][]record.SunSpecField)
+	for _, p := range d.Points {
+		schedule[p.Interval] = append(schedule[p.Interval], p.Field())
+	}
+	return schedule
+}
+
+// Field returns the field reading the point.
+func (p Point) Field() record.SunSpecField {
+	if p.Model == 0 {
+		f, _ := preset(p.Name)
+		return f
+	}
+
+	t, _ := sunspec.PointType(p.Type)
+	return record.SunSpecField{
+		Name: p.Name,
+		Points: []sunspec.Point{{
+			Model:      p.Model,
+			Point:      p.Point,
+			T:          t,
+			Scaled:     p.Scaled || p.ScalePoint != 0,
+			ScalePoint: p.ScalePoint,
+		}},
+	}
+}
+
+// Open opens the writer of the output.
+func (o Output) Open() (record.Writer, error) {
+	switch o.Type {
+	case OutputInflux:
+		switch {
+		case o.URL != "":
+			return record.NewInfluxHTTPWriter(o.URL, record.WithToken(o.Token)), nil
+		case o.UDP != "":
+			return record.DialInfluxUDP(o.UDP)
+		default:
+			return record.OpenInfluxFile(o.Path)
+		}
+	case OutputCSV:
+		var opts []record.CSVOption
+		if o.Rotation != "" {
+			opts = append(opts, record.WithRotation(o.Rotation))
+		}
+		if len(o.Columns) > 0 {
+			opts = append(opts, record.WithColumns(o.Columns...))
+		}
+		return record.NewCSVWriter(o.Path, opts...), nil
+	default:
+		return nil, fmt.Errorf("unknown output type %q", o.Type)
+	}
+}
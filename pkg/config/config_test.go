@@ -0,0 +1,206 @@
+package config
+
+import (
+	"github.com/orlopau/go-energy/pkg/record"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const siteConfig = `
+interval: 30s
+devices:
+  - name: inverter
+    protocol: sunspec
+    address: 192.168.1.10/3
+    points:
+      - name: power
+        interval: 5s
+      - name: ac_current
+        model: 103
+        point: 2
+        scaled: true
+      - name: temperature
+        model: 103
+        point: 33
+        type: int16
+        scale_point: 37
+    outputs: [influx]
+  - name: battery
+    protocol: sunspec
+    address: 192.168.1.11:1502
+    unit_id: 126
+    timeout: 1s
+  - name: grid
+    protocol: energy_meter
+    transport: unicast
+    address: 192.168.1.2:9522
+    serial: 1901401956
+outputs:
+  influx:
+    type: influx
+    url: http://localhost:8086/api/v2/write?org=home&bucket=energy
+    token: ${GOENERGY_TEST_TOKEN}
+  archive:
+    type: csv
+    path: energy.csv
+    rotation: 2006-01-02
+`
+
+func TestParse(t *testing.T) {
+	t.Setenv("GOENERGY_TEST_TOKEN", "secret")
+
+	c, err := Parse([]byte(siteConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Devices) != 3 || len(c.Outputs) != 2 {
+		t.Fatalf("unexpected config %+v", c)
+	}
+	if token := c.Outputs["influx"].Token; token != "secret" {
+		t.Fatalf("expected expanded token, got %q", token)
+	}
+
+	inverter := c.Devices[0]
+	if inverter.Interval != 30*time.Second || inverter.Timeout != DefaultTimeout || inverter.Transport != TransportTCP {
+		t.Fatalf("unexpected defaults %+v", inverter)
+	}
+	if addr, unitID, err := inverter.ModbusAddress(); err != nil || addr != "192.168.1.10:502" || unitID != 3 {
+		t.Fatalf("unexpected address %v/%v: %v", addr, unitID, err)
+	}
+
+	schedule := inverter.Schedule()
+	if len(schedule) != 2 || len(schedule[5*time.Second]) != 1 || len(schedule[30*time.Second]) != 2 {
+		t.Fatalf("unexpected schedule %+v", schedule)
+	}
+	if f := schedule[5*time.Second][0]; f.Name != "power" || len(f.Points) != 3 {
+		t.Fatalf("expected power preset, got %+v", f)
+	}
+	current := sunspec.Point{Model: 103, Point: 2, T: uint16(0), Scaled: true}
+	if f := schedule[30*time.Second][0]; f.Name != "ac_current" || f.Points[0] != current {
+		t.Fatalf("unexpected field %+v", f)
+	}
+	temperature := sunspec.Point{Model: 103, Point: 33, T: int16(0), Scaled: true, ScalePoint: 37}
+	if f := schedule[30*time.Second][1]; f.Points[0] != temperature {
+		t.Fatalf("unexpected field %+v", f)
+	}
+
+	battery := c.Devices[1]
+	if addr, unitID, _ := battery.ModbusAddress(); addr != "192.168.1.11:1502" || unitID != 126 {
+		t.Fatalf("unexpected address %v/%v", addr, unitID)
+	}
+	if battery.Timeout != time.Second || strings.Join(battery.Outputs, ",") != "archive,influx" {
+		t.Fatalf("unexpected device %+v", battery)
+	}
+	if schedule := battery.Schedule(); len(schedule[30*time.Second]) != len(record.DefaultSunSpecFields) {
+		t.Fatalf("expected default fields, got %+v", schedule)
+	}
+
+	if grid := c.Devices[2]; grid.Transport != TransportUnicast || grid.Serial != 1901401956 {
+		t.Fatalf("unexpected device %+v", grid)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := map[string]struct {
+		config string
+		err    string
+	}{
+		"unknown field": {
+			"devices:\n  - name: a\n    protocol: sunspec\n    adress: x\n",
+			"adress",
+		},
+		"missing name": {
+			"devices:\n  - protocol: sunspec\n    address: x\n",
+			"missing name",
+		},
+		"duplicate name": {
+			"devices:\n  - {name: a, protocol: energy_meter}\n  - {name: a, protocol: energy_meter}\n",
+			"duplicate name",
+		},
+		"unknown protocol": {
+			"devices:\n  - {name: a, protocol: rtu}\n",
+			"unknown protocol",
+		},
+		"transport": {
+			"devices:\n  - {name: a, protocol: sunspec, transport: udp, address: x}\n",
+			"unsupported transport",
+		},
+		"address": {
+			"devices:\n  - {name: a, protocol: sunspec, address: x/300}\n",
+			"invalid unit id",
+		},
+		"unicast without address": {
+			"devices:\n  - {name: a, protocol: energy_meter, transport: unicast}\n",
+			"missing address",
+		},
+		"unknown preset": {
+			"devices:\n  - {name: a, protocol: sunspec, address: x, points: [{name: foo}]}\n",
+			"unknown preset",
+		},
+		"point type": {
+			"devices:\n  - {name: a, protocol: sunspec, address: x, points: [{name: foo, model: 1, point: 2, type: int8}]}\n",
+			"unsupported type",
+		},
+		"unknown output": {
+			"devices:\n  - {name: a, protocol: energy_meter, outputs: [influx]}\n",
+			"unknown output",
+		},
+		"output type": {
+			"outputs:\n  a: {type: sqlite}\n",
+			"unknown type",
+		},
+		"influx target": {
+			"outputs:\n  a: {type: influx, url: http://localhost, udp: localhost:8089}\n",
+			"exactly one",
+		},
+		"interval": {
+			"interval: 10\n",
+			"interval",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(test.config))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "site.yaml")
+	if err := os.WriteFile(name, []byte("devices:\n  - {name: grid, protocol: foo}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(name)
+	if err == nil || !strings.Contains(err.Error(), name) {
+		t.Fatalf("expected error containing the file name, got %v", err)
+	}
+}
+
+func TestOutput_Open(t *testing.T) {
+	dir := t.TempDir()
+	outputs := []Output{
+		{Type: OutputInflux, URL: "http://localhost:8086/write?db=energy"},
+		{Type: OutputInflux, Path: filepath.Join(dir, "energy.lp")},
+		{Type: OutputCSV, Path: filepath.Join(dir, "energy.csv"), Rotation: "2006-01-02", Columns: []string{"time", "power"}},
+	}
+
+	for _, o := range outputs {
+		w, err := o.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Provides protocol independent interfaces for energy devices and adapters implementing them for the supported protocols.
+package device
+
+import "errors"
+
+// ErrNotSupported is returned if a device does not provide a value, e.g. an inverter without a battery.
+var ErrNotSupported = errors.New("not supported by the device")
+
+// ErrNoData is returned if a device has not sent any data yet.
+var ErrNoData = errors.New("no data received")
+
+// PowerSource is a device producing AC power.
+type PowerSource interface {
+	// Power returns the AC power produced by the device in W.
+	Power() (float64, error)
+}
+
+// PVInverter is a photovoltaic inverter.
+type PVInverter interface {
+	PowerSource
+	// EnergyTotal returns the total energy produced by the inverter in Wh.
+	EnergyTotal() (float64, error)
+}
+
+// GridMeter is a meter at the grid connection point.
+type GridMeter interface {
+	// GridPower returns the power exchanged with the grid in W, positive values are drawn from the grid
+	// and negative values are fed into the grid.
+	GridPower() (float64, error)
+}
+
+// Battery is a battery storage.
+type Battery interface {
+	// Soc returns the state of charge in percent.
+	Soc() (float64, error)
+	// BatteryPower returns the power of the battery in W, positive values charge the battery
+	// and negative values discharge it.
+	BatteryPower() (float64, error)
+}
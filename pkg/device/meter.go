@@ -0,0 +1,94 @@
+package device
+
+import (
+	"github.com/orlopau/go-energy/pkg/meter"
+	"sync"
+)
+
+var _ GridMeter = (*EnergyMeter)(nil)
+
+// EnergyMeter adapts an SMA energy meter, keeping the values of the latest telegram.
+type EnergyMeter struct {
+	meter    *meter.EnergyMeter
+	serialNo uint32
+
+	mu   sync.Mutex
+	last *meter.EnergyMeterTelegram
+	err  error
+	done chan struct{}
+}
+
+// NewEnergyMeter creates an adapter reading the telegrams of the energy meter in the background.
+//
+// If the connection receives telegrams of several meters, serialNo selects the meter, zero accepts any meter.
+// The adapter must be closed to stop reading, which also closes the energy meter.
+func NewEnergyMeter(m *meter.EnergyMeter, serialNo uint32) *EnergyMeter {
+	e := &EnergyMeter{meter: m, serialNo: serialNo, done: make(chan struct{})}
+	go e.read()
+	return e
+}
+
+func (e *EnergyMeter) read() {
+	defer close(e.done)
+
+	for {
+		t, err := e.meter.ReadTelegram()
+		if meter.IsSkippable(err) {
+			continue
+		}
+
+		e.mu.Lock()
+		if err != nil {
+			e.err = err
+			e.mu.Unlock()
+			return
+		}
+		if e.serialNo == 0 || t.SerialNo == e.serialNo {
+			e.last = t
+		}
+		e.mu.Unlock()
+	}
+}
+
+// Telegram returns the latest telegram received from the meter.
+//
+// Returns ErrNoData if no telegram has been received yet, or the error which stopped reading.
+func (e *EnergyMeter) Telegram() (*meter.EnergyMeterTelegram, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.last == nil {
+		if e.err != nil {
+			return nil, e.err
+		}
+		return nil, ErrNoData
+	}
+
+	return e.last, nil
+}
+
+// GridPower returns the difference of the imported and exported power of the latest telegram.
+func (e *EnergyMeter) GridPower() (float64, error) {
+	t, err := e.Telegram()
+	if err != nil {
+		return 0, err
+	}
+
+	imported, ok := t.Value(meter.OBISPowerImport)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	exported, ok := t.Value(meter.OBISPowerExport)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	return imported - exported, nil
+}
+
+// Close closes the energy meter and waits for the reading to stop.
+func (e *EnergyMeter) Close() error {
+	err := e.meter.Close()
+	<-e.done
+	return err
+}
@@ -0,0 +1,66 @@
+package device
+
+import (
+	"errors"
+	"github.com/orlopau/go-energy/pkg/meter"
+	"github.com/orlopau/go-energy/pkg/pcap"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func replayEnergyMeter(t *testing.T) *meter.EnergyMeter {
+	f, err := os.Open("../meter/testdata/telegrams.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	conn, err := pcap.NewReplayConn(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &meter.EnergyMeter{Conn: conn}
+}
+
+func TestEnergyMeter_GridPower(t *testing.T) {
+	e := NewEnergyMeter(replayEnergyMeter(t), 0)
+	defer e.Close()
+
+	var power float64
+	var err error
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		power, err = e.GridPower()
+		if !errors.Is(err, ErrNoData) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if power != 306.3 {
+		t.Fatalf("want %v, got %v", 306.3, power)
+	}
+}
+
+// TestEnergyMeter_OtherSerial verifies that telegrams of other meters are ignored.
+func TestEnergyMeter_OtherSerial(t *testing.T) {
+	e := NewEnergyMeter(replayEnergyMeter(t), 1)
+	defer e.Close()
+
+	var err error
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		_, err = e.GridPower()
+		if !errors.Is(err, ErrNoData) {
+			break
+		}
+	}
+
+	// the replay ends without a telegram of the meter
+	if err != io.EOF {
+		t.Fatalf("want %v, got %v", io.EOF, err)
+	}
+}
@@ -0,0 +1,76 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/speedwire"
+)
+
+var (
+	_ PVInverter = (*Speedwire)(nil)
+	_ Battery    = (*Speedwire)(nil)
+)
+
+// speedwireReader reads the values of a Speedwire device.
+type speedwireReader interface {
+	SpotACPower() (speedwire.ACPower, error)
+	Yield() (speedwire.Yield, error)
+	Battery() (speedwire.Battery, error)
+}
+
+// Speedwire adapts an SMA device connected using a *speedwire.Client.
+//
+// Most devices only answer after a login, so the client should be logged in before reading values.
+type Speedwire struct {
+	client speedwireReader
+}
+
+// NewSpeedwire creates an adapter for the Speedwire device.
+func NewSpeedwire(client speedwireReader) *Speedwire {
+	return &Speedwire{client: client}
+}
+
+// Power returns the total AC power fed into the grid.
+func (s *Speedwire) Power() (float64, error) {
+	p, err := s.client.SpotACPower()
+	if err != nil {
+		return 0, err
+	}
+	return p.Total, nil
+}
+
+// EnergyTotal returns the total yield.
+func (s *Speedwire) EnergyTotal() (float64, error) {
+	y, err := s.client.Yield()
+	if err != nil {
+		return 0, err
+	}
+	return y.TotalWh, nil
+}
+
+// battery reads the battery values, devices without a battery reject the query.
+func (s *Speedwire) battery() (speedwire.Battery, error) {
+	b, err := s.client.Battery()
+	if errors.Is(err, speedwire.ErrRequestFailed) || errors.Is(err, speedwire.ErrValueNotAvailable) {
+		return b, fmt.Errorf("%w: %v", ErrNotSupported, err)
+	}
+	return b, err
+}
+
+// Soc returns the state of charge of the battery of a battery inverter.
+func (s *Speedwire) Soc() (float64, error) {
+	b, err := s.battery()
+	if err != nil {
+		return 0, err
+	}
+	return b.Soc, nil
+}
+
+// BatteryPower returns the battery power calculated from the battery voltage and current.
+func (s *Speedwire) BatteryPower() (float64, error) {
+	b, err := s.battery()
+	if err != nil {
+		return 0, err
+	}
+	return b.Voltage * b.Current, nil
+}
@@ -0,0 +1,60 @@
+package device
+
+import (
+	"errors"
+	"github.com/orlopau/go-energy/pkg/speedwire"
+	"testing"
+)
+
+type dummySpeedwireReader struct {
+	battery *speedwire.Battery
+}
+
+func (r *dummySpeedwireReader) SpotACPower() (speedwire.ACPower, error) {
+	return speedwire.ACPower{Total: 3000, L1: 1000, L2: 1000, L3: 1000}, nil
+}
+
+func (r *dummySpeedwireReader) Yield() (speedwire.Yield, error) {
+	return speedwire.Yield{DailyWh: 5000, TotalWh: 9000000}, nil
+}
+
+func (r *dummySpeedwireReader) Battery() (speedwire.Battery, error) {
+	if r.battery == nil {
+		return speedwire.Battery{}, speedwire.ErrRequestFailed
+	}
+	return *r.battery, nil
+}
+
+func TestSpeedwire(t *testing.T) {
+	s := NewSpeedwire(&dummySpeedwireReader{battery: &speedwire.Battery{Soc: 55, Voltage: 400, Current: -2.5}})
+
+	tt := map[string]struct {
+		read func() (float64, error)
+		want float64
+	}{
+		"power":         {s.Power, 3000},
+		"energy total":  {s.EnergyTotal, 9000000},
+		"soc":           {s.Soc, 55},
+		"battery power": {s.BatteryPower, -1000},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			v, err := tc.read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v != tc.want {
+				t.Fatalf("want %v, got %v", tc.want, v)
+			}
+		})
+	}
+}
+
+func TestSpeedwire_NoBattery(t *testing.T) {
+	s := NewSpeedwire(&dummySpeedwireReader{})
+
+	if _, err := s.Soc(); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("want %v, got %v", ErrNotSupported, err)
+	}
+}
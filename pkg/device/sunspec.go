@@ -0,0 +1,63 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+)
+
+var (
+	_ PVInverter = (*SunSpec)(nil)
+	_ Battery    = (*SunSpec)(nil)
+)
+
+// pointReader reads the first available of the given SunSpec points.
+type pointReader interface {
+	GetAnyPoint(ps ...sunspec.Point) (float64, error)
+}
+
+// SunSpec adapts a SunSpec device, e.g. a *sunspec.ModbusDevice.
+//
+// Values of models not implemented by the device return ErrNotSupported.
+type SunSpec struct {
+	reader pointReader
+}
+
+// NewSunSpec creates an adapter for the SunSpec device.
+func NewSunSpec(reader pointReader) *SunSpec {
+	return &SunSpec{reader: reader}
+}
+
+func (s *SunSpec) point(ps ...sunspec.Point) (float64, error) {
+	v, err := s.reader.GetAnyPoint(ps...)
+	if errors.Is(err, sunspec.ErrPointNotImplemented) {
+		return 0, fmt.Errorf("%w: %v", ErrNotSupported, err)
+	}
+	return v, err
+}
+
+// Power returns the AC power of the inverter model.
+func (s *SunSpec) Power() (float64, error) {
+	return s.point(sunspec.PointPower1Phase, sunspec.PointPower2Phase, sunspec.PointPower3Phase)
+}
+
+// EnergyTotal returns the lifetime energy of the inverter model.
+func (s *SunSpec) EnergyTotal() (float64, error) {
+	return s.point(sunspec.PointEnergy1Phase, sunspec.PointEnergy2Phase, sunspec.PointEnergy3Phase)
+}
+
+// Soc returns the state of charge of the battery model, falling back to the storage model.
+func (s *SunSpec) Soc() (float64, error) {
+	return s.point(sunspec.PointBatterySoc, sunspec.PointSoc)
+}
+
+// BatteryPower returns the power of the battery model.
+func (s *SunSpec) BatteryPower() (float64, error) {
+	v, err := s.point(sunspec.PointBatteryPower)
+	if err != nil {
+		return 0, err
+	}
+
+	// the battery model counts discharging as positive
+	return -v, nil
+}
@@ -0,0 +1,61 @@
+package device
+
+import (
+	"errors"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"testing"
+)
+
+// dummyPointReader returns the values of the points of the implemented models.
+type dummyPointReader map[sunspec.Point]float64
+
+func (r dummyPointReader) GetAnyPoint(ps ...sunspec.Point) (float64, error) {
+	for _, p := range ps {
+		if v, ok := r[p]; ok {
+			return v, nil
+		}
+	}
+	return 0, sunspec.ErrPointNotImplemented
+}
+
+func TestSunSpec(t *testing.T) {
+	s := NewSunSpec(dummyPointReader{
+		sunspec.PointPower3Phase:  4200,
+		sunspec.PointEnergy3Phase: 12345000,
+		sunspec.PointSoc:          80,
+		sunspec.PointBatteryPower: 1500,
+	})
+
+	tt := map[string]struct {
+		read func() (float64, error)
+		want float64
+	}{
+		"power":         {s.Power, 4200},
+		"energy total":  {s.EnergyTotal, 12345000},
+		"soc":           {s.Soc, 80},
+		"battery power": {s.BatteryPower, -1500},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			v, err := tc.read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v != tc.want {
+				t.Fatalf("want %v, got %v", tc.want, v)
+			}
+		})
+	}
+}
+
+func TestSunSpec_NotSupported(t *testing.T) {
+	s := NewSunSpec(dummyPointReader{sunspec.PointPower1Phase: 800})
+
+	if _, err := s.Soc(); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("want %v, got %v", ErrNotSupported, err)
+	}
+	if _, err := s.BatteryPower(); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("want %v, got %v", ErrNotSupported, err)
+	}
+}
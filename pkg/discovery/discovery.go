@@ -3,8 +3,11 @@ package discovery
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"golang.org/x/net/ipv4"
 	"net"
 	"os"
@@ -14,6 +17,9 @@ import (
 const (
 	discoveryPayload       = "534d4100000402a0ffffffff0000002000000000"
 	discoveryVerifyPayload = "534d4100000402A000000001000200000001"
+
+	// tagIPAddress is the tag of a discovery response containing the device's ip address.
+	tagIPAddress uint16 = 0x0030
 )
 
 var multicastAddress = &net.UDPAddr{IP: net.IPv4(239, 12, 255, 254), Port: 9522}
@@ -30,74 +36,311 @@ func send(conn net.PacketConn) error {
 	return err
 }
 
-// listen listens for the returned discovery messages and returns the addresses of the senders.
-func listen(conn net.PacketConn, timeout time.Duration) ([]net.Addr, error) {
-	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+// multicastInterfaces returns the interfaces which are up and support multicast with an IPv4 address.
+func multicastInterfaces() ([]*net.Interface, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*net.Interface
+	for i := range interfaces {
+		ifi := &interfaces[i]
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				result = append(result, ifi)
+				break
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// multicastConn is a socket joined to the multicast group on one or more interfaces.
+type multicastConn struct {
+	*net.UDPConn
+	pconn      *ipv4.PacketConn
+	interfaces []*net.Interface
+}
+
+// listenMulticast opens a socket joined to the multicast group on the given interfaces.
+//
+// If no interfaces are given, all interfaces supporting multicast are used, falling back to the system
+// assigned interface. The socket allows address reuse, so it can be shared with other Speedwire users
+// listening on the same port, like the energy meter.
+func listenMulticast(interfaces []*net.Interface) (*multicastConn, error) {
+	if len(interfaces) == 0 {
+		var err error
+		interfaces, err = multicastInterfaces()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var first *net.Interface
+	if len(interfaces) > 0 {
+		first = interfaces[0]
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", first, multicastAddress)
+	if err != nil {
 		return nil, err
 	}
 
+	c := &multicastConn{UDPConn: conn, pconn: ipv4.NewPacketConn(conn), interfaces: interfaces}
+	for i := 1; i < len(interfaces); i++ {
+		ifi := interfaces[i]
+		if err := c.pconn.JoinGroup(ifi, multicastAddress); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("joining multicast group on %v: %w", ifi.Name, err)
+		}
+	}
+
+	return c, nil
+}
+
+// send sends the discovery request on each interface of the socket.
+//
+// Returns an error only if the request could not be sent on any interface.
+func (c *multicastConn) send() error {
+	if len(c.interfaces) == 0 {
+		return send(c)
+	}
+
+	var err error
+	sent := false
+	for _, ifi := range c.interfaces {
+		if e := c.pconn.SetMulticastInterface(ifi); e != nil {
+			err = e
+			continue
+		}
+		if e := send(c); e != nil {
+			err = e
+			continue
+		}
+		sent = true
+	}
+
+	if sent {
+		return nil
+	}
+	return err
+}
+
+// response is a discovery response of a device.
+type response struct {
+	addr net.Addr
+	// ip is the address reported by the device, nil if the response does not include it.
+	ip net.IP
+}
+
+// key identifies the device of the response by its ip address.
+func (r response) key() string {
+	if r.ip != nil {
+		return r.ip.String()
+	}
+	if udpAddr, ok := r.addr.(*net.UDPAddr); ok {
+		return udpAddr.IP.String()
+	}
+	return r.addr.String()
+}
+
+// parseResponse checks if the payload is a discovery response and extracts the reported ip address.
+func parseResponse(b, verifyPayload []byte) (net.IP, bool) {
+	if len(b) < len(verifyPayload) || !bytes.Equal(b[:len(verifyPayload)], verifyPayload) {
+		return nil, false
+	}
+
+	// the response continues with tags consisting of length, tag and data
+	tags := b[len(verifyPayload):]
+	for len(tags) >= 4 {
+		length := int(binary.BigEndian.Uint16(tags[0:2]))
+		tag := binary.BigEndian.Uint16(tags[2:4])
+		tags = tags[4:]
+		if length > len(tags) || tag == 0 && length == 0 {
+			break
+		}
+
+		if tag == tagIPAddress && length == net.IPv4len {
+			return net.IP(append([]byte(nil), tags[:length]...)), true
+		}
+
+		tags = tags[length:]
+	}
+
+	return nil, true
+}
+
+// listen listens for the returned discovery messages until the context is done and returns the responses of the senders.
+func listen(ctx context.Context, conn net.PacketConn) ([]response, error) {
+	// unblock the read when the context is done
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
 	verifyPayload, err := hex.DecodeString(discoveryVerifyPayload)
 	if err != nil {
 		return nil, err
 	}
 
-	results := make([]net.Addr, 0)
+	results := make([]response, 0)
 	for {
 		buf := make([]byte, 2500)
 		n, addr, err := conn.ReadFrom(buf)
 		if err != nil {
-			if errors.Is(err, os.ErrDeadlineExceeded) {
+			if ctx.Err() != nil && errors.Is(err, os.ErrDeadlineExceeded) {
 				break
 			} else {
 				return nil, err
 			}
 		}
 
+		ip, ok := parseResponse(buf[:n], verifyPayload)
+		if !ok {
+			continue
+		}
+
+		// devices answer the request of each interface, only their first response is kept
+		r := response{addr: addr, ip: ip}
 		var contains bool
 		for _, v := range results {
-			if addr == v {
+			if v.key() == r.key() {
 				contains = true
 				break
 			}
 		}
-
-		if !contains && bytes.Compare(buf[0:n], verifyPayload) == 0 {
-			results = append(results, addr)
+		if !contains {
+			results = append(results, r)
 		}
 	}
 
 	return results, nil
 }
 
+// discover sends the discovery request on the interfaces and returns the responses received until the context is done.
+func discover(ctx context.Context, interfaces []*net.Interface) (responses []response, err error) {
+	conn, err := listenMulticast(interfaces)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if e := conn.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	if err := conn.send(); err != nil {
+		return nil, err
+	}
+
+	return listen(ctx, conn)
+}
+
+// interfaceList converts the optional interface of the single interface functions to a list.
+func interfaceList(ifi *net.Interface) []*net.Interface {
+	if ifi == nil {
+		return nil
+	}
+	return []*net.Interface{ifi}
+}
+
 // DiscoverInverters discovers inverters connected to the network at the specified interface.
 //
 // The function sends a multicast discover request and waits the specified duration for responses.
+// If ifi is nil, all interfaces supporting multicast are used.
 // Returns the addresses of responding devices.
 func DiscoverInverters(ifi *net.Interface, timeout time.Duration) ([]net.Addr, error) {
-	conn, err := net.ListenUDP("udp4", multicastAddress)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return DiscoverInvertersContext(ctx, interfaceList(ifi)...)
+}
+
+// DiscoverInvertersContext discovers inverters connected to the network at the specified interfaces.
+//
+// The function sends a multicast discover request on each interface and collects the responses until the
+// context is done. If no interfaces are given, all interfaces supporting multicast are used.
+// Returns the addresses of responding devices.
+func DiscoverInvertersContext(ctx context.Context, interfaces ...*net.Interface) ([]net.Addr, error) {
+	responses, err := discover(ctx, interfaces)
 	if err != nil {
 		return nil, err
 	}
 
-	pconn := ipv4.NewPacketConn(conn)
-	err = pconn.JoinGroup(ifi, multicastAddress)
-	if err != nil {
-		return nil, err
+	addrs := make([]net.Addr, len(responses))
+	for i, r := range responses {
+		addrs[i] = r.addr
 	}
 
-	if err := send(conn); err != nil {
+	return addrs, nil
+}
+
+// DiscoverDevices discovers devices like DiscoverInverters and queries their identification using Identify.
+//
+// The timeout applies to the discovery and to each identification query.
+// Devices not answering the identification are returned with their IP address only.
+func DiscoverDevices(ifi *net.Interface, timeout time.Duration) ([]DiscoveredDevice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	responses, err := discover(ctx, interfaceList(ifi))
+	if err != nil {
 		return nil, err
 	}
 
-	addrs, err := listen(conn, timeout)
+	return identifyResponses(responses, timeout), nil
+}
+
+// DiscoverDevicesContext discovers devices like DiscoverInvertersContext and queries their identification using Identify.
+//
+// The context ends the discovery, each identification query is limited by identifyTimeout.
+// Devices not answering the identification are returned with their IP address only.
+func DiscoverDevicesContext(ctx context.Context, interfaces ...*net.Interface) ([]DiscoveredDevice, error) {
+	responses, err := discover(ctx, interfaces)
 	if err != nil {
 		return nil, err
 	}
 
-	e := conn.Close()
-	if e != nil {
-		panic(e)
+	return identifyResponses(responses, identifyTimeout), nil
+}
+
+// identifyResponses identifies the devices of the discovery responses.
+func identifyResponses(responses []response, timeout time.Duration) []DiscoveredDevice {
+	devices := make([]DiscoveredDevice, len(responses))
+	for i, r := range responses {
+		ip := r.ip
+		if ip == nil {
+			if udpAddr, ok := r.addr.(*net.UDPAddr); ok {
+				ip = udpAddr.IP
+			}
+		}
+
+		devices[i] = DiscoveredDevice{IP: ip}
+		if ip == nil {
+			continue
+		}
+
+		device, err := Identify(ip, timeout)
+		if err != nil {
+			continue
+		}
+		devices[i] = *device
 	}
 
-	return addrs, nil
+	return devices
 }
@@ -1,6 +1,7 @@
 package discovery
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"net"
@@ -95,7 +96,10 @@ func TestListen_Timeout(t *testing.T) {
 	timeout := 100 * time.Millisecond
 	start := time.Now()
 
-	_, err := listen(testConn, timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err := listen(ctx, testConn)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -127,8 +131,10 @@ func TestListen_Addresses(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	timeout := 100 * time.Millisecond
-	addrs, err := listen(&duplex.r, timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	addrs, err := listen(ctx, &duplex.r)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -138,7 +144,7 @@ func TestListen_Addresses(t *testing.T) {
 	}
 
 	for i := 0; i < len(addrs); i++ {
-		if testData[i].addr != addrs[i] {
+		if testData[i].addr != addrs[i].addr {
 			t.Fatalf("addresses not equal")
 		}
 	}
@@ -168,8 +174,10 @@ func TestListen_Invalid_Verify(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	timeout := 100 * time.Millisecond
-	addrs, err := listen(&duplex.r, timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	addrs, err := listen(ctx, &duplex.r)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -178,8 +186,8 @@ func TestListen_Invalid_Verify(t *testing.T) {
 		t.Fatalf("expected 1 addresses, got %d", len(addrs))
 	}
 
-	if addrs[0] != testData[1].addr {
-		t.Fatalf("incorrect address, expected %s got %s", testData[1].addr, addrs[0])
+	if addrs[0].addr != testData[1].addr {
+		t.Fatalf("incorrect address, expected %s got %s", testData[1].addr, addrs[0].addr)
 	}
 }
 
@@ -202,8 +210,10 @@ func TestListen_No_Duplicates(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	timeout := 100 * time.Millisecond
-	addrs, err := listen(&duplex.r, timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	addrs, err := listen(ctx, &duplex.r)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -212,8 +222,50 @@ func TestListen_No_Duplicates(t *testing.T) {
 		t.Fatalf("expected 1 addresses, got %d", len(addrs))
 	}
 
-	if addrs[0] != testData[0].addr {
-		t.Fatalf("incorrect address, expected %s got %s", testData[1].addr, addrs[0])
+	if addrs[0].addr != testData[0].addr {
+		t.Fatalf("incorrect address, expected %s got %s", testData[1].addr, addrs[0].addr)
+	}
+}
+
+// TestListen_No_Duplicates_UDP verifies that the replies of a device are deduplicated when each read returns a new
+// address.
+func TestListen_No_Duplicates_UDP(t *testing.T) {
+	t.Parallel()
+
+	testData := []testData{
+		{
+			&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9522},
+			discoveryVerifyPayload,
+		},
+		{
+			&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9522},
+			discoveryVerifyPayload,
+		},
+		{
+			&net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 9522},
+			discoveryVerifyPayload,
+		},
+	}
+
+	duplex, err := setupDiscoveryTest(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	addrs, err := listen(ctx, &duplex.r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(addrs) != 2 {
+		t.Fatalf("expected 2 addresses, got %d", len(addrs))
+	}
+
+	if addrs[0].addr.String() != "127.0.0.1:9522" || addrs[1].addr.String() != "127.0.0.2:9522" {
+		t.Fatalf("incorrect addresses, got %s and %s", addrs[0].addr, addrs[1].addr)
 	}
 }
 
@@ -222,17 +274,19 @@ func TestDiscoverInverters(t *testing.T) {
 		// send on loopback
 		verifyPayload, err := hex.DecodeString(discoveryVerifyPayload)
 		if err != nil {
-			t.Fatal(err)
+			t.Error(err)
+			return
 		}
 
 		conn, err := net.Dial("udp4", "239.12.255.254:9522")
 		if err != nil {
-			t.Fatal(err)
+			t.Error(err)
+			return
 		}
 
 		_, err = conn.Write(verifyPayload)
 		if err != nil {
-			t.Fatal(err)
+			t.Error(err)
 		}
 	}()
 
@@ -247,3 +301,109 @@ func TestDiscoverInverters(t *testing.T) {
 		t.Fatal("no addresses found")
 	}
 }
+
+func TestParseResponse(t *testing.T) {
+	verifyPayload, err := hex.DecodeString(discoveryVerifyPayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := map[string]struct {
+		payload string
+		ok      bool
+		ip      net.IP
+	}{
+		"minimal":   {discoveryVerifyPayload, true, nil},
+		"with ip":   {discoveryVerifyPayload + "000400100001000300040020000000010004003" + "0c0a8b20e0000000000", true, net.IPv4(192, 168, 178, 14)},
+		"truncated": {discoveryVerifyPayload[:20], false, nil},
+		"other":     {"534d4100000402a0ffffffff0000002000000000", false, nil},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			b, err := hex.DecodeString(tc.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ip, ok := parseResponse(b, verifyPayload)
+			if ok != tc.ok {
+				t.Fatalf("expected %v, got %v", tc.ok, ok)
+			}
+			if !ip.Equal(tc.ip) {
+				t.Fatalf("expected ip %v, got %v", tc.ip, ip)
+			}
+		})
+	}
+}
+
+// TestListen_Canceled verifies that listening stops when the context is canceled.
+func TestListen_Canceled(t *testing.T) {
+	t.Parallel()
+
+	conn, _ := net.Pipe()
+	testConn := &dummyConn{conn, dummyAddr("127.0.0.1")}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	responses, err := listen(ctx, testConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 0 {
+		t.Fatalf("expected no responses, got %v", responses)
+	}
+}
+
+// TestListenMulticast_Shared verifies that the discovery socket can be opened while another Speedwire socket,
+// like the one of the energy meter, listens on the same port, and that both receive the multicast messages.
+func TestListenMulticast_Shared(t *testing.T) {
+	other, err := net.ListenMulticastUDP("udp4", nil, multicastAddress)
+	if err != nil {
+		t.Skipf("multicast not available: %v", err)
+	}
+	defer other.Close()
+
+	conn, err := listenMulticast(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sender, err := net.Dial("udp4", multicastAddress.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	if _, err := sender.Write([]byte("SMA\x00")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []*net.UDPConn{other, conn.UDPConn} {
+		if err := c.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 16)
+		n, _, err := c.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != "SMA\x00" {
+			t.Fatalf("unexpected message %q", buf[:n])
+		}
+	}
+}
+
+func TestMulticastInterfaces(t *testing.T) {
+	interfaces, err := multicastInterfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ifi := range interfaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 {
+			t.Fatalf("interface %v does not support multicast", ifi.Name)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package discovery
+
+import (
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/speedwire"
+	"net"
+	"strconv"
+	"time"
+)
+
+// identifyTimeout is the timeout for identifying a device if no timeout is given.
+const identifyTimeout = 2 * time.Second
+
+// DiscoveredDevice contains the identification of a discovered SMA device.
+//
+// The fields queried via Speedwire are left empty if the device does not answer the query,
+// e.g. because it requires a login.
+type DiscoveredDevice struct {
+	IP              net.IP
+	SusyID          uint16
+	SerialNo        uint32
+	Name            string
+	DeviceClass     string
+	SoftwareVersion string
+}
+
+func (d DiscoveredDevice) String() string {
+	return fmt.Sprintf("DiscoveredDevice{ip:%v,susyID:%v,serialNo:%v,name:%v,class:%v,version:%v}",
+		d.IP, d.SusyID, d.SerialNo, d.Name, d.DeviceClass, d.SoftwareVersion)
+}
+
+// Identify queries the identification of the SMA device at the given address using Speedwire.
+func Identify(ip net.IP, timeout time.Duration) (*DiscoveredDevice, error) {
+	device, err := identify(net.JoinHostPort(ip.String(), strconv.Itoa(speedwire.Port)), timeout)
+	if err != nil {
+		return nil, err
+	}
+	device.IP = ip
+
+	return device, nil
+}
+
+// identify queries the identification of the device at the given address.
+func identify(addr string, timeout time.Duration) (*DiscoveredDevice, error) {
+	c, err := speedwire.Connect(addr, speedwire.WithTimeout(timeout))
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	device := &DiscoveredDevice{SusyID: c.SusyID(), SerialNo: c.SerialNo()}
+
+	// the nameplate is optional, some devices only answer after a login
+	if nameplate, err := c.Nameplate(); err == nil {
+		device.Name = nameplate.Name
+		device.DeviceClass = nameplate.DeviceClass
+		device.SoftwareVersion = nameplate.SoftwareVersion
+	}
+
+	return device, nil
+}
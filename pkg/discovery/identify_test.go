@@ -0,0 +1,134 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"github.com/orlopau/go-energy/pkg/speedwire"
+	"net"
+	"testing"
+	"time"
+)
+
+const (
+	testSusyID   uint16 = 372
+	testSerialNo uint32 = 3000123456
+
+	cmdIdentify        uint32 = 0x00000200
+	lriNameplateName   uint32 = 0x821e
+	lriNameplateClass  uint32 = 0x821f
+	lriSoftwareVersion uint32 = 0x8234
+	attributeEnd       uint32 = 0x00fffffe
+)
+
+// nameplateRecord encodes a nameplate record with the given value.
+func nameplateRecord(lri uint32, value []byte) []byte {
+	record := make([]byte, 40)
+	binary.LittleEndian.PutUint32(record, lri<<8|0x01)
+	copy(record[8:], value)
+	return record
+}
+
+// startTestInverter answers identification queries, the software version is only returned if withVersion is set.
+func startTestInverter(t *testing.T, withVersion bool) *net.UDPConn {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	class := make([]byte, 32)
+	binary.LittleEndian.PutUint32(class[0:], 8001|1<<24)
+	binary.LittleEndian.PutUint32(class[4:], attributeEnd)
+
+	version := make([]byte, 32)
+	copy(version[16:], []byte{4, 30, 0x02, 0x03})
+
+	go func() {
+		buf := make([]byte, 2500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			req, err := speedwire.ParsePacket(buf[:n])
+			if err != nil {
+				continue
+			}
+
+			res := &speedwire.Packet{
+				DstSusyID:   req.SrcSusyID,
+				DstSerialNo: req.SrcSerialNo,
+				SrcSusyID:   testSusyID,
+				SrcSerialNo: testSerialNo,
+				PacketID:    req.PacketID,
+				Command:     req.Command + 1,
+				Payload:     make([]byte, 8),
+			}
+
+			var records []byte
+			switch first := binary.LittleEndian.Uint32(req.Payload); {
+			case req.Command == cmdIdentify:
+			case first == lriNameplateName<<8:
+				records = append(nameplateRecord(lriNameplateName, []byte("SN: 3000123456")),
+					nameplateRecord(lriNameplateClass, class)...)
+			case first == lriSoftwareVersion<<8 && withVersion:
+				records = nameplateRecord(lriSoftwareVersion, version)
+			default:
+				res.ErrorCode = 0x0017
+			}
+			if len(records) > 0 {
+				binary.LittleEndian.PutUint32(res.Payload[4:], uint32(len(records)/40-1))
+				res.Payload = append(res.Payload, records...)
+			}
+
+			b, err := res.MarshalBinary()
+			if err != nil {
+				return
+			}
+			if _, err := conn.WriteToUDP(b, addr); err != nil {
+				return
+			}
+		}
+	}()
+
+	return conn
+}
+
+func TestIdentify(t *testing.T) {
+	tt := map[string]struct {
+		withVersion bool
+		version     string
+	}{
+		"complete":        {true, "03.02.30.R"},
+		"without version": {false, ""},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			inverter := startTestInverter(t, tc.withVersion)
+			defer inverter.Close()
+
+			device, err := identify(inverter.LocalAddr().String(), time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := DiscoveredDevice{
+				SusyID:          testSusyID,
+				SerialNo:        testSerialNo,
+				Name:            "SN: 3000123456",
+				DeviceClass:     "Solar Inverter",
+				SoftwareVersion: tc.version,
+			}
+			if device.String() != want.String() {
+				t.Fatalf("expected %v, got %v", want, device)
+			}
+		})
+	}
+}
+
+// TestIdentify_NoDevice verifies that an error is returned if no device answers.
+func TestIdentify_NoDevice(t *testing.T) {
+	_, err := Identify(net.IPv4(127, 0, 0, 1), 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
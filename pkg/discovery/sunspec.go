@@ -0,0 +1,200 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/modbus"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// probeTimeout is the timeout for connecting to a port and for each modbus request while scanning.
+	probeTimeout = 2 * time.Second
+	// scanWorkers is the number of addresses probed concurrently.
+	scanWorkers = 64
+	// maxScanHostBits limits the size of the scanned network to 2^maxScanHostBits addresses.
+	maxScanHostBits = 16
+)
+
+var (
+	// DefaultSunSpecPorts are the modbus tcp ports scanned if no ports are given.
+	DefaultSunSpecPorts = []int{502, 1502}
+	// DefaultSunSpecUnitIDs are the unit ids scanned if no unit ids are given.
+	//
+	// Fronius and SolarEdge devices use 1, Kostal devices 71 and SMA devices 3 or 126.
+	DefaultSunSpecUnitIDs = []byte{1, 3, 71, 126}
+)
+
+// SunSpecDevice is a SunSpec compatible device found by ScanSunSpec.
+type SunSpecDevice struct {
+	IP     net.IP
+	Port   int
+	UnitID byte
+	// Models contains the ids of the SunSpec models implemented by the device in ascending order.
+	Models []uint16
+	// Common contains the identification read from the common model, empty if it could not be read.
+	Common sunspec.CommonModel
+}
+
+func (d SunSpecDevice) String() string {
+	return fmt.Sprintf("SunSpecDevice{ip:%v,port:%v,unitID:%v,manufacturer:%v,model:%v,serialNumber:%v,models:%v}",
+		d.IP, d.Port, d.UnitID, d.Common.Manufacturer, d.Common.Model, d.Common.SerialNumber, d.Models)
+}
+
+// ScanSunSpec scans the hosts of a network for SunSpec compatible modbus tcp devices.
+//
+// The network is given in CIDR notation, e.g. 192.168.1.0/24. Every host is probed on each of the ports,
+// and each open port is probed for each unit id. If ports or unitIDs are empty, DefaultSunSpecPorts and
+// DefaultSunSpecUnitIDs are used.
+//
+// Returns the devices found so far together with the error of the context if it is done before the scan completes.
+func ScanSunSpec(ctx context.Context, cidr string, ports []int, unitIDs []byte) ([]SunSpecDevice, error) {
+	hosts, err := networkHosts(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ports) == 0 {
+		ports = DefaultSunSpecPorts
+	}
+	if len(unitIDs) == 0 {
+		unitIDs = DefaultSunSpecUnitIDs
+	}
+
+	addrs := make(chan *net.TCPAddr)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	devices := make([]SunSpecDevice, 0)
+
+	for i := 0; i < scanWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for addr := range addrs {
+				found := probeSunSpec(ctx, addr, unitIDs)
+				mu.Lock()
+				devices = append(devices, found...)
+				mu.Unlock()
+			}
+		}()
+	}
+
+scan:
+	for _, ip := range hosts {
+		for _, port := range ports {
+			select {
+			case addrs <- &net.TCPAddr{IP: ip, Port: port}:
+			case <-ctx.Done():
+				break scan
+			}
+		}
+	}
+	close(addrs)
+	wg.Wait()
+
+	sort.Slice(devices, func(i, j int) bool {
+		a, b := devices[i], devices[j]
+		if c := bytes.Compare(a.IP.To16(), b.IP.To16()); c != 0 {
+			return c < 0
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.UnitID < b.UnitID
+	})
+
+	return devices, ctx.Err()
+}
+
+// probeSunSpec checks if the port is open and probes each unit id for a SunSpec device.
+func probeSunSpec(ctx context.Context, addr *net.TCPAddr, unitIDs []byte) []SunSpecDevice {
+	dialer := net.Dialer{Timeout: probeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr.String())
+	if err != nil {
+		return nil
+	}
+	_ = conn.Close()
+
+	var devices []SunSpecDevice
+	for _, unitID := range unitIDs {
+		if ctx.Err() != nil {
+			break
+		}
+
+		device, err := probeSunSpecUnit(addr, unitID)
+		if err != nil {
+			continue
+		}
+		devices = append(devices, *device)
+	}
+
+	return devices
+}
+
+// probeSunSpecUnit reads the models and common model of the device with the given unit id.
+func probeSunSpecUnit(addr *net.TCPAddr, unitID byte) (*SunSpecDevice, error) {
+	d, err := sunspec.Connect(addr.String(), modbus.WithTimeout(probeTimeout), modbus.WithoutReconnect())
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	d.SetDeviceAddress(unitID)
+
+	models, err := d.Models()
+	if err != nil {
+		return nil, err
+	}
+
+	device := &SunSpecDevice{IP: addr.IP, Port: addr.Port, UnitID: unitID, Models: models}
+
+	// the common model is mandatory, but not every device implements all of its points
+	if common, err := d.ReadCommonModel(); err == nil {
+		device.Common = common
+	}
+
+	return device, nil
+}
+
+// networkHosts returns the host addresses of the network in CIDR notation.
+//
+// For IPv4 networks larger than /31, the network and broadcast addresses are excluded.
+func networkHosts(cidr string) ([]net.IP, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	ones, bits := network.Mask.Size()
+	if bits-ones > maxScanHostBits {
+		return nil, fmt.Errorf("network %v has more than %v addresses", network, 1<<maxScanHostBits)
+	}
+
+	hosts := make([]net.IP, 0, 1<<(bits-ones))
+	for ip := network.IP; network.Contains(ip); ip = nextIP(ip) {
+		hosts = append(hosts, ip)
+	}
+
+	if bits == 8*net.IPv4len && bits-ones > 1 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+
+	return hosts, nil
+}
+
+// nextIP returns the address following ip.
+func nextIP(ip net.IP) net.IP {
+	next := append(net.IP(nil), ip...)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
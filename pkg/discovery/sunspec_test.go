@@ -0,0 +1,131 @@
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/phayes/freeport"
+	"github.com/tbrandon/mbserver"
+	"net"
+	"testing"
+	"time"
+)
+
+// setStringRegisters writes a string into the registers starting at address.
+func setStringRegisters(r []uint16, address int, s string) {
+	b := make([]byte, (len(s)+1)/2*2)
+	copy(b, s)
+	for i := 0; i < len(b); i += 2 {
+		r[address+i/2] = binary.BigEndian.Uint16(b[i:])
+	}
+}
+
+// startSunSpecServer starts a modbus server with a common model and an inverter model at base address 40000.
+func startSunSpecServer(t *testing.T) int {
+	port, err := freeport.GetFreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := mbserver.NewServer()
+	if err := server.ListenTCP(fmt.Sprintf("127.0.0.1:%v", port)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	r := server.HoldingRegisters
+	r[40000], r[40001] = 0x5375, 0x6e53
+
+	// common model
+	r[40002], r[40003] = 1, 66
+	setStringRegisters(r, 40004, "Fronius")
+	setStringRegisters(r, 40020, "Symo 10.0-3-M")
+	setStringRegisters(r, 40036, "3")
+	setStringRegisters(r, 40044, "0.3.30.2")
+	setStringRegisters(r, 40052, "12345678")
+
+	// inverter model
+	r[40070], r[40071] = 103, 50
+	r[40122] = 0xffff
+
+	return port
+}
+
+func TestScanSunSpec(t *testing.T) {
+	port := startSunSpecServer(t)
+
+	closedPort, err := freeport.GetFreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devices, err := ScanSunSpec(context.Background(), "127.0.0.1/32", []int{closedPort, port}, []byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devices) != 1 {
+		t.Fatalf("expected 1 device, got %v", devices)
+	}
+
+	d := devices[0]
+	if !d.IP.Equal(net.IPv4(127, 0, 0, 1)) || d.Port != port || d.UnitID != 1 {
+		t.Fatalf("unexpected address %v", d)
+	}
+	if fmt.Sprint(d.Models) != "[1 103]" {
+		t.Fatalf("expected models [1 103], got %v", d.Models)
+	}
+	if d.Common.Manufacturer != "Fronius" || d.Common.Model != "Symo 10.0-3-M" || d.Common.SerialNumber != "12345678" {
+		t.Fatalf("unexpected common model %+v", d.Common)
+	}
+}
+
+func TestScanSunSpec_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	_, err := ScanSunSpec(ctx, "10.255.0.0/16", []int{502}, nil)
+	if err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+	if time.Since(start) > probeTimeout {
+		t.Fatal("scan did not stop")
+	}
+}
+
+func TestNetworkHosts(t *testing.T) {
+	tt := map[string]struct {
+		cidr  string
+		first string
+		last  string
+		count int
+		wErr  bool
+	}{
+		"single":         {cidr: "192.168.1.10/32", first: "192.168.1.10", last: "192.168.1.10", count: 1},
+		"point to point": {cidr: "192.168.1.10/31", first: "192.168.1.10", last: "192.168.1.11", count: 2},
+		"class c":        {cidr: "192.168.1.17/24", first: "192.168.1.1", last: "192.168.1.254", count: 254},
+		"ipv6":           {cidr: "fd00::/126", first: "fd00::", last: "fd00::3", count: 4},
+		"too large":      {cidr: "10.0.0.0/8", wErr: true},
+		"invalid":        {cidr: "192.168.1.1", wErr: true},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			hosts, err := networkHosts(tc.cidr)
+			if (err != nil) != tc.wErr {
+				t.Fatalf("expected error %v, got %v", tc.wErr, err)
+			}
+			if tc.wErr {
+				return
+			}
+
+			if len(hosts) != tc.count {
+				t.Fatalf("expected %v hosts, got %v", tc.count, len(hosts))
+			}
+			if hosts[0].String() != tc.first || hosts[len(hosts)-1].String() != tc.last {
+				t.Fatalf("expected %v-%v, got %v-%v", tc.first, tc.last, hosts[0], hosts[len(hosts)-1])
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package discovery
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/logger"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// watchMissedIntervals is the number of discovery intervals without a response after which a device is gone.
+	watchMissedIntervals = 3
+)
+
+// EventType is the type of a discovery event.
+type EventType int
+
+const (
+	// DeviceAppeared is emitted when a device answers the discovery for the first time.
+	DeviceAppeared EventType = iota
+	// DeviceChanged is emitted when a known device answers from a new ip address, e.g. after a new DHCP lease.
+	DeviceChanged
+	// DeviceGone is emitted when a device did not answer the discovery for several intervals.
+	DeviceGone
+)
+
+func (t EventType) String() string {
+	switch t {
+	case DeviceAppeared:
+		return "DeviceAppeared"
+	case DeviceChanged:
+		return "DeviceChanged"
+	case DeviceGone:
+		return "DeviceGone"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event is emitted by a Watcher when the state of a device changes.
+type Event struct {
+	Type   EventType
+	Device DiscoveredDevice
+	// PreviousIP is the former address of the device for DeviceChanged events.
+	PreviousIP net.IP
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("Event{type:%v,device:%v,previousIP:%v}", e.Type, e.Device, e.PreviousIP)
+}
+
+// identification is the result of identifying a device answering from an unknown ip address.
+type identification struct {
+	ip     net.IP
+	device *DiscoveredDevice
+	err    error
+}
+
+// watchedDevice is a device known to a Watcher.
+type watchedDevice struct {
+	device   DiscoveredDevice
+	lastSeen time.Time
+}
+
+// Watcher continuously discovers devices and emits events when devices appear, change their address or disappear.
+//
+// Devices are identified by their serial number, devices not answering the identification by their ip address.
+type Watcher struct {
+	conn     net.PacketConn
+	send     func() error
+	identify func(ip net.IP) (*DiscoveredDevice, error)
+	interval time.Duration
+	log      logger.Logger
+
+	events chan Event
+	done   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+
+	// devices contains the known devices by key, keys contains the key of each known ip address.
+	devices map[string]*watchedDevice
+	keys    map[string]string
+	// identifying contains the time of the last response of the ip addresses being identified.
+	identifying map[string]time.Time
+	identified  chan identification
+}
+
+// watcherConfig holds the settings applied by WatcherOptions.
+type watcherConfig struct {
+	interfaces []*net.Interface
+	log        logger.Logger
+}
+
+// WatcherOption configures a Watcher.
+type WatcherOption func(*watcherConfig)
+
+// WithInterfaces sends and receives the discovery on the given interfaces. By default, all interfaces supporting
+// multicast are used.
+func WithInterfaces(interfaces ...*net.Interface) WatcherOption {
+	return func(c *watcherConfig) {
+		c.interfaces = interfaces
+	}
+}
+
+// WithLogger sets the logger of device events and failed discovery requests, nothing is logged by default.
+func WithLogger(l logger.Logger) WatcherOption {
+	return func(c *watcherConfig) {
+		c.log = l
+	}
+}
+
+// NewWatcher starts a Watcher which re-sends the discovery request every interval and passively listens for
+// responses in between.
+//
+// The Watcher must be closed to release the connection.
+func NewWatcher(interval time.Duration, opts ...WatcherOption) (*Watcher, error) {
+	c := &watcherConfig{}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	conn, err := listenMulticast(c.interfaces)
+	if err != nil {
+		return nil, err
+	}
+
+	identify := func(ip net.IP) (*DiscoveredDevice, error) {
+		return Identify(ip, identifyTimeout)
+	}
+
+	return newWatcher(conn, conn.send, identify, interval, c.log), nil
+}
+
+func newWatcher(conn net.PacketConn, send func() error, identify func(net.IP) (*DiscoveredDevice, error), interval time.Duration, log logger.Logger) *Watcher {
+	w := &Watcher{
+		conn:     conn,
+		send:     send,
+		identify: identify,
+		interval: interval,
+		log:      logger.OrDiscard(log),
+		events:   make(chan Event, 16),
+		done:     make(chan struct{}),
+		devices:  make(map[string]*watchedDevice),
+		keys:     make(map[string]string),
+
+		identifying: make(map[string]time.Time),
+		identified:  make(chan identification),
+	}
+
+	responses := make(chan net.IP)
+	w.wg.Add(2)
+	go w.read(responses)
+	go w.run(responses)
+
+	return w
+}
+
+// Events returns the channel of emitted events, it is closed after the Watcher is closed.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Close stops the Watcher and closes its connection.
+func (w *Watcher) Close() error {
+	var err error
+	w.once.Do(func() {
+		close(w.done)
+		err = w.conn.Close()
+		w.wg.Wait()
+		close(w.events)
+	})
+	return err
+}
+
+// read reads discovery responses until the connection is closed.
+func (w *Watcher) read(responses chan<- net.IP) {
+	defer w.wg.Done()
+
+	verifyPayload, _ := hex.DecodeString(discoveryVerifyPayload)
+	buf := make([]byte, 2500)
+	for {
+		n, addr, err := w.conn.ReadFrom(buf)
+		if err != nil {
+			// the connection was closed or is unusable, wait for the watcher to be closed
+			select {
+			case <-w.done:
+			default:
+				w.log.Error("couldn't read discovery responses", "error", err)
+				<-w.done
+			}
+			return
+		}
+
+		ip, ok := parseResponse(buf[:n], verifyPayload)
+		if !ok {
+			continue
+		}
+		if ip == nil {
+			udpAddr, ok := addr.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+			ip = udpAddr.IP
+		}
+
+		select {
+		case responses <- ip:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// run sends the discovery requests and tracks the devices.
+func (w *Watcher) run(responses <-chan net.IP) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	w.sendRequest()
+	for {
+		select {
+		case <-w.done:
+			return
+		case ip := <-responses:
+			w.seen(ip, time.Now())
+		case id := <-w.identified:
+			w.add(id)
+		case now := <-ticker.C:
+			w.expire(now)
+			w.sendRequest()
+		}
+	}
+}
+
+// sendRequest sends the discovery request, a failed request is retried in the next interval.
+func (w *Watcher) sendRequest() {
+	if err := w.send(); err != nil {
+		w.log.Warn("couldn't send discovery request", "error", err)
+	}
+}
+
+// seen updates the device answering from the ip address, identifying it if the address is unknown.
+//
+// The identification runs in the background, at most once at a time per ip address.
+func (w *Watcher) seen(ip net.IP, now time.Time) {
+	if key, ok := w.keys[ip.String()]; ok {
+		w.devices[key].lastSeen = now
+		return
+	}
+
+	if _, ok := w.identifying[ip.String()]; ok {
+		w.identifying[ip.String()] = now
+		return
+	}
+	w.identifying[ip.String()] = now
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		device, err := w.identify(ip)
+		select {
+		case w.identified <- identification{ip: ip, device: device, err: err}:
+		case <-w.done:
+		}
+	}()
+}
+
+// add adds the identified device, or updates the known device with the same serial number.
+func (w *Watcher) add(id identification) {
+	ip, device, now := id.ip, id.device, w.identifying[id.ip.String()]
+	delete(w.identifying, ip.String())
+
+	if id.err != nil {
+		w.log.Debug("couldn't identify device", "ip", ip, "error", id.err)
+		device = &DiscoveredDevice{}
+	}
+	device.IP = ip
+
+	key := "ip:" + ip.String()
+	if device.SerialNo != 0 {
+		key = fmt.Sprint(device.SerialNo)
+	}
+	w.keys[ip.String()] = key
+
+	known, ok := w.devices[key]
+	if !ok {
+		w.devices[key] = &watchedDevice{device: *device, lastSeen: now}
+		w.emit(Event{Type: DeviceAppeared, Device: *device})
+		return
+	}
+
+	previous := known.device.IP
+	delete(w.keys, previous.String())
+	known.device, known.lastSeen = *device, now
+	w.emit(Event{Type: DeviceChanged, Device: *device, PreviousIP: previous})
+}
+
+// expire removes the devices which did not answer for watchMissedIntervals intervals.
+func (w *Watcher) expire(now time.Time) {
+	for key, d := range w.devices {
+		if now.Sub(d.lastSeen) < watchMissedIntervals*w.interval {
+			continue
+		}
+
+		delete(w.devices, key)
+		delete(w.keys, d.device.IP.String())
+		w.emit(Event{Type: DeviceGone, Device: d.device})
+	}
+}
+
+func (w *Watcher) emit(e Event) {
+	w.log.Info("discovery event", "type", e.Type, "ip", e.Device.IP, "serial", e.Device.SerialNo, "previous_ip", e.PreviousIP)
+
+	select {
+	case w.events <- e:
+	case <-w.done:
+	}
+}
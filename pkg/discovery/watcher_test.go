@@ -0,0 +1,174 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/orlopau/go-energy/pkg/logger"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sendTestResponse sends a discovery response reporting the ip to the watcher's connection.
+func sendTestResponse(t *testing.T, to net.Addr, ip net.IP) {
+	payload, err := hex.DecodeString(discoveryVerifyPayload + "00040030")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload = append(payload, ip.To4()...)
+
+	conn, err := net.Dial("udp4", to.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func nextEvent(t *testing.T, w *Watcher) Event {
+	select {
+	case e := <-w.Events():
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return Event{}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sends := make(chan struct{}, 100)
+	serials := map[string]uint32{
+		"10.0.0.5": 3000123456,
+		"10.0.0.6": 3000123456,
+	}
+	identify := func(ip net.IP) (*DiscoveredDevice, error) {
+		serial, ok := serials[ip.String()]
+		if !ok {
+			return nil, errors.New("no answer")
+		}
+		return &DiscoveredDevice{IP: ip, SusyID: 372, SerialNo: serial}, nil
+	}
+
+	var logs bytes.Buffer
+	interval := 50 * time.Millisecond
+	w := newWatcher(conn, func() error { sends <- struct{}{}; return nil }, identify, interval, logger.Std(log.New(&logs, "", 0)))
+	defer w.Close()
+
+	sendTestResponse(t, conn.LocalAddr(), net.IPv4(10, 0, 0, 5))
+	e := nextEvent(t, w)
+	if e.Type != DeviceAppeared || !e.Device.IP.Equal(net.IPv4(10, 0, 0, 5)) || e.Device.SerialNo != 3000123456 {
+		t.Fatalf("unexpected event %v", e)
+	}
+
+	// the same device with a new lease
+	sendTestResponse(t, conn.LocalAddr(), net.IPv4(10, 0, 0, 6))
+	e = nextEvent(t, w)
+	if e.Type != DeviceChanged || !e.Device.IP.Equal(net.IPv4(10, 0, 0, 6)) || !e.PreviousIP.Equal(net.IPv4(10, 0, 0, 5)) {
+		t.Fatalf("unexpected event %v", e)
+	}
+
+	// a device not answering the identification
+	sendTestResponse(t, conn.LocalAddr(), net.IPv4(10, 0, 0, 7))
+	e = nextEvent(t, w)
+	if e.Type != DeviceAppeared || !e.Device.IP.Equal(net.IPv4(10, 0, 0, 7)) || e.Device.SerialNo != 0 {
+		t.Fatalf("unexpected event %v", e)
+	}
+
+	gone := map[string]bool{}
+	for len(gone) < 2 {
+		e := nextEvent(t, w)
+		if e.Type != DeviceGone {
+			t.Fatalf("unexpected event %v", e)
+		}
+		gone[e.Device.IP.String()] = true
+	}
+	if !gone["10.0.0.6"] || !gone["10.0.0.7"] {
+		t.Fatalf("unexpected devices gone %v", gone)
+	}
+
+	if len(sends) < watchMissedIntervals {
+		t.Fatalf("expected at least %v discovery requests, got %v", watchMissedIntervals, len(sends))
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-w.Events(); ok {
+		t.Fatal("expected closed events channel")
+	}
+
+	if n := strings.Count(logs.String(), "INFO discovery event"); n != 5 {
+		t.Fatalf("expected 5 logged events, got %v in %q", n, logs.String())
+	}
+	if !strings.Contains(logs.String(), "DEBUG couldn't identify device ip=10.0.0.7 error=\"no answer\"") {
+		t.Fatalf("expected failed identification to be logged, got %q", logs.String())
+	}
+}
+
+func TestWatcher_SlowIdentify(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	identified := map[string]int{}
+	identify := func(ip net.IP) (*DiscoveredDevice, error) {
+		mu.Lock()
+		identified[ip.String()]++
+		mu.Unlock()
+
+		if ip.Equal(net.IPv4(10, 0, 0, 8)) {
+			<-release
+		}
+		return &DiscoveredDevice{IP: ip, SerialNo: uint32(ip.To4()[3])}, nil
+	}
+
+	w := newWatcher(conn, func() error { return nil }, identify, time.Minute, nil)
+	defer w.Close()
+
+	// the slow device answers repeatedly while being identified
+	for i := 0; i < 3; i++ {
+		sendTestResponse(t, conn.LocalAddr(), net.IPv4(10, 0, 0, 8))
+	}
+	sendTestResponse(t, conn.LocalAddr(), net.IPv4(10, 0, 0, 9))
+
+	e := nextEvent(t, w)
+	if e.Type != DeviceAppeared || !e.Device.IP.Equal(net.IPv4(10, 0, 0, 9)) {
+		t.Fatalf("expected the fast device to appear first, got %v", e)
+	}
+
+	close(release)
+	e = nextEvent(t, w)
+	if e.Type != DeviceAppeared || !e.Device.IP.Equal(net.IPv4(10, 0, 0, 8)) {
+		t.Fatalf("unexpected event %v", e)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if identified["10.0.0.8"] != 1 {
+		t.Fatalf("expected a single identification, got %v", identified["10.0.0.8"])
+	}
+}
+
+func TestEventType_String(t *testing.T) {
+	if s := DeviceGone.String(); s != "DeviceGone" {
+		t.Fatalf("expected DeviceGone, got %v", s)
+	}
+	if s := EventType(7).String(); s != "EventType(7)" {
+		t.Fatalf("expected EventType(7), got %v", s)
+	}
+}
@@ -0,0 +1,104 @@
+// Provides a Prometheus exporter for SunSpec devices and SMA energy meters.
+package exporter
+
+import (
+	"github.com/orlopau/go-energy/pkg/meter"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	namespace = "goenergy"
+
+	// contentType is the content type of the Prometheus text exposition format.
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// collector provides the current samples of one or more devices.
+type collector interface {
+	collect() []sample
+	close() error
+}
+
+// Exporter polls SunSpec devices and listens to energy meter telegrams, serving their values as
+// Prometheus metrics.
+//
+// Each device has a goenergy_up metric, which is 0 while the device is unavailable. Values of
+// unavailable devices are omitted instead of reporting stale values.
+type Exporter struct {
+	interval time.Duration
+
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// New creates an exporter polling SunSpec devices every interval.
+func New(interval time.Duration) *Exporter {
+	return &Exporter{interval: interval}
+}
+
+func (e *Exporter) add(c collector) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.collectors = append(e.collectors, c)
+}
+
+// AddSunSpec starts polling the SunSpec device with the modbus tcp address and unit id.
+//
+// Unreachable devices are retried every interval.
+func (e *Exporter) AddSunSpec(addr string, unitID byte) {
+	e.add(newSunSpecCollector(addr, unitID, e.interval, connectSunSpec))
+}
+
+// AddEnergyMeter starts reading the telegrams of the energy meter connection, exporting the values of
+// every meter sending telegrams to it. Read errors are logged with the logger of the connection. The
+// connection is closed when the exporter is closed.
+func (e *Exporter) AddEnergyMeter(m *meter.EnergyMeter) {
+	e.add(newMeterCollector(m, meterTimeout, meterRetryDelay, m.Logger))
+}
+
+// ServeHTTP writes the current metrics of all devices.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	_ = writeText(w, e.collect())
+}
+
+func (e *Exporter) collect() []sample {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var samples []sample
+	for _, c := range e.collectors {
+		samples = append(samples, c.collect()...)
+	}
+	return samples
+}
+
+// Close stops polling and closes the connections of all devices.
+func (e *Exporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var err error
+	for _, c := range e.collectors {
+		if cerr := c.close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	e.collectors = nil
+
+	return err
+}
+
+// upSample returns the sample of the goenergy_up metric.
+func upSample(up bool, labels ...label) sample {
+	return sample{
+		name:   namespace + "_up",
+		help:   "Whether the device is reachable and sends current values.",
+		typ:    typeGauge,
+		labels: labels,
+		value:  boolValue(up),
+	}
+}
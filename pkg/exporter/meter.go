@@ -0,0 +1,193 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/logger"
+	"github.com/orlopau/go-energy/pkg/meter"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	meterPrefix = namespace + "_meter"
+
+	// meterTimeout is the duration without telegrams after which an energy meter is down.
+	meterTimeout = 10 * time.Second
+	// meterRetryDelay is the time waited after a failed read before reading again.
+	meterRetryDelay = time.Second
+)
+
+// telegramReader reads energy meter telegrams, e.g. a *meter.EnergyMeter.
+type telegramReader interface {
+	ReadTelegram() (*meter.EnergyMeterTelegram, error)
+	Close() error
+}
+
+// meterState is the latest telegram of an energy meter.
+type meterState struct {
+	telegram *meter.EnergyMeterTelegram
+	received time.Time
+}
+
+// meterCollector reads telegrams in the background, keeping the latest telegram of each meter.
+//
+// Failed reads are logged and retried until the reader is closed or exhausted.
+type meterCollector struct {
+	reader     telegramReader
+	timeout    time.Duration
+	retryDelay time.Duration
+	now        func() time.Time
+	log        logger.Logger
+
+	mu     sync.Mutex
+	meters map[uint32]*meterState
+
+	closing chan struct{}
+	done    chan struct{}
+}
+
+func newMeterCollector(reader telegramReader, timeout, retryDelay time.Duration, log logger.Logger) *meterCollector {
+	c := &meterCollector{
+		reader:     reader,
+		timeout:    timeout,
+		retryDelay: retryDelay,
+		now:        time.Now,
+		log:        logger.OrDiscard(log),
+		meters:     make(map[uint32]*meterState),
+		closing:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	go c.read()
+
+	return c
+}
+
+func (c *meterCollector) read() {
+	defer close(c.done)
+
+	for {
+		t, err := c.reader.ReadTelegram()
+		if meter.IsSkippable(err) {
+			continue
+		}
+		if err != nil {
+			select {
+			case <-c.closing:
+				return
+			default:
+			}
+			if errors.Is(err, io.EOF) {
+				c.log.Info("energy meter telegrams ended")
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				c.log.Warn("stopped reading energy meter telegrams", "error", err)
+				return
+			}
+
+			c.log.Warn("couldn't read energy meter telegram, retrying", "retry_delay", c.retryDelay, "error", err)
+			select {
+			case <-c.closing:
+				return
+			case <-time.After(c.retryDelay):
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		c.meters[t.SerialNo] = &meterState{telegram: t, received: c.now()}
+		c.mu.Unlock()
+	}
+}
+
+func (c *meterCollector) collect() []sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	serials := make([]uint32, 0, len(c.meters))
+	for serial := range c.meters {
+		serials = append(serials, serial)
+	}
+	sort.Slice(serials, func(i, j int) bool { return serials[i] < serials[j] })
+
+	var samples []sample
+	now := c.now()
+	for _, serial := range serials {
+		m := c.meters[serial]
+		up := now.Sub(m.received) < c.timeout
+		labels := []label{{"serial", fmt.Sprint(serial)}, {"model", fmt.Sprint(m.telegram.SusyID)}}
+
+		samples = append(samples, upSample(up, append([]label{{"protocol", "energy_meter"}}, labels...)...))
+		if up {
+			samples = append(samples, telegramSamples(m.telegram, labels)...)
+		}
+	}
+
+	return samples
+}
+
+func (c *meterCollector) close() error {
+	close(c.closing)
+	err := c.reader.Close()
+	<-c.done
+	return err
+}
+
+// telegramSamples converts the registered values of a telegram into samples.
+//
+// Values of a phase are exported with a phase label, counters as counter metrics.
+func telegramSamples(t *meter.EnergyMeterTelegram, labels []label) []sample {
+	ids := make([]meter.OBISIdentifier, 0, len(t.Obis))
+	for id := range t.Obis {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	var samples []sample
+	for _, id := range ids {
+		info, ok := meter.LookupOBIS(id)
+		if !ok || id == meter.OBISSoftwareVersion {
+			continue
+		}
+		v, _ := t.Value(id)
+
+		quantity, help, typ := info.Name, info.Description, typeGauge
+		if strings.HasSuffix(quantity, "_total") {
+			quantity = strings.TrimSuffix(quantity, "_total")
+			help = strings.TrimSuffix(help, " counter")
+			typ = typeCounter
+		}
+
+		sampleLabels := labels
+		for phase := 1; phase <= 3; phase++ {
+			suffix := fmt.Sprintf("_l%d", phase)
+			if strings.HasSuffix(quantity, suffix) {
+				quantity = strings.TrimSuffix(quantity, suffix)
+				help = strings.TrimSuffix(help, fmt.Sprintf(" L%d", phase))
+				sampleLabels = append(append([]label(nil), labels...), label{"phase", fmt.Sprintf("L%d", phase)})
+				break
+			}
+		}
+
+		if typ == typeCounter {
+			quantity = strings.Replace(quantity, "_power", "_energy", 1)
+			help = strings.Replace(help, " power ", " energy ", 1) + " counter"
+		}
+
+		samples = append(samples, sample{
+			name:   metricName(meterPrefix, quantity, info.Unit, typ),
+			help:   help + ".",
+			typ:    typ,
+			labels: sampleLabels,
+			value:  v,
+		})
+	}
+
+	return samples
+}
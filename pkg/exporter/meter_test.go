@@ -0,0 +1,143 @@
+package exporter
+
+import (
+	"errors"
+	"github.com/orlopau/go-energy/pkg/meter"
+	"github.com/orlopau/go-energy/pkg/pcap"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func replayMeter(t *testing.T) *meter.EnergyMeter {
+	f, err := os.Open("../meter/testdata/telegrams.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	conn, err := pcap.NewReplayConn(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Port = 9522
+
+	return &meter.EnergyMeter{Conn: conn}
+}
+
+func TestMeterCollector(t *testing.T) {
+	c := newMeterCollector(replayMeter(t), meterTimeout, meterRetryDelay, nil)
+	defer c.close()
+
+	samples := waitFor(t, c, isUp(1))
+
+	phase := label{"phase", "L1"}
+	tests := map[string]struct {
+		name   string
+		labels []label
+	}{
+		"power":         {"goenergy_meter_active_power_import_watts", nil},
+		"phase power":   {"goenergy_meter_active_power_import_watts", []label{phase}},
+		"counter":       {"goenergy_meter_active_energy_import_watt_hours_total", nil},
+		"phase voltage": {"goenergy_meter_voltage_volts", []label{phase}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, ok := findSample(samples, test.name, test.labels...); !ok {
+				t.Fatalf("no sample %v %v in %v", test.name, test.labels, samples)
+			}
+		})
+	}
+
+	// the meter stops sending telegrams
+	c.mu.Lock()
+	c.now = func() time.Time { return time.Now().Add(meterTimeout) }
+	c.mu.Unlock()
+
+	samples = c.collect()
+	if v, ok := findSample(samples, "goenergy_up", label{"protocol", "energy_meter"}); !ok || v != 0 || len(samples) != 1 {
+		t.Fatalf("expected only the up sample of a down meter, got %v", samples)
+	}
+}
+
+// failingReader fails the first reads before reading from the telegramReader.
+type failingReader struct {
+	telegramReader
+	mu       sync.Mutex
+	failures int
+}
+
+func (r *failingReader) ReadTelegram() (*meter.EnergyMeterTelegram, error) {
+	r.mu.Lock()
+	if r.failures > 0 {
+		r.failures--
+		r.mu.Unlock()
+		return nil, errors.New("temporary failure")
+	}
+	r.mu.Unlock()
+	return r.telegramReader.ReadTelegram()
+}
+
+// recordingLogger counts the logged warnings.
+type recordingLogger struct {
+	mu       sync.Mutex
+	warnings int
+}
+
+func (l *recordingLogger) Debug(string, ...any) {}
+func (l *recordingLogger) Info(string, ...any)  {}
+func (l *recordingLogger) Error(string, ...any) {}
+func (l *recordingLogger) Warn(string, ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warnings++
+}
+
+func TestMeterCollector_ReadError(t *testing.T) {
+	log := &recordingLogger{}
+	c := newMeterCollector(&failingReader{telegramReader: replayMeter(t), failures: 2}, meterTimeout, time.Millisecond, log)
+	defer c.close()
+
+	// the meter is read after the failed reads
+	waitFor(t, c, isUp(1))
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if log.warnings != 2 {
+		t.Fatalf("expected the failed reads to be logged, got %v warnings", log.warnings)
+	}
+}
+
+func TestExporter_ServeHTTP(t *testing.T) {
+	e := New(time.Second)
+	e.AddEnergyMeter(replayMeter(t))
+	defer e.Close()
+
+	waitFor(t, e.collectors[0], isUp(1))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != contentType {
+		t.Fatalf("unexpected content type %v", ct)
+	}
+
+	body, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "# TYPE goenergy_meter_active_energy_import_watt_hours_total counter\n") {
+		t.Fatalf("unexpected metrics\n%s", body)
+	}
+	if !strings.Contains(string(body), `goenergy_up{protocol="energy_meter",`) {
+		t.Fatalf("missing up metric\n%s", body)
+	}
+
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
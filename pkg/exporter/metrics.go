@@ -0,0 +1,148 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// metric types of the Prometheus text format
+const (
+	typeGauge   = "gauge"
+	typeCounter = "counter"
+)
+
+// label is a name value pair of a sample.
+type label struct {
+	name, value string
+}
+
+// sample is a single value of a metric.
+type sample struct {
+	name   string
+	help   string
+	typ    string
+	labels []label
+	value  float64
+}
+
+// family groups the samples of a metric, as the text format requires them to be written together.
+type family struct {
+	name, help, typ string
+	samples         []sample
+}
+
+// groupSamples groups the samples by metric name, ordered by name.
+//
+// The help and type of a family are taken from its first sample.
+func groupSamples(samples []sample) []*family {
+	byName := make(map[string]*family)
+	families := make([]*family, 0)
+	for _, s := range samples {
+		f, ok := byName[s.name]
+		if !ok {
+			f = &family{name: s.name, help: s.help, typ: s.typ}
+			byName[s.name] = f
+			families = append(families, f)
+		}
+		f.samples = append(f.samples, s)
+	}
+
+	sort.SliceStable(families, func(i, j int) bool { return families[i].name < families[j].name })
+	return families
+}
+
+// writeText writes the samples in the Prometheus text exposition format.
+func writeText(w io.Writer, samples []sample) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range groupSamples(samples) {
+		if f.help != "" {
+			fmt.Fprintf(bw, "# HELP %v %v\n", f.name, escapeHelp(f.help))
+		}
+		fmt.Fprintf(bw, "# TYPE %v %v\n", f.name, f.typ)
+
+		for _, s := range f.samples {
+			bw.WriteString(s.name)
+			if len(s.labels) > 0 {
+				bw.WriteByte('{')
+				for i, l := range s.labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, "%v=\"%v\"", l.name, escapeLabelValue(l.value))
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(s.value))
+			bw.WriteByte('\n')
+		}
+	}
+
+	return bw.Flush()
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// metricUnits are the Prometheus unit suffixes of the units used by the devices.
+var metricUnits = map[string]string{
+	"W":    "watts",
+	"Wh":   "watt_hours",
+	"var":  "vars",
+	"varh": "var_hours",
+	"VA":   "volt_amperes",
+	"VAh":  "volt_ampere_hours",
+	"Hz":   "hertz",
+	"A":    "amperes",
+	"V":    "volts",
+	"%":    "percent",
+}
+
+// metricName builds a metric name of the quantity with the suffix of the unit.
+//
+// Counter names end with _total after the unit.
+func metricName(prefix, quantity, unit, typ string) string {
+	name := prefix + "_" + quantity
+	if suffix, ok := metricUnits[unit]; ok {
+		name += "_" + suffix
+	}
+	if typ == typeCounter {
+		name += "_total"
+	}
+	return name
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
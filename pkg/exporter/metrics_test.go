@@ -0,0 +1,53 @@
+package exporter
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	samples := []sample{
+		{name: "b_total", help: "B counter.", typ: typeCounter, value: 3},
+		{name: "a", help: "A with \\ and\nnewline.", typ: typeGauge, labels: []label{{"serial", "1"}, {"phase", "L1"}}, value: 1.5},
+		{name: "b_total", help: "B counter.", typ: typeCounter, labels: []label{{"name", "quote \" and \\"}}, value: math.Inf(1)},
+		{name: "a", typ: typeGauge, labels: []label{{"serial", "2"}}, value: -2},
+	}
+
+	var buf bytes.Buffer
+	if err := writeText(&buf, samples); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP a A with \\ and\nnewline.
+# TYPE a gauge
+a{serial="1",phase="L1"} 1.5
+a{serial="2"} -2
+# HELP b_total B counter.
+# TYPE b_total counter
+b_total 3
+b_total{name="quote \" and \\"} +Inf
+`
+	if buf.String() != want {
+		t.Fatalf("want\n%v\ngot\n%v", want, buf.String())
+	}
+}
+
+func TestMetricName(t *testing.T) {
+	tests := map[string]struct {
+		quantity, unit, typ string
+		want                string
+	}{
+		"gauge":        {"power", "W", typeGauge, "goenergy_power_watts"},
+		"counter":      {"energy", "Wh", typeCounter, "goenergy_energy_watt_hours_total"},
+		"without unit": {"power_factor", "", typeGauge, "goenergy_power_factor"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := metricName(namespace, test.quantity, test.unit, test.typ); got != test.want {
+				t.Fatalf("want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
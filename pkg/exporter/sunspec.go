@@ -0,0 +1,240 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/device"
+	"github.com/orlopau/go-energy/pkg/modbus"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"sync"
+	"time"
+)
+
+const (
+	sunSpecPrefix = namespace + "_sunspec"
+
+	// requestTimeout is the timeout for connecting to a SunSpec device and for each modbus request.
+	requestTimeout = 5 * time.Second
+)
+
+// sunSpecDevice is a connected SunSpec device, e.g. a *sunspec.ModbusDevice.
+type sunSpecDevice interface {
+	GetAnyPoint(ps ...sunspec.Point) (float64, error)
+	ReadCommonModel() (sunspec.CommonModel, error)
+	Close() error
+}
+
+// sunSpecConnector connects to the SunSpec device with the address and unit id.
+type sunSpecConnector func(addr string, unitID byte) (sunSpecDevice, error)
+
+func connectSunSpec(addr string, unitID byte) (sunSpecDevice, error) {
+	d, err := sunspec.Connect(addr, modbus.WithTimeout(requestTimeout), modbus.WithoutReconnect())
+	if err != nil {
+		return nil, err
+	}
+	d.SetDeviceAddress(unitID)
+
+	return d, nil
+}
+
+// phaseValue is a value of the inverter models measured for each phase.
+type phaseValue struct {
+	quantity, unit, help string
+	// point is the point of the first phase, scalePoint the point of the scale factor shared by all phases.
+	point, scalePoint uint16
+}
+
+var inverterPhaseValues = []phaseValue{
+	{quantity: "current", unit: "A", help: "AC current of a phase.", point: 3, scalePoint: 6},
+	{quantity: "voltage", unit: "V", help: "AC voltage of a phase to neutral.", point: 10, scalePoint: 13},
+}
+
+// points returns the points of the phase, counting from zero, of all inverter models.
+func (v phaseValue) points(phase uint16) []sunspec.Point {
+	points := make([]sunspec.Point, 0, 3)
+	for model := uint16(101); model <= 103; model++ {
+		points = append(points, sunspec.Point{
+			Model:      model,
+			Point:      v.point + phase,
+			T:          uint16(0),
+			Scaled:     true,
+			ScalePoint: v.scalePoint,
+			Unit:       v.unit,
+		})
+	}
+	return points
+}
+
+// sunSpecCollector polls a SunSpec device, keeping the samples of the last poll.
+type sunSpecCollector struct {
+	addr     string
+	unitID   byte
+	interval time.Duration
+	connect  sunSpecConnector
+
+	// device is only accessed by the polling goroutine, it is nil while disconnected.
+	device sunSpecDevice
+
+	mu      sync.Mutex
+	common  sunspec.CommonModel
+	up      bool
+	samples []sample
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newSunSpecCollector(addr string, unitID byte, interval time.Duration, connect sunSpecConnector) *sunSpecCollector {
+	c := &sunSpecCollector{
+		addr:     addr,
+		unitID:   unitID,
+		interval: interval,
+		connect:  connect,
+		done:     make(chan struct{}),
+	}
+
+	c.wg.Add(1)
+	go c.run()
+
+	return c
+}
+
+func (c *sunSpecCollector) run() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.poll()
+
+		select {
+		case <-c.done:
+			if c.device != nil {
+				_ = c.device.Close()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll reads the values of the device, connecting first if necessary.
+//
+// The device is marked down and disconnected if any request fails.
+func (c *sunSpecCollector) poll() {
+	if c.device == nil {
+		d, err := c.connect(c.addr, c.unitID)
+		if err != nil {
+			c.setDown()
+			return
+		}
+
+		common, err := d.ReadCommonModel()
+		if err != nil {
+			_ = d.Close()
+			c.setDown()
+			return
+		}
+
+		c.device = d
+		c.mu.Lock()
+		c.common = common
+		c.mu.Unlock()
+	}
+
+	samples, err := c.read()
+	if err != nil {
+		_ = c.device.Close()
+		c.device = nil
+		c.setDown()
+		return
+	}
+
+	c.mu.Lock()
+	c.up, c.samples = true, samples
+	c.mu.Unlock()
+}
+
+func (c *sunSpecCollector) setDown() {
+	c.mu.Lock()
+	c.up, c.samples = false, nil
+	c.mu.Unlock()
+}
+
+func (c *sunSpecCollector) labels(extra ...label) []label {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]label{{"serial", c.common.SerialNumber}, {"model", c.common.Model}}, extra...)
+}
+
+// read reads all values of the device, values not implemented by the device are omitted.
+func (c *sunSpecCollector) read() ([]sample, error) {
+	var samples []sample
+	add := func(quantity, unit, typ, help string, read func() (float64, error), labels ...label) error {
+		v, err := read()
+		if errors.Is(err, device.ErrNotSupported) || errors.Is(err, sunspec.ErrPointNotImplemented) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		samples = append(samples, sample{
+			name:   metricName(sunSpecPrefix, quantity, unit, typ),
+			help:   help,
+			typ:    typ,
+			labels: c.labels(labels...),
+			value:  v,
+		})
+		return nil
+	}
+
+	d := device.NewSunSpec(c.device)
+	values := []struct {
+		quantity, unit, typ, help string
+		read                      func() (float64, error)
+	}{
+		{"power", sunspec.UnitWatts, typeGauge, "AC power produced by the inverter.", d.Power},
+		{"energy", sunspec.UnitWattHours, typeCounter, "Lifetime energy produced by the inverter.", d.EnergyTotal},
+		{"battery_soc", sunspec.UnitPercentage, typeGauge, "State of charge of the battery.", d.Soc},
+		{"battery_power", sunspec.UnitWatts, typeGauge, "Power of the battery, positive values charge the battery.", d.BatteryPower},
+	}
+	for _, v := range values {
+		if err := add(v.quantity, v.unit, v.typ, v.help, v.read); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, v := range inverterPhaseValues {
+		for phase := uint16(0); phase < 3; phase++ {
+			points := v.points(phase)
+			read := func() (float64, error) { return c.device.GetAnyPoint(points...) }
+			if err := add(v.quantity, v.unit, typeGauge, v.help, read, label{"phase", fmt.Sprintf("L%d", phase+1)}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return samples, nil
+}
+
+func (c *sunSpecCollector) collect() []sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	up := upSample(c.up,
+		label{"protocol", "sunspec"},
+		label{"address", fmt.Sprintf("%v/%v", c.addr, c.unitID)},
+		label{"serial", c.common.SerialNumber},
+		label{"model", c.common.Model},
+	)
+	return append([]sample{up}, c.samples...)
+}
+
+func (c *sunSpecCollector) close() error {
+	close(c.done)
+	c.wg.Wait()
+	return nil
+}
@@ -0,0 +1,170 @@
+package exporter
+
+import (
+	"errors"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSunSpecDevice implements sunSpecDevice using fixed point values.
+type testSunSpecDevice struct {
+	mu     sync.Mutex
+	points map[sunspec.Point]float64
+	err    error
+	closed bool
+}
+
+func (d *testSunSpecDevice) GetAnyPoint(ps ...sunspec.Point) (float64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return 0, d.err
+	}
+	for _, p := range ps {
+		if v, ok := d.points[p]; ok {
+			return v, nil
+		}
+	}
+	return 0, sunspec.ErrPointNotImplemented
+}
+
+func (d *testSunSpecDevice) ReadCommonModel() (sunspec.CommonModel, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return sunspec.CommonModel{}, d.err
+	}
+	return sunspec.CommonModel{Manufacturer: "SMA", Model: "STP 10.0", SerialNumber: "3000123456"}, nil
+}
+
+func (d *testSunSpecDevice) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.closed = true
+	return nil
+}
+
+func (d *testSunSpecDevice) setErr(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.err = err
+}
+
+// findSample returns the value of the sample with the name and label values.
+func findSample(samples []sample, name string, labels ...label) (float64, bool) {
+	for _, s := range samples {
+		if s.name != name {
+			continue
+		}
+
+		matches := true
+		for _, want := range labels {
+			found := false
+			for _, l := range s.labels {
+				if l == want {
+					found = true
+				}
+			}
+			matches = matches && found
+		}
+		if matches {
+			return s.value, true
+		}
+	}
+	return 0, false
+}
+
+// waitFor polls the collector until the condition is true.
+func waitFor(t *testing.T, c collector, cond func([]sample) bool) []sample {
+	deadline := time.Now().Add(time.Second)
+	for {
+		samples := c.collect()
+		if cond(samples) {
+			return samples
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met, samples %v", samples)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func isUp(up float64) func([]sample) bool {
+	return func(samples []sample) bool {
+		v, ok := findSample(samples, "goenergy_up")
+		return ok && v == up
+	}
+}
+
+func TestSunSpecCollector(t *testing.T) {
+	d := &testSunSpecDevice{points: map[sunspec.Point]float64{
+		sunspec.PointPower3Phase:            1234,
+		sunspec.PointEnergy3Phase:           56789,
+		inverterPhaseValues[0].points(1)[2]: 4.5,
+	}}
+
+	var connects int
+	var mu sync.Mutex
+	connect := func(addr string, unitID byte) (sunSpecDevice, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		connects++
+		if addr != "10.0.0.5:502" || unitID != 3 {
+			t.Errorf("unexpected device %v/%v", addr, unitID)
+		}
+		return d, nil
+	}
+
+	c := newSunSpecCollector("10.0.0.5:502", 3, 10*time.Millisecond, connect)
+	defer c.close()
+
+	samples := waitFor(t, c, isUp(1))
+
+	serial := label{"serial", "3000123456"}
+	tests := map[string]struct {
+		name   string
+		labels []label
+		want   float64
+	}{
+		"power":   {"goenergy_sunspec_power_watts", []label{serial, {"model", "STP 10.0"}}, 1234},
+		"energy":  {"goenergy_sunspec_energy_watt_hours_total", []label{serial}, 56789},
+		"current": {"goenergy_sunspec_current_amperes", []label{serial, {"phase", "L2"}}, 4.5},
+		"up":      {"goenergy_up", []label{serial, {"protocol", "sunspec"}, {"address", "10.0.0.5:502/3"}}, 1},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			v, ok := findSample(samples, test.name, test.labels...)
+			if !ok || v != test.want {
+				t.Fatalf("want %v, got %v (found %v) in %v", test.want, v, ok, samples)
+			}
+		})
+	}
+
+	if _, ok := findSample(samples, "goenergy_sunspec_battery_soc_percent"); ok {
+		t.Fatal("unexpected sample of a point not implemented by the device")
+	}
+
+	// the device becomes unreachable, its values must not be reported anymore
+	d.setErr(errors.New("connection reset"))
+	samples = waitFor(t, c, isUp(0))
+	if len(samples) != 1 {
+		t.Fatalf("expected only the up sample, got %v", samples)
+	}
+
+	// the device is reconnected once it is reachable again
+	d.setErr(nil)
+	waitFor(t, c, isUp(1))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if connects < 2 {
+		t.Fatalf("expected a reconnect, got %v connects", connects)
+	}
+}
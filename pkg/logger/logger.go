@@ -0,0 +1,86 @@
+// Provides the structured logger interface accepted by the clients, meters and watchers of go-energy.
+//
+// The interface is implemented by *slog.Logger, applications using log/slog can pass their logger directly:
+//
+//	client, err := modbus.Connect(addr, modbus.WithLogger(slog.Default().With("device", "inverter")))
+//
+// Nothing is logged unless a logger is configured.
+package logger
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger logs messages with attributes given as alternating keys and values, compatible with *slog.Logger.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// Discard is a logger discarding all messages, used if no logger is configured.
+var Discard Logger = discard{}
+
+type discard struct{}
+
+func (discard) Debug(string, ...any) {}
+func (discard) Info(string, ...any)  {}
+func (discard) Warn(string, ...any)  {}
+func (discard) Error(string, ...any) {}
+
+// OrDiscard returns the logger, or Discard if it is nil.
+func OrDiscard(l Logger) Logger {
+	if l == nil {
+		return Discard
+	}
+	return l
+}
+
+// stdLogger writes messages to a *log.Logger.
+type stdLogger struct {
+	l *log.Logger
+}
+
+// Std returns a logger writing messages to the standard library logger in the format
+// "INFO msg key=value ...", e.g. for applications not using log/slog. The global logger is used if l is nil.
+func Std(l *log.Logger) Logger {
+	if l == nil {
+		l = log.Default()
+	}
+	return stdLogger{l: l}
+}
+
+func (s stdLogger) Debug(msg string, args ...any) { s.log("DEBUG", msg, args) }
+func (s stdLogger) Info(msg string, args ...any)  { s.log("INFO", msg, args) }
+func (s stdLogger) Warn(msg string, args ...any)  { s.log("WARN", msg, args) }
+func (s stdLogger) Error(msg string, args ...any) { s.log("ERROR", msg, args) }
+
+func (s stdLogger) log(level, msg string, args []any) {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteByte(' ')
+	b.WriteString(msg)
+
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			// a key without value, formatted like slog
+			fmt.Fprintf(&b, " !BADKEY=%v", quote(args[i]))
+			break
+		}
+		fmt.Fprintf(&b, " %v=%v", args[i], quote(args[i+1]))
+	}
+
+	s.l.Print(b.String())
+}
+
+// quote quotes values containing spaces, quotes or equal signs.
+func quote(v any) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
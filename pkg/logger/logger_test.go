@@ -0,0 +1,54 @@
+package logger
+
+import (
+	"bytes"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestStd(t *testing.T) {
+	tests := map[string]struct {
+		log  func(l Logger)
+		want string
+	}{
+		"message": {
+			log:  func(l Logger) { l.Info("connected") },
+			want: "INFO connected\n",
+		},
+		"attributes": {
+			log:  func(l Logger) { l.Warn("backing off", "address", "192.168.1.10:502", "backoff", 1500*time.Millisecond) },
+			want: "WARN backing off address=192.168.1.10:502 backoff=1.5s\n",
+		},
+		"quoted": {
+			log:  func(l Logger) { l.Error("failed", "error", "connection refused", "empty", "") },
+			want: "ERROR failed error=\"connection refused\" empty=\"\"\n",
+		},
+		"missing value": {
+			log:  func(l Logger) { l.Debug("read", "address", 40000, "unit_id") },
+			want: "DEBUG read address=40000 !BADKEY=unit_id\n",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			test.log(Std(log.New(&buf, "", 0)))
+
+			if buf.String() != test.want {
+				t.Fatalf("want %q, got %q", test.want, buf.String())
+			}
+		})
+	}
+}
+
+func TestOrDiscard(t *testing.T) {
+	if OrDiscard(nil) != Discard {
+		t.Fatal("expected Discard for nil logger")
+	}
+
+	l := Std(nil)
+	if OrDiscard(l) != l {
+		t.Fatal("expected the given logger")
+	}
+}
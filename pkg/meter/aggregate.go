@@ -0,0 +1,209 @@
+package meter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// defaultMaxGap is the longest time between two telegrams that is still weighted when averaging power.
+	defaultMaxGap = time.Minute
+	// wattSecondsPerWattHour converts the energy counters of a telegram from Ws to Wh.
+	wattSecondsPerWattHour = 3600
+	// deciWattsPerWatt converts the power values of a telegram from 0.1 W to W.
+	deciWattsPerWatt = 10
+)
+
+var (
+	ErrSerialMismatch = errors.New("telegram is from a different energy meter")
+	ErrMissingValue   = errors.New("telegram is missing a required value")
+)
+
+// Aggregate contains the values aggregated from the telegrams received within an interval.
+//
+// Power values are in W, positive values denote import from and negative values export to the grid.
+type Aggregate struct {
+	Start, End time.Time
+	Samples    int
+	// ImportedWh and ExportedWh are the energy computed from the meter's counters in Wh.
+	ImportedWh, ExportedWh float64
+	MinPowerW, MaxPowerW   float64
+	AvgPowerW              float64
+	// Resets is the number of counter resets detected within the interval.
+	Resets int
+}
+
+// SelfConsumption contains self-consumption metrics of an interval.
+type SelfConsumption struct {
+	ProducedWh float64
+	// SelfConsumedWh is the produced energy that was not exported.
+	SelfConsumedWh float64
+	// ConsumedWh is the total energy consumed by the site, both imported and self-consumed.
+	ConsumedWh float64
+	// Rate is the share of produced energy that was consumed on site.
+	Rate float64
+	// Autarky is the share of consumed energy that was covered by own production.
+	Autarky float64
+}
+
+// SelfConsumption computes the self-consumption metrics of the interval.
+//
+// The energy meter only measures the grid connection point, so the energy produced
+// within the interval has to be provided, e.g. from an inverter.
+func (a *Aggregate) SelfConsumption(producedWh float64) SelfConsumption {
+	s := SelfConsumption{ProducedWh: producedWh}
+	s.SelfConsumedWh = math.Max(producedWh-a.ExportedWh, 0)
+	s.ConsumedWh = a.ImportedWh + s.SelfConsumedWh
+
+	if producedWh > 0 {
+		s.Rate = s.SelfConsumedWh / producedWh
+	}
+	if s.ConsumedWh > 0 {
+		s.Autarky = s.SelfConsumedWh / s.ConsumedWh
+	}
+
+	return s
+}
+
+// Aggregator aggregates the telegrams of a single energy meter into fixed intervals.
+//
+// Intervals are aligned to multiples of the period, a period of 24 hours is aligned to
+// midnight in the location of the timestamps passed to Add.
+type Aggregator struct {
+	period time.Duration
+	// MaxGap is the longest time between two telegrams for which power is still time weighted.
+	// Longer gaps, e.g. caused by a restart of the meter, are not weighted.
+	MaxGap time.Duration
+
+	serial  uint32
+	prev    *aggregatorSample
+	current *Aggregate
+	weight  time.Duration
+	sum     float64
+	plain   float64
+}
+
+type aggregatorSample struct {
+	measuringTime              uint32
+	energyImport, energyExport uint64
+}
+
+// NewAggregator creates an Aggregator producing aggregates for intervals of the given period.
+func NewAggregator(period time.Duration) *Aggregator {
+	return &Aggregator{period: period, MaxGap: defaultMaxGap}
+}
+
+// Add adds a telegram received at the given time.
+//
+// If the telegram belongs to a new interval, the aggregate of the previous interval is returned.
+// Energy between two telegrams is attributed to the interval of the later telegram.
+func (a *Aggregator) Add(t *EnergyMeterTelegram, at time.Time) (*Aggregate, error) {
+	if a.prev != nil && t.SerialNo != a.serial {
+		return nil, fmt.Errorf("%w: expected serial %d, got %d", ErrSerialMismatch, a.serial, t.SerialNo)
+	}
+
+	sample, power, err := readAggregatorValues(t)
+	if err != nil {
+		return nil, err
+	}
+
+	var done *Aggregate
+	start, end := a.bounds(at)
+	if a.current != nil && !a.current.Start.Equal(start) {
+		done = a.Flush()
+	}
+	if a.current == nil {
+		a.current = &Aggregate{Start: start, End: end, MinPowerW: power, MaxPowerW: power}
+	}
+
+	c := a.current
+	c.Samples++
+	c.MinPowerW = math.Min(c.MinPowerW, power)
+	c.MaxPowerW = math.Max(c.MaxPowerW, power)
+	a.plain += power
+
+	if a.prev != nil {
+		// uint32 arithmetic handles the rollover of the millisecond counter
+		elapsed := time.Duration(sample.measuringTime-a.prev.measuringTime) * time.Millisecond
+		if elapsed <= a.MaxGap {
+			a.weight += elapsed
+			a.sum += power * elapsed.Seconds()
+		}
+
+		imported, reset := counterDelta(a.prev.energyImport, sample.energyImport)
+		exported, resetEx := counterDelta(a.prev.energyExport, sample.energyExport)
+		if reset || resetEx {
+			c.Resets++
+		}
+
+		c.ImportedWh += float64(imported) / wattSecondsPerWattHour
+		c.ExportedWh += float64(exported) / wattSecondsPerWattHour
+	}
+
+	if a.weight > 0 {
+		c.AvgPowerW = a.sum / a.weight.Seconds()
+	} else {
+		c.AvgPowerW = a.plain / float64(c.Samples)
+	}
+
+	a.serial = t.SerialNo
+	a.prev = &sample
+
+	return done, nil
+}
+
+// Flush returns the aggregate of the current, possibly incomplete interval and starts a new one.
+//
+// Returns nil if no telegram was added since the last interval was completed.
+func (a *Aggregator) Flush() *Aggregate {
+	done := a.current
+	a.current = nil
+	a.weight, a.sum, a.plain = 0, 0, 0
+
+	return done
+}
+
+// bounds returns the start and end of the interval containing the given time.
+func (a *Aggregator) bounds(at time.Time) (time.Time, time.Time) {
+	if a.period == 24*time.Hour {
+		y, m, d := at.Date()
+		start := time.Date(y, m, d, 0, 0, 0, 0, at.Location())
+		return start, start.AddDate(0, 0, 1)
+	}
+
+	start := at.Truncate(a.period)
+	return start, start.Add(a.period)
+}
+
+// counterDelta returns the difference between two readings of an energy counter.
+//
+// A decreasing counter is treated as a reset, the new reading then counts as the energy since the reset.
+func counterDelta(prev, cur uint64) (uint64, bool) {
+	if cur < prev {
+		return cur, true
+	}
+
+	return cur - prev, false
+}
+
+func readAggregatorValues(t *EnergyMeterTelegram) (aggregatorSample, float64, error) {
+	values := make([]uint64, 4)
+	for i, id := range []OBISIdentifier{OBISPowerImport, OBISPowerExport, OBISEnergyImport, OBISEnergyExport} {
+		v, ok := t.Obis[id]
+		if !ok {
+			return aggregatorSample{}, 0, fmt.Errorf("%w: %v", ErrMissingValue, id)
+		}
+		values[i] = v
+	}
+
+	power := (float64(values[0]) - float64(values[1])) / deciWattsPerWatt
+	sample := aggregatorSample{
+		measuringTime: t.MeasuringTime,
+		energyImport:  values[2],
+		energyExport:  values[3],
+	}
+
+	return sample, power, nil
+}
@@ -0,0 +1,179 @@
+package meter
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func newAggregatorTelegram(measuringTime uint32, powerImport, powerExport, energyImport, energyExport uint64) *EnergyMeterTelegram {
+	return &EnergyMeterTelegram{
+		SerialNo:      1234,
+		MeasuringTime: measuringTime,
+		Obis: map[OBISIdentifier]uint64{
+			OBISPowerImport:  powerImport,
+			OBISPowerExport:  powerExport,
+			OBISEnergyImport: energyImport,
+			OBISEnergyExport: energyExport,
+		},
+	}
+}
+
+func TestAggregator_Add(t *testing.T) {
+	start := time.Date(2020, 12, 1, 12, 0, 0, 0, time.UTC)
+
+	tt := map[string]struct {
+		telegrams  []*EnergyMeterTelegram
+		imported   float64
+		exported   float64
+		min, max   float64
+		avg        float64
+		wantResets int
+	}{
+		"simple": {
+			telegrams: []*EnergyMeterTelegram{
+				newAggregatorTelegram(1000, 10000, 0, 0, 0),
+				newAggregatorTelegram(2000, 20000, 0, 7200, 0),
+				newAggregatorTelegram(3000, 0, 10000, 7200, 3600),
+			},
+			imported: 2,
+			exported: 1,
+			min:      -1000,
+			max:      2000,
+			avg:      500,
+		},
+		"measuring time rollover": {
+			telegrams: []*EnergyMeterTelegram{
+				newAggregatorTelegram(math.MaxUint32-499, 10000, 0, 0, 0),
+				newAggregatorTelegram(500, 20000, 0, 3600, 0),
+				newAggregatorTelegram(3500, 40000, 0, 7200, 0),
+			},
+			imported: 2,
+			min:      1000,
+			max:      4000,
+			avg:      3500,
+		},
+		"counter reset": {
+			telegrams: []*EnergyMeterTelegram{
+				newAggregatorTelegram(1000, 10000, 0, 36000, 0),
+				newAggregatorTelegram(2000, 10000, 0, 3600, 0),
+			},
+			imported:   1,
+			min:        1000,
+			max:        1000,
+			avg:        1000,
+			wantResets: 1,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			a := NewAggregator(time.Minute)
+
+			for i, telegram := range tc.telegrams {
+				done, err := a.Add(telegram, start.Add(time.Duration(i)*time.Second))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if done != nil {
+					t.Fatal("interval should not be done")
+				}
+			}
+
+			agg := a.Flush()
+			if agg == nil {
+				t.Fatal("aggregate is nil")
+			}
+
+			if agg.Samples != len(tc.telegrams) {
+				t.Fatalf("expected %v samples, got %v", len(tc.telegrams), agg.Samples)
+			}
+			if agg.ImportedWh != tc.imported || agg.ExportedWh != tc.exported {
+				t.Fatalf("expected %v/%v Wh, got %v/%v Wh", tc.imported, tc.exported, agg.ImportedWh, agg.ExportedWh)
+			}
+			if agg.MinPowerW != tc.min || agg.MaxPowerW != tc.max || agg.AvgPowerW != tc.avg {
+				t.Fatalf("expected min %v max %v avg %v, got %v %v %v", tc.min, tc.max, tc.avg, agg.MinPowerW, agg.MaxPowerW, agg.AvgPowerW)
+			}
+			if agg.Resets != tc.wantResets {
+				t.Fatalf("expected %v resets, got %v", tc.wantResets, agg.Resets)
+			}
+		})
+	}
+}
+
+func TestAggregator_Add_Intervals(t *testing.T) {
+	a := NewAggregator(15 * time.Minute)
+	start := time.Date(2020, 12, 1, 12, 14, 59, 0, time.UTC)
+
+	done, err := a.Add(newAggregatorTelegram(1000, 10000, 0, 0, 0), start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done != nil {
+		t.Fatal("interval should not be done")
+	}
+
+	done, err = a.Add(newAggregatorTelegram(2000, 10000, 0, 3600, 0), start.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done == nil {
+		t.Fatal("interval should be done")
+	}
+
+	wantStart := time.Date(2020, 12, 1, 12, 0, 0, 0, time.UTC)
+	if !done.Start.Equal(wantStart) || !done.End.Equal(wantStart.Add(15*time.Minute)) {
+		t.Fatalf("unexpected interval %v - %v", done.Start, done.End)
+	}
+	if done.ImportedWh != 0 {
+		t.Fatalf("expected no energy in first interval, got %v", done.ImportedWh)
+	}
+
+	current := a.Flush()
+	if current.ImportedWh != 1 {
+		t.Fatalf("expected 1 Wh in second interval, got %v", current.ImportedWh)
+	}
+}
+
+func TestAggregator_Add_Daily(t *testing.T) {
+	loc := time.FixedZone("CET", 3600)
+	a := NewAggregator(24 * time.Hour)
+
+	_, err := a.Add(newAggregatorTelegram(1000, 0, 0, 0, 0), time.Date(2020, 12, 1, 0, 30, 0, 0, loc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agg := a.Flush()
+	wantStart := time.Date(2020, 12, 1, 0, 0, 0, 0, loc)
+	if !agg.Start.Equal(wantStart) || !agg.End.Equal(wantStart.AddDate(0, 0, 1)) {
+		t.Fatalf("unexpected interval %v - %v", agg.Start, agg.End)
+	}
+}
+
+func TestAggregator_Add_SerialMismatch(t *testing.T) {
+	a := NewAggregator(time.Minute)
+
+	if _, err := a.Add(newAggregatorTelegram(1000, 0, 0, 0, 0), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	other := newAggregatorTelegram(2000, 0, 0, 0, 0)
+	other.SerialNo = 4321
+	if _, err := a.Add(other, time.Now()); !errors.Is(err, ErrSerialMismatch) {
+		t.Fatalf("expected %v, got %v", ErrSerialMismatch, err)
+	}
+}
+
+func TestAggregate_SelfConsumption(t *testing.T) {
+	agg := &Aggregate{ImportedWh: 100, ExportedWh: 300}
+
+	s := agg.SelfConsumption(400)
+	if s.SelfConsumedWh != 100 || s.ConsumedWh != 200 {
+		t.Fatalf("expected 100 Wh self-consumed of 200 Wh, got %v of %v", s.SelfConsumedWh, s.ConsumedWh)
+	}
+	if s.Rate != 0.25 || s.Autarky != 0.5 {
+		t.Fatalf("expected rate 0.25 and autarky 0.5, got %v and %v", s.Rate, s.Autarky)
+	}
+}
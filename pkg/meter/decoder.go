@@ -3,19 +3,40 @@ package meter
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 )
 
 const (
-	startIdentifier            = "SMA"
+	startIdentifier            = "SMA\x00"
+	tagEnd              uint16 = 0x0000
+	tagData             uint16 = 0x0010
 	protocolID          uint16 = 0x6069
 	measTypeEnergyMeter uint8  = 0x08
 	measTypeAverage     uint8  = 0x04
 	measTypeVersion     uint8  = 0
 	channelInternal     uint8  = 0
 	channelOther        uint8  = 144
+
+	// telegramHeaderLength is the length of protocol identifier, SusyID, serial number and measuring time.
+	telegramHeaderLength = 12
+)
+
+var (
+	// ErrBadMagic is returned if a datagram does not start with the SMA identifier.
+	ErrBadMagic = errors.New("datagram does not start with the SMA identifier")
+	// ErrUnsupportedProtocol is returned for SMA datagrams that are not energy meter telegrams.
+	ErrUnsupportedProtocol = errors.New("unsupported protocol")
+	// ErrTruncated is returned if a datagram is shorter than its headers declare.
+	ErrTruncated = errors.New("truncated datagram")
 )
 
+// IsSkippable reports whether the error was returned for a single datagram which is not a valid telegram, e.g. as
+// other SMA datagrams are sent to the same multicast group. Reading can continue with the next datagram.
+func IsSkippable(err error) bool {
+	return errors.Is(err, ErrBadMagic) || errors.Is(err, ErrUnsupportedProtocol) || errors.Is(err, ErrTruncated)
+}
+
 // OBISIdentifier represents an identifier following the OBIS standard.
 type OBISIdentifier struct {
 	Channel, MeasVal, MeasType, Tariff uint8
@@ -36,79 +57,101 @@ type EnergyMeterTelegram struct {
 }
 
 // DecodeTelegram decodes the given energy meter compatible telegram into an EnergyMeterTelegram.
+//
+// The datagram must start with the SMA header, its length is taken from the header's data length.
+// Returns ErrBadMagic, ErrUnsupportedProtocol or ErrTruncated if the datagram is not a valid telegram.
 func DecodeTelegram(data []byte) (*EnergyMeterTelegram, error) {
-	startIndex := bytes.Index(data, []byte(startIdentifier))
-	if startIndex == -1 {
-		return nil, fmt.Errorf("couldn't find start index in the datagram")
-	}
-
-	buf := bytes.NewBuffer(data[startIndex:])
-
-	buf.Next(16)
-
-	var id uint16
-	err := binary.Read(buf, binary.BigEndian, &id)
+	payload, err := telegramPayload(data)
 	if err != nil {
 		return nil, err
 	}
-	if id != protocolID {
-		return nil, fmt.Errorf("expected %d as protocol identifier but got %d", protocolID, binary.BigEndian.Uint16(data[16:18]))
-	}
 
-	em := &EnergyMeterTelegram{
-		Obis: make(map[OBISIdentifier]uint64),
+	if len(payload) < 2 {
+		return nil, fmt.Errorf("%w: missing protocol identifier", ErrTruncated)
 	}
-
-	if err := binary.Read(buf, binary.BigEndian, &em.SusyID); err != nil {
-		return nil, err
+	if id := binary.BigEndian.Uint16(payload); id != protocolID {
+		return nil, fmt.Errorf("%w: expected %#04x as protocol identifier but got %#04x", ErrUnsupportedProtocol, protocolID, id)
 	}
-	if err := binary.Read(buf, binary.BigEndian, &em.SerialNo); err != nil {
-		return nil, err
+	if len(payload) < telegramHeaderLength {
+		return nil, fmt.Errorf("%w: telegram header needs %d bytes, got %d", ErrTruncated, telegramHeaderLength, len(payload))
 	}
-	if err := binary.Read(buf, binary.BigEndian, &em.MeasuringTime); err != nil {
-		return nil, err
+
+	em := &EnergyMeterTelegram{
+		SusyID:        binary.BigEndian.Uint16(payload[2:4]),
+		SerialNo:      binary.BigEndian.Uint32(payload[4:8]),
+		MeasuringTime: binary.BigEndian.Uint32(payload[8:12]),
+		Obis:          make(map[OBISIdentifier]uint64),
 	}
 
-	endIdentifier := OBISIdentifier{}
+	buf := payload[telegramHeaderLength:]
+	for len(buf) >= 4 {
+		obis := OBISIdentifier{Channel: buf[0], MeasVal: buf[1], MeasType: buf[2], Tariff: buf[3]}
+		buf = buf[4:]
+
+		if obis == (OBISIdentifier{}) {
+			break
+		}
 
-	// buffer len greater 4 -> another obis identifier and value
-	for buf.Len() > 4 {
-		obis := OBISIdentifier{}
-		if err := binary.Read(buf, binary.BigEndian, &obis); err != nil {
-			return nil, err
+		var size int
+		switch {
+		case obis.Channel == channelInternal && obis.MeasType == measTypeEnergyMeter:
+			size = 8
+		case obis.Channel == channelInternal && obis.MeasType == measTypeAverage:
+			size = 4
+		case obis.Channel == channelOther && obis.MeasType == measTypeVersion:
+			size = 4
+		case obis.Channel == channelInternal:
+			return nil, fmt.Errorf("%w: unexpected measurement type %d", ErrUnsupportedProtocol, obis.MeasType)
+		default:
+			return nil, fmt.Errorf("%w: unexpected channel %d", ErrUnsupportedProtocol, obis.Channel)
 		}
 
-		if obis == endIdentifier {
-			break
+		if len(buf) < size {
+			return nil, fmt.Errorf("%w: value of %v needs %d bytes, got %d", ErrTruncated, obis, size, len(buf))
 		}
 
-		switch obis.Channel {
-		case channelInternal:
-			if obis.MeasType == measTypeEnergyMeter {
-				var val uint64
-				if err := binary.Read(buf, binary.BigEndian, &val); err != nil {
-					return nil, err
-				}
-				em.Obis[obis] = val
-			} else if obis.MeasType == measTypeAverage {
-				var val uint32
-				if err := binary.Read(buf, binary.BigEndian, &val); err != nil {
-					return nil, err
-				}
-				em.Obis[obis] = uint64(val)
+		switch size {
+		case 8:
+			em.Obis[obis] = binary.BigEndian.Uint64(buf)
+		case 4:
+			if obis.Channel == channelOther {
+				em.SoftwareVersion = SoftwareVersion{Major: buf[0], Minor: buf[1], Build: buf[2], Revision: buf[3]}
 			} else {
-				return nil, fmt.Errorf("unexpected measurement type: %d", obis.MeasType)
-			}
-		case channelOther:
-			if obis.MeasType == measTypeVersion {
-				if err := binary.Read(buf, binary.BigEndian, &em.SoftwareVersion); err != nil {
-					return nil, err
-				}
+				em.Obis[obis] = uint64(binary.BigEndian.Uint32(buf))
 			}
-		default:
-			return nil, fmt.Errorf("unexpected channel: %d", obis.Channel)
 		}
+		buf = buf[size:]
 	}
 
 	return em, nil
 }
+
+// telegramPayload walks the tags of the SMA header and returns the data of the data tag.
+func telegramPayload(data []byte) ([]byte, error) {
+	if len(data) < len(startIdentifier) || !bytes.Equal(data[:len(startIdentifier)], []byte(startIdentifier)) {
+		return nil, ErrBadMagic
+	}
+
+	buf := data[len(startIdentifier):]
+	for {
+		if len(buf) < 4 {
+			return nil, fmt.Errorf("%w: missing tag header", ErrTruncated)
+		}
+
+		length := int(binary.BigEndian.Uint16(buf[0:2]))
+		tag := binary.BigEndian.Uint16(buf[2:4])
+		buf = buf[4:]
+
+		if tag == tagEnd && length == 0 {
+			return nil, fmt.Errorf("%w: no data tag", ErrUnsupportedProtocol)
+		}
+		if len(buf) < length {
+			return nil, fmt.Errorf("%w: tag %#04x needs %d bytes, got %d", ErrTruncated, tag, length, len(buf))
+		}
+		if tag == tagData {
+			return buf[:length], nil
+		}
+
+		buf = buf[length:]
+	}
+}
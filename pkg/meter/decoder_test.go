@@ -2,6 +2,7 @@ package meter
 
 import (
 	"encoding/hex"
+	"errors"
 	"testing"
 )
 
@@ -98,3 +99,86 @@ func TestDecodeTelegram(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeTelegram_Errors(t *testing.T) {
+	t.Parallel()
+
+	valid, err := hex.DecodeString(testTelegram)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discovery, err := hex.DecodeString("534d4100000402a0ffffffff0000002000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// speedwire inverter datagram with protocol 0x6065
+	inverter := append([]byte(nil), valid...)
+	inverter[17] = 0x65
+
+	// sma identifier not at the start of the datagram
+	shifted := append([]byte{0, 0}, valid...)
+
+	// first obis identifier of an unknown channel or measurement type
+	channel := append([]byte(nil), valid...)
+	channel[28] = 5
+	measType := append([]byte(nil), valid...)
+	measType[30] = 0x09
+
+	tt := map[string]struct {
+		in   []byte
+		want error
+	}{
+		"empty":                {in: nil, want: ErrBadMagic},
+		"shifted":              {in: shifted, want: ErrBadMagic},
+		"discovery":            {in: discovery, want: ErrUnsupportedProtocol},
+		"inverter":             {in: inverter, want: ErrUnsupportedProtocol},
+		"truncated tag header": {in: valid[:14], want: ErrTruncated},
+		"truncated data":       {in: valid[:200], want: ErrTruncated},
+		"unknown channel":      {in: channel, want: ErrUnsupportedProtocol},
+		"unknown type":         {in: measType, want: ErrUnsupportedProtocol},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			_, err := DecodeTelegram(tc.in)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+			if !IsSkippable(err) {
+				t.Fatalf("expected %v to be skippable", err)
+			}
+		})
+	}
+}
+
+func FuzzDecodeTelegram(f *testing.F) {
+	valid, err := hex.DecodeString(testTelegram)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	discovery, err := hex.DecodeString("534d4100000402a0ffffffff0000002000000000")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(valid)
+	f.Add(valid[:100])
+	f.Add(discovery)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		telegram, err := DecodeTelegram(data)
+		if err != nil {
+			if telegram != nil {
+				t.Fatal("telegram returned with error")
+			}
+			return
+		}
+
+		if telegram.Obis == nil {
+			t.Fatal("decoded telegram without obis map")
+		}
+	})
+}
@@ -3,12 +3,14 @@ package meter
 
 import (
 	"fmt"
+	"github.com/orlopau/go-energy/pkg/logger"
 	"io"
 	"net"
 )
 
 const (
 	multicastIP = "239.12.255.254:9522"
+	unicastAddr = ":9522"
 )
 
 type energyMeterConnection interface {
@@ -18,23 +20,115 @@ type energyMeterConnection interface {
 
 type EnergyMeter struct {
 	Conn energyMeterConnection
+	// Logger logs undecodable packets, nothing is logged if it is nil.
+	Logger logger.Logger
+}
+
+// listenConfig holds the settings applied by ListenOptions.
+type listenConfig struct {
+	ifi        *net.Interface
+	unicast    bool
+	addr       string
+	readBuffer int
+	log        logger.Logger
+}
+
+// ListenOption configures the socket opened by Listen.
+type ListenOption func(*listenConfig)
+
+// WithInterface joins the multicast group on the given network interface
+// instead of the one chosen by the system.
+func WithInterface(ifi *net.Interface) ListenOption {
+	return func(c *listenConfig) {
+		c.ifi = ifi
+	}
+}
+
+// WithUnicast receives telegrams forwarded to this host via unicast instead of joining the multicast group,
+// as used by the Sunny Home Manager in unicast mode.
+//
+// The addr is the local address to bind to. If empty, all local addresses on port 9522 are used.
+func WithUnicast(addr string) ListenOption {
+	return func(c *listenConfig) {
+		c.unicast = true
+		if addr != "" {
+			c.addr = addr
+		}
+	}
+}
+
+// WithReadBuffer sets the size of the operating system's receive buffer of the socket in bytes.
+func WithReadBuffer(bytes int) ListenOption {
+	return func(c *listenConfig) {
+		c.readBuffer = bytes
+	}
+}
+
+// WithLogger sets the logger of the EnergyMeter, nothing is logged by default.
+func WithLogger(l logger.Logger) ListenOption {
+	return func(c *listenConfig) {
+		c.log = l
+	}
 }
 
 // Listen opens a multicast socket to listen for energymeter messages.
 //
+// By default the multicast group is joined on the system assigned interface, use the
+// ListenOptions to change this behaviour.
+//
 // Returns an EnergyMeter representing the opened connection.
-func Listen() (*EnergyMeter, error) {
-	addr, err := net.ResolveUDPAddr("udp", multicastIP)
-	if err != nil {
-		return nil, err
+func Listen(opts ...ListenOption) (*EnergyMeter, error) {
+	c := &listenConfig{addr: unicastAddr}
+	for _, opt := range opts {
+		opt(c)
 	}
 
-	l, err := net.ListenMulticastUDP("udp", nil, addr)
-	if err != nil {
-		return nil, err
+	var l *net.UDPConn
+	if c.unicast {
+		addr, err := net.ResolveUDPAddr("udp", c.addr)
+		if err != nil {
+			return nil, err
+		}
+
+		l, err = net.ListenUDP("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		addr, err := net.ResolveUDPAddr("udp", multicastIP)
+		if err != nil {
+			return nil, err
+		}
+
+		l, err = net.ListenMulticastUDP("udp", c.ifi, addr)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return &EnergyMeter{Conn: l}, nil
+	if c.readBuffer > 0 {
+		if err := l.SetReadBuffer(c.readBuffer); err != nil {
+			_ = l.Close()
+			return nil, err
+		}
+	}
+
+	log := logger.OrDiscard(c.log)
+	if c.unicast {
+		log.Info("listening for energy meter telegrams", "address", l.LocalAddr(), "unicast", true)
+	} else {
+		log.Info("listening for energy meter telegrams", "address", multicastIP, "interface", interfaceName(c.ifi))
+	}
+
+	return &EnergyMeter{Conn: l, Logger: c.log}, nil
+}
+
+// interfaceName returns the name of the interface, or "default" for the interface chosen by the system.
+func interfaceName(ifi *net.Interface) string {
+	if ifi == nil {
+		return "default"
+	}
+	return ifi.Name
 }
 
 // Close closes the opened connection.
@@ -54,13 +148,14 @@ func (t *EnergyMeter) ReadTelegram() (*EnergyMeterTelegram, error) {
 	}
 
 	b := make([]byte, 8192)
-	_, _, err := t.Conn.ReadFromUDP(b)
+	n, addr, err := t.Conn.ReadFromUDP(b)
 	if err != nil {
 		return nil, err
 	}
 
-	telegram, err := DecodeTelegram(b)
+	telegram, err := DecodeTelegram(b[:n])
 	if err != nil {
+		logger.OrDiscard(t.Logger).Debug("received invalid telegram", "source", addr, "length", n, "error", err)
 		return nil, err
 	}
 
@@ -3,10 +3,43 @@ package meter
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/pcap"
+	"github.com/phayes/freeport"
+	"io"
 	"net"
+	"os"
+	"strings"
 	"testing"
 )
 
+// testLogger records the logged messages with their attributes.
+type testLogger struct {
+	messages []string
+}
+
+func (l *testLogger) record(level, msg string, args []any) {
+	l.messages = append(l.messages, fmt.Sprint(level, " ", msg, " ", args))
+}
+
+func (l *testLogger) Debug(msg string, args ...any) { l.record("DEBUG", msg, args) }
+func (l *testLogger) Info(msg string, args ...any)  { l.record("INFO", msg, args) }
+func (l *testLogger) Warn(msg string, args ...any)  { l.record("WARN", msg, args) }
+func (l *testLogger) Error(msg string, args ...any) { l.record("ERROR", msg, args) }
+
+const testTelegram = "534d4100000402a000000001024400106069015d71551764e5bdd84c0001040000000bf70001080000000002f8910910000204000" +
+	"0000000000208000000000dcdc5c87800030400000000000003080000000001f123bc00000404000000014e00040800000000016a2919e" +
+	"80009040000000c09000908000000000397ab5348000a040000000000000a08000000000e84ed5c50000d0400000003e20015040000001" +
+	"0a90015080000000005378ef3c800160400000000000016080000000003c80e74480017040000000000001708000000000105d38438001" +
+	"80400000001150018080000000000e27d9960001d0400000010b2001d08000000000578325168001e040000000000001e0800000000042" +
+	"7f938c0001f0400000008a50020040000038e1200210400000003e600290400000000000029080000000000d60cdf10002a0400000004e" +
+	"6002a080000000009ce538888002b040000000005002b080000000000aac925c0002c040000000000002c08000000000031f7dab000310" +
+	"400000000000031080000000000ec5dc47800320400000004e60032080000000009dd81cc70003304000000023c0034040000038fc2003" +
+	"50400000003e8003d040000000034003d0800000000013ddc2538003e040000000000003e0800000000048a4abc10003f0400000000000" +
+	"03f0800000000005def5bc0004004000000003d0040080000000000731be2e80045040000000050004508000000000181b137d00046040" +
+	"000000000004608000000000494ea5428004704000000002300480400000391f80049040000000286900000000200105200000000"
+
 func TestListen(t *testing.T) {
 	energyMeter, err := Listen()
 
@@ -19,6 +52,68 @@ func TestListen(t *testing.T) {
 	}
 }
 
+func TestListen_Unicast(t *testing.T) {
+	port, err := freeport.GetFreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := fmt.Sprintf("127.0.0.1:%v", port)
+	energyMeter, err := Listen(WithUnicast(addr), WithReadBuffer(1<<16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer energyMeter.Close()
+
+	msg, err := hex.DecodeString(testTelegram)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	telegram, err := energyMeter.ReadTelegram()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if telegram.SerialNo == 0 {
+		t.Fatal("no serial number included")
+	}
+}
+
+func TestListen_Interface(t *testing.T) {
+	ifis, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ifi *net.Interface
+	for i := range ifis {
+		if ifis[i].Flags&net.FlagMulticast != 0 && ifis[i].Flags&net.FlagUp != 0 {
+			ifi = &ifis[i]
+			break
+		}
+	}
+	if ifi == nil {
+		t.Skip("no multicast capable interface")
+	}
+
+	energyMeter, err := Listen(WithInterface(ifi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer energyMeter.Close()
+}
+
 type dummyEnergyMeterConnection struct {
 	msg []byte
 }
@@ -40,25 +135,13 @@ func (d *dummyEnergyMeterConnection) Close() error {
 func TestEnergyMeter_Read(t *testing.T) {
 	t.Parallel()
 
-	hexes := "534d4100000402a000000001024400106069015d71551764e5bdd84c0001040000000bf70001080000000002f8910910000204000" +
-		"0000000000208000000000dcdc5c87800030400000000000003080000000001f123bc00000404000000014e00040800000000016a2919e" +
-		"80009040000000c09000908000000000397ab5348000a040000000000000a08000000000e84ed5c50000d0400000003e20015040000001" +
-		"0a90015080000000005378ef3c800160400000000000016080000000003c80e74480017040000000000001708000000000105d38438001" +
-		"80400000001150018080000000000e27d9960001d0400000010b2001d08000000000578325168001e040000000000001e0800000000042" +
-		"7f938c0001f0400000008a50020040000038e1200210400000003e600290400000000000029080000000000d60cdf10002a0400000004e" +
-		"6002a080000000009ce538888002b040000000005002b080000000000aac925c0002c040000000000002c08000000000031f7dab000310" +
-		"400000000000031080000000000ec5dc47800320400000004e60032080000000009dd81cc70003304000000023c0034040000038fc2003" +
-		"50400000003e8003d040000000034003d0800000000013ddc2538003e040000000000003e0800000000048a4abc10003f0400000000000" +
-		"03f0800000000005def5bc0004004000000003d0040080000000000731be2e80045040000000050004508000000000181b137d00046040" +
-		"000000000004608000000000494ea5428004704000000002300480400000391f80049040000000286900000000200105200000000"
-
-	msg, err := hex.DecodeString(hexes)
+	msg, err := hex.DecodeString(testTelegram)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	emConn := &dummyEnergyMeterConnection{msg}
-	em := &EnergyMeter{emConn}
+	em := &EnergyMeter{Conn: emConn}
 
 	telegram, err := em.ReadTelegram()
 	if err != nil {
@@ -69,3 +152,51 @@ func TestEnergyMeter_Read(t *testing.T) {
 		t.Fatalf("telegram is nil")
 	}
 }
+
+// TestEnergyMeter_ReadTelegram_Replay replays a capture containing telegrams and a discovery request.
+func TestEnergyMeter_ReadTelegram_Replay(t *testing.T) {
+	f, err := os.Open("testdata/telegrams.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	conn, err := pcap.NewReplayConn(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Port = 9522
+
+	log := &testLogger{}
+	em := &EnergyMeter{Conn: conn, Logger: log}
+
+	var telegrams []*EnergyMeterTelegram
+	var invalid int
+	for {
+		telegram, err := em.ReadTelegram()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, ErrUnsupportedProtocol) {
+			invalid++
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		telegrams = append(telegrams, telegram)
+	}
+
+	if len(telegrams) != 3 || invalid != 1 {
+		t.Fatalf("expected 3 telegrams and 1 invalid packet, got %d and %d", len(telegrams), invalid)
+	}
+	if len(log.messages) != 1 || !strings.HasPrefix(log.messages[0], "DEBUG received invalid telegram [source ") {
+		t.Fatalf("expected the invalid packet to be logged, got %q", log.messages)
+	}
+
+	for i := 1; i < len(telegrams); i++ {
+		if d := telegrams[i].MeasuringTime - telegrams[i-1].MeasuringTime; d != 1000 {
+			t.Fatalf("expected 1000ms between telegrams, got %d", d)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package meter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+const (
+	// obisMediumElectricity is the value group A of all identifiers sent by an energy meter.
+	obisMediumElectricity = 1
+	// obisNoHistory is the value group F of all identifiers sent by an energy meter.
+	obisNoHistory = 255
+	// obisPhaseOffset is the difference of MeasVal between the values of two phases.
+	obisPhaseOffset = 20
+)
+
+// Well-known identifiers of values sent by an energy meter.
+var (
+	OBISPowerImport     = OBISIdentifier{Channel: channelInternal, MeasVal: 1, MeasType: measTypeAverage}
+	OBISPowerExport     = OBISIdentifier{Channel: channelInternal, MeasVal: 2, MeasType: measTypeAverage}
+	OBISEnergyImport    = OBISIdentifier{Channel: channelInternal, MeasVal: 1, MeasType: measTypeEnergyMeter}
+	OBISEnergyExport    = OBISIdentifier{Channel: channelInternal, MeasVal: 2, MeasType: measTypeEnergyMeter}
+	OBISPowerFactor     = OBISIdentifier{Channel: channelInternal, MeasVal: 13, MeasType: measTypeAverage}
+	OBISFrequency       = OBISIdentifier{Channel: channelInternal, MeasVal: 14, MeasType: measTypeAverage}
+	OBISPowerImportL1   = OBISIdentifier{Channel: channelInternal, MeasVal: 21, MeasType: measTypeAverage}
+	OBISPowerExportL1   = OBISIdentifier{Channel: channelInternal, MeasVal: 22, MeasType: measTypeAverage}
+	OBISCurrentL1       = OBISIdentifier{Channel: channelInternal, MeasVal: 31, MeasType: measTypeAverage}
+	OBISVoltageL1       = OBISIdentifier{Channel: channelInternal, MeasVal: 32, MeasType: measTypeAverage}
+	OBISPowerImportL2   = OBISIdentifier{Channel: channelInternal, MeasVal: 41, MeasType: measTypeAverage}
+	OBISPowerExportL2   = OBISIdentifier{Channel: channelInternal, MeasVal: 42, MeasType: measTypeAverage}
+	OBISCurrentL2       = OBISIdentifier{Channel: channelInternal, MeasVal: 51, MeasType: measTypeAverage}
+	OBISVoltageL2       = OBISIdentifier{Channel: channelInternal, MeasVal: 52, MeasType: measTypeAverage}
+	OBISPowerImportL3   = OBISIdentifier{Channel: channelInternal, MeasVal: 61, MeasType: measTypeAverage}
+	OBISPowerExportL3   = OBISIdentifier{Channel: channelInternal, MeasVal: 62, MeasType: measTypeAverage}
+	OBISCurrentL3       = OBISIdentifier{Channel: channelInternal, MeasVal: 71, MeasType: measTypeAverage}
+	OBISVoltageL3       = OBISIdentifier{Channel: channelInternal, MeasVal: 72, MeasType: measTypeAverage}
+	OBISSoftwareVersion = OBISIdentifier{Channel: channelOther, MeasVal: 0, MeasType: measTypeVersion}
+)
+
+var obisPattern = regexp.MustCompile(`^(?:(\d+)-)?(?:(\d+):)?(\d+)\.(\d+)\.(\d+)(?:\*(\d+))?$`)
+
+// OBISInfo describes the value of an OBISIdentifier.
+type OBISInfo struct {
+	// Name is a short snake case name of the value, e.g. for use as metric name.
+	Name string
+	Unit string
+	// Scale converts the raw value of a telegram into the unit.
+	Scale       float64
+	Description string
+}
+
+var (
+	obisRegistryMu sync.RWMutex
+	obisRegistry   = make(map[OBISIdentifier]OBISInfo)
+)
+
+// obisQuantity describes a quantity measured in total and for each phase.
+type obisQuantity struct {
+	// total and phase are the MeasVal of the total and the first phase value, zero if not measured.
+	total, phase        uint8
+	name, description   string
+	unit, counterUnit   string
+	scale, counterScale float64
+}
+
+func init() {
+	quantities := []obisQuantity{
+		{total: 1, phase: 21, name: "active_power_import", description: "Active power import",
+			unit: "W", scale: 0.1, counterUnit: "Wh", counterScale: 1.0 / 3600},
+		{total: 2, phase: 22, name: "active_power_export", description: "Active power export",
+			unit: "W", scale: 0.1, counterUnit: "Wh", counterScale: 1.0 / 3600},
+		{total: 3, phase: 23, name: "reactive_power_import", description: "Reactive power import",
+			unit: "var", scale: 0.1, counterUnit: "varh", counterScale: 1.0 / 3600},
+		{total: 4, phase: 24, name: "reactive_power_export", description: "Reactive power export",
+			unit: "var", scale: 0.1, counterUnit: "varh", counterScale: 1.0 / 3600},
+		{total: 9, phase: 29, name: "apparent_power_import", description: "Apparent power import",
+			unit: "VA", scale: 0.1, counterUnit: "VAh", counterScale: 1.0 / 3600},
+		{total: 10, phase: 30, name: "apparent_power_export", description: "Apparent power export",
+			unit: "VA", scale: 0.1, counterUnit: "VAh", counterScale: 1.0 / 3600},
+		{total: 13, phase: 33, name: "power_factor", description: "Power factor", scale: 0.001},
+		{total: 14, name: "frequency", description: "Grid frequency", unit: "Hz", scale: 0.001},
+		{phase: 31, name: "current", description: "Current", unit: "A", scale: 0.001},
+		{phase: 32, name: "voltage", description: "Voltage", unit: "V", scale: 0.001},
+	}
+
+	for _, q := range quantities {
+		if q.total != 0 {
+			registerOBISQuantity(q, q.total, "", "")
+		}
+		if q.phase != 0 {
+			for phase := uint8(0); phase < 3; phase++ {
+				registerOBISQuantity(q, q.phase+phase*obisPhaseOffset,
+					fmt.Sprintf("_l%d", phase+1), fmt.Sprintf(" L%d", phase+1))
+			}
+		}
+	}
+
+	obisRegistry[OBISSoftwareVersion] = OBISInfo{
+		Name:        "software_version",
+		Description: "Software version",
+	}
+}
+
+func registerOBISQuantity(q obisQuantity, measVal uint8, nameSuffix, descriptionSuffix string) {
+	obisRegistry[OBISIdentifier{Channel: channelInternal, MeasVal: measVal, MeasType: measTypeAverage}] = OBISInfo{
+		Name:        q.name + nameSuffix,
+		Unit:        q.unit,
+		Scale:       q.scale,
+		Description: q.description + descriptionSuffix,
+	}
+
+	if q.counterUnit != "" {
+		obisRegistry[OBISIdentifier{Channel: channelInternal, MeasVal: measVal, MeasType: measTypeEnergyMeter}] = OBISInfo{
+			Name:        q.name + nameSuffix + "_total",
+			Unit:        q.counterUnit,
+			Scale:       q.counterScale,
+			Description: q.description + descriptionSuffix + " counter",
+		}
+	}
+}
+
+// LookupOBIS returns the registered information of an identifier.
+func LookupOBIS(id OBISIdentifier) (OBISInfo, bool) {
+	obisRegistryMu.RLock()
+	defer obisRegistryMu.RUnlock()
+
+	info, ok := obisRegistry[id]
+	return info, ok
+}
+
+// RegisterOBIS registers the information of an identifier, replacing any previously registered information.
+func RegisterOBIS(id OBISIdentifier, info OBISInfo) {
+	obisRegistryMu.Lock()
+	defer obisRegistryMu.Unlock()
+
+	obisRegistry[id] = info
+}
+
+// OBISIdentifiers returns all registered identifiers.
+func OBISIdentifiers() []OBISIdentifier {
+	obisRegistryMu.RLock()
+	defer obisRegistryMu.RUnlock()
+
+	ids := make([]OBISIdentifier, 0, len(obisRegistry))
+	for id := range obisRegistry {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// ParseOBIS parses an identifier in the notation A-B:C.D.E*F.
+//
+// The value groups A, B and F are optional. If present, A must be 1 (electricity) and F must be 255.
+// B is the channel, C the measured value, D the measurement type and E the tariff.
+func ParseOBIS(s string) (OBISIdentifier, error) {
+	m := obisPattern.FindStringSubmatch(s)
+	if m == nil {
+		return OBISIdentifier{}, fmt.Errorf("invalid OBIS identifier %q", s)
+	}
+
+	groups := make([]uint8, len(m))
+	for i, v := range m[1:] {
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(v, 10, 8)
+		if err != nil {
+			return OBISIdentifier{}, fmt.Errorf("invalid OBIS identifier %q: value group out of range", s)
+		}
+		groups[i+1] = uint8(n)
+	}
+
+	if m[1] != "" && groups[1] != obisMediumElectricity {
+		return OBISIdentifier{}, fmt.Errorf("invalid OBIS identifier %q: unsupported medium %d", s, groups[1])
+	}
+	if m[6] != "" && groups[6] != obisNoHistory {
+		return OBISIdentifier{}, fmt.Errorf("invalid OBIS identifier %q: unsupported historical value %d", s, groups[6])
+	}
+
+	return OBISIdentifier{
+		Channel:  groups[2],
+		MeasVal:  groups[3],
+		MeasType: groups[4],
+		Tariff:   groups[5],
+	}, nil
+}
+
+// String returns the identifier in the notation A-B:C.D.E*F, e.g. 1-0:1.8.0*255.
+func (o OBISIdentifier) String() string {
+	return fmt.Sprintf("%d-%d:%d.%d.%d*%d", obisMediumElectricity, o.Channel, o.MeasVal, o.MeasType, o.Tariff, obisNoHistory)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (o OBISIdentifier) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (o *OBISIdentifier) UnmarshalText(text []byte) error {
+	id, err := ParseOBIS(string(text))
+	if err != nil {
+		return err
+	}
+
+	*o = id
+	return nil
+}
+
+// Value returns the value of an identifier converted into the unit of its registered information.
+//
+// Values of unregistered identifiers are returned unscaled.
+// Returns false if the telegram does not contain the identifier.
+func (t *EnergyMeterTelegram) Value(id OBISIdentifier) (float64, bool) {
+	raw, ok := t.Obis[id]
+	if !ok {
+		return 0, false
+	}
+
+	info, ok := LookupOBIS(id)
+	if !ok || info.Scale == 0 {
+		return float64(raw), true
+	}
+
+	return float64(raw) * info.Scale, true
+}
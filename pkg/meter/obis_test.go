@@ -0,0 +1,139 @@
+package meter
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+)
+
+func TestOBISIdentifier_String(t *testing.T) {
+	tt := map[string]struct {
+		in   OBISIdentifier
+		want string
+	}{
+		"energy import": {OBISEnergyImport, "1-0:1.8.0*255"},
+		"voltage":       {OBISVoltageL2, "1-0:52.4.0*255"},
+		"version":       {OBISSoftwareVersion, "1-144:0.0.0*255"},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			if s := tc.in.String(); s != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, s)
+			}
+		})
+	}
+}
+
+func TestParseOBIS(t *testing.T) {
+	tt := map[string]struct {
+		in   string
+		want OBISIdentifier
+		wErr bool
+	}{
+		"full":             {in: "1-0:1.8.0*255", want: OBISEnergyImport},
+		"without history":  {in: "1-0:21.4.0", want: OBISPowerImportL1},
+		"short":            {in: "32.4.0", want: OBISVoltageL1},
+		"channel":          {in: "144:0.0.0", want: OBISSoftwareVersion},
+		"invalid medium":   {in: "7-0:1.8.0*255", wErr: true},
+		"invalid history":  {in: "1-0:1.8.0*101", wErr: true},
+		"out of range":     {in: "1-0:256.8.0", wErr: true},
+		"invalid notation": {in: "1.8", wErr: true},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			id, err := ParseOBIS(tc.in)
+			if err != nil {
+				if tc.wErr {
+					return
+				}
+				t.Fatal(err)
+			}
+
+			if tc.wErr {
+				t.Fatal("expected error")
+			}
+
+			if id != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, id)
+			}
+		})
+	}
+}
+
+func TestOBISIdentifier_UnmarshalText(t *testing.T) {
+	text, err := OBISFrequency.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var id OBISIdentifier
+	if err := id.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+
+	if id != OBISFrequency {
+		t.Fatalf("expected %v, got %v", OBISFrequency, id)
+	}
+}
+
+func TestLookupOBIS(t *testing.T) {
+	info, ok := LookupOBIS(OBISVoltageL3)
+	if !ok {
+		t.Fatal("voltage should be registered")
+	}
+
+	if info.Name != "voltage_l3" || info.Unit != "V" {
+		t.Fatalf("unexpected info %v", info)
+	}
+
+	custom := OBISIdentifier{Channel: 0, MeasVal: 99, MeasType: 4}
+	if _, ok := LookupOBIS(custom); ok {
+		t.Fatal("custom identifier should not be registered")
+	}
+
+	RegisterOBIS(custom, OBISInfo{Name: "custom", Scale: 2})
+	info, ok = LookupOBIS(custom)
+	if !ok || info.Name != "custom" {
+		t.Fatalf("custom identifier not registered")
+	}
+}
+
+func TestEnergyMeterTelegram_Value(t *testing.T) {
+	msg, err := hex.DecodeString(testTelegram)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	telegram, err := DecodeTelegram(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := map[string]struct {
+		in   OBISIdentifier
+		want float64
+	}{
+		"voltage":      {OBISVoltageL1, 232.978},
+		"power factor": {OBISPowerFactor, 0.994},
+		"power":        {OBISPowerImport, 306.3},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			v, ok := telegram.Value(tc.in)
+			if !ok {
+				t.Fatalf("telegram did not include identifier %v", tc.in)
+			}
+
+			if math.Abs(v-tc.want) > 1e-9 {
+				t.Fatalf("expected %v, got %v", tc.want, v)
+			}
+		})
+	}
+
+	if _, ok := telegram.Value(OBISFrequency); ok {
+		t.Fatal("telegram should not include the frequency")
+	}
+}
@@ -1,138 +1,372 @@
 package modbus
 
 import (
-	"io"
-	"log"
-	"math"
-	"math/rand"
+	"bytes"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/logger"
 	"net"
+	"os"
+	"sync"
 	"time"
 )
 
-const (
-	backoffStart            = 500 * time.Millisecond
-	backoffExpoBase         = 1.2
-	backoffMax              = 30 * time.Second
-	backoffRandomMultiplier = 100 * time.Millisecond
-	timeout                 = time.Minute
-)
-
-// reconnectingConn is a network connection that tries to reconnect after the connection is closed.
+// reconnectingConn is a modbus tcp transport which connects to the device when needed and reconnects after the
+// connection broke.
+//
+// Requests are pipelined: many requests of any unit id may be outstanding on the connection, their responses are
+// matched by transaction id. A reader goroutine receives the responses and notices a closed connection without
+// blocking the next request. A dropped connection is reestablished by the next request with a single dial, retrying
+// with backoff is left to the Client. Connecting runs in a goroutine of its own shared by concurrent requests, they
+// wait for it at most for the timeout of the policy.
 type reconnectingConn struct {
-	*net.TCPConn
 	addr   string
-	cancel chan bool
+	policy RetryPolicy
+	log    logger.Logger
+
+	mu            sync.Mutex
+	session       *session
+	connecting    *connectAttempt
+	transactionID uint16
+	lastActivity  time.Time
+	idleTimer     *time.Timer
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// session is a connection to the device with its outstanding transactions, guarded by the mutex of the
+// reconnectingConn.
+type session struct {
+	conn    *net.TCPConn
+	pending map[uint16]chan<- response
+	// lastRead is the time the last response was received.
+	lastRead time.Time
+	// err is the error of the outstanding transactions after the session was dropped.
+	err error
 }
 
-func newReconnectingConn(addr string) (*reconnectingConn, error) {
+// connectAttempt is a running reconnect, its error is set before done is closed.
+type connectAttempt struct {
+	done chan struct{}
+	err  error
+}
+
+// dialError is returned when connecting to the device failed.
+type dialError struct {
+	addr string
+	err  error
+}
+
+func (e *dialError) Error() string {
+	return fmt.Sprintf("modbus: couldn't connect to %v: %v", e.addr, e.err)
+}
+
+func (e *dialError) Unwrap() error {
+	return e.err
+}
+
+// response is the result of a transaction.
+type response struct {
+	frame frame
+	err   error
+}
+
+func newReconnectingConn(addr string, policy RetryPolicy, log logger.Logger) *reconnectingConn {
 	return &reconnectingConn{
-		addr: addr,
-	}, nil
+		addr:   addr,
+		policy: policy.withDefaults(),
+		log:    logger.OrDiscard(log),
+		done:   make(chan struct{}),
+	}
+}
+
+// Connect connects to the device if it is not connected, it waits until the dial finished or the connection is
+// closed.
+func (c *reconnectingConn) Connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.awaitSession(nil)
+	return err
+}
+
+// ReadHoldingRegisters reads the registers of the unit.
+func (c *reconnectingConn) ReadHoldingRegisters(unitID byte, address, quantity uint16) ([]byte, error) {
+	data, err := readRequest(address, quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := c.transaction(unitID, FuncReadHoldingRegisters, data)
+	if err != nil {
+		return nil, err
+	}
+	return readResponse(f.data, quantity)
 }
 
-func (c *reconnectingConn) verifyConn() error {
-	if c.TCPConn == nil {
-		return c.reconnect()
+// WriteMultipleRegisters writes the registers of the unit.
+func (c *reconnectingConn) WriteMultipleRegisters(unitID byte, address, quantity uint16, value []byte) error {
+	data, err := writeRequest(address, quantity, value)
+	if err != nil {
+		return err
 	}
 
-	if !isConnAlive(c.TCPConn) {
-		err := c.reconnect()
-		if err != nil {
-			return err
-		}
+	f, err := c.transaction(unitID, FuncWriteMultipleRegisters, data)
+	if err != nil {
+		return err
 	}
+	return writeResponse(f.data, address, quantity)
+}
 
+// WriteSingleRegister writes a register of the unit.
+func (c *reconnectingConn) WriteSingleRegister(unitID byte, address, value uint16) error {
+	data := writeSingleRequest(address, value)
+	f, err := c.transaction(unitID, FuncWriteSingleRegister, data)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(f.data, data) {
+		return fmt.Errorf("modbus: invalid response to writing register %v", address)
+	}
 	return nil
 }
 
-func isConnAlive(c *net.TCPConn) bool {
-	err := c.SetReadDeadline(time.Now())
+// transaction sends the request and waits for its response, it is safe for concurrent use. Connecting and the
+// response take at most the timeout of the retry policy.
+//
+// If the device did not respond at all within the timeout, the connection is dropped and reestablished by the
+// next request.
+func (c *reconnectingConn) transaction(unitID, function byte, data []byte) (frame, error) {
+	responses := make(chan response, 1)
+	timer := time.NewTimer(c.policy.Timeout)
+	defer timer.Stop()
+
+	c.mu.Lock()
+	s, err := c.awaitSession(timer.C)
 	if err != nil {
-		return false
+		c.mu.Unlock()
+		return frame{}, err
 	}
 
-	defer func() {
-		var zero time.Time
-		err := c.SetReadDeadline(zero)
-		if err != nil {
-			panic(err)
+	id := c.nextTransactionID(s)
+	s.pending[id] = responses
+
+	sent := time.Now()
+	c.lastActivity = sent
+	c.startIdleTimer()
+
+	request := frame{transactionID: id, unitID: unitID, function: function, data: data}
+	if err := s.conn.SetWriteDeadline(sent.Add(c.policy.Timeout)); err != nil {
+		c.drop(s, err)
+		c.mu.Unlock()
+		return frame{}, err
+	}
+	if _, err := s.conn.Write(request.encode()); err != nil {
+		c.drop(s, err)
+		c.mu.Unlock()
+		return frame{}, err
+	}
+	c.mu.Unlock()
+
+	select {
+	case r := <-responses:
+		if r.err != nil {
+			return frame{}, r.err
 		}
-	}()
+		if r.frame.unitID != unitID {
+			return frame{}, fmt.Errorf("modbus: response unit id %v does not match request %v", r.frame.unitID, unitID)
+		}
+		return r.frame, r.frame.exception(function)
+	case <-timer.C:
+		err := fmt.Errorf("modbus: no response to transaction %v: %w", id, os.ErrDeadlineExceeded)
 
-	b := make([]byte, 1)
-	if _, err := c.Read(b); err == io.EOF {
-		return false
-	} else {
-		return true
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(s.pending, id)
+		if s.lastRead.Before(sent) {
+			// the device did not respond to any request since, the connection is broken
+			c.drop(s, err)
+		}
+		return frame{}, err
 	}
 }
 
-func (c *reconnectingConn) reconnect() error {
-	if c.TCPConn != nil {
-		err := c.TCPConn.Close()
-		if err != nil {
-			return err
+// nextTransactionID returns an id which is not used by an outstanding transaction. The caller must hold the mutex.
+func (c *reconnectingConn) nextTransactionID(s *session) uint16 {
+	for {
+		c.transactionID++
+		if _, ok := s.pending[c.transactionID]; !ok {
+			return c.transactionID
 		}
 	}
+}
 
-	var i int
+// read receives the responses of the session until its connection is closed.
+func (c *reconnectingConn) read(s *session) {
+	defer c.wg.Done()
 
 	for {
-		conn, err := net.DialTimeout("tcp", c.addr, timeout)
-		if err == nil {
-			tcpConn := conn.(*net.TCPConn)
-			err := tcpConn.SetKeepAlive(true)
-			if err != nil {
-				return err
-			}
-			err = tcpConn.SetKeepAlivePeriod(30 * time.Second)
-			if err != nil {
-				return err
+		f, err := readFrame(s.conn)
+
+		c.mu.Lock()
+		if err != nil {
+			c.drop(s, err)
+			for id, responses := range s.pending {
+				responses <- response{err: s.err}
+				delete(s.pending, id)
 			}
+			c.mu.Unlock()
+			return
+		}
+
+		s.lastRead = time.Now()
+		responses, ok := s.pending[f.transactionID]
+		delete(s.pending, f.transactionID)
+		c.mu.Unlock()
 
-			c.TCPConn = tcpConn
-			return nil
+		// responses of timed out transactions are discarded
+		if ok {
+			responses <- response{frame: f}
+		}
+	}
+}
+
+// awaitSession returns the session, reconnecting if the connection was dropped. The caller must hold the mutex,
+// it is released while waiting for the reconnect until it finished, the connection is closed or the timeout fires.
+func (c *reconnectingConn) awaitSession(timeout <-chan time.Time) (*session, error) {
+	for {
+		select {
+		case <-c.done:
+			return nil, net.ErrClosed
+		default:
 		}
 
-		randomOffset := rand.Float64() * float64(backoffRandomMultiplier.Milliseconds())
-		backoff := math.Pow(backoffExpoBase, float64(i))*float64(backoffStart.Milliseconds()) + randomOffset
-		if backoff < float64(backoffMax.Milliseconds()) {
-			i++
-		} else {
-			backoff = float64(backoffMax.Milliseconds())
+		if c.session != nil {
+			return c.session, nil
 		}
 
-		log.Printf("backing off %v millis...", backoff)
+		if c.connecting == nil {
+			c.connecting = &connectAttempt{done: make(chan struct{})}
+			c.wg.Add(1)
+			go c.connect(c.connecting)
+		}
+		attempt := c.connecting
 
+		c.mu.Unlock()
 		select {
-		case <-c.cancel:
-			return nil
-		case <-time.After(time.Duration(backoff) * time.Millisecond):
+		case <-attempt.done:
+			c.mu.Lock()
+			if attempt.err != nil {
+				return nil, attempt.err
+			}
+		case <-c.done:
+			c.mu.Lock()
+			return nil, net.ErrClosed
+		case <-timeout:
+			c.mu.Lock()
+			return nil, fmt.Errorf("modbus: couldn't connect to %v: %w", c.addr, os.ErrDeadlineExceeded)
 		}
 	}
 }
 
-func (c *reconnectingConn) Read(p []byte) (n int, err error) {
-	err = c.verifyConn()
+// connect dials and starts the session of the connection, it must not hold the mutex.
+func (c *reconnectingConn) connect(attempt *connectAttempt) {
+	defer c.wg.Done()
+
+	c.log.Debug("connecting to modbus device", "address", c.addr)
+	conn, err := c.dial()
 	if err != nil {
-		return 0, err
+		err = &dialError{addr: c.addr, err: err}
+	} else {
+		c.log.Info("connected to modbus device", "address", c.addr)
 	}
-	return c.TCPConn.Read(p)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err == nil {
+		select {
+		case <-c.done:
+			_ = conn.Close()
+			err = net.ErrClosed
+		default:
+			c.session = &session{conn: conn, pending: make(map[uint16]chan<- response)}
+			c.wg.Add(1)
+			go c.read(c.session)
+		}
+	}
+
+	c.connecting = nil
+	attempt.err = err
+	close(attempt.done)
 }
 
-func (c *reconnectingConn) Write(p []byte) (n int, err error) {
-	err = c.verifyConn()
+func (c *reconnectingConn) dial() (*net.TCPConn, error) {
+	conn, err := net.DialTimeout("tcp", c.addr, c.policy.Timeout)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return c.TCPConn.Write(p)
+
+	tcpConn := conn.(*net.TCPConn)
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		_ = tcpConn.Close()
+		return nil, err
+	}
+	if err := tcpConn.SetKeepAlivePeriod(30 * time.Second); err != nil {
+		_ = tcpConn.Close()
+		return nil, err
+	}
+	return tcpConn, nil
 }
 
+// drop closes the connection of the session, its outstanding transactions fail with the error.
+// The caller must hold the mutex.
+func (c *reconnectingConn) drop(s *session, err error) {
+	if s.err == nil {
+		s.err = err
+		_ = s.conn.Close()
+	}
+	if c.session == s {
+		c.session = nil
+	}
+}
+
+// startIdleTimer drops the connection after the idle timeout. The caller must hold the mutex.
+func (c *reconnectingConn) startIdleTimer() {
+	if c.idleTimer != nil {
+		c.idleTimer.Reset(c.policy.IdleTimeout)
+		return
+	}
+
+	c.idleTimer = time.AfterFunc(c.policy.IdleTimeout, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		s := c.session
+		if s != nil && len(s.pending) == 0 && time.Since(c.lastActivity) >= c.policy.IdleTimeout {
+			c.log.Debug("closing idle connection to modbus device", "address", c.addr)
+			c.drop(s, net.ErrClosed)
+		}
+	})
+}
+
+// Close closes the connection and cancels reconnecting, outstanding and following requests fail with
+// net.ErrClosed.
 func (c *reconnectingConn) Close() error {
-	if c.TCPConn != nil {
-		return c.TCPConn.Close()
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+
+	c.mu.Lock()
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	if c.session != nil {
+		c.drop(c.session, net.ErrClosed)
 	}
-	close(c.cancel)
+	c.mu.Unlock()
 
+	c.wg.Wait()
 	return nil
 }
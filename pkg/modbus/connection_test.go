@@ -1,14 +1,17 @@
 package modbus
 
 import (
-	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/phayes/freeport"
 	"go.uber.org/goleak"
-	"io"
 	"net"
+	"os"
 	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func getFreeAddr() string {
@@ -20,59 +23,99 @@ func getFreeAddr() string {
 	return fmt.Sprintf(":%v", port)
 }
 
-func newTcpServer(addr string) (*net.TCPListener, error) {
+// testServer is a modbus tcp server whose holding registers contain their address plus the unit id. Reads of
+// addresses 1000 to 1999 are answered after a delay of (address-1000) milliseconds, concurrently to other
+// requests. Each connection is closed after the given number of frames if it is positive.
+type testServer struct {
+	*net.TCPListener
+	accepted chan struct{}
+}
+
+func newTestServer(addr string, frames int) (*testServer, error) {
 	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
 
-	tcpListener, err := net.ListenTCP("tcp", tcpAddr)
+	l, err := net.ListenTCP("tcp", tcpAddr)
 	if err != nil {
 		return nil, err
 	}
 
+	s := &testServer{TCPListener: l, accepted: make(chan struct{}, 16)}
 	go func() {
-		conn, err := tcpListener.Accept()
-		if err != nil {
-			panic(err)
-		}
-
 		for {
-			buf := make([]byte, 1024)
-			_, err = conn.Read(buf)
-			if err == io.EOF {
-				break
-			}
+			conn, err := l.Accept()
 			if err != nil {
-				panic(err)
-			}
-
-			_, err = conn.Write([]byte("OK"))
-			if err != nil {
-				panic(err)
+				return
 			}
+			s.accepted <- struct{}{}
+			go serveTestFrames(conn, frames)
 		}
 	}()
 
-	return tcpListener, nil
+	return s, nil
 }
 
-func verifyPing(c net.Conn) error {
-	_, err := c.Write([]byte("TEST"))
-	if err != nil {
-		return err
+func serveTestFrames(conn net.Conn, frames int) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		_ = conn.Close()
+	}()
+
+	for i := 0; frames <= 0 || i < frames; i++ {
+		request, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			response := testResponse(request)
+			mu.Lock()
+			defer mu.Unlock()
+			_, _ = conn.Write(response.encode())
+		}()
+	}
+}
+
+func testResponse(request frame) frame {
+	response := request
+	address := binary.BigEndian.Uint16(request.data)
+	quantity := binary.BigEndian.Uint16(request.data[2:])
+
+	switch request.function {
+	case FuncReadHoldingRegisters:
+		if address >= 1000 && address < 2000 {
+			time.Sleep(time.Duration(address-1000) * time.Millisecond)
+		}
+		response.data = make([]byte, 1+quantity*2)
+		response.data[0] = byte(quantity * 2)
+		for i := uint16(0); i < quantity; i++ {
+			binary.BigEndian.PutUint16(response.data[1+i*2:], address+i+uint16(request.unitID))
+		}
+	case FuncWriteMultipleRegisters:
+		response.data = request.data[:4]
+	default:
+		response.function |= exceptionFlag
+		response.data = []byte{byte(ExceptionIllegalFunction)}
 	}
+	return response
+}
 
-	b := make([]byte, 1024)
-	l, err := c.Read(b)
+// verifyPing reads a register and verifies its value.
+func verifyPing(c *reconnectingConn, unitID byte, address uint16) error {
+	registers, err := c.ReadHoldingRegisters(unitID, address, 1)
 	if err != nil {
 		return err
 	}
-
-	if bytes.Compare([]byte("OK"), b[:l]) != 0 {
-		return fmt.Errorf("ping not equal")
+	if v := binary.BigEndian.Uint16(registers); v != address+uint16(unitID) {
+		return fmt.Errorf("expected register %v of unit %v, got %v", address, unitID, v)
 	}
-
 	return nil
 }
 
@@ -81,70 +124,227 @@ func TestMain(m *testing.M) {
 }
 
 func TestReconnectingConn_Ping(t *testing.T) {
-	addr := getFreeAddr()
-
-	server, err := newTcpServer(addr)
+	server, err := newTestServer(getFreeAddr(), 0)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer func() {
-		if err := server.Close(); err != nil {
-			panic(err)
+	defer server.Close()
+
+	conn := newReconnectingConn(server.Addr().String(), DefaultRetryPolicy, nil)
+	for i := 0; i < 3; i++ {
+		if err := verifyPing(conn, byte(i), 40000); err != nil {
+			t.Fatal(err)
 		}
-	}()
+	}
+	if err := conn.WriteMultipleRegisters(1, 40000, 1, []byte{0, 1}); err != nil {
+		t.Fatal(err)
+	}
 
-	conn, err := newReconnectingConn(server.Addr().String())
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.accepted) != 1 {
+		t.Fatalf("expected a single connection, got %v", len(server.accepted))
+	}
+	if err := verifyPing(conn, 1, 40000); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed after closing, got %v", err)
+	}
+}
+
+func TestReconnectingConn_Pipelining(t *testing.T) {
+	server, err := newTestServer(getFreeAddr(), 0)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer server.Close()
+
+	conn := newReconnectingConn(server.Addr().String(), DefaultRetryPolicy, nil)
+	defer conn.Close()
+
+	// the responses are delayed by 100ms to 10ms, arriving in reverse order
+	start := time.Now()
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			errs <- verifyPing(conn, byte(i), uint16(1100-i*10))
+		}(i)
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if d := time.Since(start); d > 300*time.Millisecond {
+		t.Fatalf("expected concurrent transactions, took %v", d)
+	}
+	if len(server.accepted) != 1 {
+		t.Fatalf("expected a single connection, got %v", len(server.accepted))
+	}
+}
 
-	err = verifyPing(conn)
+func TestReconnectingConn_Timeout(t *testing.T) {
+	server, err := newTestServer(getFreeAddr(), 0)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer server.Close()
+
+	conn := newReconnectingConn(server.Addr().String(), RetryPolicy{Timeout: 50 * time.Millisecond}, nil)
+	defer conn.Close()
+
+	if err := verifyPing(conn, 1, 1200); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	// the device did not respond at all, the next request reconnects
+	if err := verifyPing(conn, 1, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.accepted) != 2 {
+		t.Fatalf("expected a new connection after the device did not respond, got %v connections", len(server.accepted))
+	}
+}
 
-	err = conn.Close()
+func TestReconnectingConn_Exception(t *testing.T) {
+	server, err := newTestServer(getFreeAddr(), 0)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer server.Close()
+
+	conn := newReconnectingConn(server.Addr().String(), DefaultRetryPolicy, nil)
+	defer conn.Close()
+
+	_, err = conn.transaction(1, 0x2b, []byte{0, 0, 0, 0})
+	var exception *ExceptionError
+	if !errors.As(err, &exception) || exception.Code != ExceptionIllegalFunction || exception.Function != 0x2b {
+		t.Fatalf("expected illegal function exception, got %v", err)
+	}
 }
 
 func TestReconnectingConn_ReconnectInitial(t *testing.T) {
 	addr := getFreeAddr()
+	conn := newReconnectingConn(addr, DefaultRetryPolicy, nil)
+	defer conn.Close()
+
+	// the device is unreachable, each request dials once
+	if err := verifyPing(conn, 1, 40000); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("expected connection refused, got %v", err)
+	}
 
-	conn, err := newReconnectingConn(addr)
+	server, err := newTestServer(addr, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer server.Close()
 
-	var wgPing sync.WaitGroup
-	var wgServerStart sync.WaitGroup
-	wgPing.Add(1)
-	wgServerStart.Add(1)
-	go func() {
-		wgServerStart.Done()
-		err = verifyPing(conn)
-		if err != nil {
+	if err := verifyPing(conn, 1, 40000); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconnectingConn_ReconnectClosed(t *testing.T) {
+	// the server closes each connection after one frame
+	server, err := newTestServer(getFreeAddr(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	conn := newReconnectingConn(server.Addr().String(), DefaultRetryPolicy, nil)
+	defer conn.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := verifyPing(conn, 1, 40000); err != nil {
 			t.Fatal(err)
 		}
-		wgPing.Done()
-	}()
+		// wait for the close to arrive
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(server.accepted) != 3 {
+		t.Fatalf("expected 3 connections, got %v", len(server.accepted))
+	}
+}
 
-	wgServerStart.Wait()
-	server, err := newTcpServer(addr)
+func TestReconnectingConn_CloseOutstanding(t *testing.T) {
+	server, err := newTestServer(getFreeAddr(), 0)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer func() {
-		if err := server.Close(); err != nil {
-			panic(err)
-		}
+	defer server.Close()
+
+	conn := newReconnectingConn(server.Addr().String(), DefaultRetryPolicy, nil)
+
+	pinged := make(chan error)
+	go func() {
+		pinged <- verifyPing(conn, 1, 1200)
 	}()
 
-	wgPing.Wait()
+	time.Sleep(50 * time.Millisecond)
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-pinged; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestReconnectingConn_CloseUnused(t *testing.T) {
+	conn := newReconnectingConn(getFreeAddr(), DefaultRetryPolicy, nil)
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
 
-	err = conn.Close()
+func TestReconnectingConn_IdleTimeout(t *testing.T) {
+	server, err := newTestServer(getFreeAddr(), 0)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer server.Close()
+
+	conn := newReconnectingConn(server.Addr().String(), RetryPolicy{IdleTimeout: 20 * time.Millisecond}, nil)
+	defer conn.Close()
+
+	if err := verifyPing(conn, 1, 40000); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := verifyPing(conn, 1, 40000); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(server.accepted) != 2 {
+		t.Fatalf("expected a new connection after the idle timeout, got %v connections", len(server.accepted))
+	}
+}
+
+func TestReconnectingConn_Unreachable(t *testing.T) {
+	addr := getFreeAddr()
+	conn := newReconnectingConn(addr, DefaultRetryPolicy, nil)
+
+	// requests waiting for the dial share its error
+	pinged := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			pinged <- verifyPing(conn, 1, 40000)
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		var dialErr *dialError
+		if err := <-pinged; !errors.As(err, &dialErr) || dialErr.addr != addr {
+			t.Fatalf("expected dial error, got %v", err)
+		}
+	}
+
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Connect(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
 }
@@ -0,0 +1,190 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// tcpHeaderSize is the size of the modbus application protocol header including the unit id.
+	tcpHeaderSize = 7
+	// tcpMaxLength is the maximum size of a modbus tcp frame.
+	tcpMaxLength = 260
+	// tcpProtocolID is the protocol id of modbus in the header.
+	tcpProtocolID = 0
+
+	// FuncReadHoldingRegisters is the function code of reading holding registers.
+	FuncReadHoldingRegisters = 0x03
+	// FuncWriteSingleRegister is the function code of writing a single holding register.
+	FuncWriteSingleRegister = 0x06
+	// FuncWriteMultipleRegisters is the function code of writing holding registers.
+	FuncWriteMultipleRegisters = 0x10
+
+	// exceptionFlag is set in the function code of exception responses.
+	exceptionFlag = 0x80
+
+	// maxReadQuantity and maxWriteQuantity are the maximum number of registers of a request.
+	maxReadQuantity  = 125
+	maxWriteQuantity = 123
+)
+
+// ExceptionCode is the code of an exception response.
+type ExceptionCode byte
+
+const (
+	ExceptionIllegalFunction                    ExceptionCode = 0x01
+	ExceptionIllegalDataAddress                 ExceptionCode = 0x02
+	ExceptionIllegalDataValue                   ExceptionCode = 0x03
+	ExceptionServerDeviceFailure                ExceptionCode = 0x04
+	ExceptionAcknowledge                        ExceptionCode = 0x05
+	ExceptionServerDeviceBusy                   ExceptionCode = 0x06
+	ExceptionGatewayPathUnavailable             ExceptionCode = 0x0a
+	ExceptionGatewayTargetDeviceFailedToRespond ExceptionCode = 0x0b
+)
+
+func (c ExceptionCode) String() string {
+	switch c {
+	case ExceptionIllegalFunction:
+		return "illegal function"
+	case ExceptionIllegalDataAddress:
+		return "illegal data address"
+	case ExceptionIllegalDataValue:
+		return "illegal data value"
+	case ExceptionServerDeviceFailure:
+		return "server device failure"
+	case ExceptionAcknowledge:
+		return "acknowledge"
+	case ExceptionServerDeviceBusy:
+		return "server device busy"
+	case ExceptionGatewayPathUnavailable:
+		return "gateway path unavailable"
+	case ExceptionGatewayTargetDeviceFailedToRespond:
+		return "gateway target device failed to respond"
+	default:
+		return fmt.Sprintf("exception %d", byte(c))
+	}
+}
+
+// ExceptionError is an exception response of a device to a request.
+type ExceptionError struct {
+	Function byte
+	Code     ExceptionCode
+}
+
+func (e *ExceptionError) Error() string {
+	return fmt.Sprintf("modbus: exception response to function %#02x: %v", e.Function, e.Code)
+}
+
+// frame is a modbus tcp frame.
+type frame struct {
+	transactionID uint16
+	unitID        byte
+	function      byte
+	data          []byte
+}
+
+// encode returns the frame with the modbus application protocol header.
+func (f frame) encode() []byte {
+	b := make([]byte, tcpHeaderSize+1+len(f.data))
+	binary.BigEndian.PutUint16(b, f.transactionID)
+	binary.BigEndian.PutUint16(b[2:], tcpProtocolID)
+	// the length includes the unit id and function code
+	binary.BigEndian.PutUint16(b[4:], uint16(2+len(f.data)))
+	b[6] = f.unitID
+	b[7] = f.function
+	copy(b[8:], f.data)
+	return b
+}
+
+// readFrame reads a frame from the reader.
+func readFrame(r io.Reader) (frame, error) {
+	header := make([]byte, tcpHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return frame{}, err
+	}
+
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if protocol := binary.BigEndian.Uint16(header[2:]); protocol != tcpProtocolID {
+		return frame{}, fmt.Errorf("modbus: invalid protocol id %v", protocol)
+	}
+	if length < 2 || length > tcpMaxLength-tcpHeaderSize+1 {
+		return frame{}, fmt.Errorf("modbus: invalid length %v in header", length)
+	}
+
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(r, pdu); err != nil {
+		return frame{}, err
+	}
+
+	return frame{
+		transactionID: binary.BigEndian.Uint16(header),
+		unitID:        header[6],
+		function:      pdu[0],
+		data:          pdu[1:],
+	}, nil
+}
+
+// exception returns the exception of a response to the function, or nil if it is a regular response.
+func (f frame) exception(function byte) error {
+	if f.function == function|exceptionFlag && len(f.data) == 1 {
+		return &ExceptionError{Function: function, Code: ExceptionCode(f.data[0])}
+	}
+	if f.function != function {
+		return fmt.Errorf("modbus: response function %#02x does not match request %#02x", f.function, function)
+	}
+	return nil
+}
+
+// readRequest encodes the data of a read holding registers request.
+func readRequest(address, quantity uint16) ([]byte, error) {
+	if quantity < 1 || quantity > maxReadQuantity {
+		return nil, fmt.Errorf("modbus: quantity %v must be between 1 and %v", quantity, maxReadQuantity)
+	}
+
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint16(b, address)
+	binary.BigEndian.PutUint16(b[2:], quantity)
+	return b, nil
+}
+
+// readResponse decodes the registers of a read holding registers response.
+func readResponse(data []byte, quantity uint16) ([]byte, error) {
+	if len(data) < 1 || int(data[0]) != len(data)-1 || len(data)-1 != int(quantity)*2 {
+		return nil, fmt.Errorf("modbus: invalid response size %v for %v registers", len(data), quantity)
+	}
+	return data[1:], nil
+}
+
+// writeRequest encodes the data of a write multiple registers request.
+func writeRequest(address, quantity uint16, value []byte) ([]byte, error) {
+	if quantity < 1 || quantity > maxWriteQuantity {
+		return nil, fmt.Errorf("modbus: quantity %v must be between 1 and %v", quantity, maxWriteQuantity)
+	}
+	if len(value) != int(quantity)*2 {
+		return nil, fmt.Errorf("modbus: %v bytes do not match quantity %v", len(value), quantity)
+	}
+
+	b := make([]byte, 5+len(value))
+	binary.BigEndian.PutUint16(b, address)
+	binary.BigEndian.PutUint16(b[2:], quantity)
+	b[4] = byte(len(value))
+	copy(b[5:], value)
+	return b, nil
+}
+
+// writeSingleRequest encodes the data of a write single register request, the response echoes it.
+func writeSingleRequest(address, value uint16) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint16(b, address)
+	binary.BigEndian.PutUint16(b[2:], value)
+	return b
+}
+
+// writeResponse verifies the echoed address and quantity of a write multiple registers response.
+func writeResponse(data []byte, address, quantity uint16) error {
+	if len(data) != 4 || binary.BigEndian.Uint16(data) != address || binary.BigEndian.Uint16(data[2:]) != quantity {
+		return fmt.Errorf("modbus: invalid response to writing %v registers at %v", quantity, address)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFrame_Encode(t *testing.T) {
+	f := frame{transactionID: 0x0102, unitID: 3, function: FuncReadHoldingRegisters, data: []byte{0x9c, 0x40, 0x00, 0x02}}
+	want := []byte{0x01, 0x02, 0x00, 0x00, 0x00, 0x06, 0x03, 0x03, 0x9c, 0x40, 0x00, 0x02}
+
+	b := f.encode()
+	if !bytes.Equal(b, want) {
+		t.Fatalf("want % x, got % x", want, b)
+	}
+
+	decoded, err := readFrame(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.transactionID != f.transactionID || decoded.unitID != f.unitID || decoded.function != f.function ||
+		!bytes.Equal(decoded.data, f.data) {
+		t.Fatalf("want %+v, got %+v", f, decoded)
+	}
+}
+
+func TestReadFrame_Invalid(t *testing.T) {
+	tests := map[string][]byte{
+		"protocol":  {0x00, 0x01, 0x00, 0x01, 0x00, 0x03, 0x01, 0x03, 0x00},
+		"length":    {0x00, 0x01, 0x00, 0x00, 0x01, 0x00, 0x01, 0x03, 0x00},
+		"truncated": {0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03},
+	}
+
+	for name, b := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := readFrame(bytes.NewReader(b)); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestFrame_Exception(t *testing.T) {
+	tests := map[string]struct {
+		f    frame
+		code ExceptionCode
+		err  bool
+	}{
+		"response":  {f: frame{function: FuncReadHoldingRegisters, data: []byte{0}}},
+		"exception": {f: frame{function: FuncReadHoldingRegisters | exceptionFlag, data: []byte{0x02}}, code: ExceptionIllegalDataAddress, err: true},
+		"function":  {f: frame{function: FuncWriteMultipleRegisters}, err: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.f.exception(FuncReadHoldingRegisters)
+			if (err != nil) != test.err {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			var exception *ExceptionError
+			if errors.As(err, &exception) != (test.code != 0) || (exception != nil && exception.Code != test.code) {
+				t.Fatalf("unexpected exception %v", err)
+			}
+		})
+	}
+}
+
+func TestRequests_Quantity(t *testing.T) {
+	if _, err := readRequest(0, 126); err == nil {
+		t.Fatal("expected error reading more than 125 registers")
+	}
+	if _, err := writeRequest(0, 2, []byte{0, 1}); err == nil {
+		t.Fatal("expected error writing mismatching bytes")
+	}
+	if _, err := readResponse([]byte{4, 0, 1}, 2); err == nil {
+		t.Fatal("expected error of truncated response")
+	}
+	if err := writeResponse([]byte{0, 1, 0, 2}, 1, 3); err == nil {
+		t.Fatal("expected error of mismatching response")
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"github.com/xiegeo/modbusone"
+	"sync"
 )
 
 var byteOrder = binary.BigEndian
 
+// Mockbus holds registers in memory. It is safe for concurrent use and serves its registers as Handler of a Server.
 type Mockbus struct {
+	mu               sync.RWMutex
 	holdingRegisters []byte
 }
 
@@ -34,6 +36,13 @@ func (m *Mockbus) AddHoldingRegisterEntry(addr uint16, data interface{}) error {
 	start := int(addr) * 2
 	end := start + len(bs)
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if end > len(m.holdingRegisters) {
+		return fmt.Errorf("entry at %v exceeds the %v registers", addr, len(m.holdingRegisters)/2)
+	}
+
 	for k, v := range m.holdingRegisters[start:end] {
 		if v != 0 {
 			return fmt.Errorf("adding this entry would override data at byte %v", k)
@@ -56,15 +65,48 @@ func (m *Mockbus) AddHoldingRegisterEntries(entries map[uint16]interface{}) erro
 	return nil
 }
 
+// ReadHoldingRegisters returns a copy of the registers, reading registers beyond the mockbus fails with an
+// illegal data address exception.
 func (m *Mockbus) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
-	start := address * 2
-	end := start + quantity*2
+	start := int(address) * 2
+	end := start + int(quantity)*2
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.holdingRegisters) < end {
+		return nil, &ExceptionError{Function: FuncReadHoldingRegisters, Code: ExceptionIllegalDataAddress}
+	}
+
+	regs := make([]byte, end-start)
+	copy(regs, m.holdingRegisters[start:end])
+	return regs, nil
+}
 
-	if len(m.holdingRegisters) < int(end) {
-		return nil, modbusone.EcIllegalDataAddress
+// WriteHoldingRegisters overwrites the registers, writing registers beyond the mockbus fails with an illegal data
+// address exception.
+func (m *Mockbus) WriteHoldingRegisters(address uint16, value []byte) error {
+	if len(value)%2 != 0 {
+		return &ExceptionError{Function: FuncWriteMultipleRegisters, Code: ExceptionIllegalDataValue}
 	}
 
-	return m.holdingRegisters[start:end], nil
+	start := int(address) * 2
+	end := start + len(value)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.holdingRegisters) < end {
+		return &ExceptionError{Function: FuncWriteMultipleRegisters, Code: ExceptionIllegalDataAddress}
+	}
+
+	copy(m.holdingRegisters[start:end], value)
+	return nil
+}
+
+// ServeModbus answers reading and writing the holding registers of any unit.
+func (m *Mockbus) ServeModbus(r Request) ([]byte, error) {
+	return RegisterHandler{Registers: m}.ServeModbus(r)
 }
 
 func (m *Mockbus) ReadHoldingRegistersUint(address, quantity uint16) ([]uint16, error) {
@@ -4,54 +4,153 @@ package modbus
 import (
 	"bytes"
 	"encoding/binary"
-	"github.com/goburrow/modbus"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/logger"
 	"github.com/pkg/errors"
-	"io"
-	"log"
-	"math"
-	"os"
-	"syscall"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const (
-	bo     = 1 * time.Second
-	boMax  = 10 * time.Second
-	boBase = 1.7
+	// DefaultPort is the port of modbus tcp.
+	DefaultPort = 502
 )
 
 type registerReader interface {
-	ReadHoldingRegisters(address uint16, quantity uint16) (results []byte, err error)
+	ReadHoldingRegisters(unitID byte, address, quantity uint16) ([]byte, error)
+	WriteMultipleRegisters(unitID byte, address, quantity uint16, value []byte) error
+	WriteSingleRegister(unitID byte, address, value uint16) error
 }
 
 // Client represents a modbus connection.
 //
-// When the connection is unresponsive, the client will attempt to reconnect.
+// When the connection is unresponsive, the client will attempt to reconnect as configured by its RetryPolicy.
+//
+// Requests are safe for concurrent use and pipelined on the connection, clients of other units of the same
+// connection are created by ForUnit.
 type Client struct {
-	handler *modbus.TCPClientHandler
-	client  registerReader
+	addr      string
+	unitID    byte
+	conn      *reconnectingConn
+	client    registerReader
+	policy    RetryPolicy
+	reconnect bool
+	log       logger.Logger
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithTimeout sets the timeout for connecting and for the response to a request, 20s by default.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.policy.Timeout = timeout
+	}
+}
+
+// WithRetryPolicy sets the policy of reconnecting and retrying requests, DefaultRetryPolicy by default.
+//
+// A zero timeout of the policy keeps the timeout set by WithTimeout.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) {
+		if p.Timeout <= 0 {
+			p.Timeout = c.policy.Timeout
+		}
+		c.policy = p
+	}
+}
+
+// WithoutReconnect disables retrying, errors connecting or reading are returned instead. A broken connection is
+// reestablished by the next request with a single attempt.
+//
+// This is useful for probing addresses which might not belong to a modbus device.
+func WithoutReconnect() Option {
+	return func(c *Client) {
+		c.reconnect = false
+	}
+}
+
+// WithLogger sets the logger of connection attempts, nothing is logged by default.
+func WithLogger(l logger.Logger) Option {
+	return func(c *Client) {
+		c.log = logger.OrDiscard(l)
+	}
 }
 
 // Connect connects to the given address using modbus tcp.
-func Connect(addr string) (*Client, error) {
-	handler := modbus.NewTCPClientHandler(addr)
-	handler.Timeout = 20 * time.Second
-	handler.IdleTimeout = 24 * time.Hour
-	err := reconnect(handler)
-	if err != nil {
+//
+// By default, Connect blocks until the device is reachable. If the retry policy limits the attempts,
+// an error matching ErrDeviceUnreachable is returned after the last attempt.
+func Connect(addr string, opts ...Option) (*Client, error) {
+	c := &Client{policy: DefaultRetryPolicy, reconnect: true, log: logger.Discard}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.policy = c.policy.withDefaults()
+
+	c.addr = addr
+	c.conn = newReconnectingConn(addr, c.policy, c.log)
+	if err := c.retry(c.conn.Connect); err != nil {
+		_ = c.conn.Close()
 		return nil, errors.Wrap(err, "connecting to modbus")
 	}
 
-	return &Client{handler: handler, client: modbus.NewClient(handler)}, nil
+	c.client = c.conn
+	return c, nil
+}
+
+// ParseAddress parses a device address in the notation host[:port][/unit], e.g. 192.168.1.10/3.
+//
+// The port defaults to DefaultPort and the unit id to 1.
+func ParseAddress(s string) (addr string, unitID byte, err error) {
+	addr, unitID = s, 1
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		id, err := strconv.ParseUint(s[i+1:], 0, 8)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid unit id in %q", s)
+		}
+		addr, unitID = s[:i], byte(id)
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// no port, IPv6 hosts may be given with or without brackets
+		host, port = addr, strconv.Itoa(DefaultPort)
+		if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+		}
+	} else if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return "", 0, fmt.Errorf("invalid port in %q", s)
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("missing host in %q", s)
+	}
+
+	return net.JoinHostPort(host, port), unitID, nil
 }
 
+// Close closes the connection, cancelling a running reconnect.
+//
+// The connection is shared with the clients created by ForUnit, they are closed as well.
 func (c *Client) Close() error {
-	return c.handler.Close()
+	return c.conn.Close()
 }
 
 // SetSlaveID sets the slave id (device address) of following modbus requests.
+//
+// It must not be called concurrently with requests, use ForUnit to address several units concurrently.
 func (c *Client) SetSlaveID(id byte) {
-	c.handler.SlaveId = id
+	c.unitID = id
+}
+
+// ForUnit returns a client sending requests to the unit id over the connection of this client, e.g. to poll
+// many devices behind a gateway.
+func (c *Client) ForUnit(id byte) *Client {
+	unit := *c
+	unit.unitID = id
+	return &unit
 }
 
 // ReadInto reads the specified holding register into the given variable.
@@ -61,54 +160,87 @@ func (c *Client) ReadInto(address uint16, v interface{}) error {
 	return c.readBytesInto(address, uint16(b)/2, v)
 }
 
-func reconnect(handler *modbus.TCPClientHandler) error {
-	err := handler.Close()
-	if err != nil {
+// WriteFrom writes the given variable into the holding registers starting at the specified address.
+//
+// The variable is encoded big endian and must have a size of a multiple of two bytes.
+func (c *Client) WriteFrom(address uint16, v interface{}) error {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
 		return err
 	}
+	if buf.Len()%2 != 0 {
+		return errors.New("invalid data length, bytes must be multiple of two")
+	}
 
-	var i float64
-	for {
-		log.Printf("connecting to %v...", handler.Address)
-		err := handler.Connect()
-		if err == nil {
-			log.Printf("connected to %v", handler.Address)
-			return nil
-		}
-
-		backoff := math.Min(math.Pow(boBase, i)*bo.Seconds(), boMax.Seconds())
-
-		if backoff < boMax.Seconds() {
-			i++
-		}
+	return c.retry(func() error {
+		return c.client.WriteMultipleRegisters(c.unitID, address, uint16(buf.Len()/2), buf.Bytes())
+	})
+}
 
-		log.Printf("%v, retrying in %vs", errors.Wrap(err, "couldn't connect to device").Error(), backoff)
-		<-time.After(time.Duration(backoff) * time.Second)
-	}
+// WriteSingleRegister writes the holding register with the write single register function, for devices not
+// supporting writing multiple registers.
+func (c *Client) WriteSingleRegister(address, value uint16) error {
+	return c.retry(func() error {
+		return c.client.WriteSingleRegister(c.unitID, address, value)
+	})
 }
 
-func (c *Client) readBytesInto(address, quantity uint16, data interface{}) error {
-	for {
-		registers, err := c.client.ReadHoldingRegisters(address, quantity)
-		if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.EOF) {
-			err := reconnect(c.handler)
-			if err != nil {
-				return err
-			}
-			continue
-		}
-		if err != nil {
+// retry runs the request, retrying it after failures to connect and retryable errors with the backoff of the
+// retry policy until its attempts are exhausted or the connection is closed.
+//
+// The connection is dropped after errors, retries reconnect first.
+func (c *Client) retry(request func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := request()
+		var dialErr *dialError
+		connecting := errors.As(err, &dialErr)
+		if err == nil || !c.reconnect || !connecting && !c.policy.Retryable(err) || c.closed() {
 			return err
 		}
+		if c.policy.exhausted(attempt) {
+			return &UnreachableError{Address: c.addr, Attempts: attempt, Err: err}
+		}
 
-		buf := bytes.NewReader(registers)
-		err = binary.Read(buf, binary.BigEndian, data)
-		if err != nil {
+		backoff := c.policy.Backoff(attempt)
+		if connecting {
+			c.log.Warn("couldn't connect to modbus device, backing off", "address", c.addr, "attempt", attempt,
+				"backoff", backoff, "error", dialErr.err)
+		} else {
+			c.log.Debug("request to modbus device failed, backing off", "address", c.addr, "unit_id", c.unitID,
+				"attempt", attempt, "backoff", backoff, "error", err)
+		}
+
+		select {
+		case <-c.conn.done:
 			return err
+		case <-time.After(backoff):
 		}
+	}
+}
+
+// closed returns true if the connection is closed.
+func (c *Client) closed() bool {
+	select {
+	case <-c.conn.done:
+		return true
+	default:
+		return false
+	}
+}
 
-		return nil
+func (c *Client) readBytesInto(address, quantity uint16, data interface{}) error {
+	var registers []byte
+	err := c.retry(func() error {
+		var err error
+		registers, err = c.client.ReadHoldingRegisters(c.unitID, address, quantity)
+		return err
+	})
+	if err != nil {
+		return err
 	}
+
+	buf := bytes.NewReader(registers)
+	return binary.Read(buf, binary.BigEndian, data)
 }
 
 func (c *Client) ReadUint16(address uint16) (uint16, error) {
@@ -183,11 +315,12 @@ func (c *Client) ReadFloat64(address uint16) (float64, error) {
 	return val, nil
 }
 
+// ReadString reads a string of the given number of registers, trailing null bytes are removed.
 func (c *Client) ReadString(address, words uint16) (string, error) {
-	var val string
+	val := make([]byte, words*2)
 	err := c.readBytesInto(address, words, val)
 	if err != nil {
 		return "", err
 	}
-	return val, nil
+	return strings.TrimRight(string(val), "\x00"), nil
 }
@@ -0,0 +1,138 @@
+package modbus
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLogger records the logged messages with their attributes.
+type testLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *testLogger) record(level, msg string, args []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprint(level, " ", msg, " ", args))
+}
+
+func (l *testLogger) Debug(msg string, args ...any) { l.record("DEBUG", msg, args) }
+func (l *testLogger) Info(msg string, args ...any)  { l.record("INFO", msg, args) }
+func (l *testLogger) Warn(msg string, args ...any)  { l.record("WARN", msg, args) }
+func (l *testLogger) Error(msg string, args ...any) { l.record("ERROR", msg, args) }
+
+func TestParseAddress(t *testing.T) {
+	tests := map[string]struct {
+		s      string
+		addr   string
+		unitID byte
+		err    bool
+	}{
+		"host":         {s: "192.168.1.10", addr: "192.168.1.10:502", unitID: 1},
+		"port":         {s: "192.168.1.10:1502", addr: "192.168.1.10:1502", unitID: 1},
+		"unit":         {s: "inverter.local/126", addr: "inverter.local:502", unitID: 126},
+		"port unit":    {s: "192.168.1.10:1502/3", addr: "192.168.1.10:1502", unitID: 3},
+		"ipv6":         {s: "[fe80::1]:502/3", addr: "[fe80::1]:502", unitID: 3},
+		"ipv6 unit":    {s: "[fe80::1]/3", addr: "[fe80::1]:502", unitID: 3},
+		"ipv6 host":    {s: "[fe80::1]", addr: "[fe80::1]:502", unitID: 1},
+		"ipv6 bare":    {s: "fe80::1", addr: "[fe80::1]:502", unitID: 1},
+		"invalid unit": {s: "192.168.1.10/300", err: true},
+		"no host":      {s: "/3", err: true},
+		"no host port": {s: ":502", err: true},
+		"port name":    {s: "192.168.1.10:abc", err: true},
+		"port zero":    {s: "192.168.1.10:0/3", err: true},
+		"port range":   {s: "192.168.1.10:70000", err: true},
+		"empty port":   {s: "192.168.1.10:", err: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			addr, unitID, err := ParseAddress(test.s)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected error, got %v %v", addr, unitID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if addr != test.addr || unitID != test.unitID {
+				t.Fatalf("want %v %v, got %v %v", test.addr, test.unitID, addr, unitID)
+			}
+		})
+	}
+}
+
+func TestConnect_Logger(t *testing.T) {
+	addr := getFreeAddr()
+
+	// the device becomes reachable after the first attempt failed
+	listening := make(chan net.Listener)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			panic(err)
+		}
+		listening <- l
+	}()
+
+	log := &testLogger{}
+	c, err := Connect("localhost"+addr, WithLogger(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+	_ = (<-listening).Close()
+
+	want := []string{
+		fmt.Sprintf("DEBUG connecting to modbus device [address localhost%v]", addr),
+		fmt.Sprintf("WARN couldn't connect to modbus device, backing off [address localhost%v attempt 1 backoff 1s error", addr),
+		fmt.Sprintf("DEBUG connecting to modbus device [address localhost%v]", addr),
+		fmt.Sprintf("INFO connected to modbus device [address localhost%v]", addr),
+	}
+	if len(log.messages) != len(want) {
+		t.Fatalf("unexpected messages %q", log.messages)
+	}
+	for i, m := range log.messages {
+		if !strings.HasPrefix(m, want[i]) {
+			t.Fatalf("want message %q, got %q", want[i], m)
+		}
+	}
+}
+
+func TestClient_ForUnit(t *testing.T) {
+	server, err := newTestServer(getFreeAddr(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	c, err := Connect(server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetSlaveID(1)
+
+	units := []*Client{c, c.ForUnit(2), c.ForUnit(126)}
+	for _, u := range units {
+		v, err := u.ReadUint16(40000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != 40000+uint16(u.unitID) {
+			t.Fatalf("expected register of unit %v, got %v", u.unitID, v)
+		}
+	}
+
+	if len(server.accepted) != 1 {
+		t.Fatalf("expected a shared connection, got %v connections", len(server.accepted))
+	}
+}
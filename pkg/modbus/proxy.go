@@ -0,0 +1,330 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/logger"
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WritePermission reports whether the client may write the registers of the unit.
+type WritePermission func(client net.Addr, unitID byte, address, quantity uint16) bool
+
+// AllowNetworks returns a WritePermission allowing clients of the networks to write any register, the networks are
+// given in CIDR notation or as single IP addresses.
+func AllowNetworks(networks ...string) (WritePermission, error) {
+	var nets []*net.IPNet
+	for _, s := range networks {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip address %q", s)
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+
+	return func(client net.Addr, _ byte, _, _ uint16) bool {
+		host, _, err := net.SplitHostPort(client.String())
+		if err != nil {
+			return false
+		}
+		ip := net.ParseIP(host)
+		for _, n := range nets {
+			if ip != nil && n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// Proxy is a Handler forwarding the requests of many clients to a device over the single connection of a Client,
+// e.g. for inverters accepting only one or two modbus tcp clients.
+//
+// Requests are queued and forwarded by a limited number of workers, requests exceeding the queue are answered
+// with a server device busy exception. Reads may be cached for a short time. Writes are denied unless permitted.
+//
+// The retry policy of the client should limit its attempts, as requests to an unreachable device are answered
+// with a gateway target device failed to respond exception only after the last attempt.
+type Proxy struct {
+	client   *Client
+	workers  int
+	queue    chan proxyJob
+	cacheTTL time.Duration
+	writable WritePermission
+	log      logger.Logger
+
+	mu    sync.Mutex
+	cache map[cacheKey]cacheEntry
+	// generation is incremented by writes, reads forwarded before are not cached.
+	generation uint64
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// proxyJob is a queued request to the device.
+type proxyJob struct {
+	request func() error
+	result  chan<- error
+}
+
+// cacheKey identifies a cached read.
+type cacheKey struct {
+	unitID            byte
+	address, quantity uint16
+}
+
+type cacheEntry struct {
+	registers []byte
+	expires   time.Time
+}
+
+// ProxyOption configures a Proxy.
+type ProxyOption func(*Proxy)
+
+// WithQueueLength sets the maximum number of requests waiting to be forwarded, 64 by default.
+func WithQueueLength(n int) ProxyOption {
+	return func(p *Proxy) {
+		p.queue = make(chan proxyJob, n)
+	}
+}
+
+// WithMaxOutstanding sets the number of requests forwarded concurrently, 1 by default as many devices accepting
+// few clients don't answer pipelined requests either.
+func WithMaxOutstanding(n int) ProxyOption {
+	return func(p *Proxy) {
+		p.workers = n
+	}
+}
+
+// WithCacheTTL caches reads for the duration, reads are not cached by default.
+//
+// Only reads of the same registers are answered from the cache, writes invalidate the cached reads of the
+// written registers.
+func WithCacheTTL(ttl time.Duration) ProxyOption {
+	return func(p *Proxy) {
+		p.cacheTTL = ttl
+	}
+}
+
+// WithWritePermission sets the permission of clients to write registers, writes are denied by default.
+// Denied writes are answered with an illegal function exception.
+func WithWritePermission(w WritePermission) ProxyOption {
+	return func(p *Proxy) {
+		p.writable = w
+	}
+}
+
+// WithProxyLogger sets the logger of failed requests, nothing is logged by default.
+func WithProxyLogger(l logger.Logger) ProxyOption {
+	return func(p *Proxy) {
+		p.log = logger.OrDiscard(l)
+	}
+}
+
+// NewProxy creates a proxy forwarding requests over the connection of the client, serve it with a Server.
+//
+// The client is not closed by the proxy.
+func NewProxy(c *Client, opts ...ProxyOption) *Proxy {
+	p := &Proxy{
+		client:  c,
+		workers: 1,
+		queue:   make(chan proxyJob, 64),
+		writable: func(net.Addr, byte, uint16, uint16) bool {
+			return false
+		},
+		log:   logger.Discard,
+		cache: make(map[cacheKey]cacheEntry),
+		done:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.workers < 1 {
+		p.workers = 1
+	}
+
+	p.wg.Add(p.workers)
+	for i := 0; i < p.workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// ServeModbus forwards reading and writing holding registers, other function codes are answered with an illegal
+// function exception.
+func (p *Proxy) ServeModbus(r Request) ([]byte, error) {
+	return RegisterHandler{Registers: &proxyUnit{proxy: p, request: r}}.ServeModbus(r)
+}
+
+// work forwards queued requests until the proxy is closed.
+func (p *Proxy) work() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case job := <-p.queue:
+			job.result <- job.request()
+		}
+	}
+}
+
+// forward queues the request and waits for its result.
+func (p *Proxy) forward(function byte, request func() error) error {
+	result := make(chan error, 1)
+
+	select {
+	case p.queue <- proxyJob{request: request, result: result}:
+	default:
+		return &ExceptionError{Function: function, Code: ExceptionServerDeviceBusy}
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-p.done:
+		return net.ErrClosed
+	}
+}
+
+// cached returns a copy of the cached registers if they did not expire, otherwise the generation of the cache
+// to store the read registers with.
+func (p *Proxy) cached(key cacheKey) ([]byte, uint64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, p.generation, false
+	}
+	return append([]byte(nil), entry.registers...), p.generation, true
+}
+
+// store caches the registers and removes expired entries. The registers are not cached if they were read before
+// an invalidation of the generation, as they might have been written while reading.
+func (p *Proxy) store(key cacheKey, registers []byte, generation uint64) {
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if generation != p.generation {
+		return
+	}
+
+	for k, entry := range p.cache {
+		if now.After(entry.expires) {
+			delete(p.cache, k)
+		}
+	}
+	p.cache[key] = cacheEntry{registers: append([]byte(nil), registers...), expires: now.Add(p.cacheTTL)}
+}
+
+// invalidate removes the cached reads overlapping the registers.
+func (p *Proxy) invalidate(unitID byte, address, quantity uint16) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.generation++
+	for k := range p.cache {
+		if k.unitID == unitID && int(k.address) < int(address)+int(quantity) &&
+			int(address) < int(k.address)+int(k.quantity) {
+			delete(p.cache, k)
+		}
+	}
+}
+
+// Close stops forwarding requests, queued requests fail.
+func (p *Proxy) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	p.wg.Wait()
+	return nil
+}
+
+// proxyUnit forwards the registers of a request to the unit of the device.
+type proxyUnit struct {
+	proxy   *Proxy
+	request Request
+}
+
+func (u *proxyUnit) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	p := u.proxy
+	key := cacheKey{unitID: u.request.UnitID, address: address, quantity: quantity}
+	var generation uint64
+	if p.cacheTTL > 0 {
+		registers, g, ok := p.cached(key)
+		if ok {
+			return registers, nil
+		}
+		generation = g
+	}
+
+	registers := make([]byte, int(quantity)*2)
+	err := p.forward(u.request.Function, func() error {
+		return p.client.ForUnit(u.request.UnitID).ReadInto(address, registers)
+	})
+	if err != nil {
+		return nil, u.upstreamError(err)
+	}
+
+	if p.cacheTTL > 0 {
+		p.store(key, registers, generation)
+	}
+	return registers, nil
+}
+
+func (u *proxyUnit) WriteHoldingRegisters(address uint16, value []byte) error {
+	p := u.proxy
+	quantity := uint16(len(value) / 2)
+	if !p.writable(u.request.RemoteAddr, u.request.UnitID, address, quantity) {
+		p.log.Info("denied modbus write", "client", u.request.RemoteAddr, "unit_id", u.request.UnitID,
+			"address", address, "quantity", quantity)
+		return &ExceptionError{Function: u.request.Function, Code: ExceptionIllegalFunction}
+	}
+
+	err := p.forward(u.request.Function, func() error {
+		c := p.client.ForUnit(u.request.UnitID)
+		// the function is kept, as devices might support only one of them
+		if u.request.Function == FuncWriteSingleRegister {
+			return c.WriteSingleRegister(address, binary.BigEndian.Uint16(value))
+		}
+		return c.WriteFrom(address, value)
+	})
+	// the registers might have been written even if the request failed
+	p.invalidate(u.request.UnitID, address, quantity)
+	if err != nil {
+		return u.upstreamError(err)
+	}
+	return nil
+}
+
+// upstreamError returns the exceptions of the device, other errors are answered with a gateway target device
+// failed to respond exception.
+func (u *proxyUnit) upstreamError(err error) error {
+	var exception *ExceptionError
+	if errors.As(err, &exception) {
+		return exception
+	}
+
+	u.proxy.log.Warn("couldn't forward modbus request", "client", u.request.RemoteAddr, "unit_id", u.request.UnitID,
+		"function", u.request.Function, "error", err)
+	return &ExceptionError{Function: u.request.Function, Code: ExceptionGatewayTargetDeviceFailedToRespond}
+}
@@ -0,0 +1,378 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingHandler counts the requests to the handler and their maximum concurrency.
+type countingHandler struct {
+	Handler
+	// release blocks the requests until it is closed if it is not nil.
+	release  chan struct{}
+	received chan struct{}
+
+	mu        sync.Mutex
+	requests  int
+	active    int
+	maxActive int
+}
+
+func newCountingHandler(h Handler) *countingHandler {
+	return &countingHandler{Handler: h, received: make(chan struct{}, 16)}
+}
+
+func (h *countingHandler) ServeModbus(r Request) ([]byte, error) {
+	h.mu.Lock()
+	h.requests++
+	h.active++
+	if h.active > h.maxActive {
+		h.maxActive = h.active
+	}
+	h.mu.Unlock()
+
+	select {
+	case h.received <- struct{}{}:
+	default:
+	}
+	if h.release != nil {
+		<-h.release
+	}
+	defer func() {
+		h.mu.Lock()
+		h.active--
+		h.mu.Unlock()
+	}()
+
+	return h.Handler.ServeModbus(r)
+}
+
+func (h *countingHandler) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.requests
+}
+
+// startProxy serves a proxy of the upstream handler.
+func startProxy(t *testing.T, upstream Handler, opts ...ProxyOption) (addr string, closeProxy func()) {
+	upstreamServer, upstreamAddr := startServer(t, upstream)
+	c, err := Connect(upstreamAddr, WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := NewProxy(c, opts...)
+	server, addr := startServer(t, proxy)
+
+	return addr, func() {
+		_ = server.Close()
+		_ = proxy.Close()
+		_ = c.Close()
+		_ = upstreamServer.Close()
+	}
+}
+
+func TestProxy_Forward(t *testing.T) {
+	mockbus := NewMockbus(100)
+	if err := mockbus.AddHoldingRegisterEntry(10, uint16(42)); err != nil {
+		t.Fatal(err)
+	}
+	addr, closeProxy := startProxy(t, UnitMux{3: mockbus})
+	defer closeProxy()
+
+	c, err := Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetSlaveID(3)
+
+	if v, err := c.ReadUint16(10); err != nil || v != 42 {
+		t.Fatalf("expected 42, got %v %v", v, err)
+	}
+
+	var exception *ExceptionError
+	if _, err := c.ReadUint16(100); !errors.As(err, &exception) || exception.Code != ExceptionIllegalDataAddress {
+		t.Fatalf("expected illegal data address exception of the device, got %v", err)
+	}
+	if _, err := c.ForUnit(4).ReadUint16(10); !errors.As(err, &exception) ||
+		exception.Code != ExceptionGatewayPathUnavailable {
+		t.Fatalf("expected gateway path unavailable exception of the device, got %v", err)
+	}
+}
+
+func TestProxy_Unreachable(t *testing.T) {
+	upstreamServer, upstreamAddr := startServer(t, NewMockbus(10))
+	upstream, err := Connect(upstreamAddr, WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	proxy := NewProxy(upstream)
+	defer proxy.Close()
+	server, addr := startServer(t, proxy)
+	defer server.Close()
+
+	c, err := Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := upstreamServer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var exception *ExceptionError
+	if _, err := c.ReadUint16(1); !errors.As(err, &exception) ||
+		exception.Code != ExceptionGatewayTargetDeviceFailedToRespond {
+		t.Fatalf("expected gateway target device failed to respond exception, got %v", err)
+	}
+}
+
+func TestProxy_Cache(t *testing.T) {
+	upstream := newCountingHandler(NewMockbus(100))
+	addr, closeProxy := startProxy(t, upstream, WithCacheTTL(50*time.Millisecond),
+		WithWritePermission(func(net.Addr, byte, uint16, uint16) bool { return true }))
+	defer closeProxy()
+
+	c, err := Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	read := func(address uint16, want uint16, requests int) {
+		t.Helper()
+		if v, err := c.ReadUint16(address); err != nil || v != want {
+			t.Fatalf("expected %v, got %v %v", want, v, err)
+		}
+		if n := upstream.count(); n != requests {
+			t.Fatalf("expected %v upstream requests, got %v", requests, n)
+		}
+	}
+
+	read(10, 0, 1)
+	read(10, 0, 1)
+	read(11, 0, 2)
+
+	// writing invalidates the overlapping reads
+	if err := c.WriteFrom(10, uint16(7)); err != nil {
+		t.Fatal(err)
+	}
+	read(10, 7, 4)
+	read(11, 0, 4)
+
+	time.Sleep(60 * time.Millisecond)
+	read(10, 7, 5)
+}
+
+func TestProxy_CacheInvalidatedWhileReading(t *testing.T) {
+	p := NewProxy(nil, WithCacheTTL(time.Minute))
+	defer p.Close()
+
+	key := cacheKey{unitID: 1, address: 10, quantity: 2}
+	_, generation, ok := p.cached(key)
+	if ok {
+		t.Fatal("expected empty cache")
+	}
+
+	// a write of the registers completes while they are read
+	p.invalidate(1, 11, 1)
+	p.store(key, []byte{0, 1, 0, 2}, generation)
+	if _, _, ok := p.cached(key); ok {
+		t.Fatal("expected the registers read before the write not to be cached")
+	}
+
+	_, generation, _ = p.cached(key)
+	p.store(key, []byte{0, 1, 0, 2}, generation)
+	if registers, _, ok := p.cached(key); !ok || !bytes.Equal(registers, []byte{0, 1, 0, 2}) {
+		t.Fatalf("expected cached registers, got %v", registers)
+	}
+}
+
+func TestProxy_WriteSingleRegister(t *testing.T) {
+	mockbus := NewMockbus(10)
+	var mu sync.Mutex
+	var functions []byte
+	upstream := HandlerFunc(func(r Request) ([]byte, error) {
+		mu.Lock()
+		functions = append(functions, r.Function)
+		mu.Unlock()
+		return mockbus.ServeModbus(r)
+	})
+	addr, closeProxy := startProxy(t, upstream,
+		WithWritePermission(func(net.Addr, byte, uint16, uint16) bool { return true }))
+	defer closeProxy()
+
+	c, err := Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.WriteSingleRegister(2, 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteFrom(3, uint16(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := mockbus.ReadHoldingRegistersUint(2, 2); v[0] != 0x1234 || v[1] != 5 {
+		t.Fatalf("expected written registers, got %v", v)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !bytes.Equal(functions, []byte{FuncWriteSingleRegister, FuncWriteMultipleRegisters}) {
+		t.Fatalf("expected the functions of the requests to be forwarded, got %v", functions)
+	}
+}
+
+func TestProxy_WritePermission(t *testing.T) {
+	tests := map[string]struct {
+		opts  []ProxyOption
+		allow bool
+	}{
+		"denied by default": {},
+		"allowed network": {
+			opts:  []ProxyOption{WithWritePermission(mustAllowNetworks(t, "127.0.0.0/8"))},
+			allow: true,
+		},
+		"other network": {
+			opts: []ProxyOption{WithWritePermission(mustAllowNetworks(t, "192.168.1.10"))},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			mockbus := NewMockbus(10)
+			addr, closeProxy := startProxy(t, mockbus, test.opts...)
+			defer closeProxy()
+
+			c, err := Connect(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			err = c.WriteFrom(1, uint16(5))
+			if test.allow {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if v, _ := mockbus.ReadHoldingRegistersUint(1, 1); v[0] != 5 {
+					t.Fatalf("expected written register, got %v", v[0])
+				}
+				return
+			}
+
+			var exception *ExceptionError
+			if !errors.As(err, &exception) || exception.Code != ExceptionIllegalFunction {
+				t.Fatalf("expected illegal function exception, got %v", err)
+			}
+			if v, _ := mockbus.ReadHoldingRegistersUint(1, 1); v[0] != 0 {
+				t.Fatalf("expected register not to be written, got %v", v[0])
+			}
+		})
+	}
+}
+
+func mustAllowNetworks(t *testing.T, networks ...string) WritePermission {
+	w, err := AllowNetworks(networks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestAllowNetworks(t *testing.T) {
+	tests := map[string]struct {
+		networks []string
+		client   string
+		allow    bool
+		err      bool
+	}{
+		"ip":           {networks: []string{"192.168.1.10"}, client: "192.168.1.10:50000", allow: true},
+		"other ip":     {networks: []string{"192.168.1.10"}, client: "192.168.1.11:50000"},
+		"cidr":         {networks: []string{"10.0.0.0/8", "192.168.1.0/24"}, client: "192.168.1.11:50000", allow: true},
+		"ipv6":         {networks: []string{"fe80::/64"}, client: "[fe80::1]:50000", allow: true},
+		"invalid ip":   {networks: []string{"192.168.1"}, err: true},
+		"invalid cidr": {networks: []string{"192.168.1.0/33"}, err: true},
+		"no networks":  {client: "192.168.1.10:50000"},
+		"ipv4 in ipv6": {networks: []string{"192.168.1.0/24"}, client: "[::ffff:192.168.1.10]:50000", allow: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			w, err := AllowNetworks(test.networks...)
+			if test.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client, err := net.ResolveTCPAddr("tcp", test.client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allow := w(client, 1, 0, 1); allow != test.allow {
+				t.Fatalf("expected %v, got %v", test.allow, allow)
+			}
+		})
+	}
+}
+
+func TestProxy_Queue(t *testing.T) {
+	upstream := newCountingHandler(NewMockbus(10))
+	upstream.release = make(chan struct{})
+	addr, closeProxy := startProxy(t, upstream, WithQueueLength(1), WithMaxOutstanding(1))
+	defer closeProxy()
+
+	// the requests are sent over separate connections, as the server answers the requests of a connection in order
+	read := func() error {
+		conn := newReconnectingConn(addr, DefaultRetryPolicy, nil)
+		defer conn.Close()
+		_, err := conn.ReadHoldingRegisters(1, 0, 1)
+		return err
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- read()
+	}()
+	// the first request is forwarded
+	<-upstream.received
+
+	go func() {
+		errs <- read()
+	}()
+	// the second request is queued
+	time.Sleep(50 * time.Millisecond)
+
+	var exception *ExceptionError
+	if err := read(); !errors.As(err, &exception) || exception.Code != ExceptionServerDeviceBusy {
+		t.Fatalf("expected server device busy exception, got %v", err)
+	}
+
+	close(upstream.release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	upstream.mu.Lock()
+	defer upstream.mu.Unlock()
+	if upstream.maxActive != 1 {
+		t.Fatalf("expected a single outstanding request, got %v", upstream.maxActive)
+	}
+}
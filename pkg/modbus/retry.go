@@ -0,0 +1,118 @@
+package modbus
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrDeviceUnreachable is matched by the errors returned when a device is unreachable after the attempts
+// of the retry policy, see UnreachableError.
+var ErrDeviceUnreachable = errors.New("device unreachable")
+
+// UnreachableError is returned when the attempts of the retry policy are exhausted.
+type UnreachableError struct {
+	Address  string
+	Attempts int
+	// Err is the error of the last attempt.
+	Err error
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("%v: %v after %v attempts: %v", ErrDeviceUnreachable, e.Address, e.Attempts, e.Err)
+}
+
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the target is ErrDeviceUnreachable.
+func (e *UnreachableError) Is(target error) bool {
+	return target == ErrDeviceUnreachable
+}
+
+// RetryPolicy configures connecting to a device and retrying requests after connection errors.
+//
+// An attempt runs a request, reconnecting first with a single dial if the connection was dropped. Failed attempts
+// are retried after a backoff until MaxAttempts attempts failed. Connect makes its attempts without a request.
+//
+// Zero values of InitialBackoff, MaxBackoff, Multiplier, Timeout and IdleTimeout are replaced by the values
+// of DefaultRetryPolicy.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, unlimited if zero.
+	MaxAttempts int
+	// InitialBackoff is the time waited after the first failed attempt, it is multiplied by Multiplier after each
+	// further attempt up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the maximum random duration added to each backoff, spreading the reconnects of many clients.
+	Jitter time.Duration
+	// Timeout is the timeout for connecting and for the response to a request.
+	Timeout time.Duration
+	// IdleTimeout is the time after which an idle connection is closed, it is reopened by the next request.
+	IdleTimeout time.Duration
+	// Retryable reports whether an error of a request requires reconnecting and retrying the request, failures to
+	// connect are always retried. By default, timeouts, broken pipes, reset and closed connections are retried.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy retries forever, backing off from 1s up to 10s.
+var DefaultRetryPolicy = RetryPolicy{
+	InitialBackoff: 1 * time.Second,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     1.7,
+	Timeout:        20 * time.Second,
+	IdleTimeout:    24 * time.Hour,
+}
+
+// withDefaults returns the policy with zero values replaced by the defaults.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultRetryPolicy.MaxBackoff
+	}
+	if p.Multiplier <= 0 {
+		p.Multiplier = DefaultRetryPolicy.Multiplier
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = DefaultRetryPolicy.Timeout
+	}
+	if p.IdleTimeout <= 0 {
+		p.IdleTimeout = DefaultRetryPolicy.IdleTimeout
+	}
+	if p.Retryable == nil {
+		p.Retryable = isConnectionError
+	}
+	return p
+}
+
+// Backoff returns the time to wait after the failed attempt, starting at 1.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	p = p.withDefaults()
+
+	backoff := math.Min(float64(p.InitialBackoff)*math.Pow(p.Multiplier, float64(attempt-1)), float64(p.MaxBackoff))
+	if p.Jitter > 0 {
+		backoff += float64(rand.Int63n(int64(p.Jitter)))
+	}
+	return time.Duration(backoff)
+}
+
+// exhausted returns true if no attempt is left after the given number of attempts.
+func (p RetryPolicy) exhausted(attempts int) bool {
+	return p.MaxAttempts > 0 && attempts >= p.MaxAttempts
+}
+
+// isConnectionError returns true if the error requires reconnecting.
+func isConnectionError(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed)
+}
@@ -0,0 +1,230 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	tests := map[string]struct {
+		policy   RetryPolicy
+		attempt  int
+		min, max time.Duration
+	}{
+		"first":        {policy: DefaultRetryPolicy, attempt: 1, min: time.Second, max: time.Second},
+		"second":       {policy: DefaultRetryPolicy, attempt: 2, min: 1700 * time.Millisecond, max: 1700 * time.Millisecond},
+		"capped":       {policy: DefaultRetryPolicy, attempt: 10, min: 10 * time.Second, max: 10 * time.Second},
+		"zero policy":  {policy: RetryPolicy{}, attempt: 1, min: time.Second, max: time.Second},
+		"custom curve": {policy: RetryPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 2}, attempt: 3, min: 400 * time.Millisecond, max: 400 * time.Millisecond},
+		"jitter":       {policy: RetryPolicy{Jitter: 100 * time.Millisecond}, attempt: 1, min: time.Second, max: 1100 * time.Millisecond},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				if b := test.policy.Backoff(test.attempt); b < test.min || b > test.max {
+					t.Fatalf("expected backoff between %v and %v, got %v", test.min, test.max, b)
+				}
+			}
+		})
+	}
+}
+
+func TestConnect_Unreachable(t *testing.T) {
+	addr := "localhost" + getFreeAddr()
+
+	_, err := Connect(addr, WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: 10 * time.Millisecond}))
+	if !errors.Is(err, ErrDeviceUnreachable) {
+		t.Fatalf("expected ErrDeviceUnreachable, got %v", err)
+	}
+
+	var unreachable *UnreachableError
+	if !errors.As(err, &unreachable) || unreachable.Attempts != 2 || unreachable.Address != addr {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// failingReader fails all requests with the error.
+type failingReader struct {
+	err      error
+	requests int
+}
+
+func (r *failingReader) ReadHoldingRegisters(unitID byte, address, quantity uint16) ([]byte, error) {
+	r.requests++
+	return nil, r.err
+}
+
+func (r *failingReader) WriteMultipleRegisters(unitID byte, address, quantity uint16, value []byte) error {
+	r.requests++
+	return r.err
+}
+
+func (r *failingReader) WriteSingleRegister(unitID byte, address, value uint16) error {
+	r.requests++
+	return r.err
+}
+
+func TestClient_Retry(t *testing.T) {
+	// accept the reconnects of the client
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	errUnsupported := errors.New("unsupported")
+	tests := map[string]struct {
+		policy      RetryPolicy
+		err         error
+		requests    int
+		unreachable bool
+	}{
+		"retryable": {
+			policy:      RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			err:         io.EOF,
+			requests:    3,
+			unreachable: true,
+		},
+		"not retryable": {
+			policy:   RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			err:      errUnsupported,
+			requests: 1,
+		},
+		"custom retryable": {
+			policy: RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, Retryable: func(err error) bool {
+				return errors.Is(err, errUnsupported)
+			}},
+			err:         errUnsupported,
+			requests:    2,
+			unreachable: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, err := Connect(l.Addr().String(), WithRetryPolicy(test.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			r := &failingReader{err: test.err}
+			c.client = r
+
+			_, err = c.ReadUint16(40000)
+			if !errors.Is(err, test.err) || errors.Is(err, ErrDeviceUnreachable) != test.unreachable {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if r.requests != test.requests {
+				t.Fatalf("expected %v requests, got %v", test.requests, r.requests)
+			}
+
+			r.requests = 0
+			if err := c.WriteFrom(40000, uint16(1)); !errors.Is(err, test.err) || r.requests != test.requests {
+				t.Fatalf("unexpected error %v after %v requests", err, r.requests)
+			}
+		})
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	tests := map[string]struct {
+		err  error
+		want bool
+	}{
+		"timeout":    {err: os.ErrDeadlineExceeded, want: true},
+		"pipe":       {err: &net.OpError{Op: "write", Err: syscall.EPIPE}, want: true},
+		"reset":      {err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, want: true},
+		"eof":        {err: io.EOF, want: true},
+		"closed":     {err: fmt.Errorf("read: %w", net.ErrClosed), want: true},
+		"exception":  {err: &ExceptionError{Code: ExceptionIllegalDataAddress}},
+		"unexpected": {err: errors.New("unexpected")},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := isConnectionError(test.err); got != test.want {
+				t.Fatalf("want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestClient_RetryBackoff(t *testing.T) {
+	server, err := newTestServer(getFreeAddr(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	c, err := Connect(server.Addr().String(), WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: 50 * time.Millisecond, Multiplier: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	r := &failingReader{err: io.EOF}
+	c.client = r
+
+	// the attempts of the policy are made once, backing off 50ms and 100ms
+	start := time.Now()
+	if _, err := c.ReadUint16(40000); !errors.Is(err, ErrDeviceUnreachable) {
+		t.Fatalf("expected ErrDeviceUnreachable, got %v", err)
+	}
+	if r.requests != 3 {
+		t.Fatalf("expected 3 requests, got %v", r.requests)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected backoff between requests, took %v", elapsed)
+	}
+}
+
+func TestClient_CloseCancelsRetry(t *testing.T) {
+	server, err := newTestServer(getFreeAddr(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	c, err := Connect(server.Addr().String(), WithRetryPolicy(RetryPolicy{InitialBackoff: time.Hour}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.client = &failingReader{err: io.EOF}
+
+	read := make(chan error)
+	go func() {
+		_, err := c.ReadUint16(40000)
+		read <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-read:
+		if !errors.Is(err, io.EOF) {
+			t.Fatalf("expected the error of the request, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("retrying not cancelled")
+	}
+}
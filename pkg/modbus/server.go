@@ -0,0 +1,310 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"github.com/orlopau/go-energy/pkg/logger"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after the server was closed.
+var ErrServerClosed = errors.New("modbus: server closed")
+
+// Request is a request received by a Server.
+type Request struct {
+	// RemoteAddr is the address of the client which sent the request.
+	RemoteAddr net.Addr
+	UnitID     byte
+	Function   byte
+	// Data is the request following the function code.
+	Data []byte
+}
+
+// Handler answers the requests of a Server.
+//
+// ServeModbus returns the data of the response following the function code. An *ExceptionError is answered with
+// its exception code, other errors with a server device failure. Handlers are called concurrently for requests of
+// different clients.
+type Handler interface {
+	ServeModbus(r Request) ([]byte, error)
+}
+
+// HandlerFunc is a function used as Handler.
+type HandlerFunc func(r Request) ([]byte, error)
+
+func (f HandlerFunc) ServeModbus(r Request) ([]byte, error) {
+	return f(r)
+}
+
+// UnitMux dispatches requests to the handler of their unit id, requests of other units are answered with a
+// gateway path unavailable exception.
+type UnitMux map[byte]Handler
+
+func (m UnitMux) ServeModbus(r Request) ([]byte, error) {
+	h, ok := m[r.UnitID]
+	if !ok {
+		return nil, &ExceptionError{Function: r.Function, Code: ExceptionGatewayPathUnavailable}
+	}
+	return h.ServeModbus(r)
+}
+
+// Registers are holding registers served by a RegisterHandler.
+type Registers interface {
+	// ReadHoldingRegisters returns quantity*2 bytes of the registers starting at the address.
+	ReadHoldingRegisters(address, quantity uint16) ([]byte, error)
+	// WriteHoldingRegisters writes the registers starting at the address.
+	WriteHoldingRegisters(address uint16, value []byte) error
+}
+
+// RegisterHandler serves reading and writing holding registers, other function codes are answered with an
+// illegal function exception.
+type RegisterHandler struct {
+	Registers Registers
+}
+
+func (h RegisterHandler) ServeModbus(r Request) ([]byte, error) {
+	switch r.Function {
+	case FuncReadHoldingRegisters:
+		if len(r.Data) != 4 {
+			return nil, &ExceptionError{Function: r.Function, Code: ExceptionIllegalDataValue}
+		}
+		address, quantity := binary.BigEndian.Uint16(r.Data), binary.BigEndian.Uint16(r.Data[2:])
+		if err := checkRange(r.Function, address, quantity, maxReadQuantity); err != nil {
+			return nil, err
+		}
+
+		registers, err := h.Registers.ReadHoldingRegisters(address, quantity)
+		if err != nil {
+			return nil, err
+		}
+		if len(registers) != int(quantity)*2 {
+			return nil, errors.Errorf("modbus: read %v bytes of %v registers", len(registers), quantity)
+		}
+		return append([]byte{byte(len(registers))}, registers...), nil
+	case FuncWriteSingleRegister:
+		if len(r.Data) != 4 {
+			return nil, &ExceptionError{Function: r.Function, Code: ExceptionIllegalDataValue}
+		}
+		if err := h.Registers.WriteHoldingRegisters(binary.BigEndian.Uint16(r.Data), r.Data[2:]); err != nil {
+			return nil, err
+		}
+		return r.Data, nil
+	case FuncWriteMultipleRegisters:
+		if len(r.Data) < 5 {
+			return nil, &ExceptionError{Function: r.Function, Code: ExceptionIllegalDataValue}
+		}
+		address, quantity := binary.BigEndian.Uint16(r.Data), binary.BigEndian.Uint16(r.Data[2:])
+		if err := checkRange(r.Function, address, quantity, maxWriteQuantity); err != nil {
+			return nil, err
+		}
+		if int(r.Data[4]) != int(quantity)*2 || len(r.Data) != 5+int(quantity)*2 {
+			return nil, &ExceptionError{Function: r.Function, Code: ExceptionIllegalDataValue}
+		}
+
+		if err := h.Registers.WriteHoldingRegisters(address, r.Data[5:]); err != nil {
+			return nil, err
+		}
+		return r.Data[:4], nil
+	default:
+		return nil, &ExceptionError{Function: r.Function, Code: ExceptionIllegalFunction}
+	}
+}
+
+// checkRange returns an exception if the quantity is invalid or the registers exceed the address space.
+func checkRange(function byte, address, quantity, maxQuantity uint16) error {
+	if quantity < 1 || quantity > maxQuantity {
+		return &ExceptionError{Function: function, Code: ExceptionIllegalDataValue}
+	}
+	if int(address)+int(quantity) > 1<<16 {
+		return &ExceptionError{Function: function, Code: ExceptionIllegalDataAddress}
+	}
+	return nil
+}
+
+// Server is a modbus tcp server answering requests with a Handler.
+//
+// The requests of a client are answered in order, clients are served concurrently.
+type Server struct {
+	handler     Handler
+	log         logger.Logger
+	idleTimeout time.Duration
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// ServerOption configures a Server.
+type ServerOption func(*Server)
+
+// WithServerLogger sets the logger of the server, nothing is logged by default.
+func WithServerLogger(l logger.Logger) ServerOption {
+	return func(s *Server) {
+		s.log = logger.OrDiscard(l)
+	}
+}
+
+// WithIdleTimeout closes client connections without requests for the duration, they are kept open by default.
+func WithIdleTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.idleTimeout = d
+	}
+}
+
+// NewServer creates a server answering requests with the handler.
+func NewServer(h Handler, opts ...ServerOption) *Server {
+	s := &Server{
+		handler:   h,
+		log:       logger.Discard,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// ListenAndServe listens on the tcp address and serves the connections until the server is closed.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves the connections of the listener until the server is closed, it always returns a non-nil error.
+// The listener is closed by closing the server.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	s.log.Info("modbus server listening", "address", l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn answers the requests of the connection until it is closed.
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		_ = conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	s.log.Debug("modbus client connected", "client", conn.RemoteAddr())
+	for {
+		if s.idleTimeout > 0 {
+			if err := conn.SetReadDeadline(time.Now().Add(s.idleTimeout)); err != nil {
+				return
+			}
+		}
+
+		request, err := readFrame(conn)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				s.log.Debug("modbus client disconnected", "client", conn.RemoteAddr())
+			} else {
+				s.log.Debug("closing modbus client connection", "client", conn.RemoteAddr(), "error", err)
+			}
+			return
+		}
+
+		response := s.serve(conn.RemoteAddr(), request)
+		if _, err := conn.Write(response.encode()); err != nil {
+			s.log.Debug("couldn't write modbus response", "client", conn.RemoteAddr(), "error", err)
+			return
+		}
+	}
+}
+
+// serve returns the response to the request.
+func (s *Server) serve(remote net.Addr, request frame) frame {
+	response := frame{transactionID: request.transactionID, unitID: request.unitID, function: request.function}
+
+	data, err := s.handler.ServeModbus(Request{
+		RemoteAddr: remote,
+		UnitID:     request.unitID,
+		Function:   request.function,
+		Data:       request.data,
+	})
+	if err == nil {
+		response.data = data
+		return response
+	}
+
+	code := ExceptionServerDeviceFailure
+	var exception *ExceptionError
+	if errors.As(err, &exception) {
+		code = exception.Code
+	} else {
+		s.log.Warn("couldn't handle modbus request", "client", remote, "unit", request.unitID,
+			"function", request.function, "error", err)
+	}
+
+	response.function |= exceptionFlag
+	response.data = []byte{byte(code)}
+	return response
+}
+
+// Close closes the listeners and client connections and waits until the connections are served.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.listeners, l)
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
@@ -0,0 +1,176 @@
+package modbus
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// startServer serves the handler on a free local port.
+func startServer(t *testing.T, h Handler, opts ...ServerOption) (*Server, string) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(h, opts...)
+	go func() {
+		_ = s.Serve(l)
+	}()
+	return s, l.Addr().String()
+}
+
+func TestServer_Mockbus(t *testing.T) {
+	mockbus := NewMockbus(100)
+	if err := mockbus.AddHoldingRegisterEntry(10, uint32(0x53756e53)); err != nil {
+		t.Fatal(err)
+	}
+
+	server, addr := startServer(t, mockbus)
+	defer server.Close()
+
+	c, err := Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if v, err := c.ReadUint32(10); err != nil || v != 0x53756e53 {
+		t.Fatalf("expected %v, got %v %v", 0x53756e53, v, err)
+	}
+
+	if err := c.WriteFrom(20, []uint16{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	var values [3]uint16
+	if err := c.ReadInto(20, &values); err != nil || values != [3]uint16{1, 2, 3} {
+		t.Fatalf("expected written registers, got %v %v", values, err)
+	}
+
+	var exception *ExceptionError
+	if _, err := c.ReadUint32(99); !errors.As(err, &exception) || exception.Code != ExceptionIllegalDataAddress {
+		t.Fatalf("expected illegal data address exception, got %v", err)
+	}
+}
+
+func TestServer_Exceptions(t *testing.T) {
+	errFailure := errors.New("failure")
+	mux := UnitMux{
+		1: NewMockbus(10),
+		2: HandlerFunc(func(r Request) ([]byte, error) {
+			return nil, errFailure
+		}),
+	}
+
+	log := &testLogger{}
+	server, addr := startServer(t, mux, WithServerLogger(log))
+	defer server.Close()
+
+	conn := newReconnectingConn(addr, DefaultRetryPolicy, nil)
+	defer conn.Close()
+
+	tests := map[string]struct {
+		unitID   byte
+		function byte
+		data     []byte
+		code     ExceptionCode
+	}{
+		"illegal function":       {unitID: 1, function: 0x2b, data: []byte{0x0e}, code: ExceptionIllegalFunction},
+		"zero quantity":          {unitID: 1, function: FuncReadHoldingRegisters, data: []byte{0, 0, 0, 0}, code: ExceptionIllegalDataValue},
+		"quantity too large":     {unitID: 1, function: FuncReadHoldingRegisters, data: []byte{0, 0, 0, 126}, code: ExceptionIllegalDataValue},
+		"beyond address space":   {unitID: 1, function: FuncReadHoldingRegisters, data: []byte{0xff, 0xff, 0, 2}, code: ExceptionIllegalDataAddress},
+		"byte count mismatch":    {unitID: 1, function: FuncWriteMultipleRegisters, data: []byte{0, 0, 0, 1, 4, 0, 1}, code: ExceptionIllegalDataValue},
+		"unknown unit":           {unitID: 3, function: FuncReadHoldingRegisters, data: []byte{0, 0, 0, 1}, code: ExceptionGatewayPathUnavailable},
+		"handler error":          {unitID: 2, function: FuncReadHoldingRegisters, data: []byte{0, 0, 0, 1}, code: ExceptionServerDeviceFailure},
+		"write beyond registers": {unitID: 1, function: FuncWriteSingleRegister, data: []byte{0, 20, 0, 1}, code: ExceptionIllegalDataAddress},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := conn.transaction(test.unitID, test.function, test.data)
+			var exception *ExceptionError
+			if !errors.As(err, &exception) || exception.Code != test.code || exception.Function != test.function {
+				t.Fatalf("expected %v exception, got %v", test.code, err)
+			}
+		})
+	}
+
+	if len(log.messages) == 0 {
+		t.Fatal("expected the handler error to be logged")
+	}
+}
+
+func TestServer_WriteSingleRegister(t *testing.T) {
+	mockbus := NewMockbus(10)
+	server, addr := startServer(t, mockbus)
+	defer server.Close()
+
+	conn := newReconnectingConn(addr, DefaultRetryPolicy, nil)
+	defer conn.Close()
+
+	if _, err := conn.transaction(1, FuncWriteSingleRegister, []byte{0, 5, 0x12, 0x34}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := mockbus.ReadHoldingRegistersUint(5, 1); err != nil || v[0] != 0x1234 {
+		t.Fatalf("expected written register, got %v %v", v, err)
+	}
+}
+
+func TestServer_Close(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer(NewMockbus(10))
+	served := make(chan error)
+	go func() {
+		served <- server.Serve(l)
+	}()
+
+	conn := newReconnectingConn(l.Addr().String(), RetryPolicy{MaxAttempts: 1}, nil)
+	defer conn.Close()
+	if _, err := conn.ReadHoldingRegisters(0, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Fatalf("expected ErrServerClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("serving not stopped")
+	}
+
+	// the client connection was closed by the server
+	if _, err := conn.ReadHoldingRegisters(0, 0, 1); err == nil {
+		t.Fatal("expected error after closing the server")
+	}
+	if err := server.Serve(l); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("expected ErrServerClosed, got %v", err)
+	}
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	server, addr := startServer(t, NewMockbus(10), WithIdleTimeout(20*time.Millisecond))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the idle connection to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,398 @@
+package mqtt
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// localBuffer is the number of messages buffered for an in-process subscription.
+const localBuffer = 256
+
+// session is a client connected to a Broker.
+type session struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+	// filters are the topic filters subscribed by the client, guarded by the broker's mutex.
+	filters []string
+}
+
+func (s *session) write(p packet) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.conn.SetWriteDeadline(time.Now().Add(defaultTimeout)); err != nil {
+		return err
+	}
+	return writePacket(s.conn, p)
+}
+
+func (s *session) matches(topic string) bool {
+	for _, f := range s.filters {
+		if matchTopic(f, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// localSubscription is an in-process subscription of a Broker.
+type localSubscription struct {
+	filter   string
+	messages chan Message
+}
+
+// Broker is a minimal in-process MQTT 3.1.1 broker, e.g. for testing publishers without an external broker.
+//
+// Messages are delivered to subscribers with QoS 0, retained messages are kept in memory.
+// Authentication, persistent sessions and will messages are not supported.
+type Broker struct {
+	mu        sync.Mutex
+	sessions  map[*session]struct{}
+	local     map[*localSubscription]struct{}
+	retained  map[string]Message
+	listeners map[net.Listener]struct{}
+	closed    bool
+
+	wg sync.WaitGroup
+}
+
+// NewBroker creates a broker without listeners.
+func NewBroker() *Broker {
+	return &Broker{
+		sessions:  make(map[*session]struct{}),
+		local:     make(map[*localSubscription]struct{}),
+		retained:  make(map[string]Message),
+		listeners: make(map[net.Listener]struct{}),
+	}
+}
+
+// Listen listens on the tcp address and serves clients in the background until the broker is closed.
+//
+// Returns the address of the listener, e.g. to pass port 0 for a free port.
+func (b *Broker) Listen(addr string) (net.Addr, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		_ = b.Serve(l)
+	}()
+
+	return l.Addr(), nil
+}
+
+// Serve accepts clients on the listener until the listener or the broker is closed.
+func (b *Broker) Serve(l net.Listener) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	b.listeners[l] = struct{}{}
+	b.wg.Add(1)
+	b.mu.Unlock()
+	defer b.wg.Done()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			b.mu.Lock()
+			delete(b.listeners, l)
+			closed := b.closed
+			b.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			return err
+		}
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			_ = b.handle(conn)
+		}()
+	}
+}
+
+// handle serves a client until it disconnects.
+func (b *Broker) handle(conn net.Conn) error {
+	defer conn.Close()
+
+	s := &session{conn: conn}
+	r := bufio.NewReader(conn)
+
+	if err := conn.SetReadDeadline(time.Now().Add(defaultTimeout)); err != nil {
+		return err
+	}
+	keepAlive, err := b.accept(s, r)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	b.sessions[s] = struct{}{}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.sessions, s)
+		b.mu.Unlock()
+	}()
+
+	for {
+		// the client must send a packet within one and a half times the keep alive
+		deadline := time.Time{}
+		if keepAlive > 0 {
+			deadline = time.Now().Add(keepAlive * 3 / 2)
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+
+		p, err := readPacket(r)
+		if err != nil {
+			return err
+		}
+
+		switch p.typ {
+		case packetPublish:
+			m, id, err := parsePublish(p)
+			if err != nil {
+				return err
+			}
+			if m.QoS == 1 {
+				if err := s.write(packet{typ: packetPuback, body: appendUint16(nil, id)}); err != nil {
+					return err
+				}
+			}
+			b.Publish(m)
+		case packetSubscribe:
+			if err := b.subscribe(s, p); err != nil {
+				return err
+			}
+		case packetUnsubscribe:
+			if err := b.unsubscribe(s, p); err != nil {
+				return err
+			}
+		case packetPingreq:
+			if err := s.write(packet{typ: packetPingresp}); err != nil {
+				return err
+			}
+		case packetDisconnect:
+			return nil
+		case packetPuback:
+			// messages are delivered with QoS 0, acknowledgements are ignored
+		default:
+			return fmt.Errorf("%w: unexpected packet type %d", ErrMalformedPacket, p.typ)
+		}
+	}
+}
+
+// accept reads the CONNECT packet of the client and acknowledges it, returning the keep alive of the client.
+func (b *Broker) accept(s *session, r *bufio.Reader) (time.Duration, error) {
+	p, err := readPacket(r)
+	if err != nil {
+		return 0, err
+	}
+	if p.typ != packetConnect {
+		return 0, fmt.Errorf("%w: expected CONNECT, got packet type %d", ErrMalformedPacket, p.typ)
+	}
+
+	d := decoder{b: p.body}
+	name := d.string()
+	level := d.byte()
+	_ = d.byte()
+	keepAlive := time.Duration(d.uint16()) * time.Second
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	if name != protocolName || level != protocolLevel {
+		_ = s.write(packet{typ: packetConnack, body: []byte{0, 1}})
+		return 0, fmt.Errorf("%w: unsupported protocol %v level %d", ErrMalformedPacket, name, level)
+	}
+
+	return keepAlive, s.write(packet{typ: packetConnack, body: []byte{0, 0}})
+}
+
+func (b *Broker) subscribe(s *session, p packet) error {
+	d := decoder{b: p.body}
+	id := d.uint16()
+	var filters []string
+	for d.err == nil && len(d.b) > 0 {
+		filters = append(filters, d.string())
+		_ = d.byte()
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(filters) == 0 {
+		return fmt.Errorf("%w: subscribe without topic filters", ErrMalformedPacket)
+	}
+
+	b.mu.Lock()
+	s.filters = append(s.filters, filters...)
+	var retained []Message
+	for _, m := range b.retained {
+		for _, f := range filters {
+			if matchTopic(f, m.Topic) {
+				retained = append(retained, m)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	// all subscriptions are granted with QoS 0
+	body := appendUint16(nil, id)
+	body = append(body, make([]byte, len(filters))...)
+	if err := s.write(packet{typ: packetSuback, body: body}); err != nil {
+		return err
+	}
+
+	for _, m := range retained {
+		if err := s.write(publishPacket(Message{Topic: m.Topic, Payload: m.Payload, Retain: true}, 0)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *Broker) unsubscribe(s *session, p packet) error {
+	d := decoder{b: p.body}
+	id := d.uint16()
+	var filters []string
+	for d.err == nil && len(d.b) > 0 {
+		filters = append(filters, d.string())
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	b.mu.Lock()
+	remaining := s.filters[:0]
+	for _, f := range s.filters {
+		keep := true
+		for _, u := range filters {
+			if f == u {
+				keep = false
+			}
+		}
+		if keep {
+			remaining = append(remaining, f)
+		}
+	}
+	s.filters = remaining
+	b.mu.Unlock()
+
+	return s.write(packet{typ: packetUnsuback, body: appendUint16(nil, id)})
+}
+
+// Publish delivers the message to the subscribers, storing it if it is retained.
+//
+// A retained message with an empty payload removes the retained message of the topic.
+func (b *Broker) Publish(m Message) {
+	b.mu.Lock()
+	if m.Retain {
+		if len(m.Payload) == 0 {
+			delete(b.retained, m.Topic)
+		} else {
+			b.retained[m.Topic] = m
+		}
+	}
+
+	var sessions []*session
+	for s := range b.sessions {
+		if s.matches(m.Topic) {
+			sessions = append(sessions, s)
+		}
+	}
+	for l := range b.local {
+		if matchTopic(l.filter, m.Topic) {
+			select {
+			case l.messages <- m:
+			default:
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	// the retain flag is only set for retained messages sent on subscription
+	delivered := publishPacket(Message{Topic: m.Topic, Payload: m.Payload}, 0)
+	for _, s := range sessions {
+		if err := s.write(delivered); err != nil {
+			_ = s.conn.Close()
+		}
+	}
+}
+
+// Retained returns the retained message of the topic.
+func (b *Broker) Retained(topic string) (Message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m, ok := b.retained[topic]
+	return m, ok
+}
+
+// Subscribe subscribes in-process to the topic filter, returning the channel of messages and a function
+// canceling the subscription.
+//
+// Retained messages matching the filter are sent first. Messages are dropped if the buffer of the channel is full.
+func (b *Broker) Subscribe(filter string) (<-chan Message, func()) {
+	l := &localSubscription{filter: filter, messages: make(chan Message, localBuffer)}
+
+	b.mu.Lock()
+	for _, m := range b.retained {
+		if matchTopic(filter, m.Topic) {
+			select {
+			case l.messages <- m:
+			default:
+			}
+		}
+	}
+	b.local[l] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return l.messages, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.local, l)
+			b.mu.Unlock()
+			close(l.messages)
+		})
+	}
+}
+
+// Close closes all listeners and client connections.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+
+	var err error
+	for l := range b.listeners {
+		if lerr := l.Close(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	for s := range b.sessions {
+		_ = s.conn.Close()
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+	return err
+}
@@ -0,0 +1,395 @@
+package mqtt
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPort is the port of unencrypted MQTT connections.
+	DefaultPort = 1883
+
+	defaultKeepAlive = 30 * time.Second
+	defaultTimeout   = 10 * time.Second
+)
+
+var (
+	// ErrConnectionRefused is returned if the broker rejects the connection.
+	ErrConnectionRefused = errors.New("connection refused")
+	// ErrSubscriptionRejected is returned if the broker rejects a subscription.
+	ErrSubscriptionRejected = errors.New("subscription rejected")
+	// ErrClosed is returned when using a closed client or a client which lost its connection.
+	ErrClosed = errors.New("client closed")
+)
+
+// connackReasons are the reasons of the CONNACK return codes.
+var connackReasons = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// subscription is a topic filter and the handler of its messages.
+type subscription struct {
+	filter  string
+	handler func(Message)
+}
+
+// Client is a MQTT 3.1.1 client supporting QoS 0 and 1.
+//
+// The client starts a clean session and does not reconnect, a lost connection is reported by ErrClosed.
+type Client struct {
+	conn      net.Conn
+	clientID  string
+	username  string
+	password  string
+	keepAlive time.Duration
+	timeout   time.Duration
+
+	writeMu sync.Mutex
+
+	mu            sync.Mutex
+	nextID        uint16
+	pending       map[uint16]chan packet
+	subscriptions []subscription
+	err           error
+
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithClientID sets the client identifier, by default a random identifier is used.
+func WithClientID(id string) Option {
+	return func(c *Client) {
+		c.clientID = id
+	}
+}
+
+// WithCredentials sets the user name and password sent to the broker.
+func WithCredentials(username, password string) Option {
+	return func(c *Client) {
+		c.username, c.password = username, password
+	}
+}
+
+// WithKeepAlive sets the interval of keep alive pings, which is 30s by default.
+func WithKeepAlive(keepAlive time.Duration) Option {
+	return func(c *Client) {
+		c.keepAlive = keepAlive
+	}
+}
+
+// WithTimeout sets the timeout for connecting and for acknowledgements, which is 10s by default.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// Dial connects to the broker at the address, using DefaultPort if the address does not contain a port.
+func Dial(addr string, opts ...Option) (*Client, error) {
+	c := &Client{
+		keepAlive: defaultKeepAlive,
+		timeout:   defaultTimeout,
+		pending:   make(map[uint16]chan packet),
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.clientID == "" {
+		id := make([]byte, 6)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		c.clientID = "goenergy-" + hex.EncodeToString(id)
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, fmt.Sprint(DefaultPort))
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+
+	r := bufio.NewReader(conn)
+	if err := c.connect(r); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	go c.read(r)
+	if c.keepAlive > 0 {
+		go c.ping()
+	}
+
+	return c, nil
+}
+
+// connect sends the CONNECT packet and waits for the CONNACK.
+func (c *Client) connect(r *bufio.Reader) error {
+	flags := byte(flagCleanSession)
+	if c.username != "" {
+		flags |= flagUsername
+	}
+	if c.password != "" {
+		flags |= flagPassword
+	}
+
+	body := appendString(nil, protocolName)
+	body = append(body, protocolLevel, flags)
+	body = appendUint16(body, uint16(c.keepAlive/time.Second))
+	body = appendString(body, c.clientID)
+	if c.username != "" {
+		body = appendString(body, c.username)
+	}
+	if c.password != "" {
+		body = appendString(body, c.password)
+	}
+
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	if err := writePacket(c.conn, packet{typ: packetConnect, body: body}); err != nil {
+		return err
+	}
+
+	p, err := readPacket(r)
+	if err != nil {
+		return err
+	}
+	if p.typ != packetConnack || len(p.body) != 2 {
+		return fmt.Errorf("%w: expected CONNACK, got packet type %d", ErrMalformedPacket, p.typ)
+	}
+	if code := p.body[1]; code != 0 {
+		return fmt.Errorf("%w: %v", ErrConnectionRefused, connackReasons[code])
+	}
+
+	return c.conn.SetDeadline(time.Time{})
+}
+
+func (c *Client) write(p packet) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	return writePacket(c.conn, p)
+}
+
+// read handles the packets received from the broker until the connection is closed.
+func (c *Client) read(r *bufio.Reader) {
+	defer close(c.done)
+
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		switch p.typ {
+		case packetPublish:
+			m, id, err := parsePublish(p)
+			if err != nil {
+				c.fail(err)
+				return
+			}
+			if m.QoS == 1 {
+				if err := c.write(packet{typ: packetPuback, body: appendUint16(nil, id)}); err != nil {
+					c.fail(err)
+					return
+				}
+			}
+			c.dispatch(m)
+		case packetPuback, packetSuback, packetUnsuback:
+			d := decoder{b: p.body}
+			id := d.uint16()
+			c.mu.Lock()
+			ack, ok := c.pending[id]
+			delete(c.pending, id)
+			c.mu.Unlock()
+			if ok {
+				ack <- p
+			}
+		}
+	}
+}
+
+// fail stops the client after the connection failed.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.closing:
+		c.err = ErrClosed
+	default:
+		c.err = fmt.Errorf("%w: %v", ErrClosed, err)
+	}
+	_ = c.conn.Close()
+}
+
+// dispatch calls the handlers of the subscriptions matching the topic of the message.
+func (c *Client) dispatch(m Message) {
+	c.mu.Lock()
+	var handlers []func(Message)
+	for _, s := range c.subscriptions {
+		if matchTopic(s.filter, m.Topic) {
+			handlers = append(handlers, s.handler)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, h := range handlers {
+		h(m)
+	}
+}
+
+// ping sends keep alive pings until the client is closed.
+func (c *Client) ping() {
+	ticker := time.NewTicker(c.keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			_ = c.write(packet{typ: packetPingreq})
+		}
+	}
+}
+
+// request sends a packet with a new packet id and waits for its acknowledgement.
+func (c *Client) request(build func(id uint16) packet) (packet, error) {
+	ack := make(chan packet, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return packet{}, c.err
+	}
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID++
+	}
+	id := c.nextID
+	c.pending[id] = ack
+	c.mu.Unlock()
+
+	cancel := func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}
+
+	if err := c.write(build(id)); err != nil {
+		cancel()
+		return packet{}, err
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	select {
+	case p := <-ack:
+		return p, nil
+	case <-c.done:
+		cancel()
+		return packet{}, c.Err()
+	case <-timer.C:
+		cancel()
+		return packet{}, fmt.Errorf("no acknowledgement of packet %d within %v", id, c.timeout)
+	}
+}
+
+// Publish publishes the message, waiting for the acknowledgement of the broker for QoS 1.
+func (c *Client) Publish(m Message) error {
+	if m.QoS > 1 {
+		return fmt.Errorf("unsupported QoS %d", m.QoS)
+	}
+
+	if m.QoS == 0 {
+		if err := c.Err(); err != nil {
+			return err
+		}
+		return c.write(publishPacket(m, 0))
+	}
+
+	_, err := c.request(func(id uint16) packet { return publishPacket(m, id) })
+	return err
+}
+
+// Subscribe subscribes to the topic filter, calling the handler for each received message.
+//
+// Handlers are called sequentially by the receiving goroutine and must not block.
+func (c *Client) Subscribe(filter string, qos byte, handler func(Message)) error {
+	c.mu.Lock()
+	c.subscriptions = append(c.subscriptions, subscription{filter: filter, handler: handler})
+	c.mu.Unlock()
+
+	p, err := c.request(func(id uint16) packet {
+		body := appendUint16(nil, id)
+		body = appendString(body, filter)
+		body = append(body, qos)
+		return packet{typ: packetSubscribe, flags: 0x02, body: body}
+	})
+	if err == nil && (len(p.body) != 3 || p.body[2] == 0x80) {
+		err = fmt.Errorf("%w: %v", ErrSubscriptionRejected, filter)
+	}
+	if err != nil {
+		c.mu.Lock()
+		for i := len(c.subscriptions) - 1; i >= 0; i-- {
+			if c.subscriptions[i].filter == filter {
+				c.subscriptions = append(c.subscriptions[:i], c.subscriptions[i+1:]...)
+				break
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	return err
+}
+
+// Err returns the error which stopped the client, nil while it is connected.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// Close disconnects from the broker.
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closing)
+		if c.Err() == nil {
+			err = c.write(packet{typ: packetDisconnect})
+		}
+		if cerr := c.conn.Close(); cerr != nil && err == nil && c.Err() == nil {
+			err = cerr
+		}
+		<-c.done
+	})
+	return err
+}
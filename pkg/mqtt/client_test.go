@@ -0,0 +1,131 @@
+package mqtt
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func startBroker(t *testing.T) (*Broker, string) {
+	b := NewBroker()
+	addr, err := b.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	return b, addr.String()
+}
+
+func dial(t *testing.T, addr string, opts ...Option) *Client {
+	c, err := Dial(addr, append([]Option{WithTimeout(time.Second)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+func receive(t *testing.T, messages <-chan Message) Message {
+	select {
+	case m := <-messages:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+		return Message{}
+	}
+}
+
+func TestClient_Publish(t *testing.T) {
+	b, addr := startBroker(t)
+	c := dial(t, addr, WithClientID("test"), WithCredentials("user", "secret"))
+
+	messages, cancel := b.Subscribe("goenergy/#")
+	defer cancel()
+
+	if err := c.Publish(Message{Topic: "goenergy/meter/power", Payload: []byte("306.3"), QoS: 1, Retain: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Publish(Message{Topic: "goenergy/meter/voltage", Payload: []byte("230")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := receive(t, messages); m.Topic != "goenergy/meter/power" || string(m.Payload) != "306.3" {
+		t.Fatalf("unexpected message %+v", m)
+	}
+	if m := receive(t, messages); m.Topic != "goenergy/meter/voltage" {
+		t.Fatalf("unexpected message %+v", m)
+	}
+
+	if m, ok := b.Retained("goenergy/meter/power"); !ok || string(m.Payload) != "306.3" {
+		t.Fatalf("expected retained message, got %+v", m)
+	}
+	if _, ok := b.Retained("goenergy/meter/voltage"); ok {
+		t.Fatal("unexpected retained message")
+	}
+
+	// an empty retained message removes the retained message
+	if err := c.Publish(Message{Topic: "goenergy/meter/power", QoS: 1, Retain: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.Retained("goenergy/meter/power"); ok {
+		t.Fatal("expected removed retained message")
+	}
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	b, addr := startBroker(t)
+	b.Publish(Message{Topic: "goenergy/a", Payload: []byte("retained"), Retain: true})
+
+	c := dial(t, addr)
+	messages := make(chan Message, 10)
+	if err := c.Subscribe("goenergy/+", 1, func(m Message) { messages <- m }); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := receive(t, messages); m.Topic != "goenergy/a" || !m.Retain || string(m.Payload) != "retained" {
+		t.Fatalf("unexpected message %+v", m)
+	}
+
+	publisher := dial(t, addr)
+	if err := publisher.Publish(Message{Topic: "goenergy/b", Payload: []byte("live"), QoS: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Publish(Message{Topic: "other/b", Payload: []byte("other"), QoS: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := receive(t, messages); m.Topic != "goenergy/b" || m.Retain || string(m.Payload) != "live" {
+		t.Fatalf("unexpected message %+v", m)
+	}
+	select {
+	case m := <-messages:
+		t.Fatalf("unexpected message %+v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestClient_BrokerClosed(t *testing.T) {
+	b, addr := startBroker(t)
+	c := dial(t, addr)
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for c.Err() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("client did not notice the closed connection")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := c.Publish(Message{Topic: "a", QoS: 1}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
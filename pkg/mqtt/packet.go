@@ -0,0 +1,252 @@
+// Provides a minimal MQTT 3.1.1 client and broker, and a publisher for device values supporting
+// Home Assistant MQTT discovery.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// packet types of MQTT 3.1.1
+const (
+	packetConnect     byte = 1
+	packetConnack     byte = 2
+	packetPublish     byte = 3
+	packetPuback      byte = 4
+	packetSubscribe   byte = 8
+	packetSuback      byte = 9
+	packetUnsubscribe byte = 10
+	packetUnsuback    byte = 11
+	packetPingreq     byte = 12
+	packetPingresp    byte = 13
+	packetDisconnect  byte = 14
+)
+
+const (
+	protocolName  = "MQTT"
+	protocolLevel = 4
+
+	// maxRemainingLength is the largest length encodable in the four bytes of the remaining length.
+	maxRemainingLength = 268435455
+
+	flagUsername     = 0x80
+	flagPassword     = 0x40
+	flagCleanSession = 0x02
+
+	publishRetain = 0x01
+	publishQoS    = 0x06
+)
+
+var (
+	// ErrMalformedPacket is returned if a packet violates the MQTT protocol.
+	ErrMalformedPacket = errors.New("malformed packet")
+)
+
+// Message is an application message published to a topic.
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+// packet is a control packet consisting of the fixed header and the remaining bytes.
+type packet struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+// readPacket reads a control packet.
+func readPacket(r *bufio.Reader) (packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, fmt.Errorf("%w: remaining length exceeds four bytes", ErrMalformedPacket)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length += int(b&0x7f) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+
+	return packet{typ: header >> 4, flags: header & 0x0f, body: body}, nil
+}
+
+// writePacket writes a control packet.
+func writePacket(w io.Writer, p packet) error {
+	if len(p.body) > maxRemainingLength {
+		return fmt.Errorf("%w: packet of %d bytes is too large", ErrMalformedPacket, len(p.body))
+	}
+
+	b := make([]byte, 0, 5+len(p.body))
+	b = append(b, p.typ<<4|p.flags)
+	length := len(p.body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if length == 0 {
+			break
+		}
+	}
+	b = append(b, p.body...)
+
+	_, err := w.Write(b)
+	return err
+}
+
+// appendString appends a length prefixed UTF-8 string.
+func appendString(b []byte, s string) []byte {
+	b = appendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// decoder reads the fields of a packet body.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) uint16() uint16 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.b) < 2 {
+		d.err = fmt.Errorf("%w: truncated packet", ErrMalformedPacket)
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.b)
+	d.b = d.b[2:]
+	return v
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.b) < 1 {
+		d.err = fmt.Errorf("%w: truncated packet", ErrMalformedPacket)
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := int(d.uint16())
+	if d.err != nil {
+		return nil
+	}
+	if len(d.b) < n {
+		d.err = fmt.Errorf("%w: truncated packet", ErrMalformedPacket)
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// publishPacket encodes a PUBLISH packet, the packet id is only included for QoS 1.
+func publishPacket(m Message, id uint16) packet {
+	flags := m.QoS << 1
+	if m.Retain {
+		flags |= publishRetain
+	}
+
+	body := appendString(nil, m.Topic)
+	if m.QoS > 0 {
+		body = appendUint16(body, id)
+	}
+	body = append(body, m.Payload...)
+
+	return packet{typ: packetPublish, flags: flags, body: body}
+}
+
+// parsePublish decodes a PUBLISH packet and returns the message and packet id.
+func parsePublish(p packet) (Message, uint16, error) {
+	m := Message{QoS: (p.flags & publishQoS) >> 1, Retain: p.flags&publishRetain != 0}
+	if m.QoS > 1 {
+		return Message{}, 0, fmt.Errorf("%w: unsupported QoS %d", ErrMalformedPacket, m.QoS)
+	}
+
+	d := decoder{b: p.body}
+	m.Topic = d.string()
+	var id uint16
+	if m.QoS > 0 {
+		id = d.uint16()
+	}
+	if d.err != nil {
+		return Message{}, 0, d.err
+	}
+	m.Payload = append([]byte(nil), d.b...)
+
+	return m, id, nil
+}
+
+// matchTopic checks if the topic matches the filter, which may contain the wildcards + and #.
+//
+// Topics starting with $ are not matched by wildcards at the first level.
+func matchTopic(filter, topic string) bool {
+	if len(topic) > 0 && topic[0] == '$' && len(filter) > 0 && (filter[0] == '+' || filter[0] == '#') {
+		return false
+	}
+
+	for {
+		if filter == "#" {
+			return true
+		}
+
+		fi, ti := indexLevel(filter), indexLevel(topic)
+		fLevel, tLevel := filter[:fi], topic[:ti]
+		if fLevel != "+" && fLevel != tLevel {
+			return false
+		}
+
+		if fi == len(filter) || ti == len(topic) {
+			// a trailing /# also matches the parent level
+			return fi == len(filter) && ti == len(topic) || ti == len(topic) && filter[fi:] == "/#"
+		}
+		filter, topic = filter[fi+1:], topic[ti+1:]
+	}
+}
+
+// indexLevel returns the end of the first topic level.
+func indexLevel(s string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '/' {
+			return i
+		}
+	}
+	return len(s)
+}
@@ -0,0 +1,77 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMatchTopic(t *testing.T) {
+	tests := map[string]struct {
+		filter, topic string
+		want          bool
+	}{
+		"exact":                {"a/b", "a/b", true},
+		"different":            {"a/b", "a/c", false},
+		"single level":         {"a/+/c", "a/b/c", true},
+		"single level short":   {"a/+", "a/b/c", false},
+		"multi level":          {"a/#", "a/b/c", true},
+		"multi level parent":   {"a/#", "a", true},
+		"multi level all":      {"#", "a/b", true},
+		"longer filter":        {"a/b/c", "a/b", false},
+		"empty level":          {"a/+/c", "a//c", true},
+		"system topic":         {"#", "$SYS/uptime", false},
+		"system topic literal": {"$SYS/#", "$SYS/uptime", true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := matchTopic(test.filter, test.topic); got != test.want {
+				t.Fatalf("want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestPacket_RoundTrip(t *testing.T) {
+	for _, size := range []int{0, 127, 128, 16383, 16384, 2097152} {
+		body := bytes.Repeat([]byte{0xab}, size)
+
+		var buf bytes.Buffer
+		if err := writePacket(&buf, packet{typ: packetPublish, flags: 0x03, body: body}); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := readPacket(bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.typ != packetPublish || p.flags != 0x03 || !bytes.Equal(p.body, body) {
+			t.Fatalf("unexpected packet of size %v: type %v flags %v length %v", size, p.typ, p.flags, len(p.body))
+		}
+	}
+}
+
+func TestReadPacket_Malformed(t *testing.T) {
+	_, err := readPacket(bufio.NewReader(bytes.NewReader([]byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01})))
+	if !errors.Is(err, ErrMalformedPacket) {
+		t.Fatalf("expected ErrMalformedPacket, got %v", err)
+	}
+}
+
+func TestParsePublish(t *testing.T) {
+	m := Message{Topic: "a/b", Payload: []byte("42"), QoS: 1, Retain: true}
+
+	got, id, err := parsePublish(publishPacket(m, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 7 || got.Topic != m.Topic || string(got.Payload) != "42" || got.QoS != 1 || !got.Retain {
+		t.Fatalf("unexpected message %+v with id %v", got, id)
+	}
+
+	if _, _, err := parsePublish(packet{typ: packetPublish, body: []byte{0, 5, 'a'}}); !errors.Is(err, ErrMalformedPacket) {
+		t.Fatalf("expected ErrMalformedPacket, got %v", err)
+	}
+}
@@ -0,0 +1,409 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/meter"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+const (
+	// DefaultTopicTemplate is the template of the state topics, see TopicData for the available fields.
+	DefaultTopicTemplate = "goenergy/{{.Device}}/{{.Sensor}}"
+	// DefaultDiscoveryPrefix is the topic prefix of Home Assistant MQTT discovery.
+	DefaultDiscoveryPrefix = "homeassistant"
+)
+
+// Format is the payload format of published values.
+type Format int
+
+const (
+	// FormatPlain publishes the value as decimal number.
+	FormatPlain Format = iota
+	// FormatJSON publishes an object containing the value and unit.
+	FormatJSON
+)
+
+// DeviceInfo identifies the device of a sensor.
+type DeviceInfo struct {
+	// ID is the unique identifier of the device used in topics.
+	ID           string
+	Name         string
+	Manufacturer string
+	Model        string
+	SerialNumber string
+}
+
+// Sensor is a value of a device.
+type Sensor struct {
+	Device DeviceInfo
+	// ID identifies the sensor within the device, it is used in topics.
+	ID   string
+	Name string
+	Unit string
+	// DeviceClass and StateClass are the Home Assistant classes of the sensor, see SensorClasses.
+	DeviceClass string
+	StateClass  string
+	Value       float64
+}
+
+// TopicData contains the fields available in topic templates.
+type TopicData struct {
+	Device string
+	Sensor string
+	Serial string
+}
+
+// publisher publishes messages, e.g. a *Client.
+type publisher interface {
+	Publish(m Message) error
+}
+
+// Publisher publishes sensor values to topics and announces the sensors using Home Assistant MQTT discovery.
+type Publisher struct {
+	client          publisher
+	topicTemplate   string
+	topic           *template.Template
+	format          Format
+	retain          bool
+	qos             byte
+	discoveryPrefix string
+
+	mu        sync.Mutex
+	announced map[string]bool
+}
+
+// PublisherOption configures a Publisher.
+type PublisherOption func(*Publisher)
+
+// WithTopicTemplate sets the text/template of the state topics, executed with TopicData.
+func WithTopicTemplate(t string) PublisherOption {
+	return func(p *Publisher) {
+		p.topicTemplate = t
+	}
+}
+
+// WithFormat sets the payload format, FormatPlain by default.
+func WithFormat(f Format) PublisherOption {
+	return func(p *Publisher) {
+		p.format = f
+	}
+}
+
+// WithRetain sets whether state messages are retained, which they are by default.
+func WithRetain(retain bool) PublisherOption {
+	return func(p *Publisher) {
+		p.retain = retain
+	}
+}
+
+// WithQoS sets the QoS of published messages, 0 by default.
+func WithQoS(qos byte) PublisherOption {
+	return func(p *Publisher) {
+		p.qos = qos
+	}
+}
+
+// WithDiscoveryPrefix sets the topic prefix of Home Assistant discovery, an empty prefix disables discovery.
+func WithDiscoveryPrefix(prefix string) PublisherOption {
+	return func(p *Publisher) {
+		p.discoveryPrefix = prefix
+	}
+}
+
+// NewPublisher creates a publisher using the client.
+func NewPublisher(client *Client, opts ...PublisherOption) (*Publisher, error) {
+	return newPublisher(client, opts...)
+}
+
+func newPublisher(client publisher, opts ...PublisherOption) (*Publisher, error) {
+	p := &Publisher{
+		client:          client,
+		topicTemplate:   DefaultTopicTemplate,
+		retain:          true,
+		discoveryPrefix: DefaultDiscoveryPrefix,
+		announced:       make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	t, err := template.New("topic").Option("missingkey=error").Parse(p.topicTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("parsing topic template: %w", err)
+	}
+	p.topic = t
+
+	return p, nil
+}
+
+// Publish publishes the values of the sensors, announcing sensors published for the first time.
+func (p *Publisher) Publish(sensors ...Sensor) error {
+	for _, s := range sensors {
+		topic, err := p.stateTopic(s)
+		if err != nil {
+			return err
+		}
+
+		if err := p.announce(s, topic); err != nil {
+			return err
+		}
+
+		payload, err := p.payload(s)
+		if err != nil {
+			return err
+		}
+		if err := p.client.Publish(Message{Topic: topic, Payload: payload, QoS: p.qos, Retain: p.retain}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PublishTelegram publishes the values of an energy meter telegram.
+func (p *Publisher) PublishTelegram(t *meter.EnergyMeterTelegram) error {
+	return p.Publish(TelegramSensors(t)...)
+}
+
+func (p *Publisher) stateTopic(s Sensor) (string, error) {
+	var b strings.Builder
+	data := TopicData{Device: topicLevel(s.Device.ID), Sensor: topicLevel(s.ID), Serial: topicLevel(s.Device.SerialNumber)}
+	if err := p.topic.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("executing topic template: %w", err)
+	}
+	return b.String(), nil
+}
+
+func (p *Publisher) payload(s Sensor) ([]byte, error) {
+	if p.format == FormatJSON {
+		return json.Marshal(struct {
+			Value float64 `json:"value"`
+			Unit  string  `json:"unit,omitempty"`
+		}{s.Value, s.Unit})
+	}
+	return []byte(strconv.FormatFloat(s.Value, 'f', -1, 64)), nil
+}
+
+// discoveryDevice is the device of a Home Assistant discovery config.
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name,omitempty"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	SerialNumber string   `json:"serial_number,omitempty"`
+}
+
+// discoveryConfig is the Home Assistant discovery config of a sensor.
+type discoveryConfig struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	StateTopic        string          `json:"state_topic"`
+	UnitOfMeasurement string          `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+	StateClass        string          `json:"state_class,omitempty"`
+	ValueTemplate     string          `json:"value_template,omitempty"`
+	Device            discoveryDevice `json:"device"`
+}
+
+// announce publishes the retained discovery config of a sensor once.
+func (p *Publisher) announce(s Sensor, stateTopic string) error {
+	if p.discoveryPrefix == "" {
+		return nil
+	}
+
+	p.mu.Lock()
+	announced := p.announced[stateTopic]
+	p.mu.Unlock()
+	if announced {
+		return nil
+	}
+
+	deviceID, sensorID := topicLevel(s.Device.ID), topicLevel(s.ID)
+	config := discoveryConfig{
+		Name:              s.Name,
+		UniqueID:          deviceID + "_" + sensorID,
+		StateTopic:        stateTopic,
+		UnitOfMeasurement: s.Unit,
+		DeviceClass:       s.DeviceClass,
+		StateClass:        s.StateClass,
+		Device: discoveryDevice{
+			Identifiers:  []string{deviceID},
+			Name:         s.Device.Name,
+			Manufacturer: s.Device.Manufacturer,
+			Model:        s.Device.Model,
+			SerialNumber: s.Device.SerialNumber,
+		},
+	}
+	if p.format == FormatJSON {
+		config.ValueTemplate = "{{ value_json.value }}"
+	}
+
+	payload, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	topic := fmt.Sprintf("%v/sensor/%v/%v/config", p.discoveryPrefix, deviceID, sensorID)
+	if err := p.client.Publish(Message{Topic: topic, Payload: payload, QoS: p.qos, Retain: true}); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.announced[stateTopic] = true
+	p.mu.Unlock()
+
+	return nil
+}
+
+// topicLevel replaces the characters of an identifier which are not allowed or unusual in a topic level.
+func topicLevel(s string) string {
+	var b bytes.Buffer
+	for _, r := range strings.ToLower(s) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// SensorClasses returns the Home Assistant device and state class of a sensor with the identifier and unit.
+func SensorClasses(id, unit string) (deviceClass, stateClass string) {
+	switch unit {
+	case "W":
+		return "power", "measurement"
+	case "Wh":
+		return "energy", "total_increasing"
+	case "var":
+		return "reactive_power", "measurement"
+	case "VA":
+		return "apparent_power", "measurement"
+	case "varh", "VAh":
+		return "", "total_increasing"
+	case "Hz":
+		return "frequency", "measurement"
+	case "A":
+		return "current", "measurement"
+	case "V":
+		return "voltage", "measurement"
+	case "%":
+		if strings.Contains(id, "soc") {
+			return "battery", "measurement"
+		}
+		return "", "measurement"
+	}
+
+	if strings.HasPrefix(id, "power_factor") {
+		return "power_factor", "measurement"
+	}
+	return "", "measurement"
+}
+
+// newSensor creates a sensor with the classes derived from the identifier and unit.
+func newSensor(device DeviceInfo, id, name, unit string, value float64) Sensor {
+	deviceClass, stateClass := SensorClasses(id, unit)
+	return Sensor{
+		Device:      device,
+		ID:          id,
+		Name:        name,
+		Unit:        unit,
+		DeviceClass: deviceClass,
+		StateClass:  stateClass,
+		Value:       value,
+	}
+}
+
+// TelegramSensors returns the registered values of an energy meter telegram as sensors.
+func TelegramSensors(t *meter.EnergyMeterTelegram) []Sensor {
+	serial := fmt.Sprint(t.SerialNo)
+	device := DeviceInfo{
+		ID:           "sma_em_" + serial,
+		Name:         "SMA Energy Meter " + serial,
+		Manufacturer: "SMA",
+		Model:        fmt.Sprintf("Energy Meter (SUSy ID %v)", t.SusyID),
+		SerialNumber: serial,
+	}
+
+	var sensors []Sensor
+	for id := range t.Obis {
+		info, ok := meter.LookupOBIS(id)
+		if !ok || id == meter.OBISSoftwareVersion {
+			continue
+		}
+		v, _ := t.Value(id)
+		sensors = append(sensors, newSensor(device, info.Name, info.Description, info.Unit, v))
+	}
+
+	sortSensors(sensors)
+	return sensors
+}
+
+func sortSensors(sensors []Sensor) {
+	sort.Slice(sensors, func(i, j int) bool { return sensors[i].ID < sensors[j].ID })
+}
+
+// SunSpecPoint is a value of a SunSpec device published as sensor.
+type SunSpecPoint struct {
+	ID   string
+	Name string
+	// Points are alternatives of the value in different models, the first point implemented by the device is used.
+	Points []sunspec.Point
+}
+
+// DefaultSunSpecPoints are the points of inverters and batteries.
+var DefaultSunSpecPoints = []SunSpecPoint{
+	{ID: "power", Name: "Power", Points: []sunspec.Point{sunspec.PointPower1Phase, sunspec.PointPower2Phase, sunspec.PointPower3Phase}},
+	{ID: "energy_total", Name: "Energy total", Points: []sunspec.Point{sunspec.PointEnergy1Phase, sunspec.PointEnergy2Phase, sunspec.PointEnergy3Phase}},
+	{ID: "battery_soc", Name: "Battery state of charge", Points: []sunspec.Point{sunspec.PointBatterySoc, sunspec.PointSoc}},
+	{ID: "battery_power", Name: "Battery discharge power", Points: []sunspec.Point{sunspec.PointBatteryPower}},
+}
+
+// sunSpecReader reads the identification and points of a SunSpec device, e.g. a *sunspec.ModbusDevice.
+type sunSpecReader interface {
+	ReadCommonModel() (sunspec.CommonModel, error)
+	GetAnyPoint(ps ...sunspec.Point) (float64, error)
+}
+
+// ReadSunSpecSensors reads the points of the SunSpec device, points not implemented by the device are omitted.
+func ReadSunSpecSensors(d sunSpecReader, points []SunSpecPoint) ([]Sensor, error) {
+	common, err := d.ReadCommonModel()
+	if err != nil {
+		return nil, err
+	}
+
+	device := DeviceInfo{
+		ID:           "sunspec_" + common.SerialNumber,
+		Name:         strings.TrimSpace(common.Manufacturer + " " + common.Model),
+		Manufacturer: common.Manufacturer,
+		Model:        common.Model,
+		SerialNumber: common.SerialNumber,
+	}
+
+	var sensors []Sensor
+	for _, p := range points {
+		v, err := d.GetAnyPoint(p.Points...)
+		if errors.Is(err, sunspec.ErrPointNotImplemented) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var unit string
+		if len(p.Points) > 0 {
+			unit = p.Points[0].Unit
+		}
+		sensors = append(sensors, newSensor(device, p.ID, p.Name, unit, v))
+	}
+
+	return sensors, nil
+}
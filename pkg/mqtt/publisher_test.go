@@ -0,0 +1,223 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/orlopau/go-energy/pkg/meter"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"testing"
+)
+
+// testTelegram returns a telegram of a meter importing 306.3 W on L1.
+func testTelegram() *meter.EnergyMeterTelegram {
+	return &meter.EnergyMeterTelegram{
+		SusyID:   349,
+		SerialNo: 1901401956,
+		Obis: map[meter.OBISIdentifier]uint64{
+			meter.OBISPowerImport:     3063,
+			meter.OBISEnergyImport:    12760257654,
+			meter.OBISVoltageL1:       232978,
+			meter.OBISSoftwareVersion: 0x02001252,
+			// unregistered identifiers are not published
+			{Channel: 0, MeasVal: 99, MeasType: 4}: 1,
+		},
+	}
+}
+
+func discoveryConfigOf(t *testing.T, b *Broker, topic string) discoveryConfig {
+	m, ok := b.Retained(topic)
+	if !ok {
+		t.Fatalf("no discovery config at %v", topic)
+	}
+
+	var config discoveryConfig
+	if err := json.Unmarshal(m.Payload, &config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestPublisher_PublishTelegram(t *testing.T) {
+	b, addr := startBroker(t)
+	// QoS 1 waits for the broker to store the retained messages
+	p, err := NewPublisher(dial(t, addr), WithQoS(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.PublishTelegram(testTelegram()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		sensor, value, unit, deviceClass, stateClass string
+	}{
+		"power":   {"active_power_import", "306.3", "W", "power", "measurement"},
+		"counter": {"active_power_import_total", "3544516.015", "Wh", "energy", "total_increasing"},
+		"voltage": {"voltage_l1", "232.978", "V", "voltage", "measurement"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			state, ok := b.Retained("goenergy/sma_em_1901401956/" + test.sensor)
+			if !ok || string(state.Payload) != test.value {
+				t.Fatalf("want state %v, got %q (retained %v)", test.value, state.Payload, ok)
+			}
+
+			config := discoveryConfigOf(t, b, "homeassistant/sensor/sma_em_1901401956/"+test.sensor+"/config")
+			if config.StateTopic != "goenergy/sma_em_1901401956/"+test.sensor || config.UnitOfMeasurement != test.unit ||
+				config.DeviceClass != test.deviceClass || config.StateClass != test.stateClass {
+				t.Fatalf("unexpected config %+v", config)
+			}
+			if config.UniqueID != "sma_em_1901401956_"+test.sensor || config.Device.Identifiers[0] != "sma_em_1901401956" ||
+				config.Device.SerialNumber != "1901401956" || config.ValueTemplate != "" {
+				t.Fatalf("unexpected config %+v", config)
+			}
+		})
+	}
+
+	if _, ok := b.Retained("goenergy/sma_em_1901401956/software_version"); ok {
+		t.Fatal("unexpected software version sensor")
+	}
+}
+
+// testPublisher records the published messages.
+type testPublisher struct {
+	messages []Message
+}
+
+func (p *testPublisher) Publish(m Message) error {
+	p.messages = append(p.messages, m)
+	return nil
+}
+
+func TestPublisher_Options(t *testing.T) {
+	client := &testPublisher{}
+	p, err := newPublisher(client,
+		WithTopicTemplate("energy/{{.Serial}}/{{.Sensor}}/state"),
+		WithFormat(FormatJSON),
+		WithRetain(false),
+		WithQoS(1),
+		WithDiscoveryPrefix("ha"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSensor(DeviceInfo{ID: "SunSpec 3000/1", SerialNumber: "3000"}, "power", "Power", "W", 1234)
+	for i := 0; i < 2; i++ {
+		if err := p.Publish(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the sensor is announced once
+	if len(client.messages) != 3 {
+		t.Fatalf("expected 3 messages, got %+v", client.messages)
+	}
+
+	config := client.messages[0]
+	if config.Topic != "ha/sensor/sunspec_3000_1/power/config" || !config.Retain || config.QoS != 1 {
+		t.Fatalf("unexpected config message %+v", config)
+	}
+	var c discoveryConfig
+	if err := json.Unmarshal(config.Payload, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.ValueTemplate != "{{ value_json.value }}" || c.StateTopic != "energy/3000/power/state" {
+		t.Fatalf("unexpected config %+v", c)
+	}
+
+	state := client.messages[1]
+	if state.Topic != "energy/3000/power/state" || state.Retain || state.QoS != 1 || string(state.Payload) != `{"value":1234,"unit":"W"}` {
+		t.Fatalf("unexpected state message %+v with payload %s", state, state.Payload)
+	}
+}
+
+func TestNewPublisher_InvalidTemplate(t *testing.T) {
+	if _, err := newPublisher(&testPublisher{}, WithTopicTemplate("{{.Device")); err == nil {
+		t.Fatal("expected error")
+	}
+
+	p, err := newPublisher(&testPublisher{}, WithTopicTemplate("{{.Unknown}}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish(Sensor{ID: "power"}); err == nil {
+		t.Fatal("expected error of unknown field")
+	}
+}
+
+// testSunSpecReader implements sunSpecReader using fixed point values.
+type testSunSpecReader struct {
+	points map[sunspec.Point]float64
+	err    error
+}
+
+func (r *testSunSpecReader) ReadCommonModel() (sunspec.CommonModel, error) {
+	return sunspec.CommonModel{Manufacturer: "SMA", Model: "STP 10.0", SerialNumber: "3000123456"}, nil
+}
+
+func (r *testSunSpecReader) GetAnyPoint(ps ...sunspec.Point) (float64, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	for _, p := range ps {
+		if v, ok := r.points[p]; ok {
+			return v, nil
+		}
+	}
+	return 0, sunspec.ErrPointNotImplemented
+}
+
+func TestReadSunSpecSensors(t *testing.T) {
+	r := &testSunSpecReader{points: map[sunspec.Point]float64{
+		sunspec.PointPower3Phase: 1234,
+		sunspec.PointSoc:         80,
+	}}
+
+	sensors, err := ReadSunSpecSensors(r, DefaultSunSpecPoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sensors) != 2 {
+		t.Fatalf("expected 2 sensors, got %+v", sensors)
+	}
+	if s := sensors[0]; s.ID != "power" || s.Value != 1234 || s.DeviceClass != "power" || s.Device.ID != "sunspec_3000123456" || s.Device.Name != "SMA STP 10.0" {
+		t.Fatalf("unexpected sensor %+v", s)
+	}
+	if s := sensors[1]; s.ID != "battery_soc" || s.Unit != "%" || s.DeviceClass != "battery" {
+		t.Fatalf("unexpected sensor %+v", s)
+	}
+
+	r.err = errors.New("connection reset")
+	if _, err := ReadSunSpecSensors(r, DefaultSunSpecPoints); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestSensorClasses(t *testing.T) {
+	tests := map[string]struct {
+		id, unit                string
+		deviceClass, stateClass string
+	}{
+		"power":          {"active_power_import", "W", "power", "measurement"},
+		"energy":         {"active_power_import_total", "Wh", "energy", "total_increasing"},
+		"reactive":       {"reactive_power_import_total", "varh", "", "total_increasing"},
+		"frequency":      {"frequency", "Hz", "frequency", "measurement"},
+		"power factor":   {"power_factor_l1", "", "power_factor", "measurement"},
+		"soc":            {"battery_soc", "%", "battery", "measurement"},
+		"other percent":  {"efficiency", "%", "", "measurement"},
+		"unknown":        {"status", "", "", "measurement"},
+		"apparent power": {"apparent_power_export", "VA", "apparent_power", "measurement"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			deviceClass, stateClass := SensorClasses(test.id, test.unit)
+			if deviceClass != test.deviceClass || stateClass != test.stateClass {
+				t.Fatalf("want %v %v, got %v %v", test.deviceClass, test.stateClass, deviceClass, stateClass)
+			}
+		})
+	}
+}
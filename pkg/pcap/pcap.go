@@ -0,0 +1,294 @@
+// Provides reading of pcap and pcapng packet captures for replaying recorded SMA traffic.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	magicMicroseconds        uint32 = 0xa1b2c3d4
+	magicNanoseconds         uint32 = 0xa1b23c4d
+	magicMicrosecondsSwapped uint32 = 0xd4c3b2a1
+	magicNanosecondsSwapped  uint32 = 0x4d3cb2a1
+
+	blockSectionHeader     uint32 = 0x0a0d0d0a
+	blockInterface         uint32 = 0x00000001
+	blockSimplePacket      uint32 = 0x00000003
+	blockEnhancedPacket    uint32 = 0x00000006
+	byteOrderMagic         uint32 = 0x1a2b3c4d
+	optionEndOfOpt         uint16 = 0
+	optionInterfaceTsresol uint16 = 9
+
+	// maxBlockLength limits the memory allocated for a single record or block of a malformed capture.
+	maxBlockLength = 1 << 24
+)
+
+var ErrUnknownFormat = errors.New("unknown capture file format")
+
+// LinkType is the link-layer header type of captured packets, as assigned by tcpdump.org.
+type LinkType uint16
+
+const (
+	LinkTypeNull      LinkType = 0
+	LinkTypeEthernet  LinkType = 1
+	LinkTypeRaw       LinkType = 101
+	LinkTypeLinuxSLL  LinkType = 113
+	LinkTypeIPv4      LinkType = 228
+	LinkTypeIPv6      LinkType = 229
+	LinkTypeLinuxSLL2 LinkType = 276
+)
+
+// Packet is a single packet read from a capture.
+type Packet struct {
+	Timestamp time.Time
+	LinkType  LinkType
+	// Data contains the captured bytes of the packet, starting with the link-layer header.
+	Data []byte
+}
+
+// Reader reads packets from a pcap or pcapng capture.
+type Reader struct {
+	r          *bufio.Reader
+	next       func() (Packet, error)
+	byteOrder  binary.ByteOrder
+	linkType   LinkType
+	resolution time.Duration
+	// interfaces contains the interfaces of the current pcapng section.
+	interfaces []pcapngInterface
+}
+
+type pcapngInterface struct {
+	linkType LinkType
+	// unitsPerSecond is the number of timestamp units per second.
+	unitsPerSecond uint64
+}
+
+// NewReader creates a Reader, detecting the capture format from the file header.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: bufio.NewReader(r)}
+
+	head, err := reader.r.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("reading capture header: %w", err)
+	}
+
+	switch magic := binary.BigEndian.Uint32(head); magic {
+	case magicMicroseconds, magicNanoseconds, magicMicrosecondsSwapped, magicNanosecondsSwapped:
+		if err := reader.readPcapHeader(); err != nil {
+			return nil, err
+		}
+		reader.next = reader.nextPcap
+	case blockSectionHeader:
+		reader.next = reader.nextPcapng
+	default:
+		return nil, fmt.Errorf("%w: magic %#x", ErrUnknownFormat, magic)
+	}
+
+	return reader, nil
+}
+
+// Next returns the next packet of the capture.
+//
+// Returns io.EOF if there are no more packets.
+func (r *Reader) Next() (Packet, error) {
+	return r.next()
+}
+
+func (r *Reader) readPcapHeader() error {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		return fmt.Errorf("reading pcap header: %w", err)
+	}
+
+	switch binary.BigEndian.Uint32(header) {
+	case magicMicroseconds:
+		r.byteOrder, r.resolution = binary.BigEndian, time.Microsecond
+	case magicNanoseconds:
+		r.byteOrder, r.resolution = binary.BigEndian, time.Nanosecond
+	case magicMicrosecondsSwapped:
+		r.byteOrder, r.resolution = binary.LittleEndian, time.Microsecond
+	case magicNanosecondsSwapped:
+		r.byteOrder, r.resolution = binary.LittleEndian, time.Nanosecond
+	}
+
+	r.linkType = LinkType(r.byteOrder.Uint32(header[20:24]))
+	return nil
+}
+
+func (r *Reader) nextPcap() (Packet, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return Packet{}, fmt.Errorf("reading record header: %w", err)
+		}
+		return Packet{}, err
+	}
+
+	sec := r.byteOrder.Uint32(header[0:4])
+	frac := r.byteOrder.Uint32(header[4:8])
+	capLen := r.byteOrder.Uint32(header[8:12])
+	if capLen > maxBlockLength {
+		return Packet{}, fmt.Errorf("record length %d exceeds maximum", capLen)
+	}
+
+	data := make([]byte, capLen)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return Packet{}, fmt.Errorf("reading record: %w", noEOF(err))
+	}
+
+	return Packet{
+		Timestamp: time.Unix(int64(sec), int64(frac)*int64(r.resolution)),
+		LinkType:  r.linkType,
+		Data:      data,
+	}, nil
+}
+
+func (r *Reader) nextPcapng() (Packet, error) {
+	for {
+		head, err := r.r.Peek(12)
+		if err != nil {
+			if err == io.EOF && len(head) == 0 {
+				return Packet{}, io.EOF
+			}
+			return Packet{}, fmt.Errorf("reading block header: %w", noEOF(err))
+		}
+
+		blockType := binary.BigEndian.Uint32(head[0:4])
+		if blockType == blockSectionHeader {
+			// the byte order magic decides the byte order of the whole section, including the header itself
+			switch byteOrderMagic {
+			case binary.BigEndian.Uint32(head[8:12]):
+				r.byteOrder = binary.BigEndian
+			case binary.LittleEndian.Uint32(head[8:12]):
+				r.byteOrder = binary.LittleEndian
+			default:
+				return Packet{}, fmt.Errorf("%w: invalid byte order magic", ErrUnknownFormat)
+			}
+			r.interfaces = nil
+		} else if r.byteOrder == nil {
+			return Packet{}, fmt.Errorf("%w: missing section header", ErrUnknownFormat)
+		}
+
+		blockType = r.byteOrder.Uint32(head[0:4])
+		length := r.byteOrder.Uint32(head[4:8])
+		if length < 12 || length%4 != 0 || length > maxBlockLength {
+			return Packet{}, fmt.Errorf("invalid block length %d", length)
+		}
+
+		block := make([]byte, length)
+		if _, err := io.ReadFull(r.r, block); err != nil {
+			return Packet{}, fmt.Errorf("reading block: %w", noEOF(err))
+		}
+		body := block[8 : length-4]
+
+		switch blockType {
+		case blockInterface:
+			if err := r.readInterface(body); err != nil {
+				return Packet{}, err
+			}
+		case blockEnhancedPacket:
+			return r.readEnhancedPacket(body)
+		case blockSimplePacket:
+			return r.readSimplePacket(body)
+		}
+	}
+}
+
+func (r *Reader) readInterface(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("interface description block too short")
+	}
+
+	ifi := pcapngInterface{
+		linkType:       LinkType(r.byteOrder.Uint16(body[0:2])),
+		unitsPerSecond: 1e6,
+	}
+
+	options := body[8:]
+	for len(options) >= 4 {
+		code := r.byteOrder.Uint16(options[0:2])
+		length := int(r.byteOrder.Uint16(options[2:4]))
+		if code == optionEndOfOpt || 4+length > len(options) {
+			break
+		}
+
+		if code == optionInterfaceTsresol && length == 1 {
+			res := options[4]
+			exponent := float64(res & 0x7f)
+			if res&0x80 != 0 {
+				ifi.unitsPerSecond = uint64(math.Pow(2, exponent))
+			} else {
+				ifi.unitsPerSecond = uint64(math.Pow(10, exponent))
+			}
+		}
+
+		options = options[4+(length+3)/4*4:]
+	}
+
+	r.interfaces = append(r.interfaces, ifi)
+	return nil
+}
+
+func (r *Reader) readEnhancedPacket(body []byte) (Packet, error) {
+	if len(body) < 20 {
+		return Packet{}, fmt.Errorf("enhanced packet block too short")
+	}
+
+	id := r.byteOrder.Uint32(body[0:4])
+	if int(id) >= len(r.interfaces) {
+		return Packet{}, fmt.Errorf("packet references unknown interface %d", id)
+	}
+	ifi := r.interfaces[id]
+
+	ts := uint64(r.byteOrder.Uint32(body[4:8]))<<32 | uint64(r.byteOrder.Uint32(body[8:12]))
+	capLen := r.byteOrder.Uint32(body[12:16])
+	if uint64(capLen) > uint64(len(body)-20) {
+		return Packet{}, fmt.Errorf("captured length %d exceeds block", capLen)
+	}
+
+	data := make([]byte, capLen)
+	copy(data, body[20:])
+
+	sec := ts / ifi.unitsPerSecond
+	nsec := (ts % ifi.unitsPerSecond) * uint64(time.Second) / ifi.unitsPerSecond
+
+	return Packet{
+		Timestamp: time.Unix(int64(sec), int64(nsec)),
+		LinkType:  ifi.linkType,
+		Data:      data,
+	}, nil
+}
+
+func (r *Reader) readSimplePacket(body []byte) (Packet, error) {
+	if len(r.interfaces) == 0 {
+		return Packet{}, fmt.Errorf("packet references unknown interface 0")
+	}
+	if len(body) < 4 {
+		return Packet{}, fmt.Errorf("simple packet block too short")
+	}
+
+	origLen := r.byteOrder.Uint32(body[0:4])
+	data := body[4:]
+	if uint64(origLen) < uint64(len(data)) {
+		data = data[:origLen]
+	}
+
+	return Packet{
+		LinkType: r.interfaces[0].linkType,
+		Data:     append([]byte(nil), data...),
+	}, nil
+}
+
+// noEOF converts io.EOF into io.ErrUnexpectedEOF for reads that must not end the capture.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
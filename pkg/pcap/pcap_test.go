@@ -0,0 +1,265 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+var (
+	testSrc = &net.UDPAddr{IP: net.IPv4(192, 168, 1, 10), Port: 9522}
+	testDst = &net.UDPAddr{IP: net.IPv4(239, 12, 255, 254), Port: 9522}
+)
+
+func writeTestCapture(t *testing.T, datagrams []Datagram) *bytes.Buffer {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range datagrams {
+		if err := w.WriteDatagram(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &buf
+}
+
+// pcapngBlock encodes a little endian pcapng block.
+func pcapngBlock(blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+
+	length := uint32(12 + len(body))
+	b := make([]byte, 8, length)
+	binary.LittleEndian.PutUint32(b[0:4], blockType)
+	binary.LittleEndian.PutUint32(b[4:8], length)
+	b = append(b, body...)
+
+	trailer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(trailer, length)
+
+	return append(b, trailer...)
+}
+
+func TestReader_Pcap(t *testing.T) {
+	ts := time.Unix(1600000000, 123000)
+	in := []Datagram{
+		{Timestamp: ts, Src: testSrc, Dst: testDst, Payload: []byte("SMA first")},
+		{Timestamp: ts.Add(time.Second), Src: testSrc, Dst: testDst, Payload: []byte("SMA second")},
+	}
+
+	out, err := ReadDatagrams(writeTestCapture(t, in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out) != len(in) {
+		t.Fatalf("expected %d datagrams, got %d", len(in), len(out))
+	}
+
+	for i := range in {
+		if !out[i].Timestamp.Equal(in[i].Timestamp) {
+			t.Fatalf("expected timestamp %v, got %v", in[i].Timestamp, out[i].Timestamp)
+		}
+		if !bytes.Equal(out[i].Payload, in[i].Payload) {
+			t.Fatalf("expected payload %q, got %q", in[i].Payload, out[i].Payload)
+		}
+		if out[i].Src.String() != testSrc.String() || out[i].Dst.String() != testDst.String() {
+			t.Fatalf("unexpected addresses %v -> %v", out[i].Src, out[i].Dst)
+		}
+	}
+}
+
+func TestReader_Pcapng(t *testing.T) {
+	capture := writeTestCapture(t, []Datagram{{Src: testSrc, Dst: testDst, Payload: []byte("SMA")}})
+	// skip the pcap file and record header to get the ethernet frame
+	frame := capture.Bytes()[24+16:]
+
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:4], byteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:6], 1)
+	binary.LittleEndian.PutUint64(shb[8:16], ^uint64(0))
+
+	// interface with nanosecond resolution
+	idb := make([]byte, 8, 20)
+	binary.LittleEndian.PutUint16(idb[0:2], uint16(LinkTypeEthernet))
+	idb = append(idb, 9, 0, 1, 0, 9, 0, 0, 0, 0, 0, 0, 0)
+
+	ts := uint64(1600000000123456789)
+	epb := make([]byte, 20)
+	binary.LittleEndian.PutUint32(epb[4:8], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(epb[8:12], uint32(ts))
+	binary.LittleEndian.PutUint32(epb[12:16], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(epb[16:20], uint32(len(frame)))
+	epb = append(epb, frame...)
+
+	var buf bytes.Buffer
+	buf.Write(pcapngBlock(blockSectionHeader, shb))
+	buf.Write(pcapngBlock(blockInterface, idb))
+	// unknown blocks are skipped
+	buf.Write(pcapngBlock(0x00000005, make([]byte, 8)))
+	buf.Write(pcapngBlock(blockEnhancedPacket, epb))
+
+	out, err := ReadDatagrams(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out) != 1 {
+		t.Fatalf("expected 1 datagram, got %d", len(out))
+	}
+	if string(out[0].Payload) != "SMA" {
+		t.Fatalf("expected payload SMA, got %q", out[0].Payload)
+	}
+	if out[0].Timestamp.UnixNano() != int64(ts) {
+		t.Fatalf("expected timestamp %v, got %v", ts, out[0].Timestamp.UnixNano())
+	}
+}
+
+func TestNewReader_UnknownFormat(t *testing.T) {
+	_, err := NewReader(bytes.NewReader([]byte("not a capture")))
+	if !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("expected %v, got %v", ErrUnknownFormat, err)
+	}
+}
+
+func TestReader_Truncated(t *testing.T) {
+	capture := writeTestCapture(t, []Datagram{{Src: testSrc, Dst: testDst, Payload: []byte("SMA")}})
+
+	_, err := ReadDatagrams(bytes.NewReader(capture.Bytes()[:capture.Len()-1]))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestDecodeUDP(t *testing.T) {
+	udp := []byte{0x25, 0x32, 0x25, 0x32, 0, 11, 0, 0, 'S', 'M', 'A'}
+	ip := append([]byte{0x45, 0, 0, 31, 0, 0, 0, 0, 64, 17, 0, 0, 192, 168, 1, 10, 239, 12, 255, 254}, udp...)
+
+	tt := map[string]struct {
+		linkType LinkType
+		data     []byte
+		wErr     bool
+	}{
+		"raw":       {LinkTypeRaw, ip, false},
+		"ethernet":  {LinkTypeEthernet, append(append(make([]byte, 12), 0x08, 0x00), ip...), false},
+		"vlan":      {LinkTypeEthernet, append(append(make([]byte, 12), 0x81, 0x00, 0, 1, 0x08, 0x00), ip...), false},
+		"sll":       {LinkTypeLinuxSLL, append(append(make([]byte, 14), 0x08, 0x00), ip...), false},
+		"arp":       {LinkTypeEthernet, append(make([]byte, 12), 0x08, 0x06, 0, 0), true},
+		"tcp":       {LinkTypeRaw, append([]byte{0x45, 0, 0, 20, 0, 0, 0, 0, 64, 6}, make([]byte, 10)...), true},
+		"truncated": {LinkTypeRaw, ip[:25], true},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			d, err := DecodeUDP(Packet{LinkType: tc.linkType, Data: tc.data})
+			if err != nil {
+				if tc.wErr && errors.Is(err, ErrNotUDP) {
+					return
+				}
+				t.Fatal(err)
+			}
+
+			if tc.wErr {
+				t.Fatal("expected error")
+			}
+
+			if string(d.Payload) != "SMA" || d.Dst.Port != 9522 {
+				t.Fatalf("unexpected datagram %v", d)
+			}
+		})
+	}
+}
+
+func TestReplayConn_ReadFromUDP(t *testing.T) {
+	ts := time.Now()
+	other := &net.UDPAddr{IP: testDst.IP, Port: 1234}
+	capture := writeTestCapture(t, []Datagram{
+		{Timestamp: ts, Src: testSrc, Dst: testDst, Payload: []byte("first")},
+		{Timestamp: ts, Src: testSrc, Dst: other, Payload: []byte("filtered")},
+		{Timestamp: ts.Add(50 * time.Millisecond), Src: testSrc, Dst: testDst, Payload: []byte("second")},
+	})
+
+	conn, err := NewReplayConn(capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Port = 9522
+	conn.Realtime = true
+
+	start := time.Now()
+	b := make([]byte, 100)
+	for _, want := range []string{"first", "second"} {
+		n, addr, err := conn.ReadFromUDP(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b[:n]) != want {
+			t.Fatalf("expected %v, got %v", want, string(b[:n]))
+		}
+		if addr.String() != testSrc.String() {
+			t.Fatalf("expected address %v, got %v", testSrc, addr)
+		}
+	}
+
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("replay did not preserve timing")
+	}
+
+	if _, _, err := conn.ReadFromUDP(b); err != io.EOF {
+		t.Fatalf("expected %v, got %v", io.EOF, err)
+	}
+
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := conn.ReadFromUDP(b); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected %v, got %v", ErrClosed, err)
+	}
+}
+
+func TestReplayConn_CloseWhileWaiting(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	capture := writeTestCapture(t, []Datagram{
+		{Timestamp: ts, Src: testSrc, Dst: testDst, Payload: []byte("first")},
+		{Timestamp: ts.Add(time.Hour), Src: testSrc, Dst: testDst, Payload: []byte("second")},
+	})
+
+	conn, err := NewReplayConn(capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Realtime = true
+
+	b := make([]byte, 100)
+	if _, _, err := conn.ReadFromUDP(b); err != nil {
+		t.Fatal(err)
+	}
+
+	read := make(chan error)
+	go func() {
+		_, _, err := conn.ReadFromUDP(b)
+		read <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-read:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("expected %v, got %v", ErrClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("close did not interrupt the replay")
+	}
+}
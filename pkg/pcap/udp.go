@@ -0,0 +1,251 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	etherTypeIPv4  uint16 = 0x0800
+	etherTypeIPv6  uint16 = 0x86dd
+	etherTypeVLAN  uint16 = 0x8100
+	etherTypeQinQ  uint16 = 0x88a8
+	protocolUDP    uint8  = 17
+	ethernetLength        = 14
+	ipv4Length            = 20
+	ipv6Length            = 40
+	udpLength             = 8
+)
+
+var (
+	ErrNotUDP = errors.New("packet is not a udp datagram")
+	ErrClosed = errors.New("replay connection closed")
+)
+
+// Datagram is a UDP datagram decoded from a captured packet.
+type Datagram struct {
+	Timestamp time.Time
+	Src, Dst  *net.UDPAddr
+	Payload   []byte
+}
+
+// DecodeUDP decodes the UDP datagram carried by a packet.
+//
+// Returns ErrNotUDP if the packet does not carry an unfragmented UDP datagram over IPv4 or IPv6.
+func DecodeUDP(p Packet) (Datagram, error) {
+	network, err := networkLayer(p.LinkType, p.Data)
+	if err != nil {
+		return Datagram{}, err
+	}
+	if len(network) < 1 {
+		return Datagram{}, fmt.Errorf("%w: empty network layer", ErrNotUDP)
+	}
+
+	var srcIP, dstIP net.IP
+	var transport []byte
+	switch network[0] >> 4 {
+	case 4:
+		if len(network) < ipv4Length {
+			return Datagram{}, fmt.Errorf("%w: truncated ipv4 header", ErrNotUDP)
+		}
+		headerLength := int(network[0]&0x0f) * 4
+		totalLength := int(binary.BigEndian.Uint16(network[2:4]))
+		if headerLength < ipv4Length || totalLength < headerLength || totalLength > len(network) {
+			return Datagram{}, fmt.Errorf("%w: invalid ipv4 length", ErrNotUDP)
+		}
+		if network[9] != protocolUDP {
+			return Datagram{}, fmt.Errorf("%w: ip protocol %d", ErrNotUDP, network[9])
+		}
+		// more fragments flag or fragment offset set
+		if binary.BigEndian.Uint16(network[6:8])&0x3fff != 0 {
+			return Datagram{}, fmt.Errorf("%w: fragmented", ErrNotUDP)
+		}
+		srcIP, dstIP = net.IP(network[12:16]), net.IP(network[16:20])
+		transport = network[headerLength:totalLength]
+	case 6:
+		if len(network) < ipv6Length {
+			return Datagram{}, fmt.Errorf("%w: truncated ipv6 header", ErrNotUDP)
+		}
+		if network[6] != protocolUDP {
+			return Datagram{}, fmt.Errorf("%w: next header %d", ErrNotUDP, network[6])
+		}
+		payloadLength := int(binary.BigEndian.Uint16(network[4:6]))
+		if ipv6Length+payloadLength > len(network) {
+			return Datagram{}, fmt.Errorf("%w: invalid ipv6 length", ErrNotUDP)
+		}
+		srcIP, dstIP = net.IP(network[8:24]), net.IP(network[24:40])
+		transport = network[ipv6Length : ipv6Length+payloadLength]
+	default:
+		return Datagram{}, fmt.Errorf("%w: ip version %d", ErrNotUDP, network[0]>>4)
+	}
+
+	if len(transport) < udpLength {
+		return Datagram{}, fmt.Errorf("%w: truncated udp header", ErrNotUDP)
+	}
+	length := int(binary.BigEndian.Uint16(transport[4:6]))
+	if length < udpLength || length > len(transport) {
+		return Datagram{}, fmt.Errorf("%w: invalid udp length", ErrNotUDP)
+	}
+
+	return Datagram{
+		Timestamp: p.Timestamp,
+		Src:       &net.UDPAddr{IP: append(net.IP(nil), srcIP...), Port: int(binary.BigEndian.Uint16(transport[0:2]))},
+		Dst:       &net.UDPAddr{IP: append(net.IP(nil), dstIP...), Port: int(binary.BigEndian.Uint16(transport[2:4]))},
+		Payload:   transport[udpLength:length],
+	}, nil
+}
+
+// networkLayer strips the link-layer header from the packet data.
+func networkLayer(linkType LinkType, data []byte) ([]byte, error) {
+	switch linkType {
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+		return data, nil
+	case LinkTypeNull:
+		if len(data) < 4 {
+			return nil, fmt.Errorf("%w: truncated loopback header", ErrNotUDP)
+		}
+		return data[4:], nil
+	case LinkTypeEthernet:
+		if len(data) < ethernetLength {
+			return nil, fmt.Errorf("%w: truncated ethernet header", ErrNotUDP)
+		}
+		etherType := binary.BigEndian.Uint16(data[12:14])
+		data = data[ethernetLength:]
+		for etherType == etherTypeVLAN || etherType == etherTypeQinQ {
+			if len(data) < 4 {
+				return nil, fmt.Errorf("%w: truncated vlan tag", ErrNotUDP)
+			}
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+		return checkEtherType(etherType, data)
+	case LinkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil, fmt.Errorf("%w: truncated sll header", ErrNotUDP)
+		}
+		return checkEtherType(binary.BigEndian.Uint16(data[14:16]), data[16:])
+	case LinkTypeLinuxSLL2:
+		if len(data) < 20 {
+			return nil, fmt.Errorf("%w: truncated sll2 header", ErrNotUDP)
+		}
+		return checkEtherType(binary.BigEndian.Uint16(data[0:2]), data[20:])
+	default:
+		return nil, fmt.Errorf("%w: unsupported link type %d", ErrNotUDP, linkType)
+	}
+}
+
+func checkEtherType(etherType uint16, data []byte) ([]byte, error) {
+	if etherType != etherTypeIPv4 && etherType != etherTypeIPv6 {
+		return nil, fmt.Errorf("%w: ether type %#04x", ErrNotUDP, etherType)
+	}
+	return data, nil
+}
+
+// ReadDatagrams reads all UDP datagrams of a capture, skipping packets not carrying UDP.
+func ReadDatagrams(r io.Reader) ([]Datagram, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var datagrams []Datagram
+	for {
+		p, err := reader.Next()
+		if err == io.EOF {
+			return datagrams, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		d, err := DecodeUDP(p)
+		if errors.Is(err, ErrNotUDP) {
+			continue
+		}
+		datagrams = append(datagrams, d)
+	}
+}
+
+// ReplayConn replays the UDP datagrams of a capture.
+//
+// It implements the reading methods of a *net.UDPConn, so it can be used in place of a socket.
+type ReplayConn struct {
+	reader *Reader
+	// Port filters the datagrams by destination port, zero replays all datagrams.
+	Port int
+	// Realtime preserves the time between two datagrams while replaying.
+	Realtime bool
+	last     time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewReplayConn creates a ReplayConn replaying the given capture.
+func NewReplayConn(r io.Reader) (*ReplayConn, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReplayConn{reader: reader, done: make(chan struct{})}, nil
+}
+
+// ReadFromUDP reads the payload of the next datagram into b.
+//
+// Returns io.EOF after the last datagram was read, and ErrClosed after the connection was closed.
+func (c *ReplayConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	select {
+	case <-c.done:
+		return 0, nil, ErrClosed
+	default:
+	}
+
+	for {
+		p, err := c.reader.Next()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		d, err := DecodeUDP(p)
+		if errors.Is(err, ErrNotUDP) || c.Port != 0 && d.Dst.Port != c.Port {
+			continue
+		}
+
+		if c.Realtime && !c.last.IsZero() {
+			timer := time.NewTimer(d.Timestamp.Sub(c.last))
+			select {
+			case <-c.done:
+				timer.Stop()
+				return 0, nil, ErrClosed
+			case <-timer.C:
+			}
+		}
+		c.last = d.Timestamp
+
+		return copy(b, d.Payload), d.Src, nil
+	}
+}
+
+// ReadFrom implements the reading method of net.PacketConn.
+func (c *ReplayConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.ReadFromUDP(b)
+	// avoid returning a non-nil interface holding a nil address
+	if addr == nil {
+		return n, nil, err
+	}
+	return n, addr, err
+}
+
+// Close stops the replay, it may be called while reading and interrupts waiting for the next datagram.
+func (c *ReplayConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	return nil
+}
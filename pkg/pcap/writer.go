@@ -0,0 +1,94 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	pcapVersionMajor = 2
+	pcapVersionMinor = 4
+	snapLength       = 65535
+	ipv4DefaultTTL   = 64
+)
+
+// Writer writes UDP datagrams into a pcap capture with ethernet link-layer headers.
+//
+// It can be used to record traffic for later replay, e.g. as regression test data.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter creates a Writer, writing the pcap file header to w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], magicMicroseconds)
+	binary.LittleEndian.PutUint16(header[4:6], pcapVersionMajor)
+	binary.LittleEndian.PutUint16(header[6:8], pcapVersionMinor)
+	binary.LittleEndian.PutUint32(header[16:20], snapLength)
+	binary.LittleEndian.PutUint32(header[20:24], uint32(LinkTypeEthernet))
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: w}, nil
+}
+
+// WriteDatagram writes a datagram as IPv4 packet. Source and destination must be IPv4 addresses.
+func (w *Writer) WriteDatagram(d Datagram) error {
+	src, dst := d.Src.IP.To4(), d.Dst.IP.To4()
+	if src == nil || dst == nil {
+		return fmt.Errorf("datagram %v -> %v is not ipv4", d.Src, d.Dst)
+	}
+
+	udpLen := udpLength + len(d.Payload)
+	ipLen := ipv4Length + udpLen
+	if ipLen > snapLength {
+		return fmt.Errorf("payload of %d bytes too large", len(d.Payload))
+	}
+
+	frame := make([]byte, ethernetLength+ipLen)
+	// zero mac addresses, the ether type is all that readers need
+	binary.BigEndian.PutUint16(frame[12:14], etherTypeIPv4)
+
+	ip := frame[ethernetLength:]
+	ip[0] = 4<<4 | ipv4Length/4
+	binary.BigEndian.PutUint16(ip[2:4], uint16(ipLen))
+	ip[8] = ipv4DefaultTTL
+	ip[9] = protocolUDP
+	copy(ip[12:16], src)
+	copy(ip[16:20], dst)
+	binary.BigEndian.PutUint16(ip[10:12], ipv4Checksum(ip[:ipv4Length]))
+
+	udp := ip[ipv4Length:]
+	binary.BigEndian.PutUint16(udp[0:2], uint16(d.Src.Port))
+	binary.BigEndian.PutUint16(udp[2:4], uint16(d.Dst.Port))
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpLen))
+	copy(udp[udpLength:], d.Payload)
+
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:4], uint32(d.Timestamp.Unix()))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(d.Timestamp.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(len(frame)))
+
+	if _, err := w.w.Write(record); err != nil {
+		return err
+	}
+	_, err := w.w.Write(frame)
+	return err
+}
+
+func ipv4Checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i : i+2]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+
+	return ^uint16(sum)
+}
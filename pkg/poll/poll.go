@@ -0,0 +1,253 @@
+// Provides a scheduler polling the points of many SunSpec devices concurrently.
+package poll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// defaultBuffer is the size of the sample channel if no buffer is configured.
+const defaultBuffer = 64
+
+// ErrClosed is returned when adding devices to a closed scheduler.
+var ErrClosed = errors.New("scheduler closed")
+
+// Reader reads points of a device, e.g. a *sunspec.ModbusDevice.
+type Reader interface {
+	GetAnyPoint(ps ...sunspec.Point) (float64, error)
+}
+
+// Task is a value polled from a device.
+type Task struct {
+	Name string
+	// Points are alternatives of the value in different models, see sunspec.ModelReader.GetAnyPoint.
+	Points []sunspec.Point
+	// Interval is the polling interval of the task, by default the interval of the model of its first point
+	// or the interval of the device.
+	Interval time.Duration
+}
+
+// Device is a device polled by a Scheduler.
+type Device struct {
+	Name   string
+	Reader Reader
+	Tasks  []Task
+	// Interval is the default polling interval of the tasks.
+	Interval time.Duration
+	// ModelIntervals are the polling intervals of the tasks of models, overriding the interval of the device.
+	ModelIntervals map[uint16]time.Duration
+	// MaxConcurrent is the maximum number of concurrent reads of the device, 1 if it is zero.
+	// Values above 1 require a reader safe for concurrent use.
+	MaxConcurrent int
+}
+
+// Sample is the result of polling a task.
+type Sample struct {
+	Device string
+	Task   string
+	Value  float64
+	// Err is the error of the read, the value is zero if it is set.
+	Err error
+	// Time is the time the read started and Duration the time it took, including waiting for the device.
+	Time     time.Time
+	Duration time.Duration
+	// Skipped is the number of intervals skipped since the previous sample of the task because the device
+	// or the consumer of the samples was too slow.
+	Skipped int
+}
+
+func (s Sample) String() string {
+	return fmt.Sprintf("Sample{device:%v,task:%v,value:%v,err:%v,time:%v,skipped:%v}",
+		s.Device, s.Task, s.Value, s.Err, s.Time.Format(time.RFC3339Nano), s.Skipped)
+}
+
+// Scheduler polls the tasks of devices in the background, delivering the results as samples.
+//
+// Each task is polled at its interval, delayed by a random jitter. Reads of a device are limited to its maximum
+// of concurrent reads, a task is never read concurrently. If a read or the delivery of its sample takes longer
+// than the interval, the missed intervals are skipped instead of queueing reads.
+type Scheduler struct {
+	jitter  time.Duration
+	samples chan Sample
+
+	mu     sync.Mutex
+	closed bool
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// Option configures a Scheduler.
+type Option func(*Scheduler)
+
+// WithJitter delays each poll by a random duration up to the jitter, spreading the reads of tasks with the same
+// interval. There is no jitter by default.
+func WithJitter(jitter time.Duration) Option {
+	return func(s *Scheduler) {
+		s.jitter = jitter
+	}
+}
+
+// WithBuffer sets the number of samples buffered for the consumer, 64 by default.
+func WithBuffer(n int) Option {
+	return func(s *Scheduler) {
+		s.samples = make(chan Sample, n)
+	}
+}
+
+// New creates a scheduler without devices.
+func New(opts ...Option) *Scheduler {
+	s := &Scheduler{
+		samples: make(chan Sample, defaultBuffer),
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Samples returns the channel of samples, it is closed after the scheduler is closed.
+//
+// Polling blocks while the channel is full, the channel must be drained.
+func (s *Scheduler) Samples() <-chan Sample {
+	return s.samples
+}
+
+// Add starts polling the tasks of the device.
+//
+// Returns an error if the interval of a task is not positive.
+func (s *Scheduler) Add(d Device) error {
+	intervals := make([]time.Duration, len(d.Tasks))
+	for i, t := range d.Tasks {
+		intervals[i] = d.interval(t)
+		if intervals[i] <= 0 {
+			return fmt.Errorf("task %v of device %v: invalid interval %v", t.Name, d.Name, intervals[i])
+		}
+	}
+
+	concurrent := d.MaxConcurrent
+	if concurrent <= 0 {
+		concurrent = 1
+	}
+	slots := make(chan struct{}, concurrent)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+
+	for i, t := range d.Tasks {
+		s.wg.Add(1)
+		go s.poll(d, t, intervals[i], slots)
+	}
+
+	return nil
+}
+
+// interval returns the polling interval of the task.
+func (d Device) interval(t Task) time.Duration {
+	if t.Interval != 0 {
+		return t.Interval
+	}
+	if len(t.Points) > 0 {
+		if i, ok := d.ModelIntervals[t.Points[0].Model]; ok {
+			return i
+		}
+	}
+	return d.Interval
+}
+
+// poll polls the task until the scheduler is closed, slots limits the concurrent reads of the device.
+func (s *Scheduler) poll(d Device, t Task, interval time.Duration, slots chan struct{}) {
+	defer s.wg.Done()
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitter := func() time.Duration {
+		if s.jitter <= 0 {
+			return 0
+		}
+		return time.Duration(random.Int63n(int64(s.jitter)))
+	}
+
+	next := time.Now()
+	timer := time.NewTimer(jitter())
+	defer timer.Stop()
+
+	skipped := 0
+	for {
+		select {
+		case <-timer.C:
+		case <-s.done:
+			return
+		}
+
+		sample := Sample{Device: d.Name, Task: t.Name, Time: time.Now(), Skipped: skipped}
+
+		select {
+		case slots <- struct{}{}:
+		case <-s.done:
+			return
+		}
+		sample.Value, sample.Err = d.Reader.GetAnyPoint(t.Points...)
+		<-slots
+		sample.Duration = time.Since(sample.Time)
+
+		select {
+		case s.samples <- sample:
+		case <-s.done:
+			return
+		}
+
+		// skip the intervals which passed while reading and delivering
+		now := time.Now()
+		next = next.Add(interval)
+		skipped = 0
+		for !next.After(now) {
+			next = next.Add(interval)
+			skipped++
+		}
+		timer.Reset(next.Sub(now) + jitter())
+	}
+}
+
+// Close stops polling and closes the channel of samples, waiting for running reads to finish.
+func (s *Scheduler) Close() error {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		close(s.done)
+		s.mu.Unlock()
+
+		s.wg.Wait()
+		close(s.samples)
+	})
+	return nil
+}
+
+// Run delivers the samples to the handler until the context is canceled, then closes the scheduler.
+//
+// Returns ErrClosed if the scheduler is closed by another goroutine.
+func (s *Scheduler) Run(ctx context.Context, handle func(Sample)) error {
+	defer s.Close()
+
+	for {
+		select {
+		case sample, ok := <-s.samples:
+			if !ok {
+				return ErrClosed
+			}
+			handle(sample)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package poll
+
+import (
+	"context"
+	"errors"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testReader returns the model of the first point as value after a delay, tracking concurrent reads.
+type testReader struct {
+	delay time.Duration
+	err   error
+
+	mu            sync.Mutex
+	reads         int
+	concurrent    int
+	maxConcurrent int
+}
+
+func (r *testReader) GetAnyPoint(ps ...sunspec.Point) (float64, error) {
+	r.mu.Lock()
+	r.reads++
+	r.concurrent++
+	if r.concurrent > r.maxConcurrent {
+		r.maxConcurrent = r.concurrent
+	}
+	r.mu.Unlock()
+
+	time.Sleep(r.delay)
+
+	r.mu.Lock()
+	r.concurrent--
+	r.mu.Unlock()
+
+	if r.err != nil {
+		return 0, r.err
+	}
+	return float64(ps[0].Model), nil
+}
+
+// collect returns the samples received within the duration.
+func collect(s *Scheduler, d time.Duration) []Sample {
+	var samples []Sample
+	timeout := time.After(d)
+	for {
+		select {
+		case sample := <-s.Samples():
+			samples = append(samples, sample)
+		case <-timeout:
+			return samples
+		}
+	}
+}
+
+func count(samples []Sample, task string) int {
+	var n int
+	for _, s := range samples {
+		if s.Task == task {
+			n++
+		}
+	}
+	return n
+}
+
+func TestScheduler_Intervals(t *testing.T) {
+	s := New(WithJitter(5 * time.Millisecond))
+	defer s.Close()
+
+	err := s.Add(Device{
+		Name:           "inverter",
+		Reader:         &testReader{},
+		Interval:       100 * time.Millisecond,
+		ModelIntervals: map[uint16]time.Duration{802: 50 * time.Millisecond},
+		MaxConcurrent:  3,
+		Tasks: []Task{
+			{Name: "fast", Points: []sunspec.Point{sunspec.PointPower3Phase}, Interval: 20 * time.Millisecond},
+			{Name: "model", Points: []sunspec.Point{sunspec.PointBatterySoc}},
+			{Name: "device", Points: []sunspec.Point{sunspec.PointEnergy3Phase}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples := collect(s, 410*time.Millisecond)
+
+	tests := map[string]struct {
+		min, max int
+	}{
+		"fast":   {15, 21},
+		"model":  {7, 9},
+		"device": {4, 5},
+	}
+	for task, test := range tests {
+		if n := count(samples, task); n < test.min || n > test.max {
+			t.Errorf("expected %v to %v samples of %v, got %v", test.min, test.max, task, n)
+		}
+	}
+
+	for _, sample := range samples {
+		if sample.Device != "inverter" || sample.Err != nil || sample.Time.IsZero() {
+			t.Fatalf("unexpected sample %v", sample)
+		}
+		if sample.Task == "model" && sample.Value != 802 {
+			t.Fatalf("unexpected value of sample %v", sample)
+		}
+	}
+}
+
+func TestScheduler_SlowDevice(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	// the device is too slow to read both tasks every interval
+	r := &testReader{delay: 30 * time.Millisecond}
+	tasks := []Task{
+		{Name: "a", Points: []sunspec.Point{sunspec.PointPower3Phase}},
+		{Name: "b", Points: []sunspec.Point{sunspec.PointEnergy3Phase}},
+	}
+	if err := s.Add(Device{Name: "inverter", Reader: r, Interval: 20 * time.Millisecond, Tasks: tasks}); err != nil {
+		t.Fatal(err)
+	}
+
+	samples := collect(s, 300*time.Millisecond)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxConcurrent != 1 {
+		t.Fatalf("expected sequential reads, got %v concurrent reads", r.maxConcurrent)
+	}
+
+	var skipped int
+	for _, sample := range samples {
+		skipped += sample.Skipped
+	}
+	if skipped == 0 || len(samples) > 11 {
+		t.Fatalf("expected skipped intervals instead of queued reads, got %v samples skipping %v intervals", len(samples), skipped)
+	}
+}
+
+func TestScheduler_SlowConsumer(t *testing.T) {
+	s := New(WithBuffer(0))
+	defer s.Close()
+
+	r := &testReader{err: errors.New("timeout")}
+	task := Task{Name: "a", Points: []sunspec.Point{sunspec.PointPower3Phase}}
+	if err := s.Add(Device{Name: "inverter", Reader: r, Interval: 10 * time.Millisecond, Tasks: []Task{task}}); err != nil {
+		t.Fatal(err)
+	}
+
+	first := <-s.Samples()
+	time.Sleep(55 * time.Millisecond)
+	second := <-s.Samples()
+	third := <-s.Samples()
+
+	if !errors.Is(first.Err, r.err) {
+		t.Fatalf("expected read error, got %v", first)
+	}
+	// the second sample was read before and the third after the consumer was blocked
+	if second.Time.Sub(first.Time) > 20*time.Millisecond || second.Skipped != 0 || third.Skipped < 3 {
+		t.Fatalf("unexpected samples %v, %v, %v", first, second, third)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.reads > 4 {
+		t.Fatalf("expected polling to wait for the consumer, got %v reads", r.reads)
+	}
+}
+
+func TestScheduler_Add(t *testing.T) {
+	s := New()
+
+	err := s.Add(Device{Name: "inverter", Reader: &testReader{}, Tasks: []Task{{Name: "a"}}})
+	if err == nil {
+		t.Fatal("expected error of a task without interval")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-s.Samples(); ok {
+		t.Fatal("expected closed samples")
+	}
+	if err := s.Add(Device{Name: "inverter", Reader: &testReader{}}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestScheduler_Run(t *testing.T) {
+	s := New()
+	task := Task{Name: "a", Points: []sunspec.Point{sunspec.PointPower3Phase}}
+	if err := s.Add(Device{Name: "inverter", Reader: &testReader{}, Interval: time.Millisecond, Tasks: []Task{task}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var n int
+	err := s.Run(ctx, func(Sample) {
+		if n++; n == 3 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) || n < 3 {
+		t.Fatalf("expected cancellation after 3 samples, got %v after %v samples", err, n)
+	}
+	if _, ok := <-s.Samples(); ok {
+		t.Fatal("expected closed samples")
+	}
+}
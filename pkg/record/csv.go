@@ -0,0 +1,245 @@
+package record
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ColumnTime is the column of the time of a record.
+	ColumnTime = "time"
+	// ColumnMeasurement is the column of the measurement of a record.
+	ColumnMeasurement = "measurement"
+)
+
+// CSVWriter writes records to CSV files, optionally rotating the files by the time of the records.
+//
+// Each file starts with a header of its columns, existing files are appended to using their header.
+type CSVWriter struct {
+	path       string
+	rotation   string
+	columns    []string
+	timeFormat string
+	comma      rune
+
+	mu     sync.Mutex
+	name   string
+	file   *os.File
+	w      *csv.Writer
+	header []string
+}
+
+// CSVOption configures a CSVWriter.
+type CSVOption func(*CSVWriter)
+
+// WithRotation rotates the files by the time layout, which is formatted with the time of each record and inserted
+// before the extension of the path, e.g. the layout 2006-01-02 writes energy.csv to energy-2023-05-01.csv and
+// rotates the files daily.
+func WithRotation(layout string) CSVOption {
+	return func(w *CSVWriter) {
+		w.rotation = layout
+	}
+}
+
+// WithColumns sets the columns of the files.
+//
+// A column contains the time for ColumnTime, the measurement for ColumnMeasurement and otherwise the tag or field
+// of the name. By default, the columns of a new file are the time, measurement, tags and fields of its first record.
+func WithColumns(columns ...string) CSVOption {
+	return func(w *CSVWriter) {
+		w.columns = columns
+	}
+}
+
+// WithTimeFormat sets the layout of the time column, time.RFC3339 by default.
+func WithTimeFormat(layout string) CSVOption {
+	return func(w *CSVWriter) {
+		w.timeFormat = layout
+	}
+}
+
+// WithComma sets the field delimiter, ',' by default.
+func WithComma(comma rune) CSVOption {
+	return func(w *CSVWriter) {
+		w.comma = comma
+	}
+}
+
+// NewCSVWriter creates a writer of the file at the path, which is opened on the first write.
+func NewCSVWriter(path string, opts ...CSVOption) *CSVWriter {
+	w := &CSVWriter{
+		path:       path,
+		timeFormat: time.RFC3339,
+		comma:      ',',
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w
+}
+
+// Write appends the records to the files of their times, records without a time are written with the current time.
+func (w *CSVWriter) Write(records ...Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, r := range records {
+		if r.Time.IsZero() {
+			r.Time = time.Now()
+		}
+
+		if name := w.fileName(r.Time); name != w.name || w.file == nil {
+			if err := w.open(name, r); err != nil {
+				return err
+			}
+		}
+
+		if err := w.w.Write(w.row(r)); err != nil {
+			return err
+		}
+	}
+
+	if w.w == nil {
+		return nil
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// fileName returns the name of the file of records at the time.
+func (w *CSVWriter) fileName(t time.Time) string {
+	if w.rotation == "" {
+		return w.path
+	}
+
+	ext := filepath.Ext(w.path)
+	return strings.TrimSuffix(w.path, ext) + "-" + t.Format(w.rotation) + ext
+}
+
+// open closes the current file and opens the named file, writing the header if the file is new.
+func (w *CSVWriter) open(name string, first Record) error {
+	if err := w.closeFile(); err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(name); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	header, err := w.readHeader(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	w.name, w.file = name, f
+	w.w = csv.NewWriter(f)
+	w.w.Comma = w.comma
+
+	if header != nil {
+		w.header = header
+		return nil
+	}
+
+	w.header = w.columns
+	if w.header == nil {
+		w.header = recordColumns(first)
+	}
+	return w.w.Write(w.header)
+}
+
+// readHeader returns the header of an existing file, nil if the file is empty.
+//
+// The header of an existing file takes precedence over the configured columns to keep the rows of a file consistent.
+func (w *CSVWriter) readHeader(f *os.File) ([]string, error) {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return nil, err
+	}
+
+	r := csv.NewReader(f)
+	r.Comma = w.comma
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	return header, err
+}
+
+// recordColumns returns the time, measurement, sorted tags and sorted fields of the record.
+func recordColumns(r Record) []string {
+	tags := make([]string, 0, len(r.Tags))
+	for k := range r.Tags {
+		tags = append(tags, k)
+	}
+	sort.Strings(tags)
+
+	fields := make([]string, 0, len(r.Fields))
+	for k := range r.Fields {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+
+	columns := []string{ColumnTime, ColumnMeasurement}
+	columns = append(columns, tags...)
+	return append(columns, fields...)
+}
+
+// row returns the values of the record in the columns of the current file, missing values are empty.
+func (w *CSVWriter) row(r Record) []string {
+	row := make([]string, len(w.header))
+	for i, c := range w.header {
+		switch c {
+		case ColumnTime:
+			row[i] = r.Time.Format(w.timeFormat)
+		case ColumnMeasurement:
+			row[i] = r.Measurement
+		default:
+			if v, ok := r.Tags[c]; ok {
+				row[i] = v
+			} else if v, ok := r.Fields[c]; ok {
+				row[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
+	}
+
+	return row
+}
+
+func (w *CSVWriter) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+
+	w.w.Flush()
+	err := w.w.Error()
+	if cerr := w.file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	w.file, w.w, w.header = nil, nil, nil
+	return err
+}
+
+// Close closes the current file.
+func (w *CSVWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.closeFile()
+}
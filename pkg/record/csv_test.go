@@ -0,0 +1,77 @@
+package record
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readFile(t *testing.T, name string) string {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCSVWriter_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	w := NewCSVWriter(filepath.Join(dir, "data", "energy.csv"), WithRotation("2006-01-02-15"))
+
+	day := time.Date(2023, 5, 1, 10, 30, 0, 0, time.UTC)
+	records := []Record{
+		{Measurement: "sunspec", Tags: map[string]string{"serial": "1"}, Fields: map[string]float64{"power": 1.5}, Time: day},
+		// a record of the same hour is appended, missing values are empty and additional values dropped
+		{Measurement: "sunspec", Fields: map[string]float64{"soc": 80}, Time: day.Add(time.Minute)},
+		{Measurement: "sunspec", Tags: map[string]string{"serial": "1"}, Fields: map[string]float64{"power": 2}, Time: day.Add(time.Hour)},
+	}
+	if err := w.Write(records...); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "time,measurement,serial,power\n" +
+		"2023-05-01T10:30:00Z,sunspec,1,1.5\n" +
+		"2023-05-01T10:31:00Z,sunspec,,\n"
+	if s := readFile(t, filepath.Join(dir, "data", "energy-2023-05-01-10.csv")); s != expected {
+		t.Fatalf("expected %q, got %q", expected, s)
+	}
+
+	expected = "time,measurement,serial,power\n" +
+		"2023-05-01T11:30:00Z,sunspec,1,2\n"
+	if s := readFile(t, filepath.Join(dir, "data", "energy-2023-05-01-11.csv")); s != expected {
+		t.Fatalf("expected %q, got %q", expected, s)
+	}
+}
+
+func TestCSVWriter_Append(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "energy.csv")
+	at := time.Unix(1700000000, 0)
+	r := Record{Measurement: "energy_meter", Tags: map[string]string{"serial": "1"}, Fields: map[string]float64{"power_import": 306.3}, Time: at}
+
+	w := NewCSVWriter(name, WithColumns("power_import", "serial"), WithComma(';'))
+	if err := w.Write(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the header of the existing file is kept
+	w = NewCSVWriter(name, WithComma(';'))
+	r.Fields["power_import"] = 100
+	if err := w.Write(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "power_import;serial\n306.3;1\n100;1\n"
+	if s := readFile(t, name); s != expected {
+		t.Fatalf("expected %q, got %q", expected, s)
+	}
+}
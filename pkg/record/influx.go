@@ -0,0 +1,222 @@
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// udpPayloadSize is the maximum size of the UDP datagrams of line protocol, fitting into the usual MTU.
+const udpPayloadSize = 1400
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// AppendLine appends the record in InfluxDB line protocol with nanosecond precision, terminated by a newline.
+//
+// Tags with empty values and fields with NaN or infinite values are omitted, the tags and fields are sorted by key.
+// Nothing is appended if the record has no fields.
+func AppendLine(b []byte, r Record) []byte {
+	fields := make([]string, 0, len(r.Fields))
+	for k, v := range r.Fields {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		fields = append(fields, k)
+	}
+	if len(fields) == 0 {
+		return b
+	}
+	sort.Strings(fields)
+
+	tags := make([]string, 0, len(r.Tags))
+	for k, v := range r.Tags {
+		if v != "" {
+			tags = append(tags, k)
+		}
+	}
+	sort.Strings(tags)
+
+	b = append(b, measurementEscaper.Replace(r.Measurement)...)
+	for _, k := range tags {
+		b = append(b, ',')
+		b = append(b, keyEscaper.Replace(k)...)
+		b = append(b, '=')
+		b = append(b, keyEscaper.Replace(r.Tags[k])...)
+	}
+	for i, k := range fields {
+		if i == 0 {
+			b = append(b, ' ')
+		} else {
+			b = append(b, ',')
+		}
+		b = append(b, keyEscaper.Replace(k)...)
+		b = append(b, '=')
+		b = strconv.AppendFloat(b, r.Fields[k], 'f', -1, 64)
+	}
+	if !r.Time.IsZero() {
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, r.Time.UnixNano(), 10)
+	}
+
+	return append(b, '\n')
+}
+
+// InfluxWriter writes records in InfluxDB line protocol, e.g. to a file or UDP listener of InfluxDB.
+type InfluxWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+	// maxPayload is the maximum size of each write, a write contains all lines of the records if it is 0.
+	maxPayload int
+}
+
+// NewInfluxWriter creates a writer writing the lines of each call to Write at once to w.
+func NewInfluxWriter(w io.Writer) *InfluxWriter {
+	return &InfluxWriter{w: w}
+}
+
+// OpenInfluxFile creates a writer appending to the file, which is created if it does not exist.
+func OpenInfluxFile(name string) (*InfluxWriter, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewInfluxWriter(f), nil
+}
+
+// DialInfluxUDP creates a writer sending the lines to the UDP listener of InfluxDB at the address.
+//
+// Lines are sent in datagrams of at most 1400 bytes, larger lines are sent in a datagram on their own.
+func DialInfluxUDP(addr string) (*InfluxWriter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &InfluxWriter{w: conn, maxPayload: udpPayloadSize}, nil
+}
+
+// Write writes the records.
+func (w *InfluxWriter) Write(records ...Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var b []byte
+	for _, r := range records {
+		n := len(b)
+		b = AppendLine(b, r)
+		if w.maxPayload == 0 || len(b) <= w.maxPayload || n == 0 {
+			continue
+		}
+
+		if _, err := w.w.Write(b[:n]); err != nil {
+			return err
+		}
+		b = append(b[:0], b[n:]...)
+	}
+
+	if len(b) == 0 {
+		return nil
+	}
+	_, err := w.w.Write(b)
+	return err
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (w *InfluxWriter) Close() error {
+	if c, ok := w.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// InfluxHTTPWriter writes records to the HTTP write endpoint of InfluxDB.
+type InfluxHTTPWriter struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// HTTPOption configures an InfluxHTTPWriter.
+type HTTPOption func(*InfluxHTTPWriter)
+
+// WithToken sets the API token of InfluxDB 2.
+func WithToken(token string) HTTPOption {
+	return func(w *InfluxHTTPWriter) {
+		w.token = token
+	}
+}
+
+// WithHTTPClient sets the HTTP client, by default a client with a timeout of 10s is used.
+func WithHTTPClient(c *http.Client) HTTPOption {
+	return func(w *InfluxHTTPWriter) {
+		w.client = c
+	}
+}
+
+// NewInfluxHTTPWriter creates a writer posting to the write endpoint at the url including its query, e.g.
+// http://localhost:8086/api/v2/write?org=home&bucket=energy for InfluxDB 2 or
+// http://localhost:8086/write?db=energy for InfluxDB 1.
+func NewInfluxHTTPWriter(url string, opts ...HTTPOption) *InfluxHTTPWriter {
+	w := &InfluxHTTPWriter{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w
+}
+
+// Write posts the records in one request.
+func (w *InfluxHTTPWriter) Write(records ...Record) error {
+	var b []byte
+	for _, r := range records {
+		b = AppendLine(b, r)
+	}
+	if len(b) == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influx write failed with status %v: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// Close closes idle connections of the HTTP client.
+func (w *InfluxHTTPWriter) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,147 @@
+package record
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendLine(t *testing.T) {
+	at := time.Unix(1700000000, 5)
+
+	tests := map[string]struct {
+		record Record
+		line   string
+	}{
+		"tags and fields": {
+			Record{
+				Measurement: "energy_meter",
+				Tags:        map[string]string{"serial": "123", "susy_id": "349"},
+				Fields:      map[string]float64{"power_import": 306.3, "energy_import_total": 1000},
+				Time:        at,
+			},
+			"energy_meter,serial=123,susy_id=349 energy_import_total=1000,power_import=306.3 1700000000000000005\n",
+		},
+		"escaping": {
+			Record{
+				Measurement: "my meter,1",
+				Tags:        map[string]string{"model": "STP 10.0", "a=b": "c,d"},
+				Fields:      map[string]float64{"power w": 1},
+			},
+			`my\ meter\,1,a\=b=c\,d,model=STP\ 10.0 power\ w=1` + "\n",
+		},
+		"omitted values": {
+			Record{
+				Measurement: "sunspec",
+				Tags:        map[string]string{"serial": ""},
+				Fields:      map[string]float64{"power": -5, "soc": math.NaN(), "energy": math.Inf(1)},
+			},
+			"sunspec power=-5\n",
+		},
+		"no fields": {
+			Record{Measurement: "sunspec", Fields: map[string]float64{"soc": math.NaN()}},
+			"",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if line := string(AppendLine(nil, test.record)); line != test.line {
+				t.Fatalf("expected %q, got %q", test.line, line)
+			}
+		})
+	}
+}
+
+func TestInfluxWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := NewInfluxWriter(&b)
+
+	records := []Record{
+		{Measurement: "a", Fields: map[string]float64{"v": 1}},
+		{Measurement: "b", Fields: map[string]float64{"v": 2}},
+	}
+	if err := w.Write(records...); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := b.String(); s != "a v=1\nb v=2\n" {
+		t.Fatalf("unexpected lines %q", s)
+	}
+}
+
+func TestDialInfluxUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	w, err := DialInfluxUDP(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// 40 lines of 52 bytes exceed the payload of a single datagram
+	var records []Record
+	for i := 0; i < 40; i++ {
+		records = append(records, Record{Measurement: "m", Fields: map[string]float64{strings.Repeat("f", 45): 1}})
+	}
+	if err := w.Write(records...); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines int
+	buf := make([]byte, 64*1024)
+	for lines < len(records) {
+		if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("received %v lines: %v", lines, err)
+		}
+		if n > udpPayloadSize {
+			t.Fatalf("datagram of %v bytes exceeds the payload size", n)
+		}
+		lines += bytes.Count(buf[:n], []byte("\n"))
+	}
+}
+
+func TestInfluxHTTPWriter(t *testing.T) {
+	var body, auth, query string
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body, auth, query = string(b), r.Header.Get("Authorization"), r.URL.RawQuery
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("bucket not found"))
+	}))
+	defer srv.Close()
+
+	w := NewInfluxHTTPWriter(srv.URL+"/api/v2/write?org=home&bucket=energy", WithToken("secret"))
+	defer w.Close()
+
+	if err := w.Write(Record{Measurement: "m", Fields: map[string]float64{"v": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if body != "m v=1\n" || auth != "Token secret" || query != "org=home&bucket=energy" {
+		t.Fatalf("unexpected request body %q, authorization %q, query %q", body, auth, query)
+	}
+
+	status = http.StatusNotFound
+	err := w.Write(Record{Measurement: "m", Fields: map[string]float64{"v": 1}})
+	if err == nil || !strings.Contains(err.Error(), "bucket not found") {
+		t.Fatalf("expected error containing the response, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+// Provides writers storing energy meter telegrams and SunSpec values in InfluxDB line protocol and CSV files.
+package record
+
+import (
+	"errors"
+	"fmt"
+	"github.com/orlopau/go-energy/pkg/meter"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"time"
+)
+
+const (
+	// MeasurementEnergyMeter is the measurement of energy meter telegrams.
+	MeasurementEnergyMeter = "energy_meter"
+	// MeasurementSunSpec is the measurement of SunSpec values.
+	MeasurementSunSpec = "sunspec"
+)
+
+// Record is a snapshot of the values of a device at a point in time.
+type Record struct {
+	Measurement string
+	// Tags identify the device, e.g. its serial number.
+	Tags   map[string]string
+	Fields map[string]float64
+	Time   time.Time
+}
+
+// Writer writes records, e.g. an *InfluxWriter or a *CSVWriter.
+type Writer interface {
+	Write(records ...Record) error
+	Close() error
+}
+
+// FromTelegram creates a record of the registered values of an energy meter telegram received at the time.
+//
+// The fields are named by the OBIS registry, e.g. active_power_import, the record is tagged by the serial and SUSy ID.
+func FromTelegram(t *meter.EnergyMeterTelegram, at time.Time) Record {
+	r := Record{
+		Measurement: MeasurementEnergyMeter,
+		Tags: map[string]string{
+			"serial":  fmt.Sprint(t.SerialNo),
+			"susy_id": fmt.Sprint(t.SusyID),
+		},
+		Fields: make(map[string]float64, len(t.Obis)),
+		Time:   at,
+	}
+
+	for id := range t.Obis {
+		info, ok := meter.LookupOBIS(id)
+		if !ok || id == meter.OBISSoftwareVersion {
+			continue
+		}
+		r.Fields[info.Name], _ = t.Value(id)
+	}
+
+	return r
+}
+
+// SunSpecField is a field of SunSpec records.
+type SunSpecField struct {
+	Name string
+	// Points are alternatives of the value in different models, the first point implemented by the device is used.
+	Points []sunspec.Point
+}
+
+// DefaultSunSpecFields are the fields of inverters and batteries.
+var DefaultSunSpecFields = []SunSpecField{
+	{Name: "power", Points: []sunspec.Point{sunspec.PointPower1Phase, sunspec.PointPower2Phase, sunspec.PointPower3Phase}},
+	{Name: "energy_total", Points: []sunspec.Point{sunspec.PointEnergy1Phase, sunspec.PointEnergy2Phase, sunspec.PointEnergy3Phase}},
+	{Name: "battery_soc", Points: []sunspec.Point{sunspec.PointBatterySoc, sunspec.PointSoc}},
+	{Name: "battery_power", Points: []sunspec.Point{sunspec.PointBatteryPower}},
+}
+
+// sunSpecReader reads the identification and points of a SunSpec device, e.g. a *sunspec.ModbusDevice.
+type sunSpecReader interface {
+	ReadCommonModel() (sunspec.CommonModel, error)
+	GetAnyPoint(ps ...sunspec.Point) (float64, error)
+}
+
+// ReadSunSpec reads the fields of a SunSpec device into a record at the time, fields not implemented by the
+// device are omitted.
+//
+// The record is tagged by the serial number, manufacturer and model of the common model.
+func ReadSunSpec(d sunSpecReader, fields []SunSpecField, at time.Time) (Record, error) {
+	common, err := d.ReadCommonModel()
+	if err != nil {
+		return Record{}, err
+	}
+
+	r := Record{
+		Measurement: MeasurementSunSpec,
+		Tags: map[string]string{
+			"serial":       common.SerialNumber,
+			"manufacturer": common.Manufacturer,
+			"model":        common.Model,
+		},
+		Fields: make(map[string]float64, len(fields)),
+		Time:   at,
+	}
+
+	for _, f := range fields {
+		v, err := d.GetAnyPoint(f.Points...)
+		if errors.Is(err, sunspec.ErrPointNotImplemented) {
+			continue
+		}
+		if err != nil {
+			return Record{}, err
+		}
+		r.Fields[f.Name] = v
+	}
+
+	return r, nil
+}
+
+// telegramReader reads telegrams, e.g. a *meter.EnergyMeter.
+type telegramReader interface {
+	ReadTelegram() (*meter.EnergyMeterTelegram, error)
+}
+
+// WriteTelegrams writes a record of each telegram read from the energy meter until reading or writing fails.
+//
+// Datagrams which are not valid telegrams are skipped.
+func WriteTelegrams(m telegramReader, w Writer) error {
+	for {
+		t, err := m.ReadTelegram()
+		if meter.IsSkippable(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := w.Write(FromTelegram(t, time.Now())); err != nil {
+			return err
+		}
+	}
+}
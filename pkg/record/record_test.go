@@ -0,0 +1,133 @@
+package record
+
+import (
+	"errors"
+	"github.com/orlopau/go-energy/pkg/meter"
+	"github.com/orlopau/go-energy/pkg/pcap"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// testTelegram returns a telegram of a meter importing 306.3 W.
+func testTelegram() *meter.EnergyMeterTelegram {
+	return &meter.EnergyMeterTelegram{
+		SusyID:   349,
+		SerialNo: 1901401956,
+		Obis: map[meter.OBISIdentifier]uint64{
+			meter.OBISPowerImport:     3063,
+			meter.OBISEnergyImport:    12760257654,
+			meter.OBISSoftwareVersion: 0x02001252,
+			// unregistered identifiers are omitted
+			{Channel: 0, MeasVal: 99, MeasType: 4}: 1,
+		},
+	}
+}
+
+func TestFromTelegram(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	r := FromTelegram(testTelegram(), at)
+
+	if r.Measurement != MeasurementEnergyMeter || !r.Time.Equal(at) {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	if r.Tags["serial"] != "1901401956" || r.Tags["susy_id"] != "349" {
+		t.Fatalf("unexpected tags %v", r.Tags)
+	}
+	if len(r.Fields) != 2 || r.Fields["active_power_import"] != 306.3 || r.Fields["active_power_import_total"] != 3544516.015 {
+		t.Fatalf("unexpected fields %v", r.Fields)
+	}
+}
+
+// testSunSpecReader implements sunSpecReader using fixed point values.
+type testSunSpecReader struct {
+	points map[sunspec.Point]float64
+	err    error
+}
+
+func (r *testSunSpecReader) ReadCommonModel() (sunspec.CommonModel, error) {
+	return sunspec.CommonModel{Manufacturer: "SMA", Model: "STP 10.0", SerialNumber: "3000123456"}, nil
+}
+
+func (r *testSunSpecReader) GetAnyPoint(ps ...sunspec.Point) (float64, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	for _, p := range ps {
+		if v, ok := r.points[p]; ok {
+			return v, nil
+		}
+	}
+	return 0, sunspec.ErrPointNotImplemented
+}
+
+func TestReadSunSpec(t *testing.T) {
+	d := &testSunSpecReader{points: map[sunspec.Point]float64{
+		sunspec.PointPower3Phase:  1234,
+		sunspec.PointEnergy3Phase: 56789,
+	}}
+
+	r, err := ReadSunSpec(d, DefaultSunSpecFields, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Measurement != MeasurementSunSpec || r.Tags["serial"] != "3000123456" || r.Tags["model"] != "STP 10.0" {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	if len(r.Fields) != 2 || r.Fields["power"] != 1234 || r.Fields["energy_total"] != 56789 {
+		t.Fatalf("unexpected fields %v", r.Fields)
+	}
+
+	d.err = errors.New("connection reset")
+	if _, err := ReadSunSpec(d, DefaultSunSpecFields, time.Time{}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// testWriter collects written records.
+type testWriter struct {
+	records []Record
+}
+
+func (w *testWriter) Write(records ...Record) error {
+	w.records = append(w.records, records...)
+	return nil
+}
+
+func (w *testWriter) Close() error {
+	return nil
+}
+
+func TestWriteTelegrams(t *testing.T) {
+	f, err := os.Open("../meter/testdata/telegrams.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	conn, err := pcap.NewReplayConn(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Port = 9522
+
+	w := &testWriter{}
+	if err := WriteTelegrams(&meter.EnergyMeter{Conn: conn}, w); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF at the end of the capture, got %v", err)
+	}
+
+	if len(w.records) != 3 {
+		t.Fatalf("expected 3 records, got %v", len(w.records))
+	}
+	for _, r := range w.records {
+		if r.Tags["serial"] != "1901401956" || r.Time.IsZero() {
+			t.Fatalf("unexpected record %+v", r)
+		}
+	}
+	if p := w.records[0].Fields["active_power_import"]; p != 306.3 {
+		t.Fatalf("expected import power of 306.3 W, got %v", p)
+	}
+}
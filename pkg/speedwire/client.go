@@ -0,0 +1,248 @@
+package speedwire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Port is the UDP port used by Speedwire devices.
+	Port = 9522
+
+	defaultTimeout        = 5 * time.Second
+	appSusyID      uint16 = 125
+
+	cmdIdentify uint32 = 0x00000200
+	cmdLogin    uint32 = 0xfffd040c
+	cmdLogout   uint32 = 0xfffd010e
+
+	controlLogin  uint16 = 0x0100
+	controlLogout uint16 = 0x0300
+
+	// loginTimeout is the number of seconds after which the device ends the session.
+	loginTimeout   uint32 = 900
+	passwordLength        = 12
+)
+
+// UserGroup is the group of the user logging in.
+type UserGroup uint32
+
+const (
+	UserGroupUser      UserGroup = 0x07
+	UserGroupInstaller UserGroup = 0x0a
+)
+
+var (
+	ErrInvalidPassword = errors.New("invalid password")
+	// ErrRequestFailed is returned if the device answers a request with an error code.
+	ErrRequestFailed = errors.New("request failed")
+)
+
+// Client represents a Speedwire connection to a single SMA device.
+type Client struct {
+	conn        net.Conn
+	timeout     time.Duration
+	appSerialNo uint32
+	susyID      uint16
+	serialNo    uint32
+
+	mu       sync.Mutex
+	packetID uint16
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithTimeout sets the time to wait for the response to a request.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// Connect connects to the Speedwire device at the given address and queries its SUSyID and serial number.
+//
+// If the address does not contain a port, the Speedwire port 9522 is used.
+func Connect(addr string, opts ...Option) (*Client, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, strconv.Itoa(Port))
+	}
+
+	conn, err := net.Dial("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newClient(conn, opts...)
+	if err := c.identify(); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("identifying device: %w", err)
+	}
+
+	return c, nil
+}
+
+func newClient(conn net.Conn, opts ...Option) *Client {
+	c := &Client{
+		conn:        conn,
+		timeout:     defaultTimeout,
+		appSerialNo: 900000000 + uint32(rand.Int31n(100000000)),
+		susyID:      AnySusyID,
+		serialNo:    AnySerialNo,
+		packetID:    uint16(rand.Intn(int(packetIDMarker))),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// SusyID returns the SUSyID of the connected device.
+func (c *Client) SusyID() uint16 {
+	return c.susyID
+}
+
+// SerialNo returns the serial number of the connected device.
+func (c *Client) SerialNo() uint32 {
+	return c.serialNo
+}
+
+func (c *Client) identify() error {
+	res, err := c.request(&Packet{Command: cmdIdentify, Payload: make([]byte, 8)})
+	if err != nil {
+		return err
+	}
+
+	c.susyID, c.serialNo = res.SrcSusyID, res.SrcSerialNo
+	return nil
+}
+
+// Login logs in with the password of the given user group.
+//
+// Most values can only be read after logging in.
+func (c *Client) Login(group UserGroup, password string) error {
+	if len(password) > passwordLength {
+		return fmt.Errorf("%w: password must not be longer than %d characters", ErrInvalidPassword, passwordLength)
+	}
+
+	payload := make([]byte, 16+passwordLength)
+	binary.LittleEndian.PutUint32(payload[0:4], uint32(group))
+	binary.LittleEndian.PutUint32(payload[4:8], loginTimeout)
+	binary.LittleEndian.PutUint32(payload[8:12], uint32(time.Now().Unix()))
+
+	// the password is encoded by adding a group specific value to each character, including the padding
+	enc := byte(0x88)
+	if group != UserGroupUser {
+		enc = 0xbb
+	}
+	pw := payload[16:]
+	for i := range pw {
+		pw[i] = enc
+		if i < len(password) {
+			pw[i] += password[i]
+		}
+	}
+
+	_, err := c.request(&Packet{Control: controlLogin, Command: cmdLogin, Payload: payload})
+	if errors.Is(err, ErrRequestFailed) {
+		return fmt.Errorf("%w: %v", ErrInvalidPassword, err)
+	}
+
+	return err
+}
+
+// Logout ends the session of the current login.
+//
+// The device does not answer a logout, so only errors sending the request are returned.
+func (c *Client) Logout() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := &Packet{Control: controlLogout, Command: cmdLogout, Payload: []byte{0xff, 0xff, 0xff, 0xff}}
+	b, err := c.marshal(p)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.conn.Write(b)
+	return err
+}
+
+// Query requests the records with a logical record index in the range first to last using the given command.
+func (c *Client) Query(command, first, last uint32) ([]Record, error) {
+	payload := make([]byte, 8)
+	binary.LittleEndian.PutUint32(payload[0:4], first)
+	binary.LittleEndian.PutUint32(payload[4:8], last)
+
+	res, err := c.request(&Packet{Command: command, Payload: payload})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Records()
+}
+
+// marshal fills in the addresses and next packet id of the request and encodes it.
+func (c *Client) marshal(p *Packet) ([]byte, error) {
+	c.packetID = (c.packetID + 1) &^ packetIDMarker
+
+	p.DstSusyID, p.DstSerialNo = AnySusyID, AnySerialNo
+	if p.Command != cmdLogin && p.Command != cmdLogout {
+		p.DstSusyID, p.DstSerialNo = c.susyID, c.serialNo
+	}
+	p.SrcSusyID, p.SrcSerialNo = appSusyID, c.appSerialNo
+	p.PacketID = c.packetID
+
+	return p.MarshalBinary()
+}
+
+// request sends the request and waits for the response with the matching packet id.
+func (c *Client) request(p *Packet) (*Packet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := c.conn.Write(b); err != nil {
+		return nil, err
+	}
+
+	if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := ParsePacket(buf[:n])
+		if err != nil || res.PacketID != p.PacketID || res.DstSerialNo != c.appSerialNo {
+			continue
+		}
+
+		if res.ErrorCode != 0 {
+			return nil, fmt.Errorf("%w: command %#08x returned error code %#04x", ErrRequestFailed, p.Command, res.ErrorCode)
+		}
+
+		return res, nil
+	}
+}
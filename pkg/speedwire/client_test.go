@@ -0,0 +1,202 @@
+package speedwire
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	testSusyID   uint16 = 372
+	testSerialNo uint32 = 3000123456
+	testPassword        = "0000"
+)
+
+// testInverter answers Speedwire requests like an SMA inverter.
+type testInverter struct {
+	conn *net.UDPConn
+	// records contains the records returned for a command, a query returns the records within the requested range.
+	records map[uint32][][]byte
+}
+
+func startTestInverter(t *testing.T, records map[uint32][][]byte) *testInverter {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inverter := &testInverter{conn: conn, records: records}
+	go inverter.serve()
+
+	return inverter
+}
+
+func (i *testInverter) addr() string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(i.conn.LocalAddr().(*net.UDPAddr).Port))
+}
+
+func (i *testInverter) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := i.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		req, err := ParsePacket(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		res := &Packet{
+			Control:     req.Control,
+			DstSusyID:   req.SrcSusyID,
+			DstSerialNo: req.SrcSerialNo,
+			SrcSusyID:   testSusyID,
+			SrcSerialNo: testSerialNo,
+			PacketID:    req.PacketID,
+			Command:     req.Command + 1,
+		}
+
+		switch req.Command {
+		case cmdIdentify:
+			res.Payload = make([]byte, 8)
+		case cmdLogin:
+			if i.password(req.Payload) != testPassword {
+				res.ErrorCode = 0x0100
+			}
+			res.Payload = req.Payload
+		case cmdLogout:
+			continue
+		default:
+			if req.DstSerialNo != testSerialNo {
+				continue
+			}
+			res.Payload = i.query(req.Command, req.Payload)
+			if res.Payload == nil {
+				res.ErrorCode = 0x0015
+				res.Payload = make([]byte, 8)
+			}
+		}
+
+		b, err := res.MarshalBinary()
+		if err != nil {
+			return
+		}
+		if _, err := i.conn.WriteToUDP(b, addr); err != nil {
+			return
+		}
+	}
+}
+
+// password decodes the password of a login request.
+func (i *testInverter) password(payload []byte) string {
+	enc := byte(0x88)
+	if UserGroup(binary.LittleEndian.Uint32(payload)) != UserGroupUser {
+		enc = 0xbb
+	}
+
+	var pw []byte
+	for _, c := range payload[16:] {
+		if c == enc {
+			break
+		}
+		pw = append(pw, c-enc)
+	}
+	return string(pw)
+}
+
+func (i *testInverter) query(command uint32, payload []byte) []byte {
+	first := binary.LittleEndian.Uint32(payload[0:4])
+	last := binary.LittleEndian.Uint32(payload[4:8])
+
+	var records [][]byte
+	for _, r := range i.records[command] {
+		if code := binary.LittleEndian.Uint32(r) & 0x00ffffff; code >= first && code <= last {
+			records = append(records, r)
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	return recordPayload(records...)
+}
+
+func (i *testInverter) Close() error {
+	return i.conn.Close()
+}
+
+func TestConnect(t *testing.T) {
+	inverter := startTestInverter(t, nil)
+	defer inverter.Close()
+
+	c, err := Connect(inverter.addr(), WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if c.SusyID() != testSusyID || c.SerialNo() != testSerialNo {
+		t.Fatalf("expected %d/%d, got %d/%d", testSusyID, testSerialNo, c.SusyID(), c.SerialNo())
+	}
+}
+
+// TestConnect_NoDevice verifies that an error is returned if no device answers.
+func TestConnect_NoDevice(t *testing.T) {
+	_, err := Connect("127.0.0.1", WithTimeout(100*time.Millisecond))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestClient_Login(t *testing.T) {
+	tt := map[string]struct {
+		group    UserGroup
+		password string
+		want     error
+	}{
+		"user":           {UserGroupUser, testPassword, nil},
+		"installer":      {UserGroupInstaller, testPassword, nil},
+		"wrong password": {UserGroupUser, "1234", ErrInvalidPassword},
+		"too long":       {UserGroupUser, "0123456789abc", ErrInvalidPassword},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			inverter := startTestInverter(t, nil)
+			defer inverter.Close()
+
+			c, err := Connect(inverter.addr(), WithTimeout(time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			if err := c.Login(tc.group, tc.password); !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+			if err := c.Logout(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestClient_Query_Unavailable(t *testing.T) {
+	inverter := startTestInverter(t, nil)
+	defer inverter.Close()
+
+	c, err := Connect(inverter.addr(), WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.GridFrequency(); !errors.Is(err, ErrRequestFailed) {
+		t.Fatalf("expected %v, got %v", ErrRequestFailed, err)
+	}
+}
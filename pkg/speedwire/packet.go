@@ -0,0 +1,178 @@
+// Provides a client for the SMA Speedwire data protocol (SMA-Data2+) used by SMA inverters.
+package speedwire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	startIdentifier        = "SMA\x00"
+	tagGroup        uint16 = 0x02a0
+	tagData         uint16 = 0x0010
+	group           uint32 = 1
+
+	// ProtocolID is the protocol identifier of SMA-Data2+ packets.
+	ProtocolID uint16 = 0x6065
+
+	ctrlRequest    uint8  = 0xa0
+	packetIDMarker uint16 = 0x8000
+
+	// headerLength is the length of the SMA header up to the protocol identifier.
+	headerLength = 18
+	// dataHeaderLength is the length of the SMA-Data2+ header including the command.
+	dataHeaderLength   = 28
+	recordHeaderLength = 8
+)
+
+// AnySusyID and AnySerialNo address any device.
+const (
+	AnySusyID   uint16 = 0xffff
+	AnySerialNo uint32 = 0xffffffff
+)
+
+var (
+	ErrBadMagic            = errors.New("packet does not start with the SMA identifier")
+	ErrUnsupportedProtocol = errors.New("unsupported protocol")
+	ErrTruncated           = errors.New("truncated packet")
+)
+
+// Packet is an SMA-Data2+ packet transported via Speedwire.
+type Packet struct {
+	// Control is the control word of the packet, e.g. 0x0100 for a login.
+	Control     uint16
+	DstSusyID   uint16
+	DstSerialNo uint32
+	SrcSusyID   uint16
+	SrcSerialNo uint32
+	ErrorCode   uint16
+	Fragment    uint16
+	PacketID    uint16
+	Command     uint32
+	// Payload contains the data following the command, e.g. the requested range and the records.
+	Payload []byte
+}
+
+// Record is a single value record of a response.
+type Record struct {
+	// LRI is the logical record index identifying the value.
+	LRI uint32
+	// Class is the class of the value, e.g. the number of the DC input.
+	Class    uint8
+	DataType uint8
+	Time     time.Time
+	// Data contains the bytes of the record following the timestamp.
+	Data []byte
+}
+
+// MarshalBinary encodes the packet including the SMA header.
+func (p *Packet) MarshalBinary() ([]byte, error) {
+	if len(p.Payload)%4 != 0 {
+		return nil, fmt.Errorf("payload length %d is not a multiple of 4", len(p.Payload))
+	}
+
+	dataLength := 2 + dataHeaderLength + len(p.Payload)
+	if dataLength > 0xffff || (dataLength-2)/4 > 0xff {
+		return nil, fmt.Errorf("payload length %d too large", len(p.Payload))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(startIdentifier)
+	_ = binary.Write(&buf, binary.BigEndian, []uint16{4, tagGroup})
+	_ = binary.Write(&buf, binary.BigEndian, group)
+	_ = binary.Write(&buf, binary.BigEndian, []uint16{uint16(dataLength), tagData, ProtocolID})
+
+	buf.WriteByte(byte((dataLength - 2) / 4))
+	buf.WriteByte(ctrlRequest)
+	_ = binary.Write(&buf, binary.LittleEndian, p.DstSusyID)
+	_ = binary.Write(&buf, binary.LittleEndian, p.DstSerialNo)
+	_ = binary.Write(&buf, binary.LittleEndian, p.Control)
+	_ = binary.Write(&buf, binary.LittleEndian, p.SrcSusyID)
+	_ = binary.Write(&buf, binary.LittleEndian, p.SrcSerialNo)
+	_ = binary.Write(&buf, binary.LittleEndian, []uint16{p.Control, p.ErrorCode, p.Fragment, p.PacketID | packetIDMarker})
+	_ = binary.Write(&buf, binary.LittleEndian, p.Command)
+	buf.Write(p.Payload)
+
+	// end tag
+	_ = binary.Write(&buf, binary.BigEndian, uint32(0))
+
+	return buf.Bytes(), nil
+}
+
+// ParsePacket decodes an SMA-Data2+ packet.
+//
+// Returns ErrBadMagic, ErrUnsupportedProtocol or ErrTruncated if the datagram is not a valid packet.
+func ParsePacket(b []byte) (*Packet, error) {
+	if len(b) < len(startIdentifier) || !bytes.Equal(b[:len(startIdentifier)], []byte(startIdentifier)) {
+		return nil, ErrBadMagic
+	}
+	if len(b) < headerLength {
+		return nil, fmt.Errorf("%w: header needs %d bytes, got %d", ErrTruncated, headerLength, len(b))
+	}
+	if tag := binary.BigEndian.Uint16(b[14:16]); tag != tagData {
+		return nil, fmt.Errorf("%w: tag %#04x", ErrUnsupportedProtocol, tag)
+	}
+	if id := binary.BigEndian.Uint16(b[16:18]); id != ProtocolID {
+		return nil, fmt.Errorf("%w: expected %#04x as protocol identifier but got %#04x", ErrUnsupportedProtocol, ProtocolID, id)
+	}
+
+	end := 16 + int(binary.BigEndian.Uint16(b[12:14]))
+	if end > len(b) || end < headerLength+dataHeaderLength {
+		return nil, fmt.Errorf("%w: data length %d", ErrTruncated, end-16)
+	}
+
+	d := b[headerLength:end]
+	return &Packet{
+		DstSusyID:   binary.LittleEndian.Uint16(d[2:4]),
+		DstSerialNo: binary.LittleEndian.Uint32(d[4:8]),
+		Control:     binary.LittleEndian.Uint16(d[8:10]),
+		SrcSusyID:   binary.LittleEndian.Uint16(d[10:12]),
+		SrcSerialNo: binary.LittleEndian.Uint32(d[12:16]),
+		ErrorCode:   binary.LittleEndian.Uint16(d[18:20]),
+		Fragment:    binary.LittleEndian.Uint16(d[20:22]),
+		PacketID:    binary.LittleEndian.Uint16(d[22:24]) &^ packetIDMarker,
+		Command:     binary.LittleEndian.Uint32(d[24:28]),
+		Payload:     append([]byte(nil), d[dataHeaderLength:]...),
+	}, nil
+}
+
+// Records decodes the records of a response to a query.
+//
+// The payload of a response starts with the index of the first and last record, followed by the records
+// which all have the same length.
+func (p *Packet) Records() ([]Record, error) {
+	if len(p.Payload) < 8 {
+		return nil, fmt.Errorf("%w: missing record range", ErrTruncated)
+	}
+
+	first := binary.LittleEndian.Uint32(p.Payload[0:4])
+	last := binary.LittleEndian.Uint32(p.Payload[4:8])
+	data := p.Payload[8:]
+	if last < first || len(data) == 0 {
+		return nil, nil
+	}
+
+	count := int(last-first) + 1
+	size := len(data) / count
+	if size < recordHeaderLength || len(data)%count != 0 {
+		return nil, fmt.Errorf("%w: %d bytes for %d records", ErrTruncated, len(data), count)
+	}
+
+	records := make([]Record, count)
+	for i := range records {
+		r := data[i*size : (i+1)*size]
+		code := binary.LittleEndian.Uint32(r[0:4])
+		records[i] = Record{
+			LRI:      code >> 8 & 0xffff,
+			Class:    uint8(code),
+			DataType: uint8(code >> 24),
+			Time:     time.Unix(int64(binary.LittleEndian.Uint32(r[4:8])), 0),
+			Data:     r[recordHeaderLength:],
+		}
+	}
+
+	return records, nil
+}
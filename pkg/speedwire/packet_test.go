@@ -0,0 +1,138 @@
+package speedwire
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// encodeRecord encodes a record with the given values padded to size bytes.
+func encodeRecord(size int, dataType uint8, lri uint32, class uint8, values ...uint32) []byte {
+	b := make([]byte, size)
+	binary.LittleEndian.PutUint32(b[0:4], uint32(dataType)<<24|lri<<8|uint32(class))
+	binary.LittleEndian.PutUint32(b[4:8], 1600000000)
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(b[8+i*4:], v)
+	}
+	return b
+}
+
+// recordPayload encodes the payload of a response containing the given records.
+func recordPayload(records ...[]byte) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b[4:8], uint32(len(records)-1))
+	for _, r := range records {
+		b = append(b, r...)
+	}
+	return b
+}
+
+func TestPacket_MarshalBinary(t *testing.T) {
+	p := &Packet{
+		Control:     controlLogin,
+		DstSusyID:   AnySusyID,
+		DstSerialNo: AnySerialNo,
+		SrcSusyID:   appSusyID,
+		SrcSerialNo: 900000001,
+		PacketID:    0x0102,
+		Command:     cmdLogout,
+		Payload:     []byte{0xff, 0xff, 0xff, 0xff},
+	}
+
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b) != headerLength+dataHeaderLength+len(p.Payload)+4 {
+		t.Fatalf("unexpected length %d", len(b))
+	}
+	if l := binary.BigEndian.Uint16(b[12:14]); int(l) != 2+dataHeaderLength+len(p.Payload) {
+		t.Fatalf("unexpected data length %d", l)
+	}
+	if b[18] != byte((dataHeaderLength+len(p.Payload))/4) || b[19] != ctrlRequest {
+		t.Fatalf("unexpected long words %d or control %#x", b[18], b[19])
+	}
+	if id := binary.LittleEndian.Uint16(b[40:42]); id != 0x8102 {
+		t.Fatalf("expected packet id with marker, got %#x", id)
+	}
+
+	parsed, err := ParsePacket(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p, parsed) {
+		t.Fatalf("expected %+v, got %+v", p, parsed)
+	}
+}
+
+func TestPacket_MarshalBinary_InvalidPayload(t *testing.T) {
+	if _, err := (&Packet{Payload: []byte{1, 2, 3}}).MarshalBinary(); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestParsePacket_Errors(t *testing.T) {
+	valid, err := (&Packet{Command: cmdIdentify, Payload: make([]byte, 8)}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modified := func(offset int, value ...byte) []byte {
+		b := append([]byte(nil), valid...)
+		copy(b[offset:], value)
+		return b
+	}
+
+	tt := map[string]struct {
+		in   []byte
+		want error
+	}{
+		"empty":            {nil, ErrBadMagic},
+		"bad magic":        {modified(0, 'X'), ErrBadMagic},
+		"short header":     {valid[:10], ErrTruncated},
+		"energy meter":     {modified(16, 0x60, 0x69), ErrUnsupportedProtocol},
+		"other tag":        {modified(14, 0x00, 0x20), ErrUnsupportedProtocol},
+		"truncated data":   {valid[:40], ErrTruncated},
+		"too short length": {modified(12, 0x00, 0x04), ErrTruncated},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParsePacket(tc.in); !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestPacket_Records(t *testing.T) {
+	p := &Packet{Payload: recordPayload(
+		encodeRecord(28, 0x40, lriDCPower, 1, 1200),
+		encodeRecord(28, 0x40, lriDCPower, 2, 800),
+	)}
+
+	records, err := p.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	for i, r := range records {
+		if r.LRI != lriDCPower || r.Class != uint8(i+1) || r.DataType != 0x40 || len(r.Data) != 20 {
+			t.Fatalf("unexpected record %+v", r)
+		}
+		if !r.Time.Equal(time.Unix(1600000000, 0)) {
+			t.Fatalf("unexpected time %v", r.Time)
+		}
+	}
+
+	p.Payload = p.Payload[:len(p.Payload)-1]
+	if _, err := p.Records(); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected %v, got %v", ErrTruncated, err)
+	}
+}
@@ -0,0 +1,438 @@
+package speedwire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	cmdSpot      uint32 = 0x51000200
+	cmdSpotDC    uint32 = 0x53800200
+	cmdEnergy    uint32 = 0x54000200
+	cmdStatus    uint32 = 0x51800200
+	cmdNameplate uint32 = 0x58000200
+
+	lriACPowerTotal    uint32 = 0x263f
+	lriACPowerL1       uint32 = 0x4640
+	lriACPowerL2       uint32 = 0x4641
+	lriACPowerL3       uint32 = 0x4642
+	lriGridFrequency   uint32 = 0x4657
+	lriDCPower         uint32 = 0x251e
+	lriDCVoltage       uint32 = 0x451f
+	lriDCCurrent       uint32 = 0x4521
+	lriTotalYield      uint32 = 0x2601
+	lriDailyYield      uint32 = 0x2622
+	lriBatterySoc      uint32 = 0x295a
+	lriBatteryTemp     uint32 = 0x495b
+	lriBatteryVoltage  uint32 = 0x495c
+	lriBatteryCurrent  uint32 = 0x495d
+	lriOperationHealth uint32 = 0x2148
+	lriNameplateName   uint32 = 0x821e
+	lriNameplateClass  uint32 = 0x821f
+	lriNameplateType   uint32 = 0x8220
+	lriSoftwareVersion uint32 = 0x8234
+
+	nanUint32    uint32 = 0xffffffff
+	nanInt32     int32  = -0x80000000
+	nanUint64    uint64 = 0xffffffffffffffff
+	attributeEnd uint32 = 0x00fffffe
+)
+
+// ErrValueNotAvailable is returned if the device does not provide a value, e.g. at night.
+var ErrValueNotAvailable = errors.New("value not available")
+
+// deviceClasses maps the device class tags of the nameplate to their names.
+var deviceClasses = map[uint32]string{
+	8000: "All Devices",
+	8001: "Solar Inverter",
+	8002: "Wind Turbine Inverter",
+	8007: "Battery Inverter",
+	8033: "Consumer",
+	8064: "Sensor System",
+	8065: "Electricity Meter",
+	8128: "Communication Product",
+}
+
+// Status is the operating status of a device.
+type Status uint32
+
+const (
+	StatusFault   Status = 35
+	StatusOff     Status = 303
+	StatusOk      Status = 307
+	StatusWarning Status = 455
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusFault:
+		return "Fault"
+	case StatusOff:
+		return "Off"
+	case StatusOk:
+		return "Ok"
+	case StatusWarning:
+		return "Warning"
+	default:
+		return fmt.Sprintf("Status(%d)", uint32(s))
+	}
+}
+
+// ACPower contains the AC power fed into the grid in W.
+type ACPower struct {
+	Total, L1, L2, L3 float64
+}
+
+// DCInput contains the values of a single DC input (string).
+type DCInput struct {
+	Input   int
+	PowerW  float64
+	Voltage float64
+	Current float64
+}
+
+// Yield contains the energy produced in Wh.
+type Yield struct {
+	DailyWh, TotalWh float64
+}
+
+// Battery contains the values of the battery connected to a battery inverter.
+type Battery struct {
+	// Soc is the state of charge in percent.
+	Soc float64
+	// Temperature is the battery temperature in °C.
+	Temperature float64
+	Voltage     float64
+	// Current is the battery current in A, positive values charge the battery.
+	Current float64
+}
+
+// Nameplate contains the identification of a device.
+type Nameplate struct {
+	Name            string
+	DeviceClass     string
+	SoftwareVersion string
+}
+
+// Uint32 returns the first value of an unsigned record.
+func (r Record) Uint32() (uint32, error) {
+	if len(r.Data) < 4 {
+		return 0, fmt.Errorf("%w: record too short", ErrTruncated)
+	}
+
+	v := binary.LittleEndian.Uint32(r.Data)
+	if v == nanUint32 {
+		return 0, ErrValueNotAvailable
+	}
+
+	return v, nil
+}
+
+// Int32 returns the first value of a signed record.
+func (r Record) Int32() (int32, error) {
+	if len(r.Data) < 4 {
+		return 0, fmt.Errorf("%w: record too short", ErrTruncated)
+	}
+
+	v := int32(binary.LittleEndian.Uint32(r.Data))
+	if v == nanInt32 {
+		return 0, ErrValueNotAvailable
+	}
+
+	return v, nil
+}
+
+// Uint64 returns the value of a counter record.
+func (r Record) Uint64() (uint64, error) {
+	if len(r.Data) < 8 {
+		return 0, fmt.Errorf("%w: record too short", ErrTruncated)
+	}
+
+	v := binary.LittleEndian.Uint64(r.Data)
+	if v == nanUint64 {
+		return 0, ErrValueNotAvailable
+	}
+
+	return v, nil
+}
+
+// Text returns the value of a text record.
+func (r Record) Text() string {
+	return strings.TrimRight(string(r.Data), "\x00")
+}
+
+// SelectedTag returns the selected tag of a status record.
+func (r Record) SelectedTag() (uint32, error) {
+	for i := 0; i+4 <= len(r.Data); i += 4 {
+		attribute := binary.LittleEndian.Uint32(r.Data[i:])
+		if attribute&0x00ffffff == attributeEnd {
+			break
+		}
+		// the selected attribute is marked in the highest byte
+		if attribute>>24 == 1 {
+			return attribute & 0x00ffffff, nil
+		}
+	}
+
+	return 0, ErrValueNotAvailable
+}
+
+// queryLRI queries the records of the given logical record indices.
+func (c *Client) queryLRI(command, first, last uint32) ([]Record, error) {
+	return c.Query(command, first<<8, last<<8|0xff)
+}
+
+// SpotACPower reads the AC power fed into the grid.
+func (c *Client) SpotACPower() (ACPower, error) {
+	var p ACPower
+
+	total, err := c.queryLRI(cmdSpot, lriACPowerTotal, lriACPowerTotal)
+	if err != nil {
+		return p, err
+	}
+	phases, err := c.queryLRI(cmdSpot, lriACPowerL1, lriACPowerL3)
+	if err != nil {
+		return p, err
+	}
+
+	for _, r := range append(total, phases...) {
+		v, err := r.Int32()
+		if errors.Is(err, ErrValueNotAvailable) {
+			continue
+		}
+		if err != nil {
+			return p, err
+		}
+
+		switch r.LRI {
+		case lriACPowerTotal:
+			p.Total = float64(v)
+		case lriACPowerL1:
+			p.L1 = float64(v)
+		case lriACPowerL2:
+			p.L2 = float64(v)
+		case lriACPowerL3:
+			p.L3 = float64(v)
+		}
+	}
+
+	return p, nil
+}
+
+// DCInputs reads the values of all DC inputs, ordered by input.
+func (c *Client) DCInputs() ([]DCInput, error) {
+	power, err := c.queryLRI(cmdSpotDC, lriDCPower, lriDCPower)
+	if err != nil {
+		return nil, err
+	}
+	voltageCurrent, err := c.queryLRI(cmdSpotDC, lriDCVoltage, lriDCCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := make(map[uint8]*DCInput)
+	for _, r := range append(power, voltageCurrent...) {
+		v, err := r.Int32()
+		if errors.Is(err, ErrValueNotAvailable) {
+			v, err = 0, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		input, ok := inputs[r.Class]
+		if !ok {
+			input = &DCInput{Input: int(r.Class)}
+			inputs[r.Class] = input
+		}
+
+		switch r.LRI {
+		case lriDCPower:
+			input.PowerW = float64(v)
+		case lriDCVoltage:
+			input.Voltage = float64(v) / 100
+		case lriDCCurrent:
+			input.Current = float64(v) / 1000
+		}
+	}
+
+	result := make([]DCInput, 0, len(inputs))
+	for _, input := range inputs {
+		result = append(result, *input)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Input < result[j].Input
+	})
+
+	return result, nil
+}
+
+// Yield reads the energy produced today and in total.
+func (c *Client) Yield() (Yield, error) {
+	var y Yield
+
+	records, err := c.queryLRI(cmdEnergy, lriTotalYield, lriDailyYield)
+	if err != nil {
+		return y, err
+	}
+
+	for _, r := range records {
+		v, err := r.Uint64()
+		if errors.Is(err, ErrValueNotAvailable) {
+			continue
+		}
+		if err != nil {
+			return y, err
+		}
+
+		switch r.LRI {
+		case lriTotalYield:
+			y.TotalWh = float64(v)
+		case lriDailyYield:
+			y.DailyWh = float64(v)
+		}
+	}
+
+	return y, nil
+}
+
+// GridFrequency reads the grid frequency in Hz.
+func (c *Client) GridFrequency() (float64, error) {
+	r, err := c.queryOne(cmdSpot, lriGridFrequency)
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := r.Uint32()
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(v) / 100, nil
+}
+
+// Battery reads the values of the battery of a battery inverter.
+func (c *Client) Battery() (Battery, error) {
+	var b Battery
+
+	soc, err := c.queryOne(cmdSpot, lriBatterySoc)
+	if err != nil {
+		return b, err
+	}
+	v, err := soc.Uint32()
+	if err != nil {
+		return b, err
+	}
+	b.Soc = float64(v)
+
+	records, err := c.queryLRI(cmdSpot, lriBatteryTemp, lriBatteryCurrent)
+	if err != nil {
+		return b, err
+	}
+
+	for _, r := range records {
+		v, err := r.Int32()
+		if errors.Is(err, ErrValueNotAvailable) {
+			continue
+		}
+		if err != nil {
+			return b, err
+		}
+
+		switch r.LRI {
+		case lriBatteryTemp:
+			b.Temperature = float64(v) / 10
+		case lriBatteryVoltage:
+			b.Voltage = float64(v) / 100
+		case lriBatteryCurrent:
+			b.Current = float64(v) / 1000
+		}
+	}
+
+	return b, nil
+}
+
+// DeviceStatus reads the operating status of the device.
+func (c *Client) DeviceStatus() (Status, error) {
+	r, err := c.queryOne(cmdStatus, lriOperationHealth)
+	if err != nil {
+		return 0, err
+	}
+
+	tag, err := r.SelectedTag()
+	if err != nil {
+		return 0, err
+	}
+
+	return Status(tag), nil
+}
+
+// Nameplate reads the name, device class and software version of the device.
+//
+// The software version is left empty if the device rejects the query, as some devices only answer after a login.
+func (c *Client) Nameplate() (Nameplate, error) {
+	var n Nameplate
+
+	records, err := c.queryLRI(cmdNameplate, lriNameplateName, lriNameplateType)
+	if err != nil {
+		return n, err
+	}
+	version, err := c.queryLRI(cmdNameplate, lriSoftwareVersion, lriSoftwareVersion)
+	if err != nil && !errors.Is(err, ErrRequestFailed) {
+		return n, err
+	}
+
+	for _, r := range append(records, version...) {
+		switch r.LRI {
+		case lriNameplateName:
+			n.Name = r.Text()
+		case lriNameplateClass:
+			tag, err := r.SelectedTag()
+			if err != nil {
+				continue
+			}
+			if name, ok := deviceClasses[tag]; ok {
+				n.DeviceClass = name
+			} else {
+				n.DeviceClass = fmt.Sprint(tag)
+			}
+		case lriSoftwareVersion:
+			if len(r.Data) >= 20 {
+				n.SoftwareVersion = formatSoftwareVersion(r.Data[16:20])
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// queryOne queries the record of a single logical record index.
+func (c *Client) queryOne(command, lri uint32) (Record, error) {
+	records, err := c.queryLRI(command, lri, lri)
+	if err != nil {
+		return Record{}, err
+	}
+
+	for _, r := range records {
+		if r.LRI == lri {
+			return r, nil
+		}
+	}
+
+	return Record{}, ErrValueNotAvailable
+}
+
+// formatSoftwareVersion formats the version as major.minor.build.release with BCD coded major and minor.
+func formatSoftwareVersion(b []byte) string {
+	release, build, minor, major := b[0], b[1], b[2], b[3]
+
+	releaseType := fmt.Sprint(release)
+	if release <= 5 {
+		releaseType = string("NEABRS"[release])
+	}
+
+	return fmt.Sprintf("%x%x.%x%x.%02d.%s", major>>4, major&0x0f, minor>>4, minor&0x0f, build, releaseType)
+}
@@ -0,0 +1,192 @@
+package speedwire
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// counterRecord encodes a record of a 64 bit counter.
+func counterRecord(lri uint32, value uint64) []byte {
+	return encodeRecord(16, 0x00, lri, 1, uint32(value), uint32(value>>32))
+}
+
+// textRecord encodes a record of a text value.
+func textRecord(lri uint32, text string) []byte {
+	b := encodeRecord(40, 0x10, lri, 0)
+	copy(b[8:], text)
+	return b
+}
+
+func testRecords() map[uint32][][]byte {
+	nan := uint32(0x80000000)
+
+	return map[uint32][][]byte{
+		cmdSpot: {
+			encodeRecord(28, 0x40, lriACPowerTotal, 1, 4500),
+			encodeRecord(28, 0x40, lriACPowerL1, 1, 1500),
+			encodeRecord(28, 0x40, lriACPowerL2, 1, 1490),
+			encodeRecord(28, 0x40, lriACPowerL3, 1, 1510),
+			encodeRecord(28, 0x00, lriGridFrequency, 1, 4998),
+			encodeRecord(28, 0x00, lriBatterySoc, 1, 87),
+			encodeRecord(28, 0x40, lriBatteryTemp, 1, 253),
+			encodeRecord(28, 0x00, lriBatteryVoltage, 1, 51230),
+			encodeRecord(28, 0x40, lriBatteryCurrent, 1, uint32(0xffffffff-2499)),
+		},
+		cmdSpotDC: {
+			encodeRecord(28, 0x40, lriDCPower, 1, 2500),
+			encodeRecord(28, 0x40, lriDCPower, 2, nan),
+			encodeRecord(28, 0x40, lriDCVoltage, 1, 41230),
+			encodeRecord(28, 0x40, lriDCVoltage, 2, nan),
+			encodeRecord(28, 0x40, lriDCCurrent, 1, 6063),
+			encodeRecord(28, 0x40, lriDCCurrent, 2, nan),
+		},
+		cmdEnergy: {
+			counterRecord(lriTotalYield, 12345678),
+			counterRecord(lriDailyYield, 23456),
+		},
+		cmdStatus: {
+			encodeRecord(40, 0x08, lriOperationHealth, 1, uint32(StatusFault), uint32(StatusOk)|1<<24, uint32(StatusWarning), attributeEnd),
+		},
+		cmdNameplate: {
+			textRecord(lriNameplateName, "SN: 3000123456"),
+			encodeRecord(40, 0x08, lriNameplateClass, 1, 8001|1<<24, attributeEnd),
+			encodeRecord(40, 0x08, lriNameplateType, 1, 9165|1<<24, attributeEnd),
+			encodeRecord(40, 0x00, lriSoftwareVersion, 1, 0, 0, 0, 0, binary.LittleEndian.Uint32([]byte{4, 30, 0x02, 0x03})),
+		},
+	}
+}
+
+func connectTestInverter(t *testing.T, records map[uint32][][]byte) (*Client, func()) {
+	inverter := startTestInverter(t, records)
+
+	c, err := Connect(inverter.addr(), WithTimeout(time.Second))
+	if err != nil {
+		inverter.Close()
+		t.Fatal(err)
+	}
+
+	return c, func() {
+		c.Close()
+		inverter.Close()
+	}
+}
+
+func TestClient_Values(t *testing.T) {
+	c, closer := connectTestInverter(t, testRecords())
+	defer closer()
+
+	tt := map[string]struct {
+		read func() (interface{}, error)
+		want interface{}
+	}{
+		"ac power": {
+			func() (interface{}, error) { return c.SpotACPower() },
+			ACPower{Total: 4500, L1: 1500, L2: 1490, L3: 1510},
+		},
+		"dc inputs": {
+			func() (interface{}, error) { return c.DCInputs() },
+			[]DCInput{{Input: 1, PowerW: 2500, Voltage: 412.3, Current: 6.063}, {Input: 2}},
+		},
+		"yield": {
+			func() (interface{}, error) { return c.Yield() },
+			Yield{DailyWh: 23456, TotalWh: 12345678},
+		},
+		"grid frequency": {
+			func() (interface{}, error) { return c.GridFrequency() },
+			49.98,
+		},
+		"battery": {
+			func() (interface{}, error) { return c.Battery() },
+			Battery{Soc: 87, Temperature: 25.3, Voltage: 512.3, Current: -2.5},
+		},
+		"device status": {
+			func() (interface{}, error) { return c.DeviceStatus() },
+			StatusOk,
+		},
+		"nameplate": {
+			func() (interface{}, error) { return c.Nameplate() },
+			Nameplate{Name: "SN: 3000123456", DeviceClass: "Solar Inverter", SoftwareVersion: "03.02.30.R"},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			v, err := tc.read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(v, tc.want) {
+				t.Fatalf("expected %+v, got %+v", tc.want, v)
+			}
+		})
+	}
+}
+
+// TestClient_Nameplate_WithoutVersion verifies that a rejected software version query is not an error.
+func TestClient_Nameplate_WithoutVersion(t *testing.T) {
+	records := testRecords()
+	records[cmdNameplate] = records[cmdNameplate][:3]
+
+	c, closer := connectTestInverter(t, records)
+	defer closer()
+
+	n, err := c.Nameplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.SoftwareVersion != "" || n.Name == "" {
+		t.Fatalf("unexpected nameplate %+v", n)
+	}
+}
+
+func TestRecord_Values(t *testing.T) {
+	r := Record{Data: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}}
+	if _, err := r.Uint32(); !errors.Is(err, ErrValueNotAvailable) {
+		t.Fatalf("expected %v, got %v", ErrValueNotAvailable, err)
+	}
+	if _, err := r.Uint64(); !errors.Is(err, ErrValueNotAvailable) {
+		t.Fatalf("expected %v, got %v", ErrValueNotAvailable, err)
+	}
+	if v, err := r.Int32(); err != nil || v != -1 {
+		t.Fatalf("expected -1, got %v, %v", v, err)
+	}
+
+	if _, err := (Record{Data: []byte{1, 2}}).Int32(); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected %v, got %v", ErrTruncated, err)
+	}
+	if _, err := (Record{Data: make([]byte, 8)}).SelectedTag(); !errors.Is(err, ErrValueNotAvailable) {
+		t.Fatalf("expected %v, got %v", ErrValueNotAvailable, err)
+	}
+}
+
+func TestStatus_String(t *testing.T) {
+	if s := StatusWarning.String(); s != "Warning" {
+		t.Fatalf("expected Warning, got %v", s)
+	}
+	if s := Status(1).String(); s != "Status(1)" {
+		t.Fatalf("expected Status(1), got %v", s)
+	}
+}
+
+func TestFormatSoftwareVersion(t *testing.T) {
+	tt := map[string]struct {
+		in   []byte
+		want string
+	}{
+		"release": {[]byte{4, 30, 0x12, 0x03}, "03.12.30.R"},
+		"beta":    {[]byte{3, 1, 0x00, 0x01}, "01.00.01.B"},
+		"unknown": {[]byte{9, 1, 0x00, 0x01}, "01.00.01.9"},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			if v := formatSoftwareVersion(tc.in); v != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package sunspec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// addressWriter is implemented by readers which can also write registers, e.g. modbus.Client.
+type addressWriter interface {
+	WriteFrom(address uint16, v interface{}) error
+}
+
+// CacheStats are the number of reads answered by a CachedReader from its cache and from its reader.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// CachedReader implements addressReader by memoizing the registers read by Reader for TTL, so points read by
+// several consumers within the TTL are read from the device only once.
+//
+// Reads of registers contained in a cached range are answered from the cache. Writes through WriteFrom invalidate
+// the cached ranges overlapping the written registers, Invalidate clears the cache e.g. after changing the device
+// address. It is safe for concurrent use if Reader is.
+type CachedReader struct {
+	Reader addressReader
+	TTL    time.Duration
+
+	mu     sync.Mutex
+	ranges []cachedRange
+	// generation is incremented by invalidations, reads started before are not cached.
+	generation uint64
+	stats      CacheStats
+}
+
+// cachedRange are registers read from the device.
+type cachedRange struct {
+	address   uint16
+	registers []byte
+	expires   time.Time
+}
+
+// contains reports whether the registers starting at the address are part of the range.
+func (r cachedRange) contains(address, words uint16) bool {
+	return address >= r.address && int(address)+int(words) <= int(r.address)+len(r.registers)/2
+}
+
+// overlaps reports whether any of the registers starting at the address are part of the range.
+func (r cachedRange) overlaps(address, words uint16) bool {
+	return int(address) < int(r.address)+len(r.registers)/2 && int(r.address) < int(address)+int(words)
+}
+
+// read returns the registers from the cache or reads them.
+func (c *CachedReader) read(address, words uint16) ([]byte, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	for _, r := range c.ranges {
+		if now.Before(r.expires) && r.contains(address, words) {
+			c.stats.Hits++
+			start := int(address-r.address) * 2
+			registers := append([]byte(nil), r.registers[start:start+int(words)*2]...)
+			c.mu.Unlock()
+			return registers, nil
+		}
+	}
+	c.stats.Misses++
+	generation := c.generation
+	c.mu.Unlock()
+
+	registers := make([]byte, int(words)*2)
+	if err := c.Reader.ReadInto(address, registers); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		// the registers might have been written while reading
+		return registers, nil
+	}
+
+	ranges := c.ranges[:0]
+	for _, r := range c.ranges {
+		if now.Before(r.expires) {
+			ranges = append(ranges, r)
+		}
+	}
+	c.ranges = append(ranges, cachedRange{
+		address:   address,
+		registers: append([]byte(nil), registers...),
+		expires:   now.Add(c.TTL),
+	})
+
+	return registers, nil
+}
+
+// invalidate removes the cached ranges overlapping the registers.
+func (c *CachedReader) invalidate(address, words uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	ranges := c.ranges[:0]
+	for _, r := range c.ranges {
+		if !r.overlaps(address, words) {
+			ranges = append(ranges, r)
+		}
+	}
+	c.ranges = ranges
+}
+
+// Invalidate clears the cache.
+func (c *CachedReader) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.ranges = nil
+}
+
+// Stats returns the number of cache hits and misses.
+func (c *CachedReader) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
+// WriteFrom writes the variable into the registers starting at the address using Reader, which must implement
+// WriteFrom, and invalidates the cached registers.
+func (c *CachedReader) WriteFrom(address uint16, v interface{}) error {
+	w, ok := c.Reader.(addressWriter)
+	if !ok {
+		return fmt.Errorf("reader %T can't write registers", c.Reader)
+	}
+
+	size := binary.Size(v)
+	if size < 0 {
+		return fmt.Errorf("invalid type %T", v)
+	}
+
+	// the registers might have been written even if writing failed
+	defer c.invalidate(address, uint16((size+1)/2))
+	return w.WriteFrom(address, v)
+}
+
+func (c *CachedReader) ReadUint16(address uint16) (uint16, error) {
+	b, err := c.read(address, 1)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+func (c *CachedReader) ReadUint32(address uint16) (uint32, error) {
+	b, err := c.read(address, 2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func (c *CachedReader) ReadUint64(address uint16) (uint64, error) {
+	b, err := c.read(address, 4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (c *CachedReader) ReadInt16(address uint16) (int16, error) {
+	v, err := c.ReadUint16(address)
+	return int16(v), err
+}
+
+func (c *CachedReader) ReadInt32(address uint16) (int32, error) {
+	v, err := c.ReadUint32(address)
+	return int32(v), err
+}
+
+func (c *CachedReader) ReadInt64(address uint16) (int64, error) {
+	v, err := c.ReadUint64(address)
+	return int64(v), err
+}
+
+func (c *CachedReader) ReadFloat32(address uint16) (float32, error) {
+	v, err := c.ReadUint32(address)
+	return math.Float32frombits(v), err
+}
+
+func (c *CachedReader) ReadFloat64(address uint16) (float64, error) {
+	v, err := c.ReadUint64(address)
+	return math.Float64frombits(v), err
+}
+
+// ReadString reads a string of the given number of registers, trailing null bytes are removed.
+func (c *CachedReader) ReadString(address, words uint16) (string, error) {
+	b, err := c.read(address, words)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\x00"), nil
+}
+
+// ReadInto reads the registers starting at the address into the variable, which must have a size of a multiple
+// of two bytes.
+func (c *CachedReader) ReadInto(address uint16, v interface{}) error {
+	size := binary.Size(v)
+	if size < 0 || size%2 != 0 {
+		return fmt.Errorf("invalid size %v of %T, bytes must be multiple of two", size, v)
+	}
+
+	b, err := c.read(address, uint16(size/2))
+	if err != nil {
+		return err
+	}
+	return binary.Read(bytes.NewReader(b), binary.BigEndian, v)
+}
@@ -0,0 +1,209 @@
+package sunspec_test
+
+import (
+	"github.com/orlopau/go-energy/pkg/modbus"
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingServer serves a mockbus and counts the requests.
+type countingServer struct {
+	*modbus.Server
+	addr string
+
+	mu       sync.Mutex
+	requests int
+}
+
+func startCountingServer(t *testing.T, mockbus *modbus.Mockbus) *countingServer {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &countingServer{addr: l.Addr().String()}
+	s.Server = modbus.NewServer(modbus.HandlerFunc(func(r modbus.Request) ([]byte, error) {
+		s.mu.Lock()
+		s.requests++
+		s.mu.Unlock()
+		return mockbus.ServeModbus(r)
+	}))
+	go func() {
+		_ = s.Serve(l)
+	}()
+
+	return s
+}
+
+func (s *countingServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func TestCachedReader(t *testing.T) {
+	mockbus := modbus.NewMockbus(100)
+	if err := mockbus.AddHoldingRegisterEntry(10, uint32(0x00010002)); err != nil {
+		t.Fatal(err)
+	}
+	server := startCountingServer(t, mockbus)
+	defer server.Close()
+
+	client, err := modbus.Connect(server.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	r := &sunspec.CachedReader{Reader: client, TTL: 50 * time.Millisecond}
+
+	read := func(address, want uint16, requests int) {
+		t.Helper()
+		if v, err := r.ReadUint16(address); err != nil || v != want {
+			t.Fatalf("expected %v, got %v %v", want, v, err)
+		}
+		if n := server.count(); n != requests {
+			t.Fatalf("expected %v requests, got %v", requests, n)
+		}
+	}
+
+	if v, err := r.ReadUint32(10); err != nil || v != 0x00010002 {
+		t.Fatalf("expected %v, got %v %v", 0x00010002, v, err)
+	}
+	// registers contained in the cached range are not read again
+	read(10, 1, 1)
+	read(11, 2, 1)
+	read(12, 0, 2)
+
+	// writing invalidates the overlapping ranges
+	if err := r.WriteFrom(11, uint16(5)); err != nil {
+		t.Fatal(err)
+	}
+	read(11, 5, 4)
+	read(12, 0, 4)
+
+	time.Sleep(60 * time.Millisecond)
+	read(12, 0, 5)
+
+	r.Invalidate()
+	read(11, 5, 6)
+
+	if stats := r.Stats(); stats != (sunspec.CacheStats{Hits: 3, Misses: 5}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCachedReader_Types(t *testing.T) {
+	mockbus := modbus.NewMockbus(100)
+	err := mockbus.AddHoldingRegisterEntries(map[uint16]interface{}{
+		0:  int16(-2),
+		1:  int32(-3),
+		3:  int64(-4),
+		7:  math.Float32bits(1.5),
+		9:  math.Float64bits(-2.5),
+		13: []byte("abc\x00"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := startCountingServer(t, mockbus)
+	defer server.Close()
+
+	client, err := modbus.Connect(server.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	r := &sunspec.CachedReader{Reader: client, TTL: time.Minute}
+
+	var values [15]uint16
+	if err := r.ReadInto(0, &values); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := r.ReadInt16(0); err != nil || v != -2 {
+		t.Fatalf("expected int16 -2, got %v %v", v, err)
+	}
+	if v, err := r.ReadInt32(1); err != nil || v != -3 {
+		t.Fatalf("expected int32 -3, got %v %v", v, err)
+	}
+	if v, err := r.ReadInt64(3); err != nil || v != -4 {
+		t.Fatalf("expected int64 -4, got %v %v", v, err)
+	}
+	if v, err := r.ReadFloat32(7); err != nil || v != 1.5 {
+		t.Fatalf("expected float32 1.5, got %v %v", v, err)
+	}
+	if v, err := r.ReadFloat64(9); err != nil || v != -2.5 {
+		t.Fatalf("expected float64 -2.5, got %v %v", v, err)
+	}
+	if v, err := r.ReadString(13, 2); err != nil || v != "abc" {
+		t.Fatalf("expected string abc, got %q %v", v, err)
+	}
+
+	if n := server.count(); n != 1 {
+		t.Fatalf("expected the values to be read from the cache, got %v requests", n)
+	}
+}
+
+func TestCachedReader_WriteUnsupported(t *testing.T) {
+	r := &sunspec.CachedReader{Reader: &dummyAddressReader{}, TTL: time.Minute}
+
+	if err := r.WriteFrom(0, uint16(1)); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestModbusDevice_SetCacheTTL(t *testing.T) {
+	mockbus := modbus.NewMockbus(100)
+	err := mockbus.AddHoldingRegisterEntries(map[uint16]interface{}{
+		0:  uint32(0x53756e53),
+		2:  uint16(1),
+		3:  uint16(66),
+		70: uint16(2),
+		71: uint16(1),
+		72: uint16(100),
+		73: uint16(math.MaxUint16),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := startCountingServer(t, mockbus)
+	defer server.Close()
+
+	device, err := sunspec.Connect(server.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer device.Close()
+	device.SetCacheTTL(time.Minute)
+
+	point := sunspec.Point{Model: 2, Point: 2, T: uint16(0)}
+	for i := 0; i < 3; i++ {
+		if v, err := device.GetAnyPoint(point); err != nil || v != 100 {
+			t.Fatalf("expected 100, got %v %v", v, err)
+		}
+	}
+	if stats := device.CacheStats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	if err := device.WritePoint(2, 2, uint16(200)); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := device.GetAnyPoint(point); err != nil || v != 200 {
+		t.Fatalf("expected the written value 200, got %v %v", v, err)
+	}
+
+	device.SetCacheTTL(0)
+	if v, err := device.GetAnyPoint(point); err != nil || v != 200 {
+		t.Fatalf("expected 200, got %v %v", v, err)
+	}
+	if stats := device.CacheStats(); stats != (sunspec.CacheStats{}) {
+		t.Fatalf("expected no stats without cache, got %+v", stats)
+	}
+}
@@ -0,0 +1,48 @@
+package sunspec
+
+// ModelCommon is the id of the SunSpec common model implemented by all SunSpec devices.
+const ModelCommon uint16 = 1
+
+// points of the common model, the offsets include the model id and length registers
+const (
+	commonManufacturer, commonManufacturerWords uint16 = 2, 16
+	commonModel, commonModelWords               uint16 = 18, 16
+	commonOptions, commonOptionsWords           uint16 = 34, 8
+	commonVersion, commonVersionWords           uint16 = 42, 8
+	commonSerialNumber, commonSerialNumberWords uint16 = 50, 16
+)
+
+// CommonModel contains the identification of a device read from the SunSpec common model.
+type CommonModel struct {
+	Manufacturer string
+	Model        string
+	Options      string
+	Version      string
+	SerialNumber string
+}
+
+// ReadCommonModel reads the identification of the device from the common model.
+func (r *ModelReader) ReadCommonModel() (CommonModel, error) {
+	var c CommonModel
+
+	points := []struct {
+		v            *string
+		point, words uint16
+	}{
+		{&c.Manufacturer, commonManufacturer, commonManufacturerWords},
+		{&c.Model, commonModel, commonModelWords},
+		{&c.Options, commonOptions, commonOptionsWords},
+		{&c.Version, commonVersion, commonVersionWords},
+		{&c.SerialNumber, commonSerialNumber, commonSerialNumberWords},
+	}
+
+	for _, p := range points {
+		v, err := r.ReadString(ModelCommon, p.point, p.words)
+		if err != nil {
+			return CommonModel{}, err
+		}
+		*p.v = v
+	}
+
+	return c, nil
+}
@@ -0,0 +1,52 @@
+package sunspec_test
+
+import (
+	"github.com/orlopau/go-energy/pkg/sunspec"
+	"testing"
+)
+
+func TestModelReader_ReadCommonModel(t *testing.T) {
+	m := &sunspec.ModelReader{
+		Reader: &dummyAddressReader{
+			strings: map[uint16]string{
+				42: "Fronius",
+				58: "Symo 10.0-3-M",
+				74: "3",
+				82: "0.3.30.2",
+				90: "12345678",
+			},
+		},
+		Converter: &dummyModelConverter{
+			models: map[uint16]uint16{sunspec.ModelCommon: 40},
+		},
+	}
+
+	c, err := m.ReadCommonModel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := sunspec.CommonModel{
+		Manufacturer: "Fronius",
+		Model:        "Symo 10.0-3-M",
+		Options:      "3",
+		Version:      "0.3.30.2",
+		SerialNumber: "12345678",
+	}
+	if c != want {
+		t.Fatalf("want %+v, got %+v", want, c)
+	}
+}
+
+func TestModelReader_ReadCommonModel_Missing(t *testing.T) {
+	m := &sunspec.ModelReader{
+		Reader: &dummyAddressReader{strings: map[uint16]string{42: "Fronius"}},
+		Converter: &dummyModelConverter{
+			models: map[uint16]uint16{sunspec.ModelCommon: 40},
+		},
+	}
+
+	if _, err := m.ReadCommonModel(); err == nil {
+		t.Fatal("expected error")
+	}
+}
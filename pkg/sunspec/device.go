@@ -3,17 +3,21 @@ package sunspec
 import (
 	"github.com/orlopau/go-energy/pkg/modbus"
 	"github.com/pkg/errors"
+	"time"
 )
 
 type ModbusDevice struct {
 	*ModelReader
-	client *modbus.Client
+	client    *modbus.Client
+	converter *CachedModelConverter
+	cache     *CachedReader
 }
 
 // Connect connects to a SunSpec modbus TCP device.
-func Connect(addr string) (*ModbusDevice, error) {
-	// TODO remove modbus library dependency in sunspec package
-	client, err := modbus.Connect(addr)
+//
+// The options configure the underlying modbus connection.
+func Connect(addr string, opts ...modbus.Option) (*ModbusDevice, error) {
+	client, err := modbus.Connect(addr, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -34,7 +38,17 @@ func newDevice(client *modbus.Client) *ModbusDevice {
 		Converter: converter,
 	}
 
-	return &ModbusDevice{ModelReader: m, client: client}
+	return &ModbusDevice{ModelReader: m, client: client, converter: converter}
+}
+
+// Close closes the modbus connection.
+func (d *ModbusDevice) Close() error {
+	return d.client.Close()
+}
+
+// Models returns the ids of all SunSpec models implemented by the device in ascending order.
+func (d *ModbusDevice) Models() ([]uint16, error) {
+	return d.converter.Models()
 }
 
 // AutoSetDeviceAddress tries to infer the device address (slave id) from the SunSpec model.
@@ -55,4 +69,59 @@ func (d *ModbusDevice) AutoSetDeviceAddress() error {
 // SetDeviceAddress sets the device address (slave id) for following modbus requests.
 func (d *ModbusDevice) SetDeviceAddress(deviceAddr byte) {
 	d.client.SetSlaveID(deviceAddr)
+	if d.cache != nil {
+		d.cache.Invalidate()
+	}
+}
+
+// SetCacheTTL caches the registers read from the device for the ttl, see CachedReader. A zero ttl disables caching.
+//
+// It must not be called concurrently with reads.
+func (d *ModbusDevice) SetCacheTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		d.cache = nil
+		d.Reader = d.client
+		return
+	}
+
+	d.cache = &CachedReader{Reader: d.client, TTL: ttl}
+	d.Reader = d.cache
+}
+
+// CacheStats returns the hits and misses of the cache set by SetCacheTTL.
+func (d *ModbusDevice) CacheStats() CacheStats {
+	if d.cache == nil {
+		return CacheStats{}
+	}
+	return d.cache.Stats()
+}
+
+// WritePoint writes the given variable into the registers of a point, e.g. to change a set point.
+func (d *ModbusDevice) WritePoint(model, point uint16, v interface{}) error {
+	address, err := d.Converter.GetAddress(model)
+	if err != nil {
+		return err
+	}
+
+	if d.cache != nil {
+		return d.cache.WriteFrom(address+point, v)
+	}
+	return d.client.WriteFrom(address+point, v)
+}
+
+// ModelAddresses returns the start addresses of the models implemented by the device, keyed by model id.
+func (d *ModbusDevice) ModelAddresses() (map[uint16]uint16, error) {
+	models, err := d.converter.Models()
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make(map[uint16]uint16, len(models))
+	for _, m := range models {
+		if addresses[m], err = d.converter.GetAddress(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return addresses, nil
 }
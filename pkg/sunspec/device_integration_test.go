@@ -5,25 +5,19 @@ import (
 	"github.com/orlopau/go-energy/pkg/modbus"
 	"github.com/orlopau/go-energy/pkg/sunspec"
 	"github.com/phayes/freeport"
-	"github.com/xiegeo/modbusone"
-	"golang.org/x/net/context"
 	"math"
 	"net"
-	"runtime"
 	"testing"
 	"time"
 )
 
-func startSunSpecServer(ctx context.Context, t *testing.T, addr string, value int) {
-	t.Logf("modbus server starting on %v", addr)
-
+// startSunSpecServer serves a sunspec device with model 2 on the address.
+func startSunSpecServer(addr string, value int) (*modbus.Server, error) {
 	listener, err := net.Listen("tcp4", addr)
 	if err != nil {
-		t.Fatal(err)
+		return nil, err
 	}
 
-	server := modbusone.NewTCPServer(listener)
-
 	mockbus := modbus.NewMockbus(1000)
 	err = mockbus.AddHoldingRegisterEntries(map[uint16]interface{}{
 		0:  uint32(0x53756e53),
@@ -36,38 +30,17 @@ func startSunSpecServer(ctx context.Context, t *testing.T, addr string, value in
 		73: uint16(math.MaxUint16),
 		74: uint16(0),
 	})
-
 	if err != nil {
-		t.Fatal(err)
+		_ = listener.Close()
+		return nil, err
 	}
 
+	server := modbus.NewServer(mockbus)
 	go func() {
-		select {
-		case <-ctx.Done():
-			err2 := server.Close()
-			if err2 != nil {
-				t.Fatal(err2)
-			}
-		}
+		_ = server.Serve(listener)
 	}()
 
-	handler := &modbusone.SimpleHandler{
-		ReadHoldingRegisters: func(address, quantity uint16) ([]uint16, error) {
-			if ctx.Err() != nil {
-				// yikes, used to kill the connection
-				runtime.Goexit()
-			}
-			regs, err2 := mockbus.ReadHoldingRegistersUint(address, quantity)
-			return regs, err2
-		},
-	}
-
-	err = server.Serve(handler)
-	if err != nil && ctx.Err() == nil {
-		t.Fatal(err)
-	}
-
-	t.Log("server terminated")
+	return server, nil
 }
 
 func verifySunSpec(t *testing.T, device *sunspec.ModbusDevice, val int) {
@@ -95,14 +68,11 @@ func TestConnect_Simple(t *testing.T) {
 
 	addr := fmt.Sprintf(":%v", port)
 
-	started := make(chan bool)
-
-	go func() {
-		started <- true
-		startSunSpecServer(context.TODO(), t, addr, 100)
-	}()
-
-	<-started
+	server, err := startSunSpecServer(addr, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
 
 	device, err := sunspec.Connect(addr)
 	if err != nil {
@@ -122,9 +92,14 @@ func TestConnect_InitialRefused(t *testing.T) {
 
 	addr := fmt.Sprintf(":%v", port)
 
+	servers := make(chan *modbus.Server, 1)
 	go func() {
 		<-time.After(time.Second)
-		startSunSpecServer(context.TODO(), t, addr, 100)
+		server, err := startSunSpecServer(addr, 100)
+		if err != nil {
+			t.Error(err)
+		}
+		servers <- server
 	}()
 
 	device, err := sunspec.Connect(addr)
@@ -133,6 +108,10 @@ func TestConnect_InitialRefused(t *testing.T) {
 	}
 
 	verifySunSpec(t, device, 100)
+
+	if server := <-servers; server != nil {
+		_ = server.Close()
+	}
 }
 
 func TestConnect_InBetweenRefused(t *testing.T) {
@@ -145,17 +124,10 @@ func TestConnect_InBetweenRefused(t *testing.T) {
 
 	addr := fmt.Sprintf(":%v", port)
 
-	started := make(chan bool)
-
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	defer cancelFunc()
-
-	go func() {
-		started <- true
-		startSunSpecServer(ctx, t, addr, 100)
-	}()
-
-	<-started
+	server, err := startSunSpecServer(addr, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	device, err := sunspec.Connect(addr)
 	if err != nil {
@@ -165,16 +137,27 @@ func TestConnect_InBetweenRefused(t *testing.T) {
 	verifySunSpec(t, device, 100)
 
 	// shutdown device
-	cancelFunc()
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
 
 	const secondVal = 200
 
 	// start again
+	servers := make(chan *modbus.Server, 1)
 	go func() {
 		<-time.After(1 * time.Second)
-		startSunSpecServer(context.TODO(), t, addr, secondVal)
+		server, err := startSunSpecServer(addr, secondVal)
+		if err != nil {
+			t.Error(err)
+		}
+		servers <- server
 	}()
 
 	// verify while server is down to check retry mechanism
 	verifySunSpec(t, device, secondVal)
+
+	if server := <-servers; server != nil {
+		_ = server.Close()
+	}
 }
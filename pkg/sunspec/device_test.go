@@ -78,4 +78,33 @@ func TestConnect_Scan(t *testing.T) {
 	if 1337 != p {
 		t.Fatalf("want %v, got %v", 1337, p)
 	}
-}
\ No newline at end of file
+}
+
+func TestModbusDevice_WritePoint(t *testing.T) {
+	server, addr, err := setupModbusServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	setupRegisters(server.HoldingRegisters)
+
+	ss, err := sunspec.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	if err := ss.WritePoint(11, 3, int16(-42)); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := ss.ReadPointInt16(11, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p != -42 {
+		t.Fatalf("want %v, got %v", -42, p)
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"math"
+	"strconv"
 )
 
 const (
 	UnitWatts      = "W"
+	UnitWattHours  = "Wh"
 	UnitPercentage = "%"
 )
 
@@ -30,16 +32,25 @@ var (
 	PointPower1Phase   = Point{Model: 101, Point: 14, T: int16(0), Scaled: true, Unit: UnitWatts}
 	PointPower2Phase   = Point{Model: 102, Point: 14, T: int16(0), Scaled: true, Unit: UnitWatts}
 	PointPower3Phase   = Point{Model: 103, Point: 14, T: int16(0), Scaled: true, Unit: UnitWatts}
+	PointEnergy1Phase  = Point{Model: 101, Point: 24, T: uint32(0), Scaled: true, Unit: UnitWattHours}
+	PointEnergy2Phase  = Point{Model: 102, Point: 24, T: uint32(0), Scaled: true, Unit: UnitWattHours}
+	PointEnergy3Phase  = Point{Model: 103, Point: 24, T: uint32(0), Scaled: true, Unit: UnitWattHours}
+	// PointBatterySoc is the state of charge of the battery model, which is preferred over the storage model's PointSoc.
+	PointBatterySoc = Point{Model: 802, Point: 11, T: uint16(0), Scaled: true, ScalePoint: 56, Unit: UnitPercentage}
+	// PointBatteryPower is the power of the battery model, positive values discharge the battery.
+	PointBatteryPower = Point{Model: 802, Point: 47, T: int16(0), Scaled: true, ScalePoint: 63, Unit: UnitWatts}
 )
 
 // Point represents a SunSpec point.
 type Point struct {
 	Point, Model uint16
 	// T is the type of the point.
-	T            interface{}
+	T interface{}
 	// Scaled must be set to true if the value is a scaled value with an additional register for scaling.
-	Scaled       bool
-	Unit         string
+	Scaled bool
+	// ScalePoint is the point of the scale factor of a scaled value, by default the register following the value.
+	ScalePoint uint16
+	Unit       string
 }
 
 func (p Point) String() string {
@@ -132,15 +143,20 @@ func (r *ModelReader) getPoint(p Point) (float64, error) {
 		return val, nil
 	}
 
-	size := uint16(math.Floor(float64(binary.Size(tmpVal)) / 2.0))
+	scalePoint := p.ScalePoint
+	if scalePoint == 0 {
+		size := uint16(math.Floor(float64(binary.Size(tmpVal)) / 2.0))
+		scalePoint = p.Point + size
+	}
 
 	var factor uint16
-	err = r.ReadInto(p.Model, p.Point+size, &factor)
+	err = r.ReadInto(p.Model, scalePoint, &factor)
 	if err != nil {
 		return 0, err
 	}
 
-	return val * math.Pow10(int(factor)), nil
+	// scale factors are signed
+	return val * math.Pow10(int(int16(factor))), nil
 }
 
 // GetAnyPoint fetches the first available point and returns its value.
@@ -159,3 +175,60 @@ func (r *ModelReader) GetAnyPoint(ps ...Point) (float64, error) {
 
 	return 0, errors.Wrap(ErrPointNotImplemented, fmt.Sprintf("did not find any of these points %v", ps))
 }
+
+// PointType returns a value of the type with the given name for the T of a Point, e.g. uint16 or float32.
+func PointType(name string) (interface{}, error) {
+	switch name {
+	case "uint16":
+		return uint16(0), nil
+	case "int16":
+		return int16(0), nil
+	case "uint32":
+		return uint32(0), nil
+	case "int32":
+		return int32(0), nil
+	case "float32":
+		return float32(0), nil
+	case "float64":
+		return float64(0), nil
+	default:
+		return nil, fmt.Errorf("unsupported type %q", name)
+	}
+}
+
+// ParseValue parses the value as the type with the given name, see PointType, e.g. for writing a point.
+func ParseValue(name, s string) (interface{}, error) {
+	var v interface{}
+	var err error
+	switch name {
+	case "uint16":
+		var u uint64
+		u, err = strconv.ParseUint(s, 0, 16)
+		v = uint16(u)
+	case "int16":
+		var i int64
+		i, err = strconv.ParseInt(s, 0, 16)
+		v = int16(i)
+	case "uint32":
+		var u uint64
+		u, err = strconv.ParseUint(s, 0, 32)
+		v = uint32(u)
+	case "int32":
+		var i int64
+		i, err = strconv.ParseInt(s, 0, 32)
+		v = int32(i)
+	case "float32":
+		var f float64
+		f, err = strconv.ParseFloat(s, 32)
+		v = float32(f)
+	case "float64":
+		v, err = strconv.ParseFloat(s, 64)
+	default:
+		return nil, fmt.Errorf("unsupported type %q", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v value %q", name, s)
+	}
+
+	return v, nil
+}
@@ -84,3 +84,58 @@ func TestModelReader_GetPoint(t *testing.T) {
 		t.Fatalf("want %v, got %v", scaledPow, p)
 	}
 }
+
+func TestModelReader_GetPoint_ScalePoint(t *testing.T) {
+	m := &sunspec.ModelReader{
+		Reader: &dummyAddressReader{
+			ints:  map[uint16]int64{47: -1234},
+			uints: map[uint16]uint64{63: uint64(uint16(0xffff))},
+		},
+		Converter: &dummyModelConverter{
+			models: map[uint16]uint16{802: 0},
+		},
+	}
+
+	p, err := m.GetAnyPoint(sunspec.PointBatteryPower)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p != -123.4 {
+		t.Fatalf("want %v, got %v", -123.4, p)
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	tests := map[string]struct {
+		typ, s string
+		want   interface{}
+	}{
+		"uint16":       {"uint16", "0x10", uint16(16)},
+		"int16":        {"int16", "-5", int16(-5)},
+		"uint32":       {"uint32", "70000", uint32(70000)},
+		"int32":        {"int32", "-70000", int32(-70000)},
+		"float32":      {"float32", "1.5", float32(1.5)},
+		"float64":      {"float64", "2.25", 2.25},
+		"out of range": {"uint16", "70000", nil},
+		"unknown type": {"int8", "1", nil},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			v, err := sunspec.ParseValue(test.typ, test.s)
+			if test.want == nil {
+				if err == nil {
+					t.Fatalf("expected error, got %v", v)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v != test.want {
+				t.Fatalf("want %v, got %v", test.want, v)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ package sunspec
 import (
 	"fmt"
 	"math"
+	"sort"
 )
 
 const (
@@ -145,6 +146,22 @@ func (c *CachedModelConverter) GetAddress(model uint16) (uint16, error) {
 	return address, nil
 }
 
+// Models returns the ids of all models implemented by the SunSpec device in ascending order.
+func (c *CachedModelConverter) Models() ([]uint16, error) {
+	err := c.verifyModels()
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]uint16, 0, len(c.models))
+	for model := range c.models {
+		models = append(models, model)
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i] < models[j] })
+
+	return models, nil
+}
+
 // HasModel checks if the SunSpec device implements a given model.
 func (c *CachedModelConverter) HasModel(model uint16) (bool, error) {
 	err := c.verifyModels()
@@ -77,6 +77,24 @@ func TestCachedModelScanner_HasModel(t *testing.T) {
 	}
 }
 
+func TestCachedModelScanner_Models(t *testing.T) {
+	s := &dummyModelScanner{models: map[uint16]uint16{
+		103: 70,
+		1:   2,
+		160: 122,
+	}}
+	modelScanner := sunspec.CachedModelConverter{ModelScanner: s}
+
+	models, err := modelScanner.Models()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(models) != "[1 103 160]" {
+		t.Fatalf("want [1 103 160], got %v", models)
+	}
+}
+
 type dummyAddressReader struct {
 	uints   map[uint16]uint64
 	floats  map[uint16]float64